@@ -65,7 +65,9 @@ var (
 	profiling                        bool
 	name                             string
 	joinClusterTimeout               string
+	rebootNotReadyNodeAfter          string
 	workerCount                      int
+	providerConcurrencyLimit         int
 	bootstrapTokenServiceAccountName string
 	skipEvictionAfter                time.Duration
 	caBundleFile                     string
@@ -75,14 +77,22 @@ var (
 	nodeCSRApprover               bool
 	nodeHTTPProxy                 string
 	nodeNoProxy                   string
+	nodeNoProxyCIDRs              string
 	nodeInsecureRegistries        string
 	nodeRegistryMirrors           string
 	nodePauseImage                string
+	nodePauseImageRegistry        string
 	nodeContainerRuntime          string
 	podCIDR                       string
 	nodePortRange                 string
 	nodeRegistryCredentialsSecret string
 	nodeContainerdRegistryMirrors = containerruntime.RegistryMirrorsFlags{}
+
+	nodeContainerdImagePullProgressTimeout string
+	nodeContainerdMaxConcurrentDownloads   int
+
+	nodeDockerCgroupDriver  string
+	nodeDockerStorageDriver string
 )
 
 const (
@@ -117,6 +127,10 @@ type controllerRunOptions struct {
 	// deleted by the machine-controller
 	joinClusterTimeout *time.Duration
 
+	// The duration after which the instance of a machine whose node is NotReady is rebooted, if the
+	// cloud provider supports it
+	rebootNotReadyNodeAfter *time.Duration
+
 	// Will instruct the machine-controller to skip the eviction if the machine deletion is older than skipEvictionAfter
 	skipEvictionAfter time.Duration
 
@@ -129,6 +143,9 @@ type controllerRunOptions struct {
 
 	// A port range to reserve for services with NodePort visibility
 	nodePortRange string
+
+	// Overrides the number of concurrent instance creations and deletions at the cloud provider
+	providerConcurrencyLimit int
 }
 
 func main() {
@@ -146,21 +163,29 @@ func main() {
 	}
 	flag.StringVar(&clusterDNSIPs, "cluster-dns", "10.10.10.10", "Comma-separated list of DNS server IP address.")
 	flag.IntVar(&workerCount, "worker-count", 1, "Number of workers to process machines. Using a high number with a lot of machines might cause getting rate-limited from your cloud provider.")
+	flag.IntVar(&providerConcurrencyLimit, "provider-concurrency-limit", 0, "If set, limits the number of concurrent instance creations and deletions at the cloud provider, instead of the provider's default.")
 	flag.StringVar(&healthProbeAddress, "health-probe-address", "127.0.0.1:8085", "The address on which the liveness check on /healthz and readiness check on /readyz will be available")
 	flag.StringVar(&metricsAddress, "metrics-address", "127.0.0.1:8080", "The address on which Prometheus metrics will be available under /metrics")
 	flag.StringVar(&name, "name", "", "When set, the controller will only process machines with the label \"machine.k8s.io/controller\": name")
 	flag.StringVar(&joinClusterTimeout, "join-cluster-timeout", "", "when set, machines that have an owner and do not join the cluster within the configured duration will be deleted, so the owner re-creats them")
+	flag.StringVar(&rebootNotReadyNodeAfter, "reboot-not-ready-node-after", "", "when set, the instances of machines whose node is NotReady for the configured duration are rebooted once, if the cloud provider supports it")
 	flag.StringVar(&bootstrapTokenServiceAccountName, "bootstrap-token-service-account-name", "", "When set use the service account token from this SA as bootstrap token instead of creating a temporary one. Passed in namespace/name format")
 	flag.BoolVar(&profiling, "enable-profiling", false, "when set, enables the endpoints on the http server under /debug/pprof/")
 	flag.DurationVar(&skipEvictionAfter, "skip-eviction-after", 2*time.Hour, "Skips the eviction if a machine is not gone after the specified duration.")
 	flag.StringVar(&nodeHTTPProxy, "node-http-proxy", "", "If set, it configures the 'HTTP_PROXY' & 'HTTPS_PROXY' environment variable on the nodes.")
 	flag.StringVar(&nodeNoProxy, "node-no-proxy", ".svc,.cluster.local,localhost,127.0.0.1", "If set, it configures the 'NO_PROXY' environment variable on the nodes.")
+	flag.StringVar(&nodeNoProxyCIDRs, "node-no-proxy-cidrs", "", "Comma-separated list of the pod and service CIDRs of the cluster, which are added to the 'NO_PROXY' environment variable on the nodes.")
 	flag.StringVar(&nodeInsecureRegistries, "node-insecure-registries", "", "Comma separated list of registries which should be configured as insecure on the container runtime")
 	flag.StringVar(&nodeRegistryMirrors, "node-registry-mirrors", "", "Comma separated list of Docker image mirrors")
 	flag.StringVar(&nodePauseImage, "node-pause-image", "", "Image for the pause container including tag. If not set, the kubelet default will be used: https://kubernetes.io/docs/reference/command-line-tools-reference/kubelet/")
+	flag.StringVar(&nodePauseImageRegistry, "node-pause-image-registry", "", "If set, the pause image is pulled from this registry, e.g. an internal mirror, instead of the registry of -node-pause-image or of the default pause image")
 	flag.String("node-kubelet-repository", "quay.io/kubermatic/kubelet", "[NO-OP] Repository for the kubelet container. Has no effects.")
 	flag.StringVar(&nodeContainerRuntime, "node-container-runtime", "docker", "container-runtime to deploy")
 	flag.Var(&nodeContainerdRegistryMirrors, "node-containerd-registry-mirrors", "Configure registry mirrors endpoints. Can be used multiple times to specify multiple mirrors")
+	flag.StringVar(&nodeContainerdImagePullProgressTimeout, "node-containerd-image-pull-progress-timeout", "", "If set, image pulls are cancelled when there is no progress for the given duration, e.g. 10m. Requires containerd 1.7 or newer")
+	flag.IntVar(&nodeContainerdMaxConcurrentDownloads, "node-containerd-max-concurrent-downloads", 0, "If set, limits the number of layers containerd downloads concurrently per image pull")
+	flag.StringVar(&nodeDockerCgroupDriver, "node-docker-cgroup-driver", "", "If set, configures the cgroup driver of docker and of the kubelet on docker nodes, either 'systemd' (default) or 'cgroupfs'")
+	flag.StringVar(&nodeDockerStorageDriver, "node-docker-storage-driver", "", "If set, configures the storage driver of docker instead of 'overlay2'")
 	flag.StringVar(&caBundleFile, "ca-bundle", "", "path to a file containing all PEM-encoded CA certificates (will be used instead of the host's certificates if set)")
 	flag.BoolVar(&nodeCSRApprover, "node-csr-approver", true, "Enable NodeCSRApprover controller to automatically approve node serving certificate requests")
 	flag.StringVar(&podCIDR, "pod-cidr", "172.25.0.0/16", "WARNING: flag is unused, kept only for backwards compatibility")
@@ -177,6 +202,11 @@ func main() {
 		klog.Fatalf("invalid cluster dns specified: %v", err)
 	}
 
+	clusterCIDRs, err := parseCIDRs(nodeNoProxyCIDRs)
+	if err != nil {
+		klog.Fatalf("invalid node no proxy cidrs specified: %v", err)
+	}
+
 	var parsedJoinClusterTimeout *time.Duration
 	if joinClusterTimeout != "" {
 		parsedJoinClusterTimeoutLiteral, err := time.ParseDuration(joinClusterTimeout)
@@ -186,6 +216,15 @@ func main() {
 		}
 	}
 
+	var parsedRebootNotReadyNodeAfter *time.Duration
+	if rebootNotReadyNodeAfter != "" {
+		parsedRebootNotReadyNodeAfterLiteral, err := time.ParseDuration(rebootNotReadyNodeAfter)
+		if err != nil {
+			klog.Fatalf("failed to parse reboot-not-ready-node-after as duration: %v", err)
+		}
+		parsedRebootNotReadyNodeAfter = &parsedRebootNotReadyNodeAfterLiteral
+	}
+
 	// Needed for migrations
 	if err := machinesv1alpha1.AddToScheme(scheme.Scheme); err != nil {
 		klog.Fatalf("failed to add machinesv1alpha1 api to scheme: %v", err)
@@ -237,8 +276,15 @@ func main() {
 		ContainerdRegistryMirrors: nodeContainerdRegistryMirrors,
 		InsecureRegistries:        nodeInsecureRegistries,
 		PauseImage:                nodePauseImage,
+		PauseImageRegistry:        nodePauseImageRegistry,
 		RegistryMirrors:           nodeRegistryMirrors,
 		RegistryCredentialsSecret: nodeRegistryCredentialsSecret,
+
+		ContainerdImagePullProgressTimeout: nodeContainerdImagePullProgressTimeout,
+		ContainerdMaxConcurrentDownloads:   nodeContainerdMaxConcurrentDownloads,
+
+		DockerCgroupDriver:  nodeDockerCgroupDriver,
+		DockerStorageDriver: nodeDockerStorageDriver,
 	}
 	containerRuntimeConfig, err := containerruntime.BuildConfig(containerRuntimeOpts)
 	if err != nil {
@@ -258,12 +304,15 @@ func main() {
 			ClusterDNSIPs:                clusterDNSIPs,
 			HTTPProxy:                    nodeHTTPProxy,
 			NoProxy:                      nodeNoProxy,
-			PauseImage:                   nodePauseImage,
+			ClusterCIDRs:                 clusterCIDRs,
+			PauseImage:                   containerRuntimeConfig.SandboxImage,
 			RegistryCredentialsSecretRef: nodeRegistryCredentialsSecret,
 			ContainerRuntime:             containerRuntimeConfig,
 		},
 		useOSM:        useOSM,
 		nodePortRange: nodePortRange,
+
+		providerConcurrencyLimit: providerConcurrencyLimit,
 	}
 
 	if err := nodeFlags.UpdateNodeSettings(&runOptions.node); err != nil {
@@ -274,6 +323,8 @@ func main() {
 		runOptions.joinClusterTimeout = parsedJoinClusterTimeout
 	}
 
+	runOptions.rebootNotReadyNodeAfter = parsedRebootNotReadyNodeAfter
+
 	if bootstrapTokenServiceAccountName != "" {
 		flagParts := strings.Split(bootstrapTokenServiceAccountName, "/")
 		if flagPartsLen := len(flagParts); flagPartsLen != 2 {
@@ -366,9 +417,10 @@ func (bs *controllerBootstrap) Start(ctx context.Context) error {
 	client := bs.mgr.GetClient()
 
 	providerData := &cloudprovidertypes.ProviderData{
-		Ctx:    ctx,
-		Update: cloudprovidertypes.GetMachineUpdater(ctx, client),
-		Client: client,
+		Ctx:      ctx,
+		Update:   cloudprovidertypes.GetMachineUpdater(ctx, client),
+		Client:   client,
+		Recorder: bs.mgr.GetEventRecorderFor(machinecontroller.ControllerName),
 	}
 
 	// Migrate MachinesV1Alpha1Machine to ClusterV1Alpha1Machine
@@ -393,12 +445,14 @@ func (bs *controllerBootstrap) Start(ctx context.Context) error {
 		bs.opt.kubeconfigProvider,
 		providerData,
 		bs.opt.joinClusterTimeout,
+		bs.opt.rebootNotReadyNodeAfter,
 		bs.opt.name,
 		bs.opt.bootstrapTokenServiceAccountName,
 		bs.opt.skipEvictionAfter,
 		bs.opt.node,
 		bs.opt.useOSM,
 		bs.opt.nodePortRange,
+		bs.opt.providerConcurrencyLimit,
 	); err != nil {
 		return fmt.Errorf("failed to add Machine controller to manager: %v", err)
 	}
@@ -434,3 +488,18 @@ func parseClusterDNSIPs(s string) ([]net.IP, error) {
 	}
 	return ips, nil
 }
+
+func parseCIDRs(s string) ([]string, error) {
+	var cidrs []string
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("unable to parse cidr %s: %v", cidr, err)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
@@ -17,11 +17,16 @@ limitations under the License.
 package admission
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
 	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
 func TestMachineDeploymentDefaulting(t *testing.T) {
@@ -51,6 +56,25 @@ func TestMachineDeploymentDefaulting(t *testing.T) {
 			},
 			isValid: true,
 		},
+		{
+			name: "MachineDeployment with OnDelete strategy validation should succeed",
+			machineDeployment: &clusterv1alpha1.MachineDeployment{
+				Spec: clusterv1alpha1.MachineDeploymentSpec{
+					Selector: metav1.LabelSelector{
+						MatchLabels: map[string]string{"foo": "bar"},
+					},
+					Template: clusterv1alpha1.MachineTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{
+							Labels: map[string]string{"foo": "bar"},
+						},
+					},
+					Strategy: &clusterv1alpha1.MachineDeploymentStrategy{
+						Type: common.OnDeleteMachineDeploymentStrategyType,
+					},
+				},
+			},
+			isValid: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -63,3 +87,205 @@ func TestMachineDeploymentDefaulting(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateAzurePrivateIPAddress(t *testing.T) {
+	tests := []struct {
+		name          string
+		cloudProvider string
+		spec          string
+		replicas      int32
+		isValid       bool
+	}{
+		{
+			name:          "single replica with a private IP address",
+			cloudProvider: "azure",
+			spec:          `{"privateIPAddress":"10.0.0.10"}`,
+			replicas:      1,
+			isValid:       true,
+		},
+		{
+			name:          "multiple replicas with a private IP address",
+			cloudProvider: "azure",
+			spec:          `{"privateIPAddress":"10.0.0.10"}`,
+			replicas:      2,
+		},
+		{
+			name:          "multiple replicas with a private IP address from a secret",
+			cloudProvider: "azure",
+			spec:          `{"privateIPAddress":{"secretKeyRef":{"name":"ips","namespace":"kube-system","key":"node"}}}`,
+			replicas:      2,
+		},
+		{
+			name:          "multiple replicas without a private IP address",
+			cloudProvider: "azure",
+			spec:          `{"vmSize":"Standard_D2s_v3"}`,
+			replicas:      3,
+			isValid:       true,
+		},
+		{
+			name:          "other cloud provider",
+			cloudProvider: "aws",
+			spec:          `{"privateIPAddress":"10.0.0.10"}`,
+			replicas:      3,
+			isValid:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			providerSpec := fmt.Sprintf(`{"cloudProvider":%q,"cloudProviderSpec":%s,"operatingSystem":"ubuntu"}`, test.cloudProvider, test.spec)
+			spec := &clusterv1alpha1.MachineDeploymentSpec{
+				Replicas: &test.replicas,
+				Template: clusterv1alpha1.MachineTemplateSpec{
+					Spec: clusterv1alpha1.MachineSpec{
+						ProviderSpec: clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(providerSpec)}},
+					},
+				},
+			}
+
+			errs := validateAzurePrivateIPAddress(spec, field.NewPath("spec"))
+			if test.isValid != (len(errs) == 0) {
+				t.Errorf("Expected MachineDeployment to be valid: %t but got %d errors: %v", test.isValid, len(errs), errs)
+			}
+		})
+	}
+}
+
+func TestValidateAzureDataDiskAttach(t *testing.T) {
+	const (
+		attachSpec = `{"dataDiskSource":{"sourceResourceID":"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/data","createOption":"Attach"}}`
+		copySpec   = `{"dataDiskSource":{"sourceResourceID":"/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/data","createOption":"Copy"}}`
+	)
+	onDelete := &clusterv1alpha1.MachineDeploymentStrategy{Type: common.OnDeleteMachineDeploymentStrategyType}
+	rollingUpdate := func(maxSurge, maxUnavailable intstr.IntOrString) *clusterv1alpha1.MachineDeploymentStrategy {
+		return &clusterv1alpha1.MachineDeploymentStrategy{
+			Type:          common.RollingUpdateMachineDeploymentStrategyType,
+			RollingUpdate: &clusterv1alpha1.MachineRollingUpdateDeployment{MaxSurge: &maxSurge, MaxUnavailable: &maxUnavailable},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		cloudProvider string
+		spec          string
+		replicas      int32
+		strategy      *clusterv1alpha1.MachineDeploymentStrategy
+		isValid       bool
+	}{
+		{
+			name:          "single replica deleted before it's replaced",
+			cloudProvider: "azure",
+			spec:          attachSpec,
+			replicas:      1,
+			strategy:      onDelete,
+			isValid:       true,
+		},
+		{
+			name:          "single replica without a surge",
+			cloudProvider: "azure",
+			spec:          attachSpec,
+			replicas:      1,
+			strategy:      rollingUpdate(intstr.FromInt(0), intstr.FromInt(1)),
+			isValid:       true,
+		},
+		{
+			name:          "single replica with a surge",
+			cloudProvider: "azure",
+			spec:          attachSpec,
+			replicas:      1,
+			strategy:      rollingUpdate(intstr.FromInt(1), intstr.FromInt(0)),
+		},
+		{
+			name:          "single replica with a percentage surge",
+			cloudProvider: "azure",
+			spec:          attachSpec,
+			replicas:      1,
+			strategy:      rollingUpdate(intstr.FromString("25%"), intstr.FromInt(0)),
+		},
+		{
+			name:          "multiple replicas",
+			cloudProvider: "azure",
+			spec:          attachSpec,
+			replicas:      2,
+			strategy:      onDelete,
+		},
+		{
+			name:          "multiple replicas with a copied disk",
+			cloudProvider: "azure",
+			spec:          copySpec,
+			replicas:      2,
+			strategy:      rollingUpdate(intstr.FromInt(1), intstr.FromInt(0)),
+			isValid:       true,
+		},
+		{
+			name:          "other cloud provider",
+			cloudProvider: "aws",
+			spec:          attachSpec,
+			replicas:      2,
+			strategy:      rollingUpdate(intstr.FromInt(1), intstr.FromInt(0)),
+			isValid:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			providerSpec := fmt.Sprintf(`{"cloudProvider":%q,"cloudProviderSpec":%s,"operatingSystem":"ubuntu"}`, test.cloudProvider, test.spec)
+			spec := &clusterv1alpha1.MachineDeploymentSpec{
+				Replicas: &test.replicas,
+				Strategy: test.strategy,
+				Template: clusterv1alpha1.MachineTemplateSpec{
+					Spec: clusterv1alpha1.MachineSpec{
+						ProviderSpec: clusterv1alpha1.ProviderSpec{Value: &runtime.RawExtension{Raw: []byte(providerSpec)}},
+					},
+				},
+			}
+
+			errs := validateAzureDataDiskAttach(spec, field.NewPath("spec"))
+			if test.isValid != (len(errs) == 0) {
+				t.Errorf("Expected MachineDeployment to be valid: %t but got %d errors: %v", test.isValid, len(errs), errs)
+			}
+		})
+	}
+}
+
+func TestValidateKubeletConfigs(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		isValid     bool
+	}{
+		{
+			name:    "no kubelet configs",
+			isValid: true,
+		},
+		{
+			name: "valid reservations",
+			annotations: map[string]string{
+				fmt.Sprintf("%s/%s", common.KubeletConfigAnnotationPrefixV1, common.KubeReservedKubeletConfig): "cpu=500m,memory=1Gi",
+				fmt.Sprintf("%s/%s", common.KubeletConfigAnnotationPrefixV1, common.EvictionHardKubeletConfig): "memory.available<200Mi",
+			},
+			isValid: true,
+		},
+		{
+			name: "malformed reservation",
+			annotations: map[string]string{
+				fmt.Sprintf("%s/%s", common.KubeletConfigAnnotationPrefixV1, common.SystemReservedKubeletConfig): "memory=1GB",
+			},
+		},
+		{
+			name: "enforcing kube-reserved without cgroup",
+			annotations: map[string]string{
+				fmt.Sprintf("%s/%s", common.KubeletConfigAnnotationPrefixV1, common.EnforceNodeAllocatableKubeletConfig): "pods,kube-reserved",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := validateKubeletConfigs(test.annotations, field.NewPath("spec", "template", "metadata", "annotations"))
+			if test.isValid != (len(errs) == 0) {
+				t.Errorf("Expected kubelet configs to be valid: %t but got %d errors: %v", test.isValid, len(errs), errs)
+			}
+		})
+	}
+}
@@ -20,9 +20,13 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-11-01/compute"
+
 	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
 	"github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+	azuretypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/azure/types"
 	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+	"github.com/kubermatic/machine-controller/pkg/userdata/helper"
 	osmresources "k8c.io/operating-system-manager/pkg/controllers/osc/resources"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -60,9 +64,82 @@ func validateMachineDeploymentSpec(spec *v1alpha1.MachineDeploymentSpec, fldPath
 		allErrs = append(allErrs, field.Invalid(fldPath.Child("replicas"), *spec.Replicas, "replicas must be specified and can not be negative"))
 	}
 	allErrs = append(allErrs, validateMachineDeploymentStrategy(spec.Strategy, fldPath.Child("strategy"))...)
+	allErrs = append(allErrs, validateAzurePrivateIPAddress(spec, fldPath)...)
+	allErrs = append(allErrs, validateAzureDataDiskAttach(spec, fldPath)...)
+	allErrs = append(allErrs, validateKubeletConfigs(spec.Template.Annotations, fldPath.Child("template", "metadata", "annotations"))...)
+	return allErrs
+}
+
+// validateKubeletConfigs rejects malformed kubelet configs, as they would only surface when the userdata of the
+// machines gets rendered.
+func validateKubeletConfigs(annotations map[string]string, fldPath *field.Path) field.ErrorList {
+	if err := helper.ValidateKubeletConfigs(common.GetKubeletConfigs(annotations)); err != nil {
+		return field.ErrorList{field.Invalid(fldPath, annotations, err.Error())}
+	}
+	return nil
+}
+
+// validateAzurePrivateIPAddress rejects a static private IP address of Azure VMs for more than one replica, as all
+// machines of the MachineDeployment would get the same address.
+func validateAzurePrivateIPAddress(spec *v1alpha1.MachineDeploymentSpec, fldPath *field.Path) field.ErrorList {
+	if spec.Replicas == nil || *spec.Replicas <= 1 {
+		return nil
+	}
+
+	rawConfig := azureRawConfig(spec)
+	if rawConfig == nil {
+		return nil
+	}
+
+	address := rawConfig.PrivateIPAddress
+	if address.Value == "" && address.SecretKeyRef.Name == "" && address.ConfigMapKeyRef.Name == "" {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(fldPath.Child("replicas"), *spec.Replicas, "privateIPAddress of Azure VMs can only be used with a single replica")}
+}
+
+// validateAzureDataDiskAttach rejects attaching an existing data disk to Azure VMs if the MachineDeployment can
+// have more than one machine at a time, as a managed disk can only be attached to a single VM. Besides more than one
+// replica, this applies to rolling updates which surge a new machine while the old one still has the disk.
+func validateAzureDataDiskAttach(spec *v1alpha1.MachineDeploymentSpec, fldPath *field.Path) field.ErrorList {
+	rawConfig := azureRawConfig(spec)
+	if rawConfig == nil || rawConfig.DataDiskSource == nil || rawConfig.DataDiskSource.CreateOption != string(compute.DiskCreateOptionAttach) {
+		return nil
+	}
+
+	allErrs := field.ErrorList{}
+	if spec.Replicas != nil && *spec.Replicas > 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("replicas"), *spec.Replicas, "an attached dataDiskSource of Azure VMs can only be used with a single replica"))
+	}
+
+	strategy := spec.Strategy
+	if strategy != nil && strategy.Type == common.RollingUpdateMachineDeploymentStrategyType && strategy.RollingUpdate != nil && strategy.RollingUpdate.MaxSurge != nil {
+		replicas := 1
+		if spec.Replicas != nil && *spec.Replicas > 1 {
+			replicas = int(*spec.Replicas)
+		}
+		if maxSurge, err := intstr.GetValueFromIntOrPercent(strategy.RollingUpdate.MaxSurge, replicas, true); err == nil && maxSurge > 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("strategy", "rollingUpdate", "maxSurge"), strategy.RollingUpdate.MaxSurge,
+				"an attached dataDiskSource of Azure VMs can't be used with a surge, the old machine has to be deleted before the disk can be attached to the new one"))
+		}
+	}
 	return allErrs
 }
 
+// azureRawConfig returns the Azure config of the machines, or nil for other or invalid provider specs. Invalid
+// provider specs are rejected by the validation of the provider.
+func azureRawConfig(spec *v1alpha1.MachineDeploymentSpec) *azuretypes.RawConfig {
+	providerConfig, err := providerconfigtypes.GetConfig(spec.Template.Spec.ProviderSpec)
+	if err != nil || providerConfig.CloudProvider != providerconfigtypes.CloudProviderAzure {
+		return nil
+	}
+	rawConfig, err := azuretypes.GetConfig(*providerConfig)
+	if err != nil {
+		return nil
+	}
+	return rawConfig
+}
+
 func validateMachineDeploymentStrategy(strategy *v1alpha1.MachineDeploymentStrategy, fldPath *field.Path) field.ErrorList {
 	allErrs := field.ErrorList{}
 	switch strategy.Type {
@@ -70,6 +147,7 @@ func validateMachineDeploymentStrategy(strategy *v1alpha1.MachineDeploymentStrat
 		if strategy.RollingUpdate != nil {
 			allErrs = append(allErrs, validateMachineRollingUpdateDeployment(strategy.RollingUpdate, fldPath.Child("rollingUpdate"))...)
 		}
+	case common.OnDeleteMachineDeploymentStrategyType:
 	default:
 		allErrs = append(allErrs, field.Invalid(fldPath.Child("Type"), strategy.Type, "is an invalid type"))
 	}
@@ -32,6 +32,7 @@ import (
 
 	admissionv1 "k8s.io/api/admission/v1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/klog"
 )
 
@@ -85,6 +86,9 @@ func (ad *admissionData) mutateMachines(ctx context.Context, ar admissionv1.Admi
 		if err := ad.defaultAndValidateMachineSpec(ctx, &machine.Spec); err != nil {
 			return nil, err
 		}
+		if errs := validateKubeletConfigs(machine.Annotations, field.NewPath("metadata", "annotations")); len(errs) > 0 {
+			return nil, errs.ToAggregate()
+		}
 
 		common.SetKubeletFeatureGates(&machine, ad.nodeSettings.KubeletFeatureGates)
 		common.SetKubeletFlags(&machine, map[common.KubeletFlags]string{
@@ -94,7 +98,7 @@ func (ad *admissionData) mutateMachines(ctx context.Context, ar admissionv1.Admi
 		if err != nil {
 			return nil, err
 		}
-		common.SetOSLabel(&machine.Spec, string(providerConfig.OperatingSystem))
+		common.SetOSLabel(&machine.Spec, string(providerConfig.OperatingSystem), false)
 	}
 
 	return createAdmissionResponse(machineOriginal, &machine)
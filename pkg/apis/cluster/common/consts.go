@@ -50,6 +50,14 @@ const (
 	// or running out of physical machines in an on-premise environment.
 	InsufficientResourcesMachineError MachineStatusError = "InsufficientResources"
 
+	// The image of the machine, e.g. a marketplace image or an image in a
+	// gallery, can't be found at the cloud provider. This is not a
+	// transient error, the image reference must be fixed.
+	//
+	// Example: the ProviderSpec refers to a version of an image that was
+	// removed from the marketplace.
+	ImageNotFoundMachineError MachineStatusError = "ImageNotFound"
+
 	// There was an error while trying to create a Node to match this
 	// Machine. This may indicate a transient problem that will be fixed
 	// automatically with time, such as a service outage, or a terminal
@@ -126,6 +134,10 @@ const (
 	// Replace the old MachineSet by new one using rolling update
 	// i.e. gradually scale down the old MachineSet and scale up the new one.
 	RollingUpdateMachineDeploymentStrategyType MachineDeploymentStrategyType = "RollingUpdate"
+
+	// Create a new MachineSet, but only replace old machines once they got deleted manually
+	// i.e. the old MachineSet is never scaled down automatically to roll out the new one.
+	OnDeleteMachineDeploymentStrategyType MachineDeploymentStrategyType = "OnDelete"
 )
 
 type KubeletFlags string
@@ -135,11 +147,15 @@ const (
 )
 
 const (
-	SystemReservedKubeletConfig       = "SystemReserved"
-	KubeReservedKubeletConfig         = "KubeReserved"
-	EvictionHardKubeletConfig         = "EvictionHard"
-	ContainerLogMaxSizeKubeletConfig  = "ContainerLogMaxSize"
-	ContainerLogMaxFilesKubeletConfig = "ContainerLogMaxFiles"
+	SystemReservedKubeletConfig         = "SystemReserved"
+	KubeReservedKubeletConfig           = "KubeReserved"
+	EvictionHardKubeletConfig           = "EvictionHard"
+	ContainerLogMaxSizeKubeletConfig    = "ContainerLogMaxSize"
+	ContainerLogMaxFilesKubeletConfig   = "ContainerLogMaxFiles"
+	EnforceNodeAllocatableKubeletConfig = "EnforceNodeAllocatable"
+	SystemReservedCgroupKubeletConfig   = "SystemReservedCgroup"
+	KubeReservedCgroupKubeletConfig     = "KubeReservedCgroup"
+	ResolvConfKubeletConfig             = "ResolvConf"
 )
 
 const (
@@ -219,14 +235,45 @@ func GetKubeletFlags(annotations map[string]string) map[KubeletFlags]string {
 
 const OperatingSystemLabelV1 = "v1.machine-controller.kubermatic.io/operating-system"
 
-func SetOSLabel(metaobj metav1.Object, osName string) {
+// OSLabelValue returns the canonical value of the operating system label for the given operating system name.
+// It takes the name as plain string, as the OperatingSystem type of the provider config depends on this package.
+func OSLabelValue(osName string) string {
+	return strings.ToLower(strings.TrimSpace(osName))
+}
+
+// SetOSLabel sets the operating system label if it's absent. An existing label is only overwritten
+// if force is set and its value doesn't match the given operating system anymore.
+func SetOSLabel(metaobj metav1.Object, osName string, force bool) {
 	lbs := metaobj.GetLabels()
+	value := OSLabelValue(osName)
 
-	if _, found := lbs[OperatingSystemLabelV1]; !found {
+	if current, found := lbs[OperatingSystemLabelV1]; !found || (force && current != value) {
 		if lbs == nil {
 			lbs = map[string]string{}
 		}
-		lbs[OperatingSystemLabelV1] = osName
+		lbs[OperatingSystemLabelV1] = value
 		metaobj.SetLabels(lbs)
 	}
 }
+
+// ProviderInstanceIDAnnotationV1 is set on Machine objects to record the ID of the instance at the cloud provider.
+const ProviderInstanceIDAnnotationV1 = "v1.machine-controller.kubermatic.io/provider-instance-id"
+
+// SetProviderInstanceID saves the ID of the cloud provider instance into the metaobject annotations.
+// An empty ID is ignored.
+func SetProviderInstanceID(metaobj metav1.Object, id string) {
+	if id == "" {
+		return
+	}
+	annts := metaobj.GetAnnotations()
+	if annts == nil {
+		annts = map[string]string{}
+	}
+	annts[ProviderInstanceIDAnnotationV1] = id
+	metaobj.SetAnnotations(annts)
+}
+
+// GetProviderInstanceID returns the ID of the cloud provider instance recorded in the annotations, if any.
+func GetProviderInstanceID(annotations map[string]string) string {
+	return annotations[ProviderInstanceIDAnnotationV1]
+}
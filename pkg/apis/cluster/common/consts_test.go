@@ -0,0 +1,96 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOSLabelValue(t *testing.T) {
+	for _, osName := range []string{"ubuntu", "Ubuntu", " UBUNTU "} {
+		if value := OSLabelValue(osName); value != "ubuntu" {
+			t.Errorf("expected label value %q for %q, got %q", "ubuntu", osName, value)
+		}
+	}
+}
+
+func TestSetOSLabel(t *testing.T) {
+	tests := []struct {
+		name          string
+		labels        map[string]string
+		osName        string
+		force         bool
+		expectedLabel string
+	}{
+		{
+			name:          "label is set if absent",
+			osName:        "Flatcar",
+			expectedLabel: "flatcar",
+		},
+		{
+			name:          "existing label is kept",
+			labels:        map[string]string{OperatingSystemLabelV1: "ubuntu"},
+			osName:        "flatcar",
+			expectedLabel: "ubuntu",
+		},
+		{
+			name:          "stale label is overwritten when forced",
+			labels:        map[string]string{OperatingSystemLabelV1: "ubuntu"},
+			osName:        "flatcar",
+			force:         true,
+			expectedLabel: "flatcar",
+		},
+		{
+			name:          "matching label is kept when forced",
+			labels:        map[string]string{OperatingSystemLabelV1: "rhel", "foo": "bar"},
+			osName:        "RHEL",
+			force:         true,
+			expectedLabel: "rhel",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta := &metav1.ObjectMeta{Labels: test.labels}
+			SetOSLabel(meta, test.osName, test.force)
+
+			if label := meta.Labels[OperatingSystemLabelV1]; label != test.expectedLabel {
+				t.Errorf("expected label %q, got %q", test.expectedLabel, label)
+			}
+		})
+	}
+}
+
+func TestProviderInstanceID(t *testing.T) {
+	meta := &metav1.ObjectMeta{}
+	SetProviderInstanceID(meta, "")
+	if meta.Annotations != nil {
+		t.Fatalf("expected no annotations for an empty instance ID, got %v", meta.Annotations)
+	}
+
+	SetProviderInstanceID(meta, "i-0123456789")
+	if id := GetProviderInstanceID(meta.Annotations); id != "i-0123456789" {
+		t.Errorf("expected instance ID %q, got %q", "i-0123456789", id)
+	}
+
+	SetProviderInstanceID(meta, "i-9876543210")
+	if id := GetProviderInstanceID(meta.Annotations); id != "i-9876543210" {
+		t.Errorf("expected instance ID %q, got %q", "i-9876543210", id)
+	}
+}
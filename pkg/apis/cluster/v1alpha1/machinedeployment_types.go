@@ -74,8 +74,10 @@ type MachineDeploymentSpec struct {
 // MachineDeploymentStrategy describes how to replace existing machines
 // with new ones.
 type MachineDeploymentStrategy struct {
-	// Type of deployment. Currently the only supported strategy is
-	// "RollingUpdate".
+	// Type of deployment. Either "RollingUpdate" or "OnDelete".
+	// With "OnDelete", the machines of old MachineSets are only replaced by
+	// machines of the new MachineSet once they got deleted manually, old
+	// MachineSets are not scaled down automatically.
 	// Default is RollingUpdate.
 	// +optional
 	Type common.MachineDeploymentStrategyType `json:"type,omitempty"`
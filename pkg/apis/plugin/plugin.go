@@ -41,14 +41,16 @@ const (
 
 // UserDataRequest requests user data with the given arguments.
 type UserDataRequest struct {
-	MachineSpec              clusterv1alpha1.MachineSpec
-	Kubeconfig               *clientcmdapi.Config
-	CloudProviderName        string
-	CloudConfig              string
-	DNSIPs                   []net.IP
-	ExternalCloudProvider    bool
-	HTTPProxy                string
-	NoProxy                  string
+	MachineSpec           clusterv1alpha1.MachineSpec
+	Kubeconfig            *clientcmdapi.Config
+	CloudProviderName     string
+	CloudConfig           string
+	DNSIPs                []net.IP
+	ExternalCloudProvider bool
+	HTTPProxy             string
+	NoProxy               string
+	// ClusterCIDRs are the pod and service CIDRs of the cluster, which are added to NO_PROXY
+	ClusterCIDRs             []string
 	PauseImage               string
 	KubeletCloudProviderName string
 	KubeletFeatureGates      map[string]bool
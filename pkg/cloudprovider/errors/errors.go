@@ -26,12 +26,22 @@ import (
 var (
 	// ErrInstanceNotFound tells that the requested instance was not found on the cloud provider
 	ErrInstanceNotFound = errors.New("instance not found")
+
+	// ErrInstanceFailed tells that the requested instance exists on the cloud provider, but it's in a failed state
+	// it won't recover from. Providers may wrap it to add details. The machine controller deletes such instances
+	// with Cleanup, so they get recreated afterwards.
+	ErrInstanceFailed = errors.New("instance failed")
 )
 
 func IsNotFound(err error) bool {
 	return err == ErrInstanceNotFound
 }
 
+// IsInstanceFailed tells whether the error is or wraps ErrInstanceFailed.
+func IsInstanceFailed(err error) bool {
+	return errors.Is(err, ErrInstanceFailed)
+}
+
 // TerminalError is a helper struct that holds errors of type "terminal"
 type TerminalError struct {
 	Reason  common.MachineStatusError
@@ -50,3 +60,14 @@ func IsTerminalError(err error) (bool, common.MachineStatusError, string) {
 	}
 	return true, tError.Reason, tError.Message
 }
+
+// FieldValidationError is returned when the value of a field of the provider config is invalid,
+// so callers can map the error back to the offending field.
+type FieldValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (fe FieldValidationError) Error() string {
+	return fmt.Sprintf("failed to get the value of %q field, error = %v", fe.Field, fe.Reason)
+}
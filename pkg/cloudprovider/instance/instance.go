@@ -30,6 +30,20 @@ type Instance interface {
 	Status() Status
 }
 
+// HostNamer is implemented by instances whose hostname is assigned by the cloud provider, callers have to
+// type-assert the instance.
+type HostNamer interface {
+	// HostName returns the hostname or FQDN of the instance, an empty string if it's not known.
+	HostName() string
+}
+
+// StatusMessager is implemented by instances which can explain their status, e.g. the provisioning progress, callers
+// have to type-assert the instance.
+type StatusMessager interface {
+	// StatusMessage returns a human readable description of the instance status, an empty string if there is none.
+	StatusMessage() string
+}
+
 // Status represents the instance status.
 type Status string
 
@@ -38,5 +52,9 @@ const (
 	StatusDeleting Status = "deleting"
 	StatusDeleted  Status = "deleted"
 	StatusCreating Status = "creating"
-	StatusUnknown  Status = "unknown"
+	// StatusEvicted means that the instance got evicted by the cloud provider, e.g. a spot instance, and is going to be recreated
+	StatusEvicted Status = "evicted"
+	// StatusStopped means that the instance got stopped, e.g. deliberately by its owner, it exists but doesn't run
+	StatusStopped Status = "stopped"
+	StatusUnknown Status = "unknown"
 )
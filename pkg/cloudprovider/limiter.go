@@ -0,0 +1,100 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"sync"
+
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+)
+
+// defaultConcurrencyLimit is the number of concurrent Create and Cleanup calls of a cloud provider, unless the
+// provider has its own default in providerConcurrencyLimits.
+const defaultConcurrencyLimit = 20
+
+var (
+	// providerConcurrencyLimits are the defaults of providers whose APIs are rate limited more strictly
+	providerConcurrencyLimits = map[providerconfigtypes.CloudProvider]int{
+		providerconfigtypes.CloudProviderAzure: 10,
+	}
+
+	limitersLock sync.Mutex
+	// limiters are shared by all providers returned by ForProvider, a new provider is created for every request
+	limiters = map[limiterKey]*concurrencyLimiter{}
+)
+
+type limiterKey struct {
+	provider providerconfigtypes.CloudProvider
+	limit    int
+}
+
+// Option configures the provider returned by ForProvider.
+type Option func(*options)
+
+type options struct {
+	concurrencyLimit int
+}
+
+// WithConcurrencyLimit limits the number of concurrent Create and Cleanup calls of a cloud provider, it overrides the
+// provider's default. A limit lower than 1 keeps the default.
+func WithConcurrencyLimit(limit int) Option {
+	return func(o *options) {
+		if limit > 0 {
+			o.concurrencyLimit = limit
+		}
+	}
+}
+
+// concurrencyLimiter is a semaphore for the calls to a cloud provider API.
+type concurrencyLimiter struct {
+	slots chan struct{}
+}
+
+func newConcurrencyLimiter(limit int) *concurrencyLimiter {
+	return &concurrencyLimiter{slots: make(chan struct{}, limit)}
+}
+
+// acquire blocks until a slot is free, the returned func releases it. A nil limiter doesn't limit the calls.
+func (l *concurrencyLimiter) acquire() func() {
+	if l == nil {
+		return func() {}
+	}
+	l.slots <- struct{}{}
+	return func() {
+		<-l.slots
+	}
+}
+
+// sharedConcurrencyLimiter returns the limiter of the given cloud provider, it is created on first use.
+func sharedConcurrencyLimiter(provider providerconfigtypes.CloudProvider, opts ...Option) *concurrencyLimiter {
+	o := options{concurrencyLimit: defaultConcurrencyLimit}
+	if limit, found := providerConcurrencyLimits[provider]; found {
+		o.concurrencyLimit = limit
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	limitersLock.Lock()
+	defer limitersLock.Unlock()
+
+	key := limiterKey{provider: provider, limit: o.concurrencyLimit}
+	if _, found := limiters[key]; !found {
+		limiters[key] = newConcurrencyLimiter(o.concurrencyLimit)
+	}
+	return limiters[key]
+}
@@ -107,10 +107,30 @@ var (
 	}
 )
 
+// OperatingSystemsLister is implemented by all cloud providers, it can't be part of cloudprovidertypes.Provider
+// as the provider config types depend on that package.
+type OperatingSystemsLister interface {
+	// SupportedOperatingSystems returns the operating systems which are supported by the provider
+	SupportedOperatingSystems() []providerconfigtypes.OperatingSystem
+}
+
+// SupportedOperatingSystems returns the operating systems which are supported by the requested provider
+func SupportedOperatingSystems(p providerconfigtypes.CloudProvider) ([]providerconfigtypes.OperatingSystem, error) {
+	provider, err := ForProvider(p, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.(OperatingSystemsLister).SupportedOperatingSystems(), nil
+}
+
 // ForProvider returns a CloudProvider actuator for the requested provider
-func ForProvider(p providerconfigtypes.CloudProvider, cvr *providerconfig.ConfigVarResolver) (cloudprovidertypes.Provider, error) {
-	if p, found := providers[p]; found {
-		return NewValidationCacheWrappingCloudProvider(p(cvr)), nil
+func ForProvider(p providerconfigtypes.CloudProvider, cvr *providerconfig.ConfigVarResolver, opts ...Option) (cloudprovidertypes.Provider, error) {
+	if newProvider, found := providers[p]; found {
+		return &cachingValidationWrapper{
+			actualProvider: newProvider(cvr),
+			limiter:        sharedConcurrencyLimiter(p, opts...),
+		}, nil
 	}
 	return nil, ErrProviderNotFound
 }
@@ -340,6 +340,15 @@ func (p *provider) SetMetricsForMachines(machines clusterv1alpha1.MachineList) e
 	return nil
 }
 
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p *provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	// images are only looked up for these operating systems
+	return []providerconfigtypes.OperatingSystem{
+		providerconfigtypes.OperatingSystemUbuntu,
+		providerconfigtypes.OperatingSystemCentOS,
+	}
+}
+
 func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*Config, *providerconfigtypes.Config, error) {
 	if provSpec.Value == nil {
 		return nil, nil, errors.New("machine.spec.providerconfig.value is nil")
@@ -482,6 +482,12 @@ func (p *provider) SetMetricsForMachines(_ clusterv1alpha1.MachineList) error {
 	return nil
 }
 
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p *provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	// templates are provided by the user
+	return providerconfigtypes.AllOperatingSystems
+}
+
 func getClient(token string) (anxclient.Client, error) {
 	tokenOpt := anxclient.TokenFromString(token)
 	client := anxclient.HTTPClient(&http.Client{Timeout: 30 * time.Second})
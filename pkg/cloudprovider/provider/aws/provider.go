@@ -1197,6 +1197,14 @@ func (p *provider) SetMetricsForMachines(machines clusterv1alpha1.MachineList) e
 	return nil
 }
 
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p *provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	return providerconfigtypes.FilterOperatingSystems(func(os providerconfigtypes.OperatingSystem) bool {
+		_, ok := amiFilters[os]
+		return ok
+	})
+}
+
 func getIntanceCountForMachine(machine clusterv1alpha1.Machine, reservations []*ec2.Reservation) float64 {
 	var count float64
 	for _, reservation := range reservations {
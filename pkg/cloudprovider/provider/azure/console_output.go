@@ -0,0 +1,149 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Azure/go-autorest/autorest/to"
+
+	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+)
+
+const (
+	// consoleOutputSASExpiration is the lifetime in minutes of the SAS URI of the serial console log blob
+	consoleOutputSASExpiration = 5
+	// defaultConsoleOutputMaxBytes is the amount of console output returned if no limit is given
+	defaultConsoleOutputMaxBytes = 64 * 1024
+)
+
+// ErrConsoleOutputUnavailable is returned if the serial console log of a VM can't be fetched, either because
+// boot diagnostics are disabled or because the log got rotated out of the diagnostics storage.
+var ErrConsoleOutputUnavailable = errors.New("serial console output is unavailable")
+
+// ConsoleOutputOptions limit the serial console output returned by GetConsoleOutput.
+type ConsoleOutputOptions struct {
+	// MaxBytes is the maximum amount of bytes returned, it defaults to 64KiB.
+	MaxBytes int64
+	// Tail returns the end of the log instead of its beginning if it exceeds MaxBytes.
+	Tail bool
+}
+
+// GetConsoleOutput returns the serial console output of the VM of the machine, which requires boot diagnostics
+// to be enabled.
+func (p *provider) GetConsoleOutput(ctx context.Context, machine *clusterv1alpha1.Machine, opts ConsoleOutputOptions) (string, error) {
+	config, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse MachineSpec: %v", err)
+	}
+
+	if !config.EnableBootDiagnostics {
+		return "", fmt.Errorf("%w: boot diagnostics are not enabled", ErrConsoleOutputUnavailable)
+	}
+
+	vmClient, err := getVMClient(config)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := vmClient.RetrieveBootDiagnosticsData(ctx, config.ResourceGroup, machine.Name, to.Int32Ptr(consoleOutputSASExpiration))
+	if err != nil {
+		if result.StatusCode == http.StatusNotFound {
+			return "", fmt.Errorf("%w: no boot diagnostics data found for VM %q", ErrConsoleOutputUnavailable, machine.Name)
+		}
+		return "", fmt.Errorf("failed to retrieve boot diagnostics data for VM %q: %v", machine.Name, err)
+	}
+
+	if result.SerialConsoleLogBlobURI == nil || *result.SerialConsoleLogBlobURI == "" {
+		return "", fmt.Errorf("%w: VM %q has no serial console log", ErrConsoleOutputUnavailable, machine.Name)
+	}
+
+	return fetchConsoleOutput(ctx, http.DefaultClient, *result.SerialConsoleLogBlobURI, opts)
+}
+
+// fetchConsoleOutput downloads the serial console log blob and limits it according to the options. Only the
+// requested range of the blob is downloaded, if the storage ignores the range, the log is limited while reading it.
+func fetchConsoleOutput(ctx context.Context, client *http.Client, blobURI string, opts ConsoleOutputOptions) (string, error) {
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultConsoleOutputMaxBytes
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, blobURI, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request for serial console log: %v", err)
+	}
+	if opts.Tail {
+		req.Header.Set("Range", fmt.Sprintf("bytes=-%d", maxBytes))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", maxBytes-1))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch serial console log: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the log is empty
+		return "", nil
+	case http.StatusNotFound:
+		// the diagnostics storage rotated out the log
+		return "", fmt.Errorf("%w: serial console log got rotated out of the diagnostics storage", ErrConsoleOutputUnavailable)
+	default:
+		return "", fmt.Errorf("failed to fetch serial console log: unexpected status code %d", resp.StatusCode)
+	}
+
+	var output []byte
+	if opts.Tail && resp.StatusCode == http.StatusOK {
+		output, err = readTail(resp.Body, maxBytes)
+	} else {
+		output, err = io.ReadAll(io.LimitReader(resp.Body, maxBytes))
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read serial console log: %v", err)
+	}
+
+	return string(output), nil
+}
+
+// readTail returns the last n bytes of r, only keeping at most n bytes and one chunk of r in memory.
+func readTail(r io.Reader, n int64) ([]byte, error) {
+	tail := make([]byte, 0, n)
+	chunk := make([]byte, 32*1024)
+	for {
+		read, err := r.Read(chunk)
+		tail = append(tail, chunk[:read]...)
+		if excess := int64(len(tail)) - n; excess > 0 {
+			tail = append(tail[:0], tail[excess:]...)
+		}
+
+		if err == io.EOF {
+			return tail, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
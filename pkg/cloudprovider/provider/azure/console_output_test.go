@@ -0,0 +1,142 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchConsoleOutput(t *testing.T) {
+	blob := "line 1\nline 2\nline 3\n"
+
+	large := strings.Repeat("a", defaultConsoleOutputMaxBytes+10)
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		switch r.URL.Path {
+		case "/serial.log":
+			http.ServeContent(w, r, "serial.log", time.Time{}, strings.NewReader(blob))
+		case "/large.log":
+			http.ServeContent(w, r, "large.log", time.Time{}, strings.NewReader(large))
+		case "/empty.log":
+			http.ServeContent(w, r, "empty.log", time.Time{}, strings.NewReader(""))
+		case "/no-ranges.log":
+			// storage which ignores the requested range
+			_, _ = w.Write([]byte(large + blob))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		path           string
+		opts           ConsoleOutputOptions
+		expectedOutput string
+		expectedLength int
+		expectedRange  string
+		unavailable    bool
+	}{
+		{
+			name:           "whole log",
+			path:           "/serial.log",
+			expectedOutput: blob,
+		},
+		{
+			name:           "limited log",
+			path:           "/serial.log",
+			opts:           ConsoleOutputOptions{MaxBytes: 7},
+			expectedOutput: "line 1\n",
+			expectedRange:  "bytes=0-6",
+		},
+		{
+			name:           "tail of log",
+			path:           "/serial.log",
+			opts:           ConsoleOutputOptions{MaxBytes: 7, Tail: true},
+			expectedOutput: "line 3\n",
+			expectedRange:  "bytes=-7",
+		},
+		{
+			name:           "default limit",
+			path:           "/large.log",
+			opts:           ConsoleOutputOptions{Tail: true},
+			expectedLength: defaultConsoleOutputMaxBytes,
+			expectedRange:  fmt.Sprintf("bytes=-%d", defaultConsoleOutputMaxBytes),
+		},
+		{
+			name:           "tail of empty log",
+			path:           "/empty.log",
+			opts:           ConsoleOutputOptions{Tail: true},
+			expectedOutput: "",
+		},
+		{
+			name:           "tail without range support",
+			path:           "/no-ranges.log",
+			opts:           ConsoleOutputOptions{MaxBytes: 14, Tail: true},
+			expectedOutput: "line 2\nline 3\n",
+		},
+		{
+			name:           "beginning without range support",
+			path:           "/no-ranges.log",
+			opts:           ConsoleOutputOptions{MaxBytes: 3},
+			expectedOutput: "aaa",
+		},
+		{
+			name:        "rotated log",
+			path:        "/rotated.log",
+			unavailable: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranges = nil
+			output, err := fetchConsoleOutput(context.Background(), server.Client(), server.URL+test.path, test.opts)
+			if test.unavailable {
+				if !errors.Is(err, ErrConsoleOutputUnavailable) {
+					t.Fatalf("expected console output to be unavailable, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to fetch console output: %v", err)
+			}
+			if test.expectedRange != "" && (len(ranges) != 1 || ranges[0] != test.expectedRange) {
+				t.Errorf("expected range %q to be requested, got %v", test.expectedRange, ranges)
+			}
+
+			if test.expectedLength > 0 {
+				if len(output) != test.expectedLength {
+					t.Errorf("expected %d bytes of output, got %d", test.expectedLength, len(output))
+				}
+				return
+			}
+			if output != test.expectedOutput {
+				t.Errorf("expected output %q, got %q", test.expectedOutput, output)
+			}
+		})
+	}
+}
@@ -19,18 +19,72 @@ package azure
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-11-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-05-01/network"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2020-10-01/resources"
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/to"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
+	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	azuretypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/azure/types"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/util"
 
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
 )
 
+// hasMachineUIDTag returns true if the resource tags contain the UID of the machine. All resources are tagged
+// when they are created, so they can be found for the cleanup even if creating the machine failed midway.
+func hasMachineUIDTag(tags map[string]*string, machineUID types.UID) bool {
+	return tags[machineUIDTag] != nil && *tags[machineUIDTag] == string(machineUID)
+}
+
+// resourcePage is a page of a list of Azure resources, the SDK provides one for each type of resource.
+type resourcePage[T any] interface {
+	NotDone() bool
+	Values() []T
+	NextWithContext(ctx context.Context) error
+}
+
+// filterByMachineUID iterates all pages of the list and returns the resources tagged with the machine's UID.
+func filterByMachineUID[T any](ctx context.Context, list resourcePage[T], tags func(T) map[string]*string, machineUID types.UID) ([]T, error) {
+	var matching []T
+	for list.NotDone() {
+		for _, resource := range list.Values() {
+			if hasMachineUIDTag(tags(resource), machineUID) {
+				matching = append(matching, resource)
+			}
+		}
+		if err := list.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("failed to iterate the result list: %v", err)
+		}
+	}
+
+	return matching, nil
+}
+
+func vmTags(vm compute.VirtualMachine) map[string]*string {
+	return vm.Tags
+}
+
+func diskTags(disk compute.Disk) map[string]*string {
+	return disk.Tags
+}
+
+func interfaceTags(iface network.Interface) map[string]*string {
+	return iface.Tags
+}
+
+func publicIPAddressTags(ip network.PublicIPAddress) map[string]*string {
+	return ip.Tags
+}
+
 // deleteInterfacesByMachineUID will remove all network interfaces tagged with the specific machine's UID.
 // The machine has to be deleted or disassociated with the interfaces beforehand, since Azure won't allow
 // us to remove interfaces connected to a VM.
@@ -40,30 +94,114 @@ func deleteInterfacesByMachineUID(ctx context.Context, c *config, machineUID typ
 		return fmt.Errorf("failed to create interfaces client: %v", err)
 	}
 
-	list, err := ifClient.List(ctx, c.ResourceGroup)
+	interfaces, err := getInterfacesByMachineUID(ctx, ifClient, c, machineUID)
 	if err != nil {
-		return fmt.Errorf("failed to list interfaces in resource group %q", c.ResourceGroup)
+		return err
 	}
 
-	var allInterfaces []network.Interface
+	for _, iface := range interfaces {
+		future, err := ifClient.Delete(ctx, c.NetworkResourceGroup, *iface.Name)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to delete interface %s: %v", *iface.Name, err)
+		}
 
-	for list.NotDone() {
-		allInterfaces = append(allInterfaces, list.Values()...)
-		if err = list.NextWithContext(ctx); err != nil {
-			return fmt.Errorf("failed to iterate the result list: %s", err)
+		if err = waitForOperation(ctx, future, ifClient.Client, 0, operationTimeout, fmt.Sprintf("deletion of interface %s", *iface.Name)); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to wait for deletion of interface %s: %v", *iface.Name, err)
 		}
 	}
 
-	for _, iface := range allInterfaces {
-		if iface.Tags != nil && iface.Tags[machineUIDTag] != nil && *iface.Tags[machineUIDTag] == string(machineUID) {
-			future, err := ifClient.Delete(ctx, c.ResourceGroup, *iface.Name)
-			if err != nil {
-				return err
+	return nil
+}
+
+// getInterfacesByMachineUID returns the network interfaces in the resource group which are tagged with the
+// machine's UID.
+func getInterfacesByMachineUID(ctx context.Context, ifClient *network.InterfacesClient, c *config, machineUID types.UID) ([]network.Interface, error) {
+	list, err := ifClient.List(ctx, c.NetworkResourceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces in resource group %q: %v", c.NetworkResourceGroup, err)
+	}
+
+	return filterByMachineUID[network.Interface](ctx, &list, interfaceTags, machineUID)
+}
+
+// hasSecurityGroups returns whether the NIC of a VM gets associated with security groups.
+func hasSecurityGroups(c *config) bool {
+	return c.SecurityGroupName != "" || len(c.ApplicationSecurityGroups) > 0
+}
+
+// setApplicationSecurityGroups sets the application security groups of all IP configurations of the NIC.
+func setApplicationSecurityGroups(iface *network.Interface, applicationSecurityGroups []network.ApplicationSecurityGroup) {
+	if iface.InterfacePropertiesFormat == nil || iface.IPConfigurations == nil {
+		return
+	}
+
+	for i := range *iface.IPConfigurations {
+		ipConfig := &(*iface.IPConfigurations)[i]
+		if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil {
+			continue
+		}
+		if len(applicationSecurityGroups) == 0 {
+			ipConfig.ApplicationSecurityGroups = nil
+			continue
+		}
+		ipConfig.ApplicationSecurityGroups = &applicationSecurityGroups
+	}
+}
+
+// removeSecurityGroupAssociations removes the network and application security groups from the NIC and returns
+// whether it had any. Only the associations are removed, the security groups are kept.
+func removeSecurityGroupAssociations(iface *network.Interface) bool {
+	if iface.InterfacePropertiesFormat == nil {
+		return false
+	}
+
+	changed := iface.NetworkSecurityGroup != nil
+	iface.NetworkSecurityGroup = nil
+
+	if iface.IPConfigurations != nil {
+		for _, ipConfig := range *iface.IPConfigurations {
+			if ipConfig.InterfaceIPConfigurationPropertiesFormat != nil && ipConfig.ApplicationSecurityGroups != nil && len(*ipConfig.ApplicationSecurityGroups) > 0 {
+				changed = true
 			}
+		}
+	}
+	setApplicationSecurityGroups(iface, nil)
+
+	return changed
+}
 
-			if err = future.WaitForCompletionRef(ctx, ifClient.Client); err != nil {
-				return err
+// dissociateSecurityGroupsByMachineUID removes the security groups from the network interfaces tagged with the
+// machine's UID, so no associations are left behind by the controller, e.g. if the NIC can't be deleted.
+func dissociateSecurityGroupsByMachineUID(ctx context.Context, c *config, machineUID types.UID) error {
+	ifClient, err := getInterfacesClient(c)
+	if err != nil {
+		return fmt.Errorf("failed to create interfaces client: %v", err)
+	}
+
+	interfaces, err := getInterfacesByMachineUID(ctx, ifClient, c, machineUID)
+	if err != nil {
+		return err
+	}
+
+	for i := range interfaces {
+		iface := &interfaces[i]
+		if !removeSecurityGroupAssociations(iface) {
+			continue
+		}
+
+		future, err := ifClient.CreateOrUpdate(ctx, c.NetworkResourceGroup, *iface.Name, *iface)
+		if err != nil {
+			if isNotFound(err) {
+				continue
 			}
+			return err
+		}
+
+		if err = waitForOperation(ctx, future, ifClient.Client, 0, operationTimeout, fmt.Sprintf("update of interface %s", *iface.Name)); err != nil && !isNotFound(err) {
+			return err
 		}
 	}
 
@@ -79,70 +217,70 @@ func deleteIPAddressesByMachineUID(ctx context.Context, c *config, machineUID ty
 		return fmt.Errorf("failed to create IP addresses client: %v", err)
 	}
 
-	list, err := ipClient.List(ctx, c.ResourceGroup)
+	list, err := ipClient.List(ctx, c.NetworkResourceGroup)
 	if err != nil {
-		return fmt.Errorf("failed to list public IP addresses in resource group %q", c.ResourceGroup)
+		return fmt.Errorf("failed to list public IP addresses in resource group %q: %v", c.NetworkResourceGroup, err)
 	}
 
-	var allIPs []network.PublicIPAddress
-
-	for list.NotDone() {
-		allIPs = append(allIPs, list.Values()...)
-		if err = list.Next(); err != nil {
-			return fmt.Errorf("failed to iterate the result list: %s", err)
-		}
+	ips, err := filterByMachineUID[network.PublicIPAddress](ctx, &list, publicIPAddressTags, machineUID)
+	if err != nil {
+		return err
 	}
 
-	for _, ip := range allIPs {
-		if ip.Tags != nil && ip.Tags[machineUIDTag] != nil && *ip.Tags[machineUIDTag] == string(machineUID) {
-			future, err := ipClient.Delete(ctx, c.ResourceGroup, *ip.Name)
-			if err != nil {
-				return err
+	for _, ip := range ips {
+		future, err := ipClient.Delete(ctx, c.NetworkResourceGroup, *ip.Name)
+		if err != nil {
+			if isNotFound(err) {
+				continue
 			}
+			return fmt.Errorf("failed to delete public IP address %s: %v", *ip.Name, err)
+		}
 
-			if err = future.WaitForCompletionRef(ctx, ipClient.Client); err != nil {
-				return err
-			}
+		if err = waitForOperation(ctx, future, ipClient.Client, 0, operationTimeout, fmt.Sprintf("deletion of public IP address %s", *ip.Name)); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to wait for deletion of public IP address %s: %v", *ip.Name, err)
 		}
 	}
 
 	return nil
 }
 
+// deleteVMsByMachineUID will remove the VMs in the resource group which are tagged with the machine's UID.
 func deleteVMsByMachineUID(ctx context.Context, c *config, machineUID types.UID) error {
 	vmClient, err := getVMClient(c)
 	if err != nil {
 		return err
 	}
 
-	list, err := vmClient.ListAll(ctx, "", "")
+	vms, err := getVMsByMachineUID(ctx, vmClient, c, machineUID)
 	if err != nil {
 		return err
 	}
 
-	var allServers []compute.VirtualMachine
+	for _, vm := range vms {
+		future, err := vmClient.Delete(ctx, c.ResourceGroup, *vm.Name, nil)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to delete VM %s: %v", *vm.Name, err)
+		}
 
-	for list.NotDone() {
-		allServers = append(allServers, list.Values()...)
-		if err = list.Next(); err != nil {
-			return fmt.Errorf("failed to iterate the result list: %s", err)
+		if err = waitForOperation(ctx, future, vmClient.Client, c.VMPollInterval, c.VMDeleteTimeout, fmt.Sprintf("deletion of VM %s", *vm.Name)); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to wait for deletion of VM %s: %v", *vm.Name, err)
 		}
 	}
 
-	for _, vm := range allServers {
-		if vm.Tags != nil && vm.Tags[machineUIDTag] != nil && *vm.Tags[machineUIDTag] == string(machineUID) {
-			future, err := vmClient.Delete(ctx, c.ResourceGroup, *vm.Name, nil)
-			if err != nil {
-				return err
-			}
+	return nil
+}
 
-			if err = future.WaitForCompletionRef(ctx, vmClient.Client); err != nil {
-				return err
-			}
-		}
+// getVMsByMachineUID returns the VMs in the resource group which are tagged with the machine's UID.
+func getVMsByMachineUID(ctx context.Context, vmClient *compute.VirtualMachinesClient, c *config, machineUID types.UID) ([]compute.VirtualMachine, error) {
+	list, err := vmClient.List(ctx, c.ResourceGroup, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs in resource group %q: %v", c.ResourceGroup, err)
 	}
 
-	return nil
+	return filterByMachineUID[compute.VirtualMachine](ctx, &list, vmTags, machineUID)
 }
 
 func deleteDisksByMachineUID(ctx context.Context, c *config, machineUID types.UID) error {
@@ -159,10 +297,13 @@ func deleteDisksByMachineUID(ctx context.Context, c *config, machineUID types.UI
 	for _, disk := range matchingDisks {
 		future, err := disksClient.Delete(ctx, c.ResourceGroup, *disk.Name)
 		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
 			return fmt.Errorf("failed to delete disk %s: %v", *disk.Name, err)
 		}
 
-		if err = future.WaitForCompletionRef(ctx, disksClient.Client); err != nil {
+		if err = waitForOperation(ctx, future, disksClient.Client, 0, operationTimeout, fmt.Sprintf("deletion of disk %s", *disk.Name)); err != nil && !isNotFound(err) {
 			return fmt.Errorf("failed to wait for deletion of disk %s: %v", *disk.Name, err)
 		}
 	}
@@ -170,57 +311,324 @@ func deleteDisksByMachineUID(ctx context.Context, c *config, machineUID types.UI
 	return nil
 }
 
+// isOSDisk returns whether the disk holds an operating system, data disks have no OS type.
+func isOSDisk(disk compute.Disk) bool {
+	return disk.DiskProperties != nil && disk.OsType != ""
+}
+
+// retainedDiskTags returns the tags of a retained disk, the machine UID tag is replaced by the name and the UID of
+// the machine and the time it got deleted.
+func retainedDiskTags(tags map[string]*string, machine *clusterv1alpha1.Machine, now time.Time) map[string]*string {
+	retainedTags := map[string]*string{}
+	for key, value := range tags {
+		if key != machineUIDTag {
+			retainedTags[key] = value
+		}
+	}
+
+	retainedTags[retainedMachineNameTag] = to.StringPtr(machine.Name)
+	retainedTags[retainedMachineUIDTag] = to.StringPtr(string(machine.UID))
+	retainedTags[retainedAtTag] = to.StringPtr(now.UTC().Format(time.RFC3339))
+
+	return retainedTags
+}
+
+// retainOSDisks re-tags the OS disks of the machine, so they are kept when the other disks of the machine get
+// deleted. The VM has to be deleted beforehand.
+func retainOSDisks(ctx context.Context, c *config, machine *clusterv1alpha1.Machine) error {
+	disksClient, err := getDisksClient(c)
+	if err != nil {
+		return fmt.Errorf("failed to get disks client: %v", err)
+	}
+
+	disks, err := getDisksByMachineUID(ctx, disksClient, c, machine.UID)
+	if err != nil {
+		return err
+	}
+
+	for _, disk := range disks {
+		if !isOSDisk(disk) {
+			continue
+		}
+
+		klog.Infof("retaining OS disk %s of VM %q", *disk.Name, machine.Name)
+		future, err := disksClient.Update(ctx, c.ResourceGroup, *disk.Name, compute.DiskUpdate{Tags: retainedDiskTags(disk.Tags, machine, time.Now())})
+		if err != nil {
+			return fmt.Errorf("failed to update tags of disk %s: %v", *disk.Name, err)
+		}
+
+		if err = waitForOperation(ctx, future, disksClient.Client, 0, operationTimeout, fmt.Sprintf("tag update of disk %s", *disk.Name)); err != nil {
+			return fmt.Errorf("failed to wait for the tag update of disk %s: %v", *disk.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// getDisksByMachineUID returns the disks in the resource group which are tagged with the machine's UID.
 func getDisksByMachineUID(ctx context.Context, disksClient *compute.DisksClient, c *config, UID types.UID) ([]compute.Disk, error) {
+	list, err := disksClient.ListByResourceGroup(ctx, c.ResourceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disks in resource group %q: %v", c.ResourceGroup, err)
+	}
 
-	list, err := disksClient.List(ctx)
+	return filterByMachineUID[compute.Disk](ctx, &list, diskTags, UID)
+}
+
+// getDataDiskSpec returns the desired state of the managed data disk of the machine. The disk is tagged with
+// the machine's UID, so it gets cleaned up together with the other disks of the machine.
+func getDataDiskSpec(machineUID types.UID, c *config) compute.Disk {
+	disk := compute.Disk{
+		Location: to.StringPtr(c.Location),
+		Zones:    &c.Zones,
+		DiskProperties: &compute.DiskProperties{
+			CreationData: &compute.CreationData{
+				CreateOption: compute.DiskCreateOptionEmpty,
+			},
+			DiskIOPSReadWrite: c.DataDiskIOPS,
+			DiskMBpsReadWrite: c.DataDiskThroughput,
+			Tier:              c.DataDiskPerformanceTier,
+		},
+		Tags: resourceTags(machineUID, c),
+	}
+
+	// A copy inherits the size of its source unless a larger size is given
+	if c.DataDiskSourceID != "" {
+		disk.CreationData = &compute.CreationData{
+			CreateOption:     compute.DiskCreateOptionCopy,
+			SourceResourceID: to.StringPtr(c.DataDiskSourceID),
+		}
+	}
+	if c.DataDiskSize != 0 {
+		disk.DiskSizeGB = to.Int32Ptr(c.DataDiskSize)
+	}
+
+	if c.DataDiskSKU != nil {
+		disk.Sku = &compute.DiskSku{
+			Name: compute.DiskStorageAccountTypes(*c.DataDiskSKU),
+		}
+	}
+
+	return disk
+}
+
+// createOrUpdateDataDisk creates a managed data disk for the machine, which is either empty or a copy of the
+// data disk source.
+func createOrUpdateDataDisk(ctx context.Context, diskName string, machineUID types.UID, c *config) (*compute.Disk, error) {
+	klog.Infof("Creating data disk %q", diskName)
+	disksClient, err := getDisksClient(c)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list disks: %v", err)
+		return nil, fmt.Errorf("failed to get disks client: %v", err)
 	}
 
-	var allDisks, matchingDisks []compute.Disk
-	for list.NotDone() {
-		allDisks = append(allDisks, list.Values()...)
-		if err = list.Next(); err != nil {
-			return nil, fmt.Errorf("failed to iterate the result list: %s", err)
+	future, err := disksClient.CreateOrUpdate(ctx, c.ResourceGroup, diskName, getDataDiskSpec(machineUID, c))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data disk: %v", err)
+	}
+
+	if err = waitForOperation(ctx, future, disksClient.Client, 0, operationTimeout, fmt.Sprintf("creation of data disk %s", diskName)); err != nil {
+		return nil, fmt.Errorf("failed to wait for creation of data disk: %v", err)
+	}
+
+	disk, err := future.Result(*disksClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data disk creation result: %v", err)
+	}
+
+	return &disk, nil
+}
+
+func updateDiskPerformanceTier(ctx context.Context, diskName, tier string, c *config) error {
+	klog.Infof("Setting performance tier of disk %q to %s", diskName, tier)
+	disksClient, err := getDisksClient(c)
+	if err != nil {
+		return fmt.Errorf("failed to get disks client: %v", err)
+	}
+
+	future, err := disksClient.Update(ctx, c.ResourceGroup, diskName, compute.DiskUpdate{
+		DiskUpdateProperties: &compute.DiskUpdateProperties{
+			Tier: to.StringPtr(tier),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update disk: %v", err)
+	}
+
+	if err = waitForOperation(ctx, future, disksClient.Client, 0, operationTimeout, fmt.Sprintf("update of disk %s", diskName)); err != nil {
+		return fmt.Errorf("failed to wait for update of disk: %v", err)
+	}
+
+	return nil
+}
+
+const (
+	gpuDriverExtensionName      = "NvidiaGpuDriverLinux"
+	gpuDriverExtensionPublisher = "Microsoft.HpcCompute"
+	gpuDriverExtensionVersion   = "1.6"
+)
+
+// amdGPUFamilies are the VM families with AMD GPUs, their driver extension is only available for Windows.
+var amdGPUFamilies = map[string]bool{
+	"standardNVSv4Family":       true,
+	"StandardNGADSV620v1Family": true,
+}
+
+// gpuDriverExtension returns the GPU driver VM extension for the VM SKU, which must have NVIDIA GPUs.
+func gpuDriverExtension(sku compute.ResourceSku, location string) (*compute.VirtualMachineExtension, error) {
+	gpus := 0
+	if sku.Capabilities != nil {
+		for _, capability := range *sku.Capabilities {
+			if capability.Name != nil && *capability.Name == CapabilityGPUs && capability.Value != nil {
+				gpus, _ = strconv.Atoi(*capability.Value)
+			}
+		}
+	}
+
+	if gpus == 0 {
+		return nil, cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("VM size %q has no GPUs, the GPU driver can't be installed", to.String(sku.Name)),
 		}
 	}
 
-	for _, disk := range allDisks {
-		if disk.Tags != nil && disk.Tags[machineUIDTag] != nil && *disk.Tags[machineUIDTag] == string(UID) {
-			matchingDisks = append(matchingDisks, disk)
+	if sku.Family != nil && amdGPUFamilies[*sku.Family] {
+		return nil, cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("VM size %q has AMD GPUs, the GPU driver extension is only available for NVIDIA GPUs on Linux", to.String(sku.Name)),
 		}
 	}
 
-	return matchingDisks, nil
+	return &compute.VirtualMachineExtension{
+		Location: to.StringPtr(location),
+		VirtualMachineExtensionProperties: &compute.VirtualMachineExtensionProperties{
+			Publisher:               to.StringPtr(gpuDriverExtensionPublisher),
+			Type:                    to.StringPtr(gpuDriverExtensionName),
+			TypeHandlerVersion:      to.StringPtr(gpuDriverExtensionVersion),
+			AutoUpgradeMinorVersion: to.BoolPtr(true),
+		},
+	}, nil
 }
 
-func createOrUpdatePublicIPAddress(ctx context.Context, ipName string, ipVersion network.IPVersion, sku network.PublicIPAddressSkuName, ipAllocationMethod network.IPAllocationMethod, machineUID types.UID, c *config) (*network.PublicIPAddress, error) {
-	klog.Infof("Creating public IP %q", ipName)
-	ipClient, err := getIPClient(c)
+func installGPUDriver(ctx context.Context, c *config, vmName string) error {
+	sku, err := getSKU(ctx, c)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to get VM SKU: %v", err)
+	}
+
+	extension, err := gpuDriverExtension(sku, c.Location)
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("Installing GPU driver extension on VM %q", vmName)
+	return createOrUpdateVMExtension(ctx, c, vmName, gpuDriverExtensionName, *extension)
+}
+
+// vmExtensionName returns the name of the extension on the VM, which defaults to its type.
+func vmExtensionName(extension azuretypes.VMExtension) string {
+	if extension.Name != "" {
+		return extension.Name
+	}
+	return extension.Type
+}
+
+// getVMExtension returns the VM extension for an extension of the provider spec.
+func getVMExtension(extension azuretypes.VMExtension, location string) compute.VirtualMachineExtension {
+	props := &compute.VirtualMachineExtensionProperties{
+		Publisher:               to.StringPtr(extension.Publisher),
+		Type:                    to.StringPtr(extension.Type),
+		TypeHandlerVersion:      to.StringPtr(extension.Version),
+		AutoUpgradeMinorVersion: extension.AutoUpgradeMinorVersion,
+	}
+	// the settings are interfaces, so nil maps would be sent as null instead of being omitted
+	if len(extension.Settings) > 0 {
+		props.Settings = extension.Settings
+	}
+	if len(extension.ProtectedSettings) > 0 {
+		props.ProtectedSettings = extension.ProtectedSettings
+	}
+
+	return compute.VirtualMachineExtension{
+		Location:                          to.StringPtr(location),
+		VirtualMachineExtensionProperties: props,
+	}
+}
+
+// installVMExtension installs an extension of the provider spec. Its protected settings must never be logged.
+func installVMExtension(ctx context.Context, c *config, vmName string, extension azuretypes.VMExtension) error {
+	klog.Infof("Installing VM extension %q (%s/%s %s) on VM %q", vmExtensionName(extension), extension.Publisher, extension.Type, extension.Version, vmName)
+	return createOrUpdateVMExtension(ctx, c, vmName, vmExtensionName(extension), getVMExtension(extension, c.Location))
+}
+
+// installVMExtensions installs the GPU driver and the extensions of the config on the VM. Extensions are created or
+// updated, so they can be installed again on VMs which already have them.
+func installVMExtensions(ctx context.Context, c *config, vmName string) error {
+	if c.InstallGPUDriver {
+		if err := installGPUDriver(ctx, c, vmName); err != nil {
+			return fmt.Errorf("failed to install GPU driver on VM %q: %v", vmName, err)
+		}
+	}
+
+	for _, extension := range c.Extensions {
+		if err := installVMExtension(ctx, c, vmName, extension); err != nil {
+			return fmt.Errorf("failed to install extension %q on VM %q: %v", vmExtensionName(extension), vmName, err)
+		}
 	}
 
-	ipParams := network.PublicIPAddress{
+	return nil
+}
+
+func createOrUpdateVMExtension(ctx context.Context, c *config, vmName, name string, extension compute.VirtualMachineExtension) error {
+	extensionsClient, err := getVMExtensionsClient(c)
+	if err != nil {
+		return fmt.Errorf("failed to get VM extensions client: %v", err)
+	}
+
+	future, err := extensionsClient.CreateOrUpdate(ctx, c.ResourceGroup, vmName, name, extension)
+	if err != nil {
+		return fmt.Errorf("failed to create VM extension: %v", err)
+	}
+
+	if err = waitForOperation(ctx, future, extensionsClient.Client, 0, operationTimeout, fmt.Sprintf("creation of VM extension %s", name)); err != nil {
+		return fmt.Errorf("failed to wait for creation of VM extension: %v", err)
+	}
+
+	return nil
+}
+
+// publicIPAddressSpec returns the public IP address of the VM. The idle timeout is only set if it is configured, so
+// that Azure applies its default otherwise.
+func publicIPAddressSpec(ipName string, ipVersion network.IPVersion, sku network.PublicIPAddressSkuName, ipAllocationMethod network.IPAllocationMethod, machineUID types.UID, c *config) network.PublicIPAddress {
+	return network.PublicIPAddress{
 		Name:     to.StringPtr(ipName),
 		Location: to.StringPtr(c.Location),
 		PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
 			PublicIPAddressVersion:   ipVersion,
 			PublicIPAllocationMethod: ipAllocationMethod,
+			IdleTimeoutInMinutes:     c.PublicIPIdleTimeoutMinutes,
 		},
-		Tags:  map[string]*string{machineUIDTag: to.StringPtr(string(machineUID))},
+		Tags:  resourceTags(machineUID, c),
 		Zones: &c.Zones,
 		Sku: &network.PublicIPAddressSku{
 			Name: sku,
 		},
 	}
+}
 
-	future, err := ipClient.CreateOrUpdate(ctx, c.ResourceGroup, ipName, ipParams)
+func createOrUpdatePublicIPAddress(ctx context.Context, ipName string, ipVersion network.IPVersion, sku network.PublicIPAddressSkuName, ipAllocationMethod network.IPAllocationMethod, machineUID types.UID, c *config) (*network.PublicIPAddress, error) {
+	klog.Infof("Creating public IP %q", ipName)
+	ipClient, err := getIPClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ipParams := publicIPAddressSpec(ipName, ipVersion, sku, ipAllocationMethod, machineUID, c)
+	future, err := ipClient.CreateOrUpdate(ctx, c.NetworkResourceGroup, ipName, ipParams)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create public IP address: %v", err)
 	}
 
-	err = future.WaitForCompletionRef(ctx, ipClient.Client)
+	err = waitForOperation(ctx, future, ipClient.Client, 0, operationTimeout, fmt.Sprintf("creation of public IP address %s", ipName))
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve public IP address creation result: %v", err)
 	}
@@ -230,7 +638,7 @@ func createOrUpdatePublicIPAddress(ctx context.Context, ipName string, ipVersion
 	}
 
 	klog.Infof("Fetching info for IP address %q", ipName)
-	ip, err := getPublicIPAddress(ctx, ipName, c.ResourceGroup, ipClient)
+	ip, err := getPublicIPAddress(ctx, ipName, c.NetworkResourceGroup, ipClient)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch info about public IP %q: %v", ipName, err)
 	}
@@ -247,87 +655,155 @@ func getPublicIPAddress(ctx context.Context, ipName string, resourceGroup string
 	return &ip, nil
 }
 
+// configuredSubnet returns the subnet of the config, which is either given by its ID or by its name and the name
+// of its virtual network.
+func configuredSubnet(c *config) (subnetResource, error) {
+	if c.SubnetID != "" {
+		return parseSubnetID(c.SubnetID)
+	}
+
+	return subnetResource{
+		subscriptionID: c.SubscriptionID,
+		resourceGroup:  c.VNetResourceGroup,
+		vnetName:       c.VNetName,
+		name:           c.SubnetName,
+	}, nil
+}
+
 func getSubnet(ctx context.Context, c *config) (network.Subnet, error) {
-	subnetsClient, err := getSubnetsClient(c)
+	subnet, err := configuredSubnet(c)
+	if err != nil {
+		return network.Subnet{}, err
+	}
+
+	subnetsClient, err := getSubnetsClient(c, subnet.subscriptionID)
 	if err != nil {
 		return network.Subnet{}, fmt.Errorf("failed to create subnets client: %v", err)
 	}
 
-	return subnetsClient.Get(ctx, c.VNetResourceGroup, c.VNetName, c.SubnetName, "")
+	return subnetsClient.Get(ctx, subnet.resourceGroup, subnet.vnetName, subnet.name, "")
 }
 
 func getSKU(ctx context.Context, c *config) (compute.ResourceSku, error) {
+	skus, err := getVMSKUs(ctx, c)
+	if err != nil {
+		return compute.ResourceSku{}, err
+	}
+
+	for _, sku := range skus {
+		if *sku.Name == c.VMSize {
+			return sku, nil
+		}
+	}
+
+	return compute.ResourceSku{}, fmt.Errorf("no VM SKU '%s' found for subscription '%s'", c.VMSize, c.SubscriptionID)
+}
+
+// getVMSKUs returns all VM SKUs which are offered in the location of the config.
+func getVMSKUs(ctx context.Context, c *config) ([]compute.ResourceSku, error) {
 	cacheLock.Lock()
 	defer cacheLock.Unlock()
 
-	cacheKey := fmt.Sprintf("%s-%s", c.Location, c.VMSize)
-	cacheSku, found := cache.Get(cacheKey)
+	cacheKey := fmt.Sprintf("%s-%s-vm-skus", c.SubscriptionID, c.Location)
+	cacheSkus, found := cache.Get(cacheKey)
 	if found {
-		klog.V(3).Info("found SKU in cache!")
-		return cacheSku.(compute.ResourceSku), nil
+		klog.V(3).Info("found SKUs in cache!")
+		return cacheSkus.([]compute.ResourceSku), nil
 	}
 
 	skuClient, err := getSKUClient(c)
 	if err != nil {
-		return compute.ResourceSku{}, fmt.Errorf("failed to (create) SKU client: %w", err)
+		return nil, fmt.Errorf("failed to (create) SKU client: %w", err)
 	}
 
 	skuPages, err := skuClient.List(ctx, fmt.Sprintf("location eq '%s'", c.Location), "false")
 	if err != nil {
-		return compute.ResourceSku{}, fmt.Errorf("failed to list available SKUs: %w", err)
+		return nil, fmt.Errorf("failed to list available SKUs: %w", err)
 	}
 
-	var sku *compute.ResourceSku
-
-	for skuPages.NotDone() && sku == nil {
-		skus := skuPages.Values()
-		for _, skuResult := range skus {
+	var skus []compute.ResourceSku
+	for skuPages.NotDone() {
+		for _, skuResult := range skuPages.Values() {
 			// skip invalid SKU results so we don't trigger a nil pointer exception
 			if skuResult.ResourceType == nil || skuResult.Name == nil {
 				continue
 			}
 
-			if *skuResult.ResourceType == "virtualMachines" && *skuResult.Name == c.VMSize {
-				sku = &skuResult
-				break
+			if *skuResult.ResourceType == "virtualMachines" {
+				skus = append(skus, skuResult)
 			}
 		}
 
-		// only fetch the next page if we haven't found our SKU yet
-		if sku == nil {
-			if err := skuPages.NextWithContext(ctx); err != nil {
-				return compute.ResourceSku{}, fmt.Errorf("failed to list available SKUs: %w", err)
-			}
+		if err := skuPages.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("failed to list available SKUs: %w", err)
 		}
 	}
 
-	if sku == nil {
-		return compute.ResourceSku{}, fmt.Errorf("no VM SKU '%s' found for subscription '%s'", c.VMSize, c.SubscriptionID)
+	cache.SetDefault(cacheKey, skus)
+
+	return skus, nil
+}
+
+// checkDataDiskSourceExists checks that the snapshot or the disk the data disk is created from exists.
+func checkDataDiskSourceExists(ctx context.Context, c *config, source azure.Resource) error {
+	sourceConfig := *c
+	sourceConfig.SubscriptionID = source.SubscriptionID
+
+	if strings.EqualFold(source.ResourceType, "snapshots") {
+		snapshotsClient, err := getSnapshotsClient(&sourceConfig)
+		if err != nil {
+			return fmt.Errorf("failed to get snapshots client: %v", err)
+		}
+		if _, err := snapshotsClient.Get(ctx, source.ResourceGroup, source.ResourceName); err != nil {
+			return fmt.Errorf("failed to get data disk source snapshot %q: %v", source.ResourceName, err)
+		}
+		return nil
 	}
 
-	cache.SetDefault(cacheKey, *sku)
+	disksClient, err := getDisksClient(&sourceConfig)
+	if err != nil {
+		return fmt.Errorf("failed to get disks client: %v", err)
+	}
+	if _, err := disksClient.Get(ctx, source.ResourceGroup, source.ResourceName); err != nil {
+		return fmt.Errorf("failed to get data disk source disk %q: %v", source.ResourceName, err)
+	}
 
-	return *sku, nil
+	return nil
 }
 
-func getVirtualNetwork(ctx context.Context, c *config) (network.VirtualNetwork, error) {
-	virtualNetworksClient, err := getVirtualNetworksClient(c)
+func getVirtualMachineScaleSet(ctx context.Context, c *config) (compute.VirtualMachineScaleSet, error) {
+	scaleSetsClient, err := getVirtualMachineScaleSetsClient(c)
 	if err != nil {
-		return network.VirtualNetwork{}, err
+		return compute.VirtualMachineScaleSet{}, err
 	}
 
-	return virtualNetworksClient.Get(ctx, c.VNetResourceGroup, c.VNetName, "")
+	return scaleSetsClient.Get(ctx, c.ResourceGroup, c.VirtualMachineScaleSet, "")
 }
 
-func createOrUpdateNetworkInterface(ctx context.Context, ifName string, machineUID types.UID, config *config, publicIP, publicIPv6 *network.PublicIPAddress, ipFamily util.IPFamily) (*network.Interface, error) {
-	ifClient, err := getInterfacesClient(config)
+func getResourceGroup(ctx context.Context, c *config) (resources.Group, error) {
+	groupsClient, err := getGroupsClient(c)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create interfaces client: %v", err)
+		return resources.Group{}, err
 	}
 
-	subnet, err := getSubnet(ctx, config)
+	return groupsClient.Get(ctx, c.ResourceGroup)
+}
+
+func getVirtualNetwork(ctx context.Context, c *config) (network.VirtualNetwork, error) {
+	virtualNetworksClient, err := getVirtualNetworksClient(c)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch subnet: %v", err)
+		return network.VirtualNetwork{}, err
+	}
+
+	return virtualNetworksClient.Get(ctx, c.VNetResourceGroup, c.VNetName, "")
+}
+
+// getNetworkInterfaceSpec returns the desired state of the machine's network interface. If no public IP
+// should be assigned, the IP configurations explicitly carry no public IP, so that updating an existing
+// interface detaches any public IP which might still be attached to it.
+func getNetworkInterfaceSpec(ifName string, machineUID types.UID, config *config, subnet network.Subnet, publicIP, publicIPv6 *network.PublicIPAddress, ipFamily util.IPFamily) network.Interface {
+	if !config.AssignPublicIP {
+		publicIP, publicIPv6 = nil, nil
 	}
 
 	ifSpec := network.Interface{
@@ -336,7 +812,7 @@ func createOrUpdateNetworkInterface(ctx context.Context, ifName string, machineU
 		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
 			IPConfigurations: &[]network.InterfaceIPConfiguration{},
 		},
-		Tags: map[string]*string{machineUIDTag: to.StringPtr(string(machineUID))},
+		Tags: resourceTags(machineUID, config),
 	}
 
 	*ifSpec.InterfacePropertiesFormat.IPConfigurations = append(*ifSpec.InterfacePropertiesFormat.IPConfigurations, network.InterfaceIPConfiguration{
@@ -349,6 +825,12 @@ func createOrUpdateNetworkInterface(ctx context.Context, ifName string, machineU
 		},
 	})
 
+	if config.PrivateIPAddress != "" {
+		primary := (*ifSpec.InterfacePropertiesFormat.IPConfigurations)[0].InterfaceIPConfigurationPropertiesFormat
+		primary.PrivateIPAllocationMethod = network.IPAllocationMethodStatic
+		primary.PrivateIPAddress = to.StringPtr(config.PrivateIPAddress)
+	}
+
 	if ipFamily == util.DualStack {
 		*ifSpec.InterfacePropertiesFormat.IPConfigurations = append(*ifSpec.InterfacePropertiesFormat.IPConfigurations, network.InterfaceIPConfiguration{
 			Name: to.StringPtr("ip-config-2"),
@@ -362,26 +844,122 @@ func createOrUpdateNetworkInterface(ctx context.Context, ifName string, machineU
 		})
 	}
 
+	return ifSpec
+}
+
+// ipAddressAvailabilityChecker is the part of the virtual networks client which checks whether a private IP address
+// is available.
+type ipAddressAvailabilityChecker interface {
+	CheckIPAddressAvailability(ctx context.Context, resourceGroupName string, virtualNetworkName string, IPAddress string) (network.IPAddressAvailabilityResult, error)
+}
+
+// checkPrivateIPAddressAvailable ensures that the static private IP address isn't used by another resource in the
+// virtual network of the subnet. The address is available if the existing interface of the machine already uses it,
+// e.g. after a previous attempt to create the machine. A conflict isn't terminal, so the creation is retried, e.g.
+// once a machine which got created concurrently with the same address is gone.
+func checkPrivateIPAddressAvailable(ctx context.Context, checker ipAddressAvailabilityChecker, subnet subnetResource, address string, existing *network.Interface) error {
+	if existing != nil {
+		for _, ip := range privateIPAddresses(*existing) {
+			if ip == address {
+				return nil
+			}
+		}
+	}
+
+	result, err := checker.CheckIPAddressAvailability(ctx, subnet.resourceGroup, subnet.vnetName, address)
+	if err != nil {
+		return fmt.Errorf("failed to check the availability of private IP address %q: %w", address, err)
+	}
+
+	if to.Bool(result.IsPlatformReserved) {
+		return cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: fmt.Sprintf("private IP address %q is reserved by Azure", address),
+		}
+	}
+	if !to.Bool(result.Available) {
+		return fmt.Errorf("private IP address %q is already in use in virtual network %q, retrying", address, subnet.vnetName)
+	}
+
+	return nil
+}
+
+// ensurePrivateIPAddressAvailable checks the availability of the static private IP address of the config, if any.
+func ensurePrivateIPAddressAvailable(ctx context.Context, ifClient *network.InterfacesClient, ifName string, c *config) error {
+	if c.PrivateIPAddress == "" {
+		return nil
+	}
+
+	var existing *network.Interface
+	iface, err := ifClient.Get(ctx, c.NetworkResourceGroup, ifName, "")
+	switch {
+	case err == nil:
+		existing = &iface
+	case !isNotFound(err):
+		return fmt.Errorf("failed to get interface %q: %w", ifName, err)
+	}
+
+	subnet, err := configuredSubnet(c)
+	if err != nil {
+		return err
+	}
+
+	// The virtual network of a subnet given by its ID may be in another subscription
+	vnetConfig := *c
+	vnetConfig.SubscriptionID = subnet.subscriptionID
+	virtualNetworksClient, err := getVirtualNetworksClient(&vnetConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create virtual networks client: %w", err)
+	}
+
+	return checkPrivateIPAddressAvailable(ctx, virtualNetworksClient, subnet, c.PrivateIPAddress, existing)
+}
+
+func createOrUpdateNetworkInterface(ctx context.Context, ifName string, machineUID types.UID, config *config, publicIP, publicIPv6 *network.PublicIPAddress, ipFamily util.IPFamily) (*network.Interface, error) {
+	ifClient, err := getInterfacesClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create interfaces client: %v", err)
+	}
+
+	// A subnet given by its ID is referenced directly, it was already checked by the validation
+	subnet := network.Subnet{ID: to.StringPtr(config.SubnetID)}
+	if config.SubnetID == "" {
+		subnet, err = getSubnet(ctx, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch subnet: %v", err)
+		}
+	}
+
+	if err := ensurePrivateIPAddressAvailable(ctx, ifClient, ifName, config); err != nil {
+		return nil, err
+	}
+
+	ifSpec := getNetworkInterfaceSpec(ifName, machineUID, config, subnet, publicIP, publicIPv6, ipFamily)
+
 	if config.SecurityGroupName != "" {
-		authorizer, err := auth.NewClientCredentialsConfig(config.ClientID, config.ClientSecret, config.TenantID).Authorizer()
+		secGroupID, err := resolveResourceID(ctx, config, resourceKindSecurityGroup, config.SecurityGroupName)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create authorizer for security groups: %v", err)
+			return nil, err
 		}
-		secGroupClient := network.NewSecurityGroupsClient(config.SubscriptionID)
-		secGroupClient.Authorizer = authorizer
-		secGroup, err := secGroupClient.Get(ctx, config.ResourceGroup, config.SecurityGroupName, "")
+		ifSpec.NetworkSecurityGroup = &network.SecurityGroup{ID: to.StringPtr(secGroupID)}
+	}
+
+	var applicationSecurityGroups []network.ApplicationSecurityGroup
+	for _, name := range config.ApplicationSecurityGroups {
+		id, err := resolveResourceID(ctx, config, resourceKindApplicationSecurityGroup, name)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get securityGroup %q: %v", config.SecurityGroupName, err)
+			return nil, err
 		}
-		ifSpec.NetworkSecurityGroup = &secGroup
+		applicationSecurityGroups = append(applicationSecurityGroups, network.ApplicationSecurityGroup{ID: to.StringPtr(id)})
 	}
+	setApplicationSecurityGroups(&ifSpec, applicationSecurityGroups)
 	klog.Infof("Creating/Updating public network interface %q", ifName)
-	future, err := ifClient.CreateOrUpdate(ctx, config.ResourceGroup, ifName, ifSpec)
+	future, err := ifClient.CreateOrUpdate(ctx, config.NetworkResourceGroup, ifName, ifSpec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create interface: %v", err)
 	}
 
-	err = future.WaitForCompletionRef(ctx, ifClient.Client)
+	err = waitForOperation(ctx, future, ifClient.Client, 0, operationTimeout, fmt.Sprintf("creation of interface %s", ifName))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get interface creation response: %v", err)
 	}
@@ -392,7 +970,7 @@ func createOrUpdateNetworkInterface(ctx context.Context, ifName string, machineU
 	}
 
 	klog.Infof("Fetching info about network interface %q", ifName)
-	iface, err := ifClient.Get(ctx, config.ResourceGroup, ifName, "")
+	iface, err := ifClient.Get(ctx, config.NetworkResourceGroup, ifName, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch info about interface %q: %v", ifName, err)
 	}
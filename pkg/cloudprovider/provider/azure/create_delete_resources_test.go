@@ -0,0 +1,306 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-11-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-05-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const testMachineUID = types.UID("machine-1")
+
+// testPages returns the tags of the resources on each page, the name of a resource is its position on all pages.
+// Resources with the test machine's UID tag are returned by the filters.
+func testPages() [][]map[string]*string {
+	matching := map[string]*string{machineUIDTag: to.StringPtr(string(testMachineUID))}
+	other := map[string]*string{machineUIDTag: to.StringPtr("machine-2")}
+
+	return [][]map[string]*string{
+		{matching, other},
+		{},
+		{other, nil},
+		{matching},
+	}
+}
+
+// fakeResourcePage returns the pages one after another, getting the next page fails with err.
+type fakeResourcePage[T any] struct {
+	pages [][]T
+	err   error
+}
+
+func (p *fakeResourcePage[T]) NotDone() bool {
+	return len(p.pages) > 0
+}
+
+func (p *fakeResourcePage[T]) Values() []T {
+	return p.pages[0]
+}
+
+func (p *fakeResourcePage[T]) NextWithContext(context.Context) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.pages = p.pages[1:]
+	return nil
+}
+
+// newTestResourcePage returns the test pages with resources created by newResource.
+func newTestResourcePage[T any](newResource func(name string, tags map[string]*string) T, err error) *fakeResourcePage[T] {
+	list := &fakeResourcePage[T]{err: err}
+	offset := 0
+	for _, tags := range testPages() {
+		page := []T{}
+		for i := range tags {
+			page = append(page, newResource(fmt.Sprintf("resource-%d", offset+i), tags[i]))
+		}
+		list.pages = append(list.pages, page)
+		offset += len(tags)
+	}
+	return list
+}
+
+// filterTestResources filters the test pages of the resource type by the test machine's UID and returns the names
+// of the matching resources.
+func filterTestResources[T any](newResource func(name string, tags map[string]*string) T, tags func(T) map[string]*string, name func(T) *string, err error) ([]string, error) {
+	resources, err := filterByMachineUID[T](context.Background(), newTestResourcePage(newResource, err), tags, testMachineUID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, resource := range resources {
+		names = append(names, to.String(name(resource)))
+	}
+	return names, nil
+}
+
+func TestFilterByMachineUID(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter func(err error) ([]string, error)
+	}{
+		{
+			name: "VMs",
+			filter: func(err error) ([]string, error) {
+				return filterTestResources(func(name string, tags map[string]*string) compute.VirtualMachine {
+					return compute.VirtualMachine{Name: to.StringPtr(name), Tags: tags}
+				}, vmTags, func(vm compute.VirtualMachine) *string { return vm.Name }, err)
+			},
+		},
+		{
+			name: "disks",
+			filter: func(err error) ([]string, error) {
+				return filterTestResources(func(name string, tags map[string]*string) compute.Disk {
+					return compute.Disk{Name: to.StringPtr(name), Tags: tags}
+				}, diskTags, func(disk compute.Disk) *string { return disk.Name }, err)
+			},
+		},
+		{
+			name: "network interfaces",
+			filter: func(err error) ([]string, error) {
+				return filterTestResources(func(name string, tags map[string]*string) network.Interface {
+					return network.Interface{Name: to.StringPtr(name), Tags: tags}
+				}, interfaceTags, func(iface network.Interface) *string { return iface.Name }, err)
+			},
+		},
+		{
+			name: "public IP addresses",
+			filter: func(err error) ([]string, error) {
+				return filterTestResources(func(name string, tags map[string]*string) network.PublicIPAddress {
+					return network.PublicIPAddress{Name: to.StringPtr(name), Tags: tags}
+				}, publicIPAddressTags, func(ip network.PublicIPAddress) *string { return ip.Name }, err)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names, err := test.filter(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := []string{"resource-0", "resource-4"}; !reflect.DeepEqual(names, expected) {
+				t.Errorf("expected resources %v, got %v", expected, names)
+			}
+
+			if _, err := test.filter(errors.New("throttled")); err == nil {
+				t.Error("expected the error of the next page to be returned")
+			}
+		})
+	}
+}
+
+func TestManagedInstances(t *testing.T) {
+	list := newTestResourcePage(func(name string, tags map[string]*string) compute.VirtualMachine {
+		return compute.VirtualMachine{Name: to.StringPtr(name), Tags: tags}
+	}, nil)
+
+	instances, err := managedInstances(context.Background(), list)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []cloudprovidertypes.ManagedInstance{
+		{Name: "resource-0", MachineUID: testMachineUID},
+		{Name: "resource-1", MachineUID: "machine-2"},
+		{Name: "resource-2", MachineUID: "machine-2"},
+		{Name: "resource-4", MachineUID: testMachineUID},
+	}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("expected managed instances %v, got %v", expected, instances)
+	}
+}
+
+func TestIsOSDisk(t *testing.T) {
+	tests := []struct {
+		name     string
+		disk     compute.Disk
+		expected bool
+	}{
+		{
+			name:     "OS disk",
+			disk:     compute.Disk{DiskProperties: &compute.DiskProperties{OsType: compute.OperatingSystemTypesLinux}},
+			expected: true,
+		},
+		{
+			name: "data disk",
+			disk: compute.Disk{DiskProperties: &compute.DiskProperties{}},
+		},
+		{
+			name: "no properties",
+			disk: compute.Disk{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if isOSDisk(test.disk) != test.expected {
+				t.Errorf("expected isOSDisk to return %t", test.expected)
+			}
+		})
+	}
+}
+
+func TestRetainedDiskTags(t *testing.T) {
+	machine := &clusterv1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "node-1", UID: testMachineUID}}
+	tags := map[string]*string{
+		machineUIDTag: to.StringPtr(string(testMachineUID)),
+		"team":        to.StringPtr("platform"),
+	}
+
+	retainedTags := retainedDiskTags(tags, machine, time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+	if hasMachineUIDTag(retainedTags, testMachineUID) {
+		t.Error("expected the machine UID tag to be removed, otherwise the disk gets deleted with the machine")
+	}
+
+	expected := map[string]string{
+		"team":                 "platform",
+		retainedMachineNameTag: "node-1",
+		retainedMachineUIDTag:  string(testMachineUID),
+		retainedAtTag:          "2022-06-01T12:00:00Z",
+	}
+	actual := map[string]string{}
+	for key, value := range retainedTags {
+		actual[key] = to.String(value)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected tags %v, got %v", expected, actual)
+	}
+	if tags[machineUIDTag] == nil {
+		t.Error("expected the tags of the disk to not be modified")
+	}
+}
+
+// fakeIPAddressAvailabilityChecker reports the addresses which are in use by other resources as unavailable.
+type fakeIPAddressAvailabilityChecker struct {
+	inUse  map[string]bool
+	checks int
+}
+
+func (c *fakeIPAddressAvailabilityChecker) CheckIPAddressAvailability(_ context.Context, _ string, _ string, address string) (network.IPAddressAvailabilityResult, error) {
+	c.checks++
+	return network.IPAddressAvailabilityResult{Available: to.BoolPtr(!c.inUse[address])}, nil
+}
+
+func TestCheckPrivateIPAddressAvailable(t *testing.T) {
+	subnet := subnetResource{resourceGroup: "network", vnetName: "vnet", name: "subnet"}
+	ownInterface := &network.Interface{InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+		IPConfigurations: &[]network.InterfaceIPConfiguration{{
+			InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+				PrivateIPAddress: to.StringPtr("10.0.0.10"),
+			},
+		}},
+	}}
+
+	tests := []struct {
+		name           string
+		address        string
+		existing       *network.Interface
+		expectError    bool
+		expectedChecks int
+	}{
+		{
+			name:           "available address",
+			address:        "10.0.0.11",
+			expectedChecks: 1,
+		},
+		{
+			name:           "address used by another machine",
+			address:        "10.0.0.10",
+			expectError:    true,
+			expectedChecks: 1,
+		},
+		{
+			name:     "address used by the interface of the machine",
+			address:  "10.0.0.10",
+			existing: ownInterface,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checker := &fakeIPAddressAvailabilityChecker{inUse: map[string]bool{"10.0.0.10": true}}
+
+			err := checkPrivateIPAddressAvailable(context.Background(), checker, subnet, test.address, test.existing)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if isTerminal, _, _ := cloudprovidererrors.IsTerminalError(err); isTerminal {
+				t.Errorf("expected a conflict to be retried, got terminal error %v", err)
+			}
+			if checker.checks != test.expectedChecks {
+				t.Errorf("expected %d availability checks, got %d", test.expectedChecks, checker.checks)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-11-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// usesDedicatedHost tells whether the VM is placed on a dedicated host, either on the given host or on a host of the
+// given host group which is picked by Azure.
+func usesDedicatedHost(c *config) bool {
+	return c.HostGroupID != "" || c.HostID != ""
+}
+
+// configuredDedicatedHost returns the dedicated host of the config, or its host group if no host is given.
+func configuredDedicatedHost(c *config) (dedicatedHostResource, error) {
+	if c.HostID != "" {
+		return parseDedicatedHostID(c.HostID, true)
+	}
+	return parseDedicatedHostID(c.HostGroupID, false)
+}
+
+// validateDedicatedHostConfig checks the combination of a dedicated host with the other placement options, it
+// doesn't call the Azure API. The host group of a host is derived from the host, so only one of both can be set.
+// Spot VMs, availability sets and scale sets aren't supported on dedicated hosts and the host group determines the
+// single zone of the VM.
+func validateDedicatedHostConfig(c *config) error {
+	if !usesDedicatedHost(c) {
+		return nil
+	}
+
+	if c.HostGroupID != "" && c.HostID != "" {
+		return errors.New("hostGroupID and hostID can't be set at the same time, the host group is derived from the host")
+	}
+	if c.HostGroupID != "" {
+		if _, err := parseDedicatedHostID(c.HostGroupID, false); err != nil {
+			return fmt.Errorf("invalid hostGroupID: %w", err)
+		}
+	}
+	if c.HostID != "" {
+		if _, err := parseDedicatedHostID(c.HostID, true); err != nil {
+			return fmt.Errorf("invalid hostID: %w", err)
+		}
+	}
+
+	if c.Spot {
+		return errors.New("spot VMs can't be placed on dedicated hosts")
+	}
+	if c.AvailabilitySet != "" && (c.AssignAvailabilitySet == nil || *c.AssignAvailabilitySet) {
+		return errors.New("availabilitySet can't be used together with a dedicated host")
+	}
+	if c.VirtualMachineScaleSet != "" {
+		return errors.New("virtualMachineScaleSet can't be used together with a dedicated host")
+	}
+	if len(c.Zones) > 1 {
+		return fmt.Errorf("VMs on dedicated hosts can only be placed in a single zone, got zones %v", c.Zones)
+	}
+
+	return nil
+}
+
+// validateDedicatedHostGroup checks that the zone of the VM matches the zone of the host group. Host groups without
+// zone support all zones of the region. Without a host, Azure picks the host, which requires automatic placement
+// to be enabled on the host group.
+func validateDedicatedHostGroup(group compute.DedicatedHostGroup, zones []string, automaticPlacement bool) error {
+	if group.Zones != nil && len(*group.Zones) > 0 {
+		if len(zones) != 1 || !strings.EqualFold(zones[0], (*group.Zones)[0]) {
+			return fmt.Errorf("host group %q is in zone %s, zones of the VM must be [%s], got %v", to.String(group.Name), (*group.Zones)[0], (*group.Zones)[0], zones)
+		}
+	}
+
+	if automaticPlacement {
+		if group.DedicatedHostGroupProperties == nil || !to.Bool(group.SupportAutomaticPlacement) {
+			return fmt.Errorf("host group %q doesn't support automatic placement, hostID has to be set", to.String(group.Name))
+		}
+	}
+
+	return nil
+}
+
+// validateDedicatedHostSKU checks that the VM size is of the series the dedicated host was deployed for. The SKU of a
+// host is named after the series with its hardware generation, e.g. DSv3-Type1 for the VM family standardDSv3Family.
+// Hosts or VM sizes without a known SKU aren't checked.
+func validateDedicatedHostSKU(host compute.DedicatedHost, vmSKU compute.ResourceSku) error {
+	if host.Sku == nil || to.String(host.Sku.Name) == "" || to.String(vmSKU.Family) == "" {
+		return nil
+	}
+
+	series := strings.SplitN(*host.Sku.Name, "-", 2)[0]
+	if !strings.EqualFold(*vmSKU.Family, "standard"+series+"Family") {
+		return fmt.Errorf("dedicated host %q with SKU %s only supports VM sizes of the %s series, VM size %s is of family %s", to.String(host.Name), *host.Sku.Name, series, to.String(vmSKU.Name), *vmSKU.Family)
+	}
+
+	return nil
+}
+
+// checkDedicatedHost ensures that the dedicated host group, and the host if it's given, exist, that the host
+// group matches the zone of the VM and that the host supports the VM size.
+func checkDedicatedHost(ctx context.Context, c *config) error {
+	if !usesDedicatedHost(c) {
+		return nil
+	}
+
+	resource, err := configuredDedicatedHost(c)
+	if err != nil {
+		return err
+	}
+	isHost := resource.host != ""
+
+	hostGroupsClient, err := getDedicatedHostGroupsClient(c, resource.subscriptionID)
+	if err != nil {
+		return fmt.Errorf("failed to create dedicated host groups client: %w", err)
+	}
+	group, err := hostGroupsClient.Get(ctx, resource.resourceGroup, resource.hostGroup, "")
+	if err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("dedicated host group %q not found in resource group %q", resource.hostGroup, resource.resourceGroup)
+		}
+		return fmt.Errorf("failed to get dedicated host group %q: %w", resource.hostGroup, err)
+	}
+	if err := validateDedicatedHostGroup(group, c.Zones, !isHost); err != nil {
+		return err
+	}
+
+	if isHost {
+		hostsClient, err := getDedicatedHostsClient(c, resource.subscriptionID)
+		if err != nil {
+			return fmt.Errorf("failed to create dedicated hosts client: %w", err)
+		}
+		host, err := hostsClient.Get(ctx, resource.resourceGroup, resource.hostGroup, resource.host, "")
+		if err != nil {
+			if isNotFound(err) {
+				return fmt.Errorf("dedicated host %q not found in host group %q", resource.host, resource.hostGroup)
+			}
+			return fmt.Errorf("failed to get dedicated host %q: %w", resource.host, err)
+		}
+
+		vmSKU, err := getSKU(ctx, c)
+		if err != nil {
+			return fmt.Errorf("failed to get VM SKU: %w", err)
+		}
+		if err := validateDedicatedHostSKU(host, vmSKU); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setDedicatedHost places the VM on the dedicated host or host group of the config.
+func setDedicatedHost(properties *compute.VirtualMachineProperties, c *config) {
+	if c.HostID != "" {
+		properties.Host = &compute.SubResource{ID: to.StringPtr(c.HostID)}
+	}
+	if c.HostGroupID != "" {
+		properties.HostGroup = &compute.SubResource{ID: to.StringPtr(c.HostGroupID)}
+	}
+}
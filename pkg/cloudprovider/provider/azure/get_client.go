@@ -19,15 +19,23 @@ package azure
 import (
 	"fmt"
 
+	"github.com/Azure/azure-sdk-for-go/services/authorization/mgmt/2015-07-01/authorization"
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-11-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-05-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2020-10-01/resources"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 )
 
+// newAuthorizer returns the authorizer of the clients, tests replace it to send the requests to a fake API.
+var newAuthorizer = func(c *config) (autorest.Authorizer, error) {
+	return auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+}
+
 func getIPClient(c *config) (*network.PublicIPAddressesClient, error) {
 	var err error
 	ipClient := network.NewPublicIPAddressesClient(c.SubscriptionID)
-	ipClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	ipClient.Authorizer, err = newAuthorizer(c)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create authorizer: %v", err)
 	}
@@ -35,32 +43,36 @@ func getIPClient(c *config) (*network.PublicIPAddressesClient, error) {
 	return &ipClient, nil
 }
 
-func getIPConfigClient(c *config) (*network.InterfaceIPConfigurationsClient, error) {
+// getSubnetsClient returns a client for the subnets of the given subscription, which may differ from the
+// subscription of the config if the subnet is referenced by its ID.
+func getSubnetsClient(c *config, subscriptionID string) (*network.SubnetsClient, error) {
 	var err error
-	ipConfigClient := network.NewInterfaceIPConfigurationsClient(c.SubscriptionID)
-	ipConfigClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	subnetClient := network.NewSubnetsClient(subscriptionID)
+	subnetClient.Authorizer, err = newAuthorizer(c)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create authorizer: %s", err.Error())
 	}
 
-	return &ipConfigClient, nil
+	return &subnetClient, nil
 }
 
-func getSubnetsClient(c *config) (*network.SubnetsClient, error) {
+// getRouteTablesClient returns a client for the route tables of the given subscription, which may differ from the
+// subscription of the config for route tables of subnets referenced by their ID.
+func getRouteTablesClient(c *config, subscriptionID string) (*network.RouteTablesClient, error) {
 	var err error
-	subnetClient := network.NewSubnetsClient(c.SubscriptionID)
-	subnetClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	routeTablesClient := network.NewRouteTablesClient(subscriptionID)
+	routeTablesClient.Authorizer, err = newAuthorizer(c)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create authorizer: %s", err.Error())
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
 	}
 
-	return &subnetClient, nil
+	return &routeTablesClient, nil
 }
 
 func getVirtualNetworksClient(c *config) (*network.VirtualNetworksClient, error) {
 	var err error
 	virtualNetworksClient := network.NewVirtualNetworksClient(c.SubscriptionID)
-	virtualNetworksClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	virtualNetworksClient.Authorizer, err = newAuthorizer(c)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create authorizer: %v", err)
 	}
@@ -70,7 +82,7 @@ func getVirtualNetworksClient(c *config) (*network.VirtualNetworksClient, error)
 func getVMClient(c *config) (*compute.VirtualMachinesClient, error) {
 	var err error
 	vmClient := compute.NewVirtualMachinesClient(c.SubscriptionID)
-	vmClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	vmClient.Authorizer, err = newAuthorizer(c)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create authorizer: %s", err.Error())
 	}
@@ -81,7 +93,7 @@ func getVMClient(c *config) (*compute.VirtualMachinesClient, error) {
 func getSKUClient(c *config) (*compute.ResourceSkusClient, error) {
 	var err error
 	skuClient := compute.NewResourceSkusClient(c.SubscriptionID)
-	skuClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	skuClient.Authorizer, err = newAuthorizer(c)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create authorizer: %w", err)
 	}
@@ -92,7 +104,7 @@ func getSKUClient(c *config) (*compute.ResourceSkusClient, error) {
 func getInterfacesClient(c *config) (*network.InterfacesClient, error) {
 	var err error
 	ifClient := network.NewInterfacesClient(c.SubscriptionID)
-	ifClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	ifClient.Authorizer, err = newAuthorizer(c)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create authorizer: %s", err.Error())
 	}
@@ -103,10 +115,157 @@ func getInterfacesClient(c *config) (*network.InterfacesClient, error) {
 func getDisksClient(c *config) (*compute.DisksClient, error) {
 	var err error
 	disksClient := compute.NewDisksClient(c.SubscriptionID)
-	disksClient.Authorizer, err = auth.NewClientCredentialsConfig(c.ClientID, c.ClientSecret, c.TenantID).Authorizer()
+	disksClient.Authorizer, err = newAuthorizer(c)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create authorizer: %s", err.Error())
 	}
 
 	return &disksClient, err
 }
+
+func getAvailabilitySetsClient(c *config) (*compute.AvailabilitySetsClient, error) {
+	var err error
+	availabilitySetsClient := compute.NewAvailabilitySetsClient(c.SubscriptionID)
+	availabilitySetsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &availabilitySetsClient, nil
+}
+
+func getSecurityGroupsClient(c *config) (*network.SecurityGroupsClient, error) {
+	var err error
+	securityGroupsClient := network.NewSecurityGroupsClient(c.SubscriptionID)
+	securityGroupsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &securityGroupsClient, nil
+}
+
+func getApplicationSecurityGroupsClient(c *config) (*network.ApplicationSecurityGroupsClient, error) {
+	var err error
+	applicationSecurityGroupsClient := network.NewApplicationSecurityGroupsClient(c.SubscriptionID)
+	applicationSecurityGroupsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &applicationSecurityGroupsClient, nil
+}
+
+// getDedicatedHostGroupsClient returns a client for the dedicated host groups of the given subscription, which may
+// differ from the subscription of the config.
+func getDedicatedHostGroupsClient(c *config, subscriptionID string) (*compute.DedicatedHostGroupsClient, error) {
+	var err error
+	hostGroupsClient := compute.NewDedicatedHostGroupsClient(subscriptionID)
+	hostGroupsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &hostGroupsClient, nil
+}
+
+// getDedicatedHostsClient returns a client for the dedicated hosts of the given subscription, which may differ from
+// the subscription of the config.
+func getDedicatedHostsClient(c *config, subscriptionID string) (*compute.DedicatedHostsClient, error) {
+	var err error
+	hostsClient := compute.NewDedicatedHostsClient(subscriptionID)
+	hostsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &hostsClient, nil
+}
+
+func getVirtualMachineScaleSetsClient(c *config) (*compute.VirtualMachineScaleSetsClient, error) {
+	var err error
+	scaleSetsClient := compute.NewVirtualMachineScaleSetsClient(c.SubscriptionID)
+	scaleSetsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &scaleSetsClient, nil
+}
+
+func getSnapshotsClient(c *config) (*compute.SnapshotsClient, error) {
+	var err error
+	snapshotsClient := compute.NewSnapshotsClient(c.SubscriptionID)
+	snapshotsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &snapshotsClient, nil
+}
+
+func getImagesClient(c *config) (*compute.ImagesClient, error) {
+	var err error
+	imagesClient := compute.NewImagesClient(c.SubscriptionID)
+	imagesClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &imagesClient, nil
+}
+
+func getGalleryImageVersionsClient(c *config) (*compute.GalleryImageVersionsClient, error) {
+	var err error
+	versionsClient := compute.NewGalleryImageVersionsClient(c.SubscriptionID)
+	versionsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &versionsClient, nil
+}
+
+func getVirtualMachineImagesClient(c *config) (*compute.VirtualMachineImagesClient, error) {
+	var err error
+	imagesClient := compute.NewVirtualMachineImagesClient(c.SubscriptionID)
+	imagesClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &imagesClient, nil
+}
+
+func getVMExtensionsClient(c *config) (*compute.VirtualMachineExtensionsClient, error) {
+	var err error
+	extensionsClient := compute.NewVirtualMachineExtensionsClient(c.SubscriptionID)
+	extensionsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %s", err.Error())
+	}
+
+	return &extensionsClient, err
+}
+
+func getGroupsClient(c *config) (*resources.GroupsClient, error) {
+	var err error
+	groupsClient := resources.NewGroupsClient(c.SubscriptionID)
+	groupsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &groupsClient, nil
+}
+
+func getPermissionsClient(c *config) (*authorization.PermissionsClient, error) {
+	var err error
+	permissionsClient := authorization.NewPermissionsClient(c.SubscriptionID)
+	permissionsClient.Authorizer, err = newAuthorizer(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer: %w", err)
+	}
+
+	return &permissionsClient, nil
+}
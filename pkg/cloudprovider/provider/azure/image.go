@@ -0,0 +1,278 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-11-01/compute"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+)
+
+// imageResource identifies a managed image or a version of a shared gallery image, both can be in another
+// subscription and resource group than the VM.
+type imageResource struct {
+	SubscriptionID string
+	ResourceGroup  string
+	// Image is the name of the managed image or the gallery image definition.
+	Image string
+	// Gallery and Version are only set for gallery image versions.
+	Gallery string
+	Version string
+}
+
+// parseImageID parses the ID of a managed image or a gallery image version. False is returned for any other ID,
+// including the ID of a gallery image definition, as its version is only picked when the VM gets created.
+func parseImageID(id string) (imageResource, bool) {
+	// /subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/images/<image>
+	// /subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/galleries/<gallery>/images/<image>/versions/<version>
+	resource, err := azure.ParseResourceID(id)
+	if err != nil || !strings.EqualFold(resource.Provider, "Microsoft.Compute") {
+		return imageResource{}, false
+	}
+
+	// The resource only contains the name of the last child resource, the names of its parents are taken from the ID
+	image := imageResource{SubscriptionID: resource.SubscriptionID, ResourceGroup: resource.ResourceGroup}
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	switch {
+	case len(parts) == 8 && strings.EqualFold(resource.ResourceType, "images"):
+		image.Image = resource.ResourceName
+		return image, true
+	case len(parts) == 12 && strings.EqualFold(resource.ResourceType, "galleries") &&
+		strings.EqualFold(parts[8], "images") && strings.EqualFold(parts[10], "versions"):
+		image.Gallery, image.Image, image.Version = parts[7], parts[9], resource.ResourceName
+		return image, true
+	}
+
+	return imageResource{}, false
+}
+
+// getImageOSDiskSize returns the size in GB of the OS disk of the image or 0 if the size isn't known. Only managed
+// images and gallery image versions expose the size of their OS disk, marketplace images don't.
+func getImageOSDiskSize(ctx context.Context, c *config, imageRef *compute.ImageReference) (int32, error) {
+	if imageRef == nil || to.String(imageRef.ID) == "" {
+		return 0, nil
+	}
+
+	image, ok := parseImageID(*imageRef.ID)
+	if !ok {
+		return 0, nil
+	}
+
+	if image.Gallery == "" {
+		client, err := getImagesClient(imageConfig(c, image))
+		if err != nil {
+			return 0, fmt.Errorf("failed to create images client: %v", err)
+		}
+
+		managedImage, err := client.Get(ctx, image.ResourceGroup, image.Image, "")
+		if err != nil {
+			return 0, fmt.Errorf("failed to get image %q: %v", image.Image, err)
+		}
+		if managedImage.ImageProperties == nil || managedImage.StorageProfile == nil || managedImage.StorageProfile.OsDisk == nil {
+			return 0, nil
+		}
+
+		return to.Int32(managedImage.StorageProfile.OsDisk.DiskSizeGB), nil
+	}
+
+	client, err := getGalleryImageVersionsClient(imageConfig(c, image))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create gallery image versions client: %v", err)
+	}
+
+	version, err := client.Get(ctx, image.ResourceGroup, image.Gallery, image.Image, image.Version, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get version %q of gallery image %q: %v", image.Version, image.Image, err)
+	}
+	if version.GalleryImageVersionProperties == nil || version.StorageProfile == nil || version.StorageProfile.OsDiskImage == nil {
+		return 0, nil
+	}
+
+	return to.Int32(version.StorageProfile.OsDiskImage.SizeInGB), nil
+}
+
+// validateOSDiskSize checks that the configured OS disk size isn't smaller than the OS disk of the image, 0 means
+// that the size of the image is used.
+func validateOSDiskSize(osDiskSize, imageOSDiskSize int32) error {
+	if osDiskSize == 0 || imageOSDiskSize == 0 || osDiskSize >= imageOSDiskSize {
+		return nil
+	}
+
+	return fmt.Errorf("osDiskSize of %d GB is smaller than the OS disk of the image with %d GB, OS disks can't be shrunk; "+
+		"increase osDiskSize or omit it to use the size of the image", osDiskSize, imageOSDiskSize)
+}
+
+// getImageDataDiskLUNs returns the LUNs used by the data disks of the image. Only managed images and gallery image
+// versions can contain data disks, marketplace images are treated as having none.
+func getImageDataDiskLUNs(ctx context.Context, c *config, imageRef *compute.ImageReference) ([]int32, error) {
+	if imageRef == nil || to.String(imageRef.ID) == "" {
+		return nil, nil
+	}
+
+	image, ok := parseImageID(*imageRef.ID)
+	if !ok {
+		return nil, nil
+	}
+
+	var luns []int32
+	if image.Gallery == "" {
+		client, err := getImagesClient(imageConfig(c, image))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create images client: %v", err)
+		}
+
+		managedImage, err := client.Get(ctx, image.ResourceGroup, image.Image, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image %q: %v", image.Image, err)
+		}
+		if managedImage.ImageProperties == nil || managedImage.StorageProfile == nil || managedImage.StorageProfile.DataDisks == nil {
+			return nil, nil
+		}
+
+		for _, disk := range *managedImage.StorageProfile.DataDisks {
+			luns = append(luns, to.Int32(disk.Lun))
+		}
+		return luns, nil
+	}
+
+	client, err := getGalleryImageVersionsClient(imageConfig(c, image))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gallery image versions client: %v", err)
+	}
+
+	version, err := client.Get(ctx, image.ResourceGroup, image.Gallery, image.Image, image.Version, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version %q of gallery image %q: %v", image.Version, image.Image, err)
+	}
+	if version.GalleryImageVersionProperties == nil || version.StorageProfile == nil || version.StorageProfile.DataDiskImages == nil {
+		return nil, nil
+	}
+
+	for _, disk := range *version.StorageProfile.DataDiskImages {
+		luns = append(luns, to.Int32(disk.Lun))
+	}
+	return luns, nil
+}
+
+// checkImageExists returns a terminal ImageNotFound error if the image can't be found. Managed images and gallery
+// image versions are looked up by their ID, marketplace images by publisher, offer, SKU and version, where latest
+// refers to the newest version of the SKU.
+func checkImageExists(ctx context.Context, c *config, imageRef *compute.ImageReference) error {
+	if imageRef == nil {
+		return nil
+	}
+
+	if id := to.String(imageRef.ID); id != "" {
+		image, ok := parseImageID(id)
+		if !ok {
+			return nil
+		}
+
+		imageCfg := imageConfig(c, image)
+		imagesClient, err := getImagesClient(imageCfg)
+		if err != nil {
+			return fmt.Errorf("failed to create images client: %v", err)
+		}
+		versionsClient, err := getGalleryImageVersionsClient(imageCfg)
+		if err != nil {
+			return fmt.Errorf("failed to create gallery image versions client: %v", err)
+		}
+
+		return checkImageIDExists(ctx, imagesClient, versionsClient, image)
+	}
+
+	client, err := getVirtualMachineImagesClient(c)
+	if err != nil {
+		return fmt.Errorf("failed to create virtual machine images client: %v", err)
+	}
+
+	publisher, offer, sku, version := to.String(imageRef.Publisher), to.String(imageRef.Offer), to.String(imageRef.Sku), to.String(imageRef.Version)
+	if version == "" || strings.EqualFold(version, "latest") {
+		versions, err := client.List(ctx, c.Location, publisher, offer, sku, "", to.Int32Ptr(1), "name desc")
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to list versions of marketplace image %s:%s:%s: %v", publisher, offer, sku, err)
+		}
+		if err != nil || versions.Value == nil || len(*versions.Value) == 0 {
+			return imageNotFoundError("marketplace image %s:%s:%s not found in location %q", publisher, offer, sku, c.Location)
+		}
+		return nil
+	}
+
+	if _, err := client.Get(ctx, c.Location, publisher, offer, sku, version); err != nil {
+		if isNotFound(err) {
+			return imageNotFoundError("marketplace image %s:%s:%s:%s not found in location %q", publisher, offer, sku, version, c.Location)
+		}
+		return fmt.Errorf("failed to get marketplace image %s:%s:%s:%s: %v", publisher, offer, sku, version, err)
+	}
+
+	return nil
+}
+
+// imageConfig returns a copy of the config for the subscription of the image, which can differ from the subscription
+// of the VM, e.g. for images shared from a central gallery.
+func imageConfig(c *config, image imageResource) *config {
+	cfg := *c
+	cfg.SubscriptionID = image.SubscriptionID
+	return &cfg
+}
+
+// imageGetter is the part of the images client which is required to check that a managed image exists.
+type imageGetter interface {
+	Get(ctx context.Context, resourceGroupName string, imageName string, expand string) (compute.Image, error)
+}
+
+// galleryImageVersionGetter is the part of the gallery image versions client which is required to check that a
+// gallery image version exists.
+type galleryImageVersionGetter interface {
+	Get(ctx context.Context, resourceGroupName string, galleryName string, galleryImageName string, galleryImageVersionName string, expand compute.ReplicationStatusTypes) (compute.GalleryImageVersion, error)
+}
+
+// checkImageIDExists returns a terminal ImageNotFound error if the managed image or gallery image version can't be
+// found.
+func checkImageIDExists(ctx context.Context, images imageGetter, versions galleryImageVersionGetter, image imageResource) error {
+	if image.Gallery == "" {
+		if _, err := images.Get(ctx, image.ResourceGroup, image.Image, ""); err != nil {
+			if isNotFound(err) {
+				return imageNotFoundError("image %q not found in resource group %q", image.Image, image.ResourceGroup)
+			}
+			return fmt.Errorf("failed to get image %q: %v", image.Image, err)
+		}
+		return nil
+	}
+
+	if _, err := versions.Get(ctx, image.ResourceGroup, image.Gallery, image.Image, image.Version, ""); err != nil {
+		if isNotFound(err) {
+			return imageNotFoundError("version %q of image %q not found in gallery %q", image.Version, image.Image, image.Gallery)
+		}
+		return fmt.Errorf("failed to get version %q of gallery image %q: %v", image.Version, image.Image, err)
+	}
+	return nil
+}
+
+func imageNotFoundError(format string, args ...interface{}) error {
+	return cloudprovidererrors.TerminalError{
+		Reason:  common.ImageNotFoundMachineError,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-11-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+)
+
+func TestParseImageID(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		expected imageResource
+		ok       bool
+	}{
+		{
+			name:     "managed image",
+			id:       "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/ubuntu",
+			expected: imageResource{SubscriptionID: "sub", ResourceGroup: "rg", Image: "ubuntu"},
+			ok:       true,
+		},
+		{
+			name:     "gallery image version",
+			id:       "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/ubuntu/versions/1.0.0",
+			expected: imageResource{SubscriptionID: "sub", ResourceGroup: "rg", Gallery: "gallery", Image: "ubuntu", Version: "1.0.0"},
+			ok:       true,
+		},
+		{
+			name: "gallery image definition",
+			id:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/galleries/gallery/images/ubuntu",
+		},
+		{
+			name: "other resource",
+			id:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk",
+		},
+		{
+			name: "other provider",
+			id:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/images/ubuntu",
+		},
+		{
+			name: "invalid ID",
+			id:   "ubuntu",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image, ok := parseImageID(test.id)
+			if ok != test.ok {
+				t.Fatalf("expected ok to be %t, got %t", test.ok, ok)
+			}
+			if image != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, image)
+			}
+		})
+	}
+}
+
+func TestImageConfig(t *testing.T) {
+	c := &config{SubscriptionID: "vm-sub", ResourceGroup: "vm-rg"}
+
+	image, ok := parseImageID("/subscriptions/image-sub/resourceGroups/rg/providers/Microsoft.Compute/images/ubuntu")
+	if !ok {
+		t.Fatal("failed to parse the image ID")
+	}
+	if cfg := imageConfig(c, image); cfg.SubscriptionID != "image-sub" {
+		t.Errorf("expected the subscription of the image, got %q", cfg.SubscriptionID)
+	}
+	if c.SubscriptionID != "vm-sub" {
+		t.Errorf("expected the config of the VM to be unchanged, got subscription %q", c.SubscriptionID)
+	}
+}
+
+type fakeImageGetter struct {
+	err       error
+	requested string
+}
+
+func (f *fakeImageGetter) Get(_ context.Context, resourceGroupName string, imageName string, _ string) (compute.Image, error) {
+	f.requested = resourceGroupName + "/" + imageName
+	return compute.Image{}, f.err
+}
+
+type fakeGalleryImageVersionGetter struct {
+	err       error
+	requested string
+}
+
+func (f *fakeGalleryImageVersionGetter) Get(_ context.Context, resourceGroupName string, galleryName string, galleryImageName string, galleryImageVersionName string, _ compute.ReplicationStatusTypes) (compute.GalleryImageVersion, error) {
+	f.requested = resourceGroupName + "/" + galleryName + "/" + galleryImageName + "/" + galleryImageVersionName
+	return compute.GalleryImageVersion{}, f.err
+}
+
+func TestCheckImageIDExists(t *testing.T) {
+	errNotFound := autorest.DetailedError{StatusCode: http.StatusNotFound, Original: errors.New("not found")}
+	managedImage := imageResource{ResourceGroup: "rg", Image: "ubuntu"}
+	galleryImage := imageResource{ResourceGroup: "rg", Gallery: "gallery", Image: "ubuntu", Version: "1.0.0"}
+
+	tests := []struct {
+		name              string
+		image             imageResource
+		err               error
+		expectedRequest   string
+		expectErr         bool
+		expectNotFoundErr bool
+	}{
+		{
+			name:            "managed image exists",
+			image:           managedImage,
+			expectedRequest: "rg/ubuntu",
+		},
+		{
+			name:              "managed image not found",
+			image:             managedImage,
+			err:               errNotFound,
+			expectedRequest:   "rg/ubuntu",
+			expectErr:         true,
+			expectNotFoundErr: true,
+		},
+		{
+			name:            "gallery image version exists",
+			image:           galleryImage,
+			expectedRequest: "rg/gallery/ubuntu/1.0.0",
+		},
+		{
+			name:              "gallery image version not found",
+			image:             galleryImage,
+			err:               errNotFound,
+			expectedRequest:   "rg/gallery/ubuntu/1.0.0",
+			expectErr:         true,
+			expectNotFoundErr: true,
+		},
+		{
+			name:            "gallery image version lookup fails",
+			image:           galleryImage,
+			err:             errors.New("forbidden"),
+			expectedRequest: "rg/gallery/ubuntu/1.0.0",
+			expectErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			images := &fakeImageGetter{err: test.err}
+			versions := &fakeGalleryImageVersionGetter{err: test.err}
+
+			err := checkImageIDExists(context.Background(), images, versions, test.image)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error: %t, got: %v", test.expectErr, err)
+			}
+			if isTerminal, _, _ := cloudprovidererrors.IsTerminalError(err); isTerminal != test.expectNotFoundErr {
+				t.Errorf("expected a terminal ImageNotFound error: %t, got: %v", test.expectNotFoundErr, err)
+			}
+			if requested := images.requested + versions.requested; requested != test.expectedRequest {
+				t.Errorf("expected %q to be requested, got %q", test.expectedRequest, requested)
+			}
+		})
+	}
+}
+
+func TestValidateOSDiskSize(t *testing.T) {
+	tests := []struct {
+		name            string
+		osDiskSize      int32
+		imageOSDiskSize int32
+		expectErr       bool
+	}{
+		{name: "image default", osDiskSize: 0, imageOSDiskSize: 64},
+		{name: "unknown image size", osDiskSize: 16, imageOSDiskSize: 0},
+		{name: "same size", osDiskSize: 64, imageOSDiskSize: 64},
+		{name: "larger size", osDiskSize: 128, imageOSDiskSize: 64},
+		{name: "smaller size", osDiskSize: 30, imageOSDiskSize: 64, expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateOSDiskSize(test.osDiskSize, test.imageOSDiskSize)
+			if (err != nil) != test.expectErr {
+				t.Errorf("expected error: %t, got %v", test.expectErr, err)
+			}
+		})
+	}
+}
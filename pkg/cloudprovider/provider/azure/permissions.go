@@ -0,0 +1,244 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/authorization/mgmt/2015-07-01/authorization"
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// permissionsRequestTimeout is the timeout for listing the permissions on all required resource groups
+const permissionsRequestTimeout = 30 * time.Second
+
+// ValidatePermissions lists the permissions of the service principal on the resource groups used by the spec and
+// returns the actions which are required to create and delete the VM but aren't permitted.
+func (p *provider) ValidatePermissions(spec clusterv1alpha1.MachineSpec) ([]string, error) {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	required, err := requiredActions(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), permissionsRequestTimeout)
+	defer cancel()
+
+	client, err := getPermissionsClient(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create permissions client: %v", err)
+	}
+
+	resourceGroups := make([]string, 0, len(required))
+	for resourceGroup := range required {
+		resourceGroups = append(resourceGroups, resourceGroup)
+	}
+	sort.Strings(resourceGroups)
+
+	var missing []string
+	for _, resourceGroup := range resourceGroups {
+		permissions, err := listPermissions(ctx, client, resourceGroup)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, action := range missingActions(permissions, required[resourceGroup]) {
+			missing = append(missing, fmt.Sprintf("%s on resource group %q", action, resourceGroup))
+		}
+	}
+
+	return missing, nil
+}
+
+// requiredActions returns the actions required to create and delete a VM for the config by resource group.
+func requiredActions(c *config) (map[string][]string, error) {
+	required := map[string][]string{}
+	add := func(resourceGroup string, actions ...string) {
+		for _, action := range actions {
+			if !sets.NewString(required[resourceGroup]...).Has(action) {
+				required[resourceGroup] = append(required[resourceGroup], action)
+			}
+		}
+	}
+
+	add(c.ResourceGroup,
+		"Microsoft.Compute/virtualMachines/read",
+		"Microsoft.Compute/virtualMachines/write",
+		"Microsoft.Compute/virtualMachines/delete",
+		"Microsoft.Compute/disks/read",
+		"Microsoft.Compute/disks/write",
+		"Microsoft.Compute/disks/delete",
+	)
+	add(c.NetworkResourceGroup,
+		"Microsoft.Network/networkInterfaces/read",
+		"Microsoft.Network/networkInterfaces/write",
+		"Microsoft.Network/networkInterfaces/delete",
+		"Microsoft.Network/networkInterfaces/join/action",
+	)
+	if c.SubnetID == "" {
+		add(c.VNetResourceGroup,
+			"Microsoft.Network/virtualNetworks/read",
+			"Microsoft.Network/virtualNetworks/subnets/read",
+			"Microsoft.Network/virtualNetworks/subnets/join/action",
+		)
+	} else {
+		subnet, err := parseSubnetID(c.SubnetID)
+		if err != nil {
+			return nil, err
+		}
+		// Permissions are only listed in the subscription of the config, a subnet in another subscription is
+		// checked by the validation instead
+		if strings.EqualFold(subnet.subscriptionID, c.SubscriptionID) {
+			add(subnet.resourceGroup,
+				"Microsoft.Network/virtualNetworks/subnets/read",
+				"Microsoft.Network/virtualNetworks/subnets/join/action",
+			)
+		}
+	}
+
+	if c.PrivateIPAddress != "" {
+		subnet, err := configuredSubnet(c)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(subnet.subscriptionID, c.SubscriptionID) {
+			add(subnet.resourceGroup, "Microsoft.Network/virtualNetworks/CheckIpAddressAvailability/read")
+		}
+	}
+
+	if c.AssignPublicIP {
+		add(c.NetworkResourceGroup,
+			"Microsoft.Network/publicIPAddresses/read",
+			"Microsoft.Network/publicIPAddresses/write",
+			"Microsoft.Network/publicIPAddresses/delete",
+			"Microsoft.Network/publicIPAddresses/join/action",
+		)
+	}
+	if c.AvailabilitySet != "" && (c.AssignAvailabilitySet == nil || *c.AssignAvailabilitySet) {
+		add(c.ResourceGroup, "Microsoft.Compute/availabilitySets/read")
+	}
+	if c.VirtualMachineScaleSet != "" {
+		add(c.ResourceGroup, "Microsoft.Compute/virtualMachineScaleSets/read")
+	}
+	if usesDedicatedHost(c) {
+		resource, err := configuredDedicatedHost(c)
+		if err != nil {
+			return nil, err
+		}
+		// Like subnets, dedicated hosts in another subscription are only checked by the validation
+		if strings.EqualFold(resource.subscriptionID, c.SubscriptionID) {
+			add(resource.resourceGroup, "Microsoft.Compute/hostGroups/read")
+			if resource.host != "" {
+				add(resource.resourceGroup, "Microsoft.Compute/hostGroups/hosts/read")
+			}
+		}
+	}
+	if c.SecurityGroupName != "" {
+		add(c.ResourceGroup,
+			"Microsoft.Network/networkSecurityGroups/read",
+			"Microsoft.Network/networkSecurityGroups/join/action",
+		)
+	}
+	if len(c.ApplicationSecurityGroups) > 0 {
+		add(c.ResourceGroup,
+			"Microsoft.Network/applicationSecurityGroups/read",
+			"Microsoft.Network/applicationSecurityGroups/joinIpConfiguration/action",
+		)
+	}
+	if c.InstallGPUDriver {
+		add(c.ResourceGroup,
+			"Microsoft.Compute/virtualMachines/extensions/read",
+			"Microsoft.Compute/virtualMachines/extensions/write",
+		)
+	}
+	if c.DataDiskSourceID != "" {
+		source, err := azure.ParseResourceID(c.DataDiskSourceID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sourceResourceID: %v", err)
+		}
+		add(source.ResourceGroup, fmt.Sprintf("%s/%s/read", source.Provider, source.ResourceType))
+	}
+
+	return required, nil
+}
+
+func listPermissions(ctx context.Context, client *authorization.PermissionsClient, resourceGroup string) ([]authorization.Permission, error) {
+	list, err := client.ListForResourceGroup(ctx, resourceGroup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list permissions on resource group %q: %v", resourceGroup, err)
+	}
+
+	var permissions []authorization.Permission
+	for list.NotDone() {
+		permissions = append(permissions, list.Values()...)
+		if err = list.Next(); err != nil {
+			return nil, fmt.Errorf("failed to iterate the result list: %v", err)
+		}
+	}
+
+	return permissions, nil
+}
+
+// missingActions returns the actions which aren't permitted. An action is permitted if it matches the actions of a
+// permission without matching its not actions, just like Azure evaluates the role assignments.
+func missingActions(permissions []authorization.Permission, actions []string) []string {
+	var missing []string
+	for _, action := range actions {
+		permitted := false
+		for _, permission := range permissions {
+			if matchesAnyAction(permission.Actions, action) && !matchesAnyAction(permission.NotActions, action) {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			missing = append(missing, action)
+		}
+	}
+
+	return missing
+}
+
+// matchesAnyAction returns whether the action matches one of the patterns, which can contain wildcards, e.g.
+// "Microsoft.Compute/*" or "*/read". Actions are case-insensitive.
+func matchesAnyAction(patterns *[]string, action string) bool {
+	if patterns == nil {
+		return false
+	}
+
+	for _, pattern := range *patterns {
+		expr := "(?i)^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+		if regexp.MustCompile(expr).MatchString(action) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/authorization/mgmt/2015-07-01/authorization"
+)
+
+func TestMissingActions(t *testing.T) {
+	actions := []string{
+		"Microsoft.Compute/virtualMachines/read",
+		"Microsoft.Compute/virtualMachines/write",
+		"Microsoft.Network/networkInterfaces/delete",
+	}
+
+	tests := []struct {
+		name        string
+		permissions []authorization.Permission
+		expected    []string
+	}{
+		{
+			name:     "no permissions",
+			expected: actions,
+		},
+		{
+			name:        "owner",
+			permissions: []authorization.Permission{{Actions: &[]string{"*"}}},
+		},
+		{
+			name:        "reader",
+			permissions: []authorization.Permission{{Actions: &[]string{"*/read"}}},
+			expected:    []string{"Microsoft.Compute/virtualMachines/write", "Microsoft.Network/networkInterfaces/delete"},
+		},
+		{
+			name: "wildcard with not actions",
+			permissions: []authorization.Permission{{
+				Actions:    &[]string{"microsoft.compute/*", "Microsoft.Network/networkInterfaces/*"},
+				NotActions: &[]string{"Microsoft.Compute/virtualMachines/write"},
+			}},
+			expected: []string{"Microsoft.Compute/virtualMachines/write"},
+		},
+		{
+			name: "not actions of another permission",
+			permissions: []authorization.Permission{
+				{Actions: &[]string{"*"}, NotActions: &[]string{"*/write"}},
+				{Actions: &[]string{"Microsoft.Compute/virtualMachines/write"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if missing := missingActions(test.permissions, actions); !reflect.DeepEqual(missing, test.expected) {
+				t.Errorf("expected missing actions %v, got %v", test.expected, missing)
+			}
+		})
+	}
+}
+
+func TestRequiredActions(t *testing.T) {
+	c := &config{
+		ResourceGroup:        "rg",
+		VNetResourceGroup:    "network-rg",
+		NetworkResourceGroup: "rg",
+		AssignPublicIP:       true,
+		DataDiskSourceID:     "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/disk",
+	}
+
+	required, err := requiredActions(c)
+	if err != nil {
+		t.Fatalf("failed to get required actions: %v", err)
+	}
+
+	if len(required) != 2 {
+		t.Fatalf("expected actions for 2 resource groups, got %v", required)
+	}
+	if len(missingActions(nil, required["network-rg"])) != 3 {
+		t.Errorf("expected 3 actions on the network resource group, got %v", required["network-rg"])
+	}
+
+	counts := map[string]int{}
+	for _, action := range required["rg"] {
+		counts[action]++
+	}
+	if counts["Microsoft.Network/publicIPAddresses/write"] != 1 {
+		t.Errorf("expected the public IP to require write access, got %v", required["rg"])
+	}
+	if counts["Microsoft.Compute/disks/read"] != 1 {
+		t.Errorf("expected the disk read action to be required once, got %v", required["rg"])
+	}
+}
+
+func TestRequiredActionsNetworkResourceGroup(t *testing.T) {
+	c := &config{
+		ResourceGroup:        "rg",
+		VNetResourceGroup:    "vnet-rg",
+		NetworkResourceGroup: "nic-rg",
+		AssignPublicIP:       true,
+	}
+
+	required, err := requiredActions(c)
+	if err != nil {
+		t.Fatalf("failed to get required actions: %v", err)
+	}
+
+	for _, action := range required["rg"] {
+		if strings.HasPrefix(action, "Microsoft.Network/") {
+			t.Errorf("expected no network actions on the resource group of the VM, got %s", action)
+		}
+	}
+	if len(missingActions(nil, required["nic-rg"])) != 8 {
+		t.Errorf("expected 8 actions on the network resource group, got %v", required["nic-rg"])
+	}
+}
+
+func TestRequiredActionsDedicatedHost(t *testing.T) {
+	tests := []struct {
+		name        string
+		hostGroupID string
+		hostID      string
+		expected    map[string]int
+	}{
+		{
+			name:        "host group in the same subscription",
+			hostGroupID: "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups/group",
+			expected:    map[string]int{"rg": 13, "hosts-rg": 1},
+		},
+		{
+			name:     "host in the same subscription",
+			hostID:   "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups/group/hosts/host-1",
+			expected: map[string]int{"rg": 13, "hosts-rg": 2},
+		},
+		{
+			name:     "host in another subscription",
+			hostID:   "/subscriptions/other/resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups/group/hosts/host-1",
+			expected: map[string]int{"rg": 13},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{
+				SubscriptionID:       "sub",
+				ResourceGroup:        "rg",
+				VNetResourceGroup:    "rg",
+				NetworkResourceGroup: "rg",
+				HostGroupID:          test.hostGroupID,
+				HostID:               test.hostID,
+			}
+
+			required, err := requiredActions(c)
+			if err != nil {
+				t.Fatalf("failed to get required actions: %v", err)
+			}
+
+			counts := map[string]int{}
+			for resourceGroup, actions := range required {
+				counts[resourceGroup] = len(actions)
+			}
+			if !reflect.DeepEqual(counts, test.expected) {
+				t.Errorf("expected the number of actions by resource group to be %v, got %v", test.expected, counts)
+			}
+		})
+	}
+}
+
+func TestRequiredActionsSubnetID(t *testing.T) {
+	tests := []struct {
+		name     string
+		subnetID string
+		expected map[string]int
+	}{
+		{
+			name:     "subnet in the same subscription",
+			subnetID: "/subscriptions/sub/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub/subnets/nodes",
+			expected: map[string]int{"rg": 10, "hub-rg": 2},
+		},
+		{
+			name:     "subnet in another subscription",
+			subnetID: "/subscriptions/hub/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub/subnets/nodes",
+			expected: map[string]int{"rg": 10},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{
+				SubscriptionID:       "sub",
+				ResourceGroup:        "rg",
+				VNetResourceGroup:    "network-rg",
+				NetworkResourceGroup: "rg",
+				SubnetID:             test.subnetID,
+			}
+
+			required, err := requiredActions(c)
+			if err != nil {
+				t.Fatalf("failed to get required actions: %v", err)
+			}
+
+			counts := map[string]int{}
+			for resourceGroup, actions := range required {
+				counts[resourceGroup] = len(actions)
+			}
+			if !reflect.DeepEqual(counts, test.expected) {
+				t.Errorf("expected the number of actions by resource group to be %v, got %v", test.expected, counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+	cloudproviderutil "github.com/kubermatic/machine-controller/pkg/cloudprovider/util"
+
+	"k8s.io/klog"
+)
+
+const (
+	// priceRequestTimeout is the timeout for fetching the retail prices of a VM size
+	priceRequestTimeout = 30 * time.Second
+	// hourlyUnitOfMeasure is the unit of measure of the hourly consumption prices of VMs
+	hourlyUnitOfMeasure = "1 Hour"
+)
+
+// retailPricesRetryOptions retry requests to the retail prices API which are throttled or fail on the server side,
+// it's a variable to be able to test without delays.
+var retailPricesRetryOptions = cloudproviderutil.RetryOptions{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    8 * time.Second,
+	Jitter:      0.5,
+	Retryable:   isRetryableRetailPricesError,
+}
+
+// retailPricesURL is the endpoint of the Azure retail prices API, it's a variable to be able to test against a
+// local server.
+var retailPricesURL = "https://prices.azure.com/api/retail/prices"
+
+type retailPrices struct {
+	Items        []retailPrice `json:"Items"`
+	NextPageLink string        `json:"NextPageLink"`
+}
+
+type retailPrice struct {
+	CurrencyCode  string  `json:"currencyCode"`
+	RetailPrice   float64 `json:"retailPrice"`
+	UnitOfMeasure string  `json:"unitOfMeasure"`
+	ArmRegionName string  `json:"armRegionName"`
+	ArmSkuName    string  `json:"armSkuName"`
+	SkuName       string  `json:"skuName"`
+	ProductName   string  `json:"productName"`
+	Type          string  `json:"type"`
+}
+
+// Price returns the estimated hourly price in USD of the VM size in the location of the given spec, based on the
+// Azure retail prices. Spot VMs are priced with the current spot price, not with the configured max price.
+func (p *provider) Price(spec clusterv1alpha1.MachineSpec) (float64, error) {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), priceRequestTimeout)
+	defer cancel()
+
+	return getVMSizePrice(ctx, http.DefaultClient, c.Location, c.VMSize, c.Spot)
+}
+
+// getVMSizePrice returns the hourly Linux consumption price in USD of the VM size in the location.
+func getVMSizePrice(ctx context.Context, client *http.Client, location, vmSize string, spot bool) (float64, error) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	cacheKey := fmt.Sprintf("%s-%s-%t-vm-price", location, vmSize, spot)
+	if cachedPrice, found := cache.Get(cacheKey); found {
+		klog.V(3).Info("found VM price in cache!")
+		return cachedPrice.(float64), nil
+	}
+
+	filter := fmt.Sprintf("serviceName eq 'Virtual Machines' and priceType eq 'Consumption' and armRegionName eq '%s' and armSkuName eq '%s'", location, vmSize)
+	nextPage := fmt.Sprintf("%s?currencyCode=USD&$filter=%s", retailPricesURL, url.QueryEscape(filter))
+
+	for nextPage != "" {
+		var prices *retailPrices
+		err := cloudproviderutil.RetryWithBackoff(ctx, retailPricesRetryOptions, func() error {
+			var err error
+			prices, err = fetchRetailPrices(ctx, client, nextPage)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		for _, price := range prices.Items {
+			if !isLinuxHourlyPrice(price, spot) {
+				continue
+			}
+			cache.SetDefault(cacheKey, price.RetailPrice)
+			return price.RetailPrice, nil
+		}
+
+		nextPage = prices.NextPageLink
+	}
+
+	return 0, fmt.Errorf("no retail price found for VM size %q in location %q", vmSize, location)
+}
+
+func fetchRetailPrices(ctx context.Context, client *http.Client, pageURL string) (*retailPrices, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for retail prices: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch retail prices: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, retailPricesStatusError{statusCode: resp.StatusCode}
+	}
+
+	prices := &retailPrices{}
+	if err := json.NewDecoder(resp.Body).Decode(prices); err != nil {
+		return nil, fmt.Errorf("failed to decode retail prices: %v", err)
+	}
+
+	return prices, nil
+}
+
+// retailPricesStatusError is returned for responses of the retail prices API with an unexpected status code.
+type retailPricesStatusError struct {
+	statusCode int
+}
+
+func (e retailPricesStatusError) Error() string {
+	return fmt.Sprintf("failed to fetch retail prices: unexpected status code %d", e.statusCode)
+}
+
+// isRetryableRetailPricesError returns whether the request was throttled or failed on the server side.
+func isRetryableRetailPricesError(err error) bool {
+	var statusErr retailPricesStatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	return statusErr.statusCode == http.StatusTooManyRequests || statusErr.statusCode >= http.StatusInternalServerError
+}
+
+// isLinuxHourlyPrice returns whether the price is the hourly Linux price of either a regular or a spot VM.
+// Windows and low priority prices are ignored, as those VMs can't be created by machine-controller.
+func isLinuxHourlyPrice(price retailPrice, spot bool) bool {
+	if price.CurrencyCode != "USD" || price.UnitOfMeasure != hourlyUnitOfMeasure {
+		return false
+	}
+	if strings.Contains(price.ProductName, "Windows") || strings.HasSuffix(price.SkuName, "Low Priority") {
+		return false
+	}
+
+	return strings.HasSuffix(price.SkuName, "Spot") == spot
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetVMSizePrice(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prices := retailPrices{}
+		if r.URL.Query().Get("page") == "" {
+			prices.Items = []retailPrice{
+				{CurrencyCode: "USD", RetailPrice: 0.2, UnitOfMeasure: "1 Hour", SkuName: "D2s v3", ProductName: "Virtual Machines DSv3 Series Windows"},
+				{CurrencyCode: "USD", RetailPrice: 0.01, UnitOfMeasure: "1 Hour", SkuName: "D2s v3 Low Priority", ProductName: "Virtual Machines DSv3 Series"},
+			}
+			prices.NextPageLink = server.URL + "?page=2"
+		} else {
+			prices.Items = []retailPrice{
+				{CurrencyCode: "USD", RetailPrice: 0.02, UnitOfMeasure: "1 Hour", SkuName: "D2s v3 Spot", ProductName: "Virtual Machines DSv3 Series"},
+				{CurrencyCode: "USD", RetailPrice: 0.096, UnitOfMeasure: "1 Hour", SkuName: "D2s v3", ProductName: "Virtual Machines DSv3 Series"},
+			}
+		}
+		_ = json.NewEncoder(w).Encode(prices)
+	}))
+	defer server.Close()
+
+	oldRetailPricesURL := retailPricesURL
+	retailPricesURL = server.URL
+	defer func() { retailPricesURL = oldRetailPricesURL }()
+
+	tests := []struct {
+		name          string
+		location      string
+		vmSize        string
+		spot          bool
+		expectedPrice float64
+	}{
+		{
+			name:          "regular VM",
+			location:      "pricing-test-regular",
+			vmSize:        "Standard_D2s_v3",
+			expectedPrice: 0.096,
+		},
+		{
+			name:          "spot VM",
+			location:      "pricing-test-spot",
+			vmSize:        "Standard_D2s_v3",
+			spot:          true,
+			expectedPrice: 0.02,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			price, err := getVMSizePrice(context.Background(), server.Client(), test.location, test.vmSize, test.spot)
+			if err != nil {
+				t.Fatalf("failed to get price: %v", err)
+			}
+			if price != test.expectedPrice {
+				t.Errorf("expected price %v, got %v", test.expectedPrice, price)
+			}
+		})
+	}
+}
+
+func TestGetVMSizePriceRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		statusCodes   []int
+		expectError   bool
+		expectedCalls int
+	}{
+		{
+			name:          "throttled",
+			statusCodes:   []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+			expectedCalls: 3,
+		},
+		{
+			name:          "bad request",
+			statusCodes:   []int{http.StatusBadRequest},
+			expectError:   true,
+			expectedCalls: 1,
+		},
+	}
+
+	oldRetailPricesURL, oldRetryOptions := retailPricesURL, retailPricesRetryOptions
+	defer func() { retailPricesURL, retailPricesRetryOptions = oldRetailPricesURL, oldRetryOptions }()
+	retailPricesRetryOptions.BaseDelay = time.Millisecond
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls <= len(test.statusCodes) {
+					w.WriteHeader(test.statusCodes[calls-1])
+					return
+				}
+				prices := retailPrices{Items: []retailPrice{
+					{CurrencyCode: "USD", RetailPrice: 0.096, UnitOfMeasure: "1 Hour", SkuName: "D2s v3", ProductName: "Virtual Machines DSv3 Series"},
+				}}
+				_ = json.NewEncoder(w).Encode(prices)
+			}))
+			defer server.Close()
+			retailPricesURL = server.URL
+
+			_, err := getVMSizePrice(context.Background(), server.Client(), "pricing-test-retries-"+test.name, "Standard_D2s_v3", false)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if calls != test.expectedCalls {
+				t.Errorf("expected %d requests, got %d", test.expectedCalls, calls)
+			}
+		})
+	}
+}
@@ -21,12 +21,20 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-11-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-05-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2020-10-01/resources"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/to"
 	gocache "github.com/patrickmn/go-cache"
 
@@ -44,22 +52,71 @@ import (
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog"
 	"k8s.io/utils/pointer"
+	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
 	CapabilityPremiumIO = "PremiumIO"
 	CapabilityUltraSSD  = "UltraSSDAvailable"
 	CapabilityValueTrue = "True"
+	CapabilityGPUs      = "GPUs"
+
+	CapabilityHyperVGenerations = "HyperVGenerations"
+	CapabilityMaxDataDiskCount  = "MaxDataDiskCount"
+
+	CapabilityEphemeralOSDiskSupported = "EphemeralOSDiskSupported"
+	CapabilityCachedDiskBytes          = "CachedDiskBytes"
+	CapabilityMaxResourceVolumeMB      = "MaxResourceVolumeMB"
+
+	// defaultImageOSDiskSize is the size in GB of the OS disk of marketplace Linux images, which don't expose it.
+	defaultImageOSDiskSize = 30
+
+	// maxDataDiskLUN is the highest LUN Azure allows for data disks.
+	maxDataDiskLUN = 63
+
+	// securityTypesStandard is the default security type, it's not yet part of the compute API version we use.
+	securityTypesStandard compute.SecurityTypes = "Standard"
+
+	// storageAccountTypesPremiumV2LRS is not yet part of the compute API version we use.
+	storageAccountTypesPremiumV2LRS compute.StorageAccountTypes = "PremiumV2_LRS"
 
 	machineUIDTag = "Machine-UID"
 
+	// provisioningStateFailed is the provisioning state of resources whose last operation failed
+	provisioningStateFailed = "Failed"
+
+	// default keys of the tags which group the resources of machines by MachineDeployment and cluster
+	defaultMachineDeploymentTagKey = "MachineDeployment"
+	defaultClusterTagKey           = "Cluster"
+
+	// retained*Tags replace the machine UID tag of OS disks which are kept after the machine got deleted
+	retainedMachineNameTag = "Retained-Machine-Name"
+	retainedMachineUIDTag  = "Retained-Machine-UID"
+	retainedAtTag          = "Retained-At"
+
 	finalizerPublicIP   = "kubermatic.io/cleanup-azure-public-ip"
 	finalizerPublicIPv6 = "kubermatic.io/cleanup-azure-public-ipv6"
 	finalizerNIC        = "kubermatic.io/cleanup-azure-nic"
 	finalizerDisks      = "kubermatic.io/cleanup-azure-disks"
 	finalizerVM         = "kubermatic.io/cleanup-azure-vm"
+	// finalizerSecurityGroups guards the associations of the NIC with security groups, the groups are never deleted
+	finalizerSecurityGroups = "kubermatic.io/cleanup-azure-nic-security-groups"
+
+	// droppedTagsAnnotationKey records the keys of the tags which exceeded the tag limit of the VM
+	droppedTagsAnnotationKey = "kubermatic.io/azure-dropped-tags"
+
+	// maxVMTags is the maximum number of tags Azure supports per resource
+	maxVMTags = 50
+	// maxTagKeyLength and maxTagValueLength are the maximum number of characters of tag keys and values
+	maxTagKeyLength   = 512
+	maxTagValueLength = 256
+	// invalidTagKeyCharacters can't be used in tag keys
+	invalidTagKeyCharacters = `<>%&\?/`
 )
 
 const (
@@ -82,6 +139,7 @@ type config struct {
 	Location              string
 	ResourceGroup         string
 	VNetResourceGroup     string
+	NetworkResourceGroup  string
 	VMSize                string
 	VNetName              string
 	SubnetName            string
@@ -95,13 +153,71 @@ type config struct {
 	ImagePlan             *compute.Plan
 	ImageReference        *compute.ImageReference
 
-	OSDiskSize   int32
-	OSDiskSKU    *compute.StorageAccountTypes
-	DataDiskSize int32
-	DataDiskSKU  *compute.StorageAccountTypes
-
-	AssignPublicIP bool
-	Tags           map[string]string
+	ApplicationSecurityGroups []string
+
+	SubnetID string
+
+	OSDiskSize         int32
+	OSDiskSKU          *compute.StorageAccountTypes
+	DataDiskSize       int32
+	DataDiskSKU        *compute.StorageAccountTypes
+	DataDiskIOPS       *int64
+	DataDiskThroughput *int64
+
+	OSDiskPerformanceTier   *string
+	DataDiskPerformanceTier *string
+	DataDiskSourceID        string
+	DataDiskCreateOption    compute.DiskCreateOption
+	RetainOSDiskOnDelete    bool
+	PreferEphemeralOSDisk   bool
+
+	// EphemeralOSDiskPlacement is set in Create if the OS disk is ephemeral, it's empty for managed OS disks.
+	EphemeralOSDiskPlacement compute.DiffDiskPlacement
+
+	AssignPublicIP             bool
+	PublicIPIdleTimeoutMinutes *int32
+	RequireEgress              bool
+	PrivateIPAddress           string
+	Tags                       map[string]string
+
+	ComputerName       string
+	ComputerNamePrefix string
+	AdminUsername      string
+
+	AllowExtensionOperations *bool
+	EnableBootDiagnostics    bool
+	InstallGPUDriver         bool
+	Extensions               []azuretypes.VMExtension
+	NetworkMTU               *int
+
+	EnforceResourceGroupLocation bool
+
+	SecurityType compute.SecurityTypes
+	UefiSettings *compute.UefiSettings
+
+	VirtualMachineScaleSet string
+	PlatformFaultDomain    *int32
+	HostGroupID            string
+	HostID                 string
+
+	Spot                   bool
+	SpotMaxPrice           float64
+	EvictionCooldown       time.Duration
+	EvictionCooldownJitter time.Duration
+
+	InstanceCacheTTL time.Duration
+	APITimeout       time.Duration
+	VMCreateTimeout  time.Duration
+	VMDeleteTimeout  time.Duration
+	VMPollInterval   time.Duration
+
+	LicenseType string
+
+	MachineDeploymentTagKey string
+	ClusterTagKey           string
+	// GroupingTags are not part of the provider spec, they are derived from the machine when its resources get
+	// created or migrated.
+	GroupingTags map[string]string
 }
 
 type azureVM struct {
@@ -110,6 +226,11 @@ type azureVM struct {
 	status      instance.Status
 }
 
+// spotEviction is cached for evicted Spot VMs, the recreation of the VM is delayed until the cooldown is over.
+type spotEviction struct {
+	cooldownUntil time.Time
+}
+
 func (vm *azureVM) Addresses() map[string]v1.NodeAddressType {
 	return vm.ipAddresses
 }
@@ -126,6 +247,14 @@ func (vm *azureVM) Status() instance.Status {
 	return vm.status
 }
 
+// HostName returns the computer name of the VM, which is the hostname of the guest OS.
+func (vm *azureVM) HostName() string {
+	if vm.vm.VirtualMachineProperties == nil || vm.vm.OsProfile == nil {
+		return ""
+	}
+	return to.String(vm.vm.OsProfile.ComputerName)
+}
+
 var imageReferences = map[providerconfigtypes.OperatingSystem]compute.ImageReference{
 	providerconfigtypes.OperatingSystemCentOS: {
 		Publisher: to.StringPtr("OpenLogic"),
@@ -188,6 +317,19 @@ var dataDiskSKUs = map[compute.StorageAccountTypes]string{
 	compute.StorageAccountTypesStandardSSDLRS: "", // StandardSSD_LRS
 	compute.StorageAccountTypesPremiumLRS:     "", // Premium_LRS
 	compute.StorageAccountTypesUltraSSDLRS:    "", // UltraSSD_LRS
+	storageAccountTypesPremiumV2LRS:           "", // PremiumV2_LRS
+}
+
+// performanceTiers are the performance tiers which can be set for disks of the given SKU.
+var performanceTiers = map[compute.StorageAccountTypes][]string{
+	compute.StorageAccountTypesPremiumLRS: {"P1", "P2", "P3", "P4", "P6", "P10", "P15", "P20", "P30", "P40", "P50", "P60", "P70", "P80"},
+	compute.StorageAccountTypesPremiumZRS: {"P1", "P2", "P3", "P4", "P6", "P10", "P15", "P20", "P30", "P40", "P50", "P60", "P70", "P80"},
+}
+
+// dataDiskPerformanceSKUs are the data disk SKUs which allow provisioning IOPS and throughput independently of the disk size.
+var dataDiskPerformanceSKUs = map[compute.StorageAccountTypes]string{
+	compute.StorageAccountTypesUltraSSDLRS: "", // UltraSSD_LRS
+	storageAccountTypesPremiumV2LRS:        "", // PremiumV2_LRS
 }
 
 var (
@@ -197,6 +339,102 @@ var (
 	cache     = gocache.New(10*time.Minute, 10*time.Minute)
 )
 
+const (
+	defaultInstanceCacheTTL = 30 * time.Second
+	defaultAPITimeout       = 5 * time.Minute
+	// operationTimeout bounds the long running operations other than the creation and the deletion of the VM, so a
+	// single stuck operation can't use up the API timeout of the whole reconciliation
+	operationTimeout = 2 * time.Minute
+	// spotEvictionRetention is the time an eviction is remembered after its cooldown is over
+	spotEvictionRetention = 10 * time.Minute
+	// readyCheckInterval is the interval in which the status of a VM is checked while waiting for it to be ready
+	readyCheckInterval = 5 * time.Second
+
+	// minNetworkMTU and maxNetworkMTU are the MTUs supported by Azure network interfaces
+	minNetworkMTU = 1280
+	maxNetworkMTU = 9000
+
+	// minPublicIPIdleTimeoutMinutes and maxPublicIPIdleTimeoutMinutes are the idle timeouts supported by public IPs
+	minPublicIPIdleTimeoutMinutes = 4
+	maxPublicIPIdleTimeoutMinutes = 30
+
+	// vmTypeStandard and vmTypeVMSS are the VM types of the external cloud controller manager for standalone VMs
+	// and VMs of scale sets
+	vmTypeStandard = "standard"
+	vmTypeVMSS     = "vmss"
+
+	// the backoff of API requests of the external cloud controller manager, which are its defaults
+	cloudProviderBackoffRetries  = 6
+	cloudProviderBackoffDuration = 5
+	cloudProviderBackoffExponent = 1.5
+	cloudProviderBackoffJitter   = 1.0
+)
+
+// maxComputerNameLengths are the maximum lengths of the computer name supported by Azure per OS type.
+var maxComputerNameLengths = map[compute.OperatingSystemTypes]int{
+	compute.OperatingSystemTypesLinux:   64,
+	compute.OperatingSystemTypesWindows: 15,
+}
+
+// cachedInstance holds the results of the API calls made to get the status and the addresses of a VM.
+type cachedInstance struct {
+	ipAddresses map[string]v1.NodeAddressType
+	status      instance.Status
+}
+
+func instanceCacheKey(uid types.UID) string {
+	return fmt.Sprintf("instance-%s", uid)
+}
+
+// apiContext returns the context for the Azure API calls of a single reconciliation, which is cancelled once the
+// API timeout is reached, so a hanging Azure endpoint can't block the reconciliation forever.
+func apiContext(c *config) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.APITimeout)
+}
+
+// apiTimeoutError marks errors caused by reaching the API timeout of the context. Those are never terminal, so the
+// machine is requeued and the calls are retried.
+func apiTimeoutError(ctx context.Context, c *config, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	return fmt.Errorf("azure API calls did not complete within %s, retrying: %w; last error: %v", c.APITimeout, ctx.Err(), err)
+}
+
+// operationFuture is the future of a long running Azure operation.
+type operationFuture interface {
+	WaitForCompletionRef(ctx context.Context, client autorest.Client) error
+}
+
+// waitForOperation waits for the long running operation within its own timeout, polling its state with the poll
+// interval, a poll interval of 0 keeps the one of the client. A timeout of 0 means that only the deadline of ctx
+// applies. If the operation doesn't complete in time, an error is returned which isn't terminal, so the operation
+// is checked again in the next reconciliation.
+func waitForOperation(ctx context.Context, future operationFuture, client autorest.Client, pollInterval, timeout time.Duration, operation string) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if pollInterval > 0 {
+		client.PollingDelay = pollInterval
+	}
+
+	if err := future.WaitForCompletionRef(ctx, client); err != nil {
+		if timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%s did not complete within %s, retrying: %w", operation, timeout, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func spotEvictionCacheKey(uid types.UID) string {
+	return fmt.Sprintf("spot-eviction-%s", uid)
+}
+
 func getOSImageReference(c *config, os providerconfigtypes.OperatingSystem) (*compute.ImageReference, error) {
 	if c.ImageID != "" {
 		return &compute.ImageReference{
@@ -215,17 +453,251 @@ func getOSImageReference(c *config, os providerconfigtypes.OperatingSystem) (*co
 
 	ref, supported := imageReferences[os]
 	if !supported {
-		return nil, fmt.Errorf("operating system %q not supported", os)
+		return nil, cloudprovidererrors.TerminalError{
+			Reason:  common.ImageNotFoundMachineError,
+			Message: fmt.Sprintf("operating system %q not supported, no default image exists for it", os),
+		}
 	}
 
 	return &ref, nil
 }
 
+// limitTags merges the tag groups, which are given in the order of their priority, and drops tags of the groups
+// with the lowest priority once the limit is reached. The first group contains the tags managed by the controller,
+// which are always kept. Within a group, tags are kept in the order of their keys to be deterministic.
+// The keys of the dropped tags are returned.
+func limitTags(limit int, groups ...map[string]string) (map[string]*string, []string) {
+	tags := map[string]*string{}
+	var dropped []string
+
+	for i, group := range groups {
+		keys := make([]string, 0, len(group))
+		for key := range group {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if _, exists := tags[key]; exists {
+				continue
+			}
+			if i > 0 && len(tags) >= limit {
+				dropped = append(dropped, key)
+				continue
+			}
+			tags[key] = to.StringPtr(group[key])
+		}
+	}
+
+	return tags, dropped
+}
+
+// groupingTagKey returns the configured key of a grouping tag. The default key is only used if the user-provided
+// tags don't contain it, Azure treats tag keys case-insensitively.
+func groupingTagKey(configured *string, defaultKey string, tags map[string]string) string {
+	if configured != nil {
+		return *configured
+	}
+	for key := range tags {
+		if strings.EqualFold(key, defaultKey) {
+			return ""
+		}
+	}
+	return defaultKey
+}
+
+// groupingTags returns the tags which group the resources of the machine by its MachineDeployment and its cluster,
+// which is the namespace of the machine. The MachineDeployment tag is omitted for machines without MachineDeployment.
+func groupingTags(c *config, machine *clusterv1alpha1.Machine, machineDeploymentName string) map[string]string {
+	tags := map[string]string{}
+	if c.MachineDeploymentTagKey != "" && machineDeploymentName != "" {
+		tags[c.MachineDeploymentTagKey] = machineDeploymentName
+	}
+	if c.ClusterTagKey != "" && machine.Namespace != "" {
+		tags[c.ClusterTagKey] = machine.Namespace
+	}
+	return tags
+}
+
+// getMachineDeploymentName returns the name of the MachineDeployment which owns the MachineSet of the machine, it's
+// empty for machines without MachineDeployment. Failing to get the MachineSet is an error, as the VM would miss
+// its MachineDeployment tag otherwise.
+func getMachineDeploymentName(ctx context.Context, machine *clusterv1alpha1.Machine, client ctrlruntimeclient.Client) (string, error) {
+	for _, ownerRef := range machine.OwnerReferences {
+		if ownerRef.Kind != "MachineSet" {
+			continue
+		}
+
+		machineSet := &clusterv1alpha1.MachineSet{}
+		if err := client.Get(ctx, types.NamespacedName{Name: ownerRef.Name, Namespace: machine.Namespace}, machineSet); err != nil {
+			return "", fmt.Errorf("failed to get MachineSet %q of machine %q: %w", ownerRef.Name, machine.Name, err)
+		}
+		for _, machineSetOwnerRef := range machineSet.OwnerReferences {
+			if machineSetOwnerRef.Kind == "MachineDeployment" {
+				return machineSetOwnerRef.Name, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// existingGroupingTags returns the grouping tags of an existing resource of the machine.
+func existingGroupingTags(c *config, tags map[string]*string) map[string]string {
+	grouping := map[string]string{}
+	for _, key := range []string{c.MachineDeploymentTagKey, c.ClusterTagKey} {
+		if value := tags[key]; key != "" && value != nil {
+			grouping[key] = *value
+		}
+	}
+	return grouping
+}
+
+// resourceTags returns the tags of the resources of the machine, which are the machine UID tag and the grouping tags.
+func resourceTags(machineUID types.UID, c *config) map[string]*string {
+	tags := map[string]*string{machineUIDTag: to.StringPtr(string(machineUID))}
+	for key, value := range c.GroupingTags {
+		tags[key] = to.StringPtr(value)
+	}
+	return tags
+}
+
+// validateGroupingTagKeys ensures that the keys of the grouping tags neither collide with each other nor with the
+// machine UID tag or the user-provided tags.
+func validateGroupingTagKeys(c *config) error {
+	reserved := []string{machineUIDTag}
+	for key := range c.Tags {
+		reserved = append(reserved, key)
+	}
+	sort.Strings(reserved)
+
+	for _, groupingKey := range []struct{ field, key string }{
+		{field: "machineDeploymentTagKey", key: c.MachineDeploymentTagKey},
+		{field: "clusterTagKey", key: c.ClusterTagKey},
+	} {
+		if groupingKey.key == "" {
+			continue
+		}
+		for _, key := range reserved {
+			if strings.EqualFold(groupingKey.key, key) {
+				return fmt.Errorf("%s %q collides with the tag %q", groupingKey.field, groupingKey.key, key)
+			}
+		}
+		reserved = append(reserved, groupingKey.key)
+	}
+
+	return nil
+}
+
+// reservedTagKeys returns the keys of the tags managed by the controller, which are the machine UID tag and the
+// enabled grouping tags.
+func reservedTagKeys(c *config) []string {
+	keys := []string{machineUIDTag}
+	for _, key := range []string{c.MachineDeploymentTagKey, c.ClusterTagKey} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// validateTags ensures that the user-provided tags are within the limits of Azure, the tags of the VM with the
+// reserved keys are managed by the controller.
+func validateTags(tags map[string]string, reservedKeys []string) error {
+	if limit := maxVMTags - len(reservedKeys); len(tags) > limit {
+		return invalidTagsError(fmt.Sprintf("at most %d tags are allowed as the tags %s are reserved, got %d", limit, strings.Join(reservedKeys, ", "), len(tags)))
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch {
+		case key == "":
+			return invalidTagsError("tag keys must not be empty")
+		case strings.EqualFold(key, machineUIDTag):
+			return invalidTagsError(fmt.Sprintf("tag %q is reserved", key))
+		case utf8.RuneCountInString(key) > maxTagKeyLength:
+			return invalidTagsError(fmt.Sprintf("key of tag %q exceeds %d characters", key, maxTagKeyLength))
+		case strings.ContainsAny(key, invalidTagKeyCharacters):
+			return invalidTagsError(fmt.Sprintf("key of tag %q must not contain any of the characters %s", key, invalidTagKeyCharacters))
+		case utf8.RuneCountInString(tags[key]) > maxTagValueLength:
+			return invalidTagsError(fmt.Sprintf("value of tag %q exceeds %d characters", key, maxTagValueLength))
+		}
+	}
+
+	return nil
+}
+
+func invalidTagsError(message string) error {
+	return cloudprovidererrors.TerminalError{
+		Reason:  common.InvalidConfigurationMachineError,
+		Message: fmt.Sprintf("invalid tags: %s", message),
+	}
+}
+
+// setDroppedTagsAnnotation records the keys of the dropped tags on the machine, or removes a stale record.
+func setDroppedTagsAnnotation(machine *clusterv1alpha1.Machine, droppedTags []string) {
+	if len(droppedTags) == 0 {
+		delete(machine.Annotations, droppedTagsAnnotationKey)
+		return
+	}
+
+	if machine.Annotations == nil {
+		machine.Annotations = map[string]string{}
+	}
+	machine.Annotations[droppedTagsAnnotationKey] = strings.Join(droppedTags, ",")
+}
+
+// getOSPlan returns the plan of the marketplace image of the VM. If the image reference is configured for an
+// operating system whose images require a plan, e.g. to use RHEL 9 instead of RHEL 8, the plan is derived from it.
+func getOSPlan(c *config, os providerconfigtypes.OperatingSystem) *compute.Plan {
+	if c.ImagePlan != nil {
+		return c.ImagePlan
+	}
+
+	plan, found := osPlans[os]
+	if !found || c.ImageID != "" || c.ImageReference == nil {
+		return plan
+	}
+
+	return &compute.Plan{
+		Name:      c.ImageReference.Sku,
+		Publisher: to.StringPtr(strings.ToLower(to.String(c.ImageReference.Publisher))),
+		Product:   c.ImageReference.Offer,
+	}
+}
+
 // New returns a digitalocean provider
 func New(configVarResolver *providerconfig.ConfigVarResolver) cloudprovidertypes.Provider {
 	return &provider{configVarResolver: configVarResolver}
 }
 
+// getConfigVarDurationValue returns the duration of the config var or defaultDuration if it isn't set. Unlike
+// GetConfigVarDurationValueOrDefault, an explicit 0 is kept, so it can disable a setting with a default.
+func (p *provider) getConfigVarDurationValue(configVar providerconfigtypes.ConfigVarString, defaultDuration time.Duration) (time.Duration, error) {
+	value, err := p.configVarResolver.GetConfigVarStringValue(configVar)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return defaultDuration, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("duration %q must not be negative", value)
+	}
+
+	return duration, nil
+}
+
 func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*config, *providerconfigtypes.Config, error) {
 	if provSpec.Value == nil {
 		return nil, nil, fmt.Errorf("machine.spec.providerconfig.value is nil")
@@ -248,89 +720,231 @@ func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*config, *p
 	c := config{}
 	c.SubscriptionID, err = p.configVarResolver.GetConfigVarStringValueOrEnv(rawCfg.SubscriptionID, envSubscriptionID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"subscriptionID\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "subscriptionID", Reason: err.Error()}
 	}
 
 	c.TenantID, err = p.configVarResolver.GetConfigVarStringValueOrEnv(rawCfg.TenantID, envTenantID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"tenantID\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "tenantID", Reason: err.Error()}
 	}
 
 	c.ClientID, err = p.configVarResolver.GetConfigVarStringValueOrEnv(rawCfg.ClientID, envClientID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"clientID\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "clientID", Reason: err.Error()}
 	}
 
 	c.ClientSecret, err = p.configVarResolver.GetConfigVarStringValueOrEnv(rawCfg.ClientSecret, envClientSecret)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"clientSecret\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "clientSecret", Reason: err.Error()}
 	}
 
 	c.ResourceGroup, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.ResourceGroup)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"resourceGroup\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "resourceGroup", Reason: err.Error()}
 	}
 
 	c.VNetResourceGroup, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.VNetResourceGroup)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"vnetResourceGroup\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "vnetResourceGroup", Reason: err.Error()}
 	}
 
 	if c.VNetResourceGroup == "" {
 		c.VNetResourceGroup = c.ResourceGroup
 	}
 
+	c.NetworkResourceGroup, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.NetworkResourceGroup)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "networkResourceGroup", Reason: err.Error()}
+	}
+
+	if c.NetworkResourceGroup == "" {
+		c.NetworkResourceGroup = c.ResourceGroup
+	}
+
 	c.Location, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.Location)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"location\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "location", Reason: err.Error()}
 	}
 
 	c.VMSize, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.VMSize)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"vmSize\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "vmSize", Reason: err.Error()}
 	}
 
 	c.VNetName, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.VNetName)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"vnetName\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "vnetName", Reason: err.Error()}
 	}
 
 	c.SubnetName, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.SubnetName)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"subnetName\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "subnetName", Reason: err.Error()}
+	}
+
+	c.SubnetID, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.SubnetID)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "subnetID", Reason: err.Error()}
 	}
 
 	c.LoadBalancerSku, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.LoadBalancerSku)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"loadBalancerSku\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "loadBalancerSku", Reason: err.Error()}
 	}
 
 	c.RouteTableName, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.RouteTableName)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"routeTableName\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "routeTableName", Reason: err.Error()}
 	}
 
 	c.AssignPublicIP, _, err = p.configVarResolver.GetConfigVarBoolValue(rawCfg.AssignPublicIP)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"assignPublicIP\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "assignPublicIP", Reason: err.Error()}
+	}
+	c.PublicIPIdleTimeoutMinutes = rawCfg.PublicIPIdleTimeoutMinutes
+	c.RequireEgress = rawCfg.RequireEgress
+
+	c.PrivateIPAddress, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.PrivateIPAddress)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "privateIPAddress", Reason: err.Error()}
+	}
+
+	c.ComputerName, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.ComputerName)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "computerName", Reason: err.Error()}
+	}
+
+	c.ComputerNamePrefix, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.ComputerNamePrefix)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "computerNamePrefix", Reason: err.Error()}
+	}
+
+	c.AdminUsername, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.AdminUsername)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "adminUsername", Reason: err.Error()}
+	}
+
+	if rawCfg.DataDiskSource != nil {
+		c.DataDiskSourceID, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.DataDiskSource.SourceResourceID)
+		if err != nil {
+			return nil, nil, cloudprovidererrors.FieldValidationError{Field: "dataDiskSource.sourceResourceID", Reason: err.Error()}
+		}
+		c.DataDiskCreateOption = compute.DiskCreateOption(rawCfg.DataDiskSource.CreateOption)
+	}
+
+	if rawCfg.Spot != nil {
+		c.Spot = true
+		c.SpotMaxPrice = -1
+		if rawCfg.Spot.MaxPrice != nil {
+			c.SpotMaxPrice = *rawCfg.Spot.MaxPrice
+		}
+
+		c.EvictionCooldown, err = p.configVarResolver.GetConfigVarDurationValueOrDefault(rawCfg.Spot.EvictionCooldown, 0)
+		if err != nil {
+			return nil, nil, cloudprovidererrors.FieldValidationError{Field: "spot.evictionCooldown", Reason: err.Error()}
+		}
+
+		c.EvictionCooldownJitter, err = p.configVarResolver.GetConfigVarDurationValueOrDefault(rawCfg.Spot.EvictionCooldownJitter, 0)
+		if err != nil {
+			return nil, nil, cloudprovidererrors.FieldValidationError{Field: "spot.evictionCooldownJitter", Reason: err.Error()}
+		}
+	}
+
+	c.InstanceCacheTTL, err = p.getConfigVarDurationValue(rawCfg.InstanceCacheTTL, defaultInstanceCacheTTL)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "instanceCacheTTL", Reason: err.Error()}
+	}
+
+	c.APITimeout, err = p.getConfigVarDurationValue(rawCfg.APITimeout, defaultAPITimeout)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "apiTimeout", Reason: err.Error()}
+	}
+	if c.APITimeout == 0 {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "apiTimeout", Reason: "must be greater than 0"}
+	}
+
+	for _, field := range []struct {
+		name  string
+		value providerconfigtypes.ConfigVarString
+		dest  *time.Duration
+	}{
+		{name: "vmCreateTimeout", value: rawCfg.VMCreateTimeout, dest: &c.VMCreateTimeout},
+		{name: "vmDeleteTimeout", value: rawCfg.VMDeleteTimeout, dest: &c.VMDeleteTimeout},
+		{name: "vmPollInterval", value: rawCfg.VMPollInterval, dest: &c.VMPollInterval},
+	} {
+		*field.dest, err = p.getConfigVarDurationValue(field.value, 0)
+		if err != nil {
+			return nil, nil, cloudprovidererrors.FieldValidationError{Field: field.name, Reason: err.Error()}
+		}
 	}
 
 	c.AssignAvailabilitySet = rawCfg.AssignAvailabilitySet
+	c.AllowExtensionOperations = rawCfg.AllowExtensionOperations
+	c.EnableBootDiagnostics = rawCfg.EnableBootDiagnostics
+	c.InstallGPUDriver = rawCfg.InstallGPUDriver
+	c.Extensions = rawCfg.Extensions
+	c.EnforceResourceGroupLocation = rawCfg.EnforceResourceGroupLocation
+	c.RetainOSDiskOnDelete = rawCfg.RetainOSDiskOnDelete
+	c.PreferEphemeralOSDisk = rawCfg.PreferEphemeralOSDisk
+	if rawCfg.SecurityType != nil {
+		c.SecurityType = compute.SecurityTypes(*rawCfg.SecurityType)
+	}
+	if rawCfg.UefiSettings != nil {
+		c.UefiSettings = &compute.UefiSettings{
+			SecureBootEnabled: rawCfg.UefiSettings.SecureBootEnabled,
+			VTpmEnabled:       rawCfg.UefiSettings.VTpmEnabled,
+		}
+	}
+	c.NetworkMTU = rawCfg.NetworkMTU
 
 	c.AvailabilitySet, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.AvailabilitySet)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"availabilitySet\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "availabilitySet", Reason: err.Error()}
+	}
+
+	c.VirtualMachineScaleSet, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.VirtualMachineScaleSet)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "virtualMachineScaleSet", Reason: err.Error()}
+	}
+
+	c.LicenseType, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.LicenseType)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "licenseType", Reason: err.Error()}
+	}
+	c.PlatformFaultDomain = rawCfg.PlatformFaultDomain
+
+	c.HostGroupID, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.HostGroupID)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "hostGroupID", Reason: err.Error()}
+	}
+
+	c.HostID, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.HostID)
+	if err != nil {
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "hostID", Reason: err.Error()}
 	}
 
 	c.SecurityGroupName, err = p.configVarResolver.GetConfigVarStringValue(rawCfg.SecurityGroupName)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get the value of \"securityGroupName\" field, error = %v", err)
+		return nil, nil, cloudprovidererrors.FieldValidationError{Field: "securityGroupName", Reason: err.Error()}
+	}
+
+	for _, applicationSecurityGroup := range rawCfg.ApplicationSecurityGroups {
+		name, err := p.configVarResolver.GetConfigVarStringValue(applicationSecurityGroup)
+		if err != nil {
+			return nil, nil, cloudprovidererrors.FieldValidationError{Field: "applicationSecurityGroups", Reason: err.Error()}
+		}
+		c.ApplicationSecurityGroups = append(c.ApplicationSecurityGroups, name)
 	}
 
 	c.Zones = rawCfg.Zones
 	c.Tags = rawCfg.Tags
+	c.MachineDeploymentTagKey = groupingTagKey(rawCfg.MachineDeploymentTagKey, defaultMachineDeploymentTagKey, rawCfg.Tags)
+	c.ClusterTagKey = groupingTagKey(rawCfg.ClusterTagKey, defaultClusterTagKey, rawCfg.Tags)
 	c.OSDiskSize = rawCfg.OSDiskSize
 	c.DataDiskSize = rawCfg.DataDiskSize
+	c.DataDiskIOPS = rawCfg.DataDiskIOPS
+	c.DataDiskThroughput = rawCfg.DataDiskThroughput
+	c.OSDiskPerformanceTier = rawCfg.OSDiskPerformanceTier
+	c.DataDiskPerformanceTier = rawCfg.DataDiskPerformanceTier
 
 	if rawCfg.OSDiskSKU != nil {
 		c.OSDiskSKU = storageTypePtr(*rawCfg.OSDiskSKU)
@@ -366,10 +980,7 @@ func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*config, *p
 }
 
 func getVMIPAddresses(ctx context.Context, c *config, vm *compute.VirtualMachine) (map[string]v1.NodeAddressType, error) {
-	var (
-		ipAddresses = map[string]v1.NodeAddressType{}
-		err         error
-	)
+	ipAddresses := map[string]v1.NodeAddressType{}
 
 	if vm.VirtualMachineProperties == nil {
 		return nil, fmt.Errorf("machine is missing properties")
@@ -390,10 +1001,13 @@ func getVMIPAddresses(ctx context.Context, c *config, vm *compute.VirtualMachine
 
 		splitIfaceID := strings.Split(*iface.ID, "/")
 		ifaceName := splitIfaceID[len(splitIfaceID)-1]
-		ipAddresses, err = getNICIPAddresses(ctx, c, ifaceName)
-		if vm.NetworkProfile.NetworkInterfaces == nil {
+		nicAddresses, err := getNICIPAddresses(ctx, c, ifaceName)
+		if err != nil {
 			return nil, fmt.Errorf("failed to get addresses for interface %q: %v", ifaceName, err)
 		}
+		for ip, addressType := range nicAddresses {
+			ipAddresses[ip] = addressType
+		}
 	}
 
 	return ipAddresses, nil
@@ -405,60 +1019,62 @@ func getNICIPAddresses(ctx context.Context, c *config, ifaceName string) (map[st
 		return nil, fmt.Errorf("failed to create interfaces client: %v", err)
 	}
 
-	netIf, err := ifClient.Get(ctx, c.ResourceGroup, ifaceName, "")
+	netIf, err := ifClient.Get(ctx, c.NetworkResourceGroup, ifaceName, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get interface %q: %v", ifaceName, err.Error())
 	}
 
-	ipAddresses := map[string]v1.NodeAddressType{}
-
-	if netIf.IPConfigurations == nil {
-		return ipAddresses, nil
-	}
-
-	for _, conf := range *netIf.IPConfigurations {
-		var name string
-		if conf.Name != nil {
-			name = *conf.Name
-		} else {
-			klog.Warningf("IP configuration of NIC %q was returned with no name, trying to dissect the ID.", ifaceName)
-			if conf.ID == nil || len(*conf.ID) == 0 {
-				return nil, fmt.Errorf("IP configuration of NIC %q was returned with no ID", ifaceName)
+	var externalIPs []string
+	if c.AssignPublicIP {
+		for _, ipName := range []string{publicIPName(ifaceName), publicIPv6Name(ifaceName)} {
+			publicIPs, err := getIPAddressStrings(ctx, c, ipName)
+			if err != nil {
+				return nil, fmt.Errorf("failed to retrieve IP string for IP %q: %v", ipName, err)
 			}
-			splitConfID := strings.Split(*conf.ID, "/")
-			name = splitConfID[len(splitConfID)-1]
+			externalIPs = append(externalIPs, publicIPs...)
 		}
+	}
 
-		if c.AssignPublicIP {
-			publicIPs, err := getIPAddressStrings(ctx, c, publicIPName(ifaceName))
-			if err != nil {
-				return nil, fmt.Errorf("failed to retrieve IP string for IP %q: %v", name, err)
-			}
-			for _, ip := range publicIPs {
-				ipAddresses[ip] = v1.NodeExternalIP
-			}
+	return nodeAddresses(privateIPAddresses(netIf), externalIPs), nil
+}
 
-			publicIP6s, err := getIPAddressStrings(ctx, c, publicIPv6Name(ifaceName))
-			if err != nil {
-				return nil, fmt.Errorf("failed to retrieve IP string for IP %q: %v", name, err)
-			}
-			for _, ip := range publicIP6s {
-				ipAddresses[ip] = v1.NodeExternalIP
-			}
+// privateIPAddresses returns the private addresses of all IP configurations of the interface, including the
+// IPv6 ones of dual-stack interfaces. The addresses are sorted, IPv4 addresses first. IP configurations whose
+// dynamic address isn't allocated yet are skipped.
+func privateIPAddresses(netIf network.Interface) []string {
+	var ips []string
+	if netIf.InterfacePropertiesFormat == nil || netIf.IPConfigurations == nil {
+		return ips
+	}
 
+	for _, conf := range *netIf.IPConfigurations {
+		if conf.InterfaceIPConfigurationPropertiesFormat == nil || net.ParseIP(to.String(conf.PrivateIPAddress)) == nil {
+			continue
 		}
+		ips = append(ips, *conf.PrivateIPAddress)
+	}
 
-		internalIPs, err := getInternalIPAddresses(ctx, c, ifaceName, name)
-		if err != nil {
-			return nil, fmt.Errorf("failed to retrieve internal IP string for IP %q: %v", name, err)
-		}
-		for _, ip := range internalIPs {
-			ipAddresses[ip] = v1.NodeInternalIP
+	sort.Slice(ips, func(i, j int) bool {
+		iIsV4, jIsV4 := net.ParseIP(ips[i]).To4() != nil, net.ParseIP(ips[j]).To4() != nil
+		if iIsV4 != jIsV4 {
+			return iIsV4
 		}
+		return ips[i] < ips[j]
+	})
 
-	}
+	return ips
+}
 
-	return ipAddresses, nil
+// nodeAddresses builds the address map of a node from its internal and external addresses.
+func nodeAddresses(internalIPs, externalIPs []string) map[string]v1.NodeAddressType {
+	ipAddresses := map[string]v1.NodeAddressType{}
+	for _, ip := range externalIPs {
+		ipAddresses[ip] = v1.NodeExternalIP
+	}
+	for _, ip := range internalIPs {
+		ipAddresses[ip] = v1.NodeInternalIP
+	}
+	return ipAddresses
 }
 
 func getIPAddressStrings(ctx context.Context, c *config, addrName string) ([]string, error) {
@@ -467,7 +1083,7 @@ func getIPAddressStrings(ctx context.Context, c *config, addrName string) ([]str
 		return nil, fmt.Errorf("failed to create IP address client: %v", err)
 	}
 
-	ip, err := ipClient.Get(ctx, c.ResourceGroup, addrName, "")
+	ip, err := ipClient.Get(ctx, c.NetworkResourceGroup, addrName, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get IP %q: %v", addrName, err)
 	}
@@ -484,33 +1100,14 @@ func getIPAddressStrings(ctx context.Context, c *config, addrName string) ([]str
 	return ipAddresses, nil
 }
 
-func getInternalIPAddresses(ctx context.Context, c *config, inetface, ipconfigName string) ([]string, error) {
-	var ipAddresses []string
-	ipConfigClient, err := getIPConfigClient(c)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create IP config client: %v", err)
-	}
-
-	internalIP, err := ipConfigClient.Get(ctx, c.ResourceGroup, inetface, ipconfigName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get IP config %q: %v", inetface, err)
-	}
-
-	if internalIP.ID == nil {
-		return nil, fmt.Errorf("private IP %q has nil IPConfiguration", inetface)
-	}
-	if internalIP.PrivateIPAddress != nil {
-		ipAddresses = append(ipAddresses, *internalIP.PrivateIPAddress)
-	}
-
-	return ipAddresses, nil
-}
-
 func (p *provider) AddDefaults(spec clusterv1alpha1.MachineSpec) (clusterv1alpha1.MachineSpec, error) {
 	return spec, nil
 }
 
-func getStorageProfile(config *config, providerCfg *providerconfigtypes.Config) (*compute.StorageProfile, error) {
+// getStorageProfile returns the storage profile for the VM. If dataDiskID is set, the referenced pre-created
+// managed disk is attached instead of letting Azure create an empty data disk. The data disk gets the lowest LUN
+// that isn't reserved by a data disk of the image.
+func getStorageProfile(config *config, providerCfg *providerconfigtypes.Config, dataDiskID *string, imageDataDiskLUNs []int32) (*compute.StorageProfile, error) {
 	osRef, err := getOSImageReference(config, providerCfg.OperatingSystem)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get OSImageReference: %v", err)
@@ -519,7 +1116,19 @@ func getStorageProfile(config *config, providerCfg *providerconfigtypes.Config)
 	sp := &compute.StorageProfile{
 		ImageReference: osRef,
 	}
-	if config.OSDiskSize != 0 {
+	if config.EphemeralOSDiskPlacement != "" {
+		sp.OsDisk = &compute.OSDisk{
+			CreateOption: compute.DiskCreateOptionTypesFromImage,
+			Caching:      compute.CachingTypesReadOnly,
+			DiffDiskSettings: &compute.DiffDiskSettings{
+				Option:    compute.DiffDiskOptionsLocal,
+				Placement: config.EphemeralOSDiskPlacement,
+			},
+		}
+		if config.OSDiskSize != 0 {
+			sp.OsDisk.DiskSizeGB = pointer.Int32Ptr(config.OSDiskSize)
+		}
+	} else if config.OSDiskSize != 0 {
 		sp.OsDisk = &compute.OSDisk{
 			DiskSizeGB:   pointer.Int32Ptr(config.OSDiskSize),
 			CreateOption: compute.DiskCreateOptionTypesFromImage,
@@ -532,11 +1141,29 @@ func getStorageProfile(config *config, providerCfg *providerconfigtypes.Config)
 		}
 	}
 
-	if config.DataDiskSize != 0 {
+	if dataDiskID == nil && config.DataDiskSize == 0 {
+		return sp, nil
+	}
+
+	luns, err := assignDataDiskLUNs(imageDataDiskLUNs, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if dataDiskID != nil {
+		sp.DataDisks = &[]compute.DataDisk{
+			{
+				Lun:          pointer.Int32Ptr(luns[0]),
+				CreateOption: compute.DiskCreateOptionTypesAttach,
+				ManagedDisk: &compute.ManagedDiskParameters{
+					ID: dataDiskID,
+				},
+			},
+		}
+	} else {
 		sp.DataDisks = &[]compute.DataDisk{
 			{
-				// this should be in range 0-63 and should be unique per datadisk, since we have only one datadisk, this should be fine
-				Lun:          new(int32),
+				Lun:          pointer.Int32Ptr(luns[0]),
 				DiskSizeGB:   pointer.Int32Ptr(config.DataDiskSize),
 				CreateOption: compute.DiskCreateOptionTypesEmpty,
 			},
@@ -561,19 +1188,97 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 		}
 	}
 
-	vmClient, err := getVMClient(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create VM client: %v", err)
-	}
+	ctx, cancel := apiContext(config)
+	defer cancel()
 
-	// We genete a random SSH key, since Azure won't let us create a VM without an SSH key or a password
-	key, err := ssh.NewKey()
+	vm, err := createIfNotExists(
+		func() (*azureVM, error) { return p.get(ctx, machine, config) },
+		func() (*azureVM, error) { return p.create(ctx, machine, data, userdata, config, providerCfg) },
+		func(vm *azureVM) error { return finishVMSetup(ctx, config, vm.vm) },
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate ssh key: %v", err)
+		return nil, apiTimeoutError(ctx, config, err)
 	}
 
-	ipFamily := providerCfg.Network.GetIPFamily()
-	sku := network.PublicIPAddressSkuNameBasic
+	return vm, nil
+}
+
+// createIfNotExists adopts the existing VM of the machine and only creates a VM if there is none. A previous Create
+// might have been interrupted after the VM got created, e.g. by a restart of the controller, and creating the VM
+// again would fail. The interrupted Create might not have finished the setup of the VM either, so adopt has to
+// finish it.
+func createIfNotExists(get, create func() (*azureVM, error), adopt func(*azureVM) error) (*azureVM, error) {
+	vm, err := get()
+	if err == nil {
+		klog.V(2).Infof("adopting existing VM %q", vm.Name())
+		if err := adopt(vm); err != nil {
+			return nil, fmt.Errorf("failed to adopt VM %q: %w", vm.Name(), err)
+		}
+		return vm, nil
+	}
+	if err != cloudprovidererrors.ErrInstanceNotFound {
+		return nil, err
+	}
+
+	return create()
+}
+
+// finishVMSetup runs the steps which can only be done once the VM exists. It is used for created and adopted VMs, so
+// all steps have to be idempotent.
+func finishVMSetup(ctx context.Context, config *config, vm *compute.VirtualMachine) error {
+	// The OS disk is created together with the VM, so its performance tier can only be changed afterwards.
+	if config.OSDiskPerformanceTier != nil && vm.StorageProfile != nil && vm.StorageProfile.OsDisk != nil && vm.StorageProfile.OsDisk.Name != nil {
+		if err := updateDiskPerformanceTier(ctx, *vm.StorageProfile.OsDisk.Name, *config.OSDiskPerformanceTier, config); err != nil {
+			return fmt.Errorf("failed to set performance tier of OS disk: %v", err)
+		}
+	}
+
+	return installVMExtensions(ctx, config, to.String(vm.Name))
+}
+
+func (p *provider) create(ctx context.Context, machine *clusterv1alpha1.Machine, data *cloudprovidertypes.ProviderData, userdata string, config *config, providerCfg *providerconfigtypes.Config) (*azureVM, error) {
+	if !config.AssignPublicIP {
+		subnet, err := getSubnet(ctx, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get subnet: %v", err)
+		}
+		if !hasOutboundConnectivity(config, subnet, getSubnetRouteTable(ctx, config, subnet)) {
+			data.RecordWarningEvent(machine, "NoEgress", "%s", noEgressMessage(subnet))
+		}
+	}
+
+	// The VM of the machine joined the cluster before and is gone without a cleanup, so it got evicted
+	if config.Spot && machine.Status.NodeRef != nil {
+		if eviction := recordSpotEviction(config, machine.UID, time.Now()); time.Now().Before(eviction.cooldownUntil) {
+			return nil, fmt.Errorf("recreation of evicted spot VM %q is delayed until %s", machine.Name, eviction.cooldownUntil.Format(time.RFC3339))
+		}
+	}
+
+	vmClient, err := getVMClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VM client: %v", err)
+	}
+
+	// Azure won't let us create a VM without an SSH key or a password, so we generate a random SSH key
+	// if no SSH keys are provided
+	publicKeys := providerCfg.SSHPublicKeys
+	if len(publicKeys) == 0 {
+		key, err := ssh.NewKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ssh key: %v", err)
+		}
+		publicKeys = []string{key.PublicKey}
+	}
+
+	// Machines which aren't owned by a MachineDeployment are only grouped by their cluster
+	machineDeploymentName, err := getMachineDeploymentName(ctx, machine, data.Client)
+	if err != nil {
+		return nil, err
+	}
+	config.GroupingTags = groupingTags(config, machine, machineDeploymentName)
+
+	ipFamily := providerCfg.Network.GetIPFamily()
+	sku := network.PublicIPAddressSkuNameBasic
 	if ipFamily == util.DualStack {
 		// 1. Cannot specify basic sku PublicIp for an IPv6 network interface ipConfiguration.
 		// 2. Different basic sku and standard sku public Ip resources in availability set is not allowed.
@@ -593,13 +1298,15 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 		}); err != nil {
 			return nil, err
 		}
-		publicIP, err = createOrUpdatePublicIPAddress(context.TODO(), publicIPName(ifaceName(machine)), network.IPVersionIPv4, sku, network.IPAllocationMethodStatic, machine.UID, config)
+		data.RecordEvent(machine, "CreatingPublicIP", "Creating public IP %q", publicIPName(ifaceName(machine)))
+		publicIP, err = createOrUpdatePublicIPAddress(ctx, publicIPName(ifaceName(machine)), network.IPVersionIPv4, sku, network.IPAllocationMethodStatic, machine.UID, config)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create public IP: %v", err)
 		}
 
 		if ipFamily == util.DualStack {
-			publicIPv6, err = createOrUpdatePublicIPAddress(context.TODO(), publicIPv6Name(ifaceName(machine)), network.IPVersionIPv6, sku, network.IPAllocationMethodStatic, machine.UID, config)
+			data.RecordEvent(machine, "CreatingPublicIP", "Creating public IP %q", publicIPv6Name(ifaceName(machine)))
+			publicIPv6, err = createOrUpdatePublicIPAddress(ctx, publicIPv6Name(ifaceName(machine)), network.IPVersionIPv6, sku, network.IPAllocationMethodStatic, machine.UID, config)
 			if err != nil {
 				return nil, fmt.Errorf("failed to create public IP: %v", err)
 			}
@@ -610,28 +1317,80 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 		if !kuberneteshelper.HasFinalizer(updatedMachine, finalizerNIC) {
 			updatedMachine.Finalizers = append(updatedMachine.Finalizers, finalizerNIC)
 		}
+		if hasSecurityGroups(config) && !kuberneteshelper.HasFinalizer(updatedMachine, finalizerSecurityGroups) {
+			updatedMachine.Finalizers = append(updatedMachine.Finalizers, finalizerSecurityGroups)
+		}
 	}); err != nil {
 		return nil, err
 	}
 
-	iface, err := createOrUpdateNetworkInterface(context.TODO(), ifaceName(machine), machine.UID, config, publicIP, publicIPv6, ipFamily)
+	data.RecordEvent(machine, "CreatingNetworkInterface", "Creating network interface %q", ifaceName(machine))
+	iface, err := createOrUpdateNetworkInterface(ctx, ifaceName(machine), machine.UID, config, publicIP, publicIPv6, ipFamily)
 	if err != nil {
+		if isTerminal, _, _ := cloudprovidererrors.IsTerminalError(err); isTerminal {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to generate main network interface: %v", err)
 	}
 
-	tags := make(map[string]*string, len(config.Tags)+1)
-	for k, v := range config.Tags {
-		tags[k] = to.StringPtr(v)
+	controllerTags := map[string]string{machineUIDTag: string(machine.UID)}
+	for key, value := range config.GroupingTags {
+		controllerTags[key] = value
+	}
+	tags, droppedTags := limitTags(maxVMTags, controllerTags, config.Tags)
+	if len(droppedTags) > 0 {
+		klog.Warningf("VM of machine %q exceeds the limit of %d tags, dropping tags %s", machine.Name, maxVMTags, strings.Join(droppedTags, ", "))
+	}
+
+	osPlane := getOSPlan(config, providerCfg.OperatingSystem)
+
+	adminUserName := adminUsername(config, providerCfg.OperatingSystem)
+
+	if err := data.Update(machine, func(updatedMachine *clusterv1alpha1.Machine) {
+		if !kuberneteshelper.HasFinalizer(updatedMachine, finalizerDisks) {
+			updatedMachine.Finalizers = append(updatedMachine.Finalizers, finalizerDisks)
+		}
+		setDroppedTagsAnnotation(updatedMachine, droppedTags)
+	}); err != nil {
+		return nil, err
+	}
+
+	// Provisioned IOPS, throughput, the performance tier and a copy source can't be set via the VM's storage
+	// profile, so the data disk has to be created upfront and attached to the VM afterwards.
+	var dataDiskID *string
+	if config.DataDiskCreateOption == compute.DiskCreateOptionAttach {
+		dataDiskID = to.StringPtr(config.DataDiskSourceID)
+	} else if hasDataDiskPerformanceSettings(config) || config.DataDiskPerformanceTier != nil || config.DataDiskSourceID != "" {
+		data.RecordEvent(machine, "CreatingDataDisk", "Creating data disk %q", dataDiskName(machine))
+		dataDisk, err := createOrUpdateDataDisk(ctx, dataDiskName(machine), machine.UID, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create data disk: %v", err)
+		}
+		dataDiskID = dataDisk.ID
+	}
+
+	imageRef, err := getOSImageReference(config, providerCfg.OperatingSystem)
+	if err != nil {
+		return nil, err
+	}
+
+	var imageDataDiskLUNs []int32
+	if dataDiskID != nil || config.DataDiskSize != 0 {
+		imageDataDiskLUNs, err = getImageDataDiskLUNs(ctx, config, imageRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get data disk LUNs of the image: %v", err)
+		}
 	}
-	tags[machineUIDTag] = to.StringPtr(string(machine.UID))
 
-	osPlane := osPlans[providerCfg.OperatingSystem]
-	if config.ImagePlan != nil {
-		osPlane = config.ImagePlan
+	config.EphemeralOSDiskPlacement, err = getEphemeralOSDiskPlacement(ctx, config, imageRef)
+	if err != nil {
+		return nil, err
+	}
+	if config.PreferEphemeralOSDisk && config.EphemeralOSDiskPlacement == "" {
+		data.RecordEvent(machine, "ManagedOSDiskFallback", "VM size %q doesn't support an ephemeral OS disk, using a managed OS disk", config.VMSize)
 	}
 
-	adminUserName := getOSUsername(providerCfg.OperatingSystem)
-	storageProfile, err := getStorageProfile(config, providerCfg)
+	storageProfile, err := getStorageProfile(config, providerCfg, dataDiskID, imageDataDiskLUNs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get StorageProfile: %v", err)
 	}
@@ -649,22 +1408,7 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 					},
 				},
 			},
-			OsProfile: &compute.OSProfile{
-				AdminUsername: to.StringPtr(adminUserName),
-				ComputerName:  &machine.Name,
-				LinuxConfiguration: &compute.LinuxConfiguration{
-					DisablePasswordAuthentication: to.BoolPtr(true),
-					SSH: &compute.SSHConfiguration{
-						PublicKeys: &[]compute.SSHPublicKey{
-							{
-								Path:    to.StringPtr(fmt.Sprintf("/home/%s/.ssh/authorized_keys", adminUserName)),
-								KeyData: &key.PublicKey,
-							},
-						},
-					},
-				},
-				CustomData: to.StringPtr(base64.StdEncoding.EncodeToString([]byte(userdata))),
-			},
+			OsProfile:      getOSProfile(config, machine.Name, adminUserName, publicKeys, userdata),
 			StorageProfile: storageProfile,
 		},
 		Tags:  tags,
@@ -673,16 +1417,44 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 
 	if config.AssignAvailabilitySet == nil && config.AvailabilitySet != "" ||
 		config.AssignAvailabilitySet != nil && *config.AssignAvailabilitySet && config.AvailabilitySet != "" {
-		// Azure expects the full path to the resource
-		asURI := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/availabilitySets/%s", config.SubscriptionID, config.ResourceGroup, config.AvailabilitySet)
-		vmSpec.VirtualMachineProperties.AvailabilitySet = &compute.SubResource{ID: to.StringPtr(asURI)}
+		asID, err := resolveResourceID(ctx, config, resourceKindAvailabilitySet, config.AvailabilitySet)
+		if err != nil {
+			return nil, err
+		}
+		vmSpec.VirtualMachineProperties.AvailabilitySet = &compute.SubResource{ID: to.StringPtr(asID)}
+	}
+
+	if config.VirtualMachineScaleSet != "" {
+		scaleSetID, err := resolveResourceID(ctx, config, resourceKindVirtualMachineScaleSet, config.VirtualMachineScaleSet)
+		if err != nil {
+			return nil, err
+		}
+		setVirtualMachineScaleSet(vmSpec.VirtualMachineProperties, scaleSetID, config.PlatformFaultDomain)
+	}
+
+	setDedicatedHost(vmSpec.VirtualMachineProperties, config)
+
+	vmSpec.VirtualMachineProperties.SecurityProfile = getSecurityProfile(config)
+
+	if config.LicenseType != "" {
+		vmSpec.VirtualMachineProperties.LicenseType = to.StringPtr(config.LicenseType)
+	}
+
+	if config.EnableBootDiagnostics {
+		// boot diagnostics are written to a managed storage account if no storage URI is set
+		vmSpec.VirtualMachineProperties.DiagnosticsProfile = &compute.DiagnosticsProfile{
+			BootDiagnostics: &compute.BootDiagnostics{Enabled: to.BoolPtr(true)},
+		}
+	}
+
+	if config.Spot {
+		vmSpec.VirtualMachineProperties.Priority = compute.VirtualMachinePriorityTypesSpot
+		vmSpec.VirtualMachineProperties.EvictionPolicy = compute.VirtualMachineEvictionPolicyTypesDelete
+		vmSpec.VirtualMachineProperties.BillingProfile = &compute.BillingProfile{MaxPrice: to.Float64Ptr(config.SpotMaxPrice)}
 	}
 
 	klog.Infof("Creating machine %q", machine.Name)
 	if err := data.Update(machine, func(updatedMachine *clusterv1alpha1.Machine) {
-		if !kuberneteshelper.HasFinalizer(updatedMachine, finalizerDisks) {
-			updatedMachine.Finalizers = append(updatedMachine.Finalizers, finalizerDisks)
-		}
 		if !kuberneteshelper.HasFinalizer(machine, finalizerVM) {
 			updatedMachine.Finalizers = append(updatedMachine.Finalizers, finalizerVM)
 		}
@@ -690,14 +1462,16 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 		return nil, err
 	}
 
-	future, err := vmClient.CreateOrUpdate(context.TODO(), config.ResourceGroup, machine.Name, vmSpec)
+	data.RecordEvent(machine, "CreatingVM", "Creating VM %q", machine.Name)
+	future, err := vmClient.CreateOrUpdate(ctx, config.ResourceGroup, machine.Name, vmSpec)
 	if err != nil {
 		return nil, fmt.Errorf("trying to create a VM: %v", err)
 	}
 
-	err = future.WaitForCompletionRef(context.TODO(), vmClient.Client)
+	data.RecordEvent(machine, "WaitingForVM", "Waiting for VM %q to be provisioned", machine.Name)
+	err = waitForOperation(ctx, future, vmClient.Client, config.VMPollInterval, config.VMCreateTimeout, fmt.Sprintf("creation of VM %q", machine.Name))
 	if err != nil {
-		return nil, fmt.Errorf("waiting for operation returned: %v", err.Error())
+		return nil, fmt.Errorf("waiting for operation returned: %w", err)
 	}
 
 	vm, err := future.Result(*vmClient)
@@ -706,21 +1480,29 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 	}
 
 	// get the actual VM object filled in with additional data
-	vm, err = vmClient.Get(context.TODO(), config.ResourceGroup, machine.Name, "")
+	vm, err = vmClient.Get(ctx, config.ResourceGroup, machine.Name, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve updated data for VM %q: %v", machine.Name, err)
 	}
 
-	ipAddresses, err := getVMIPAddresses(context.TODO(), config, &vm)
+	if err := finishVMSetup(ctx, config, &vm); err != nil {
+		return nil, err
+	}
+
+	ipAddresses, err := getVMIPAddresses(ctx, config, &vm)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve IP addresses for VM %q: %v", machine.Name, err.Error())
 	}
 
-	status, err := getVMStatus(context.TODO(), config, machine.Name)
+	status, err := getVMStatus(ctx, config, machine.Name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve status for VM %q: %v", machine.Name, err.Error())
 	}
 
+	// A VM of a previous attempt may have been cached with outdated addresses
+	cache.Delete(instanceCacheKey(machine.UID))
+	cache.Delete(spotEvictionCacheKey(machine.UID))
+
 	return &azureVM{vm: &vm, ipAddresses: ipAddresses, status: status}, nil
 }
 
@@ -730,19 +1512,29 @@ func (p *provider) Cleanup(machine *clusterv1alpha1.Machine, data *cloudprovider
 		return false, fmt.Errorf("failed to parse MachineSpec: %v", err)
 	}
 
-	_, err = p.get(machine)
-	// If a defunct VM got created, the `Get` call returns an error - But not because the request
-	// failed but because the VM has an invalid config hence always delete except on err == cloudprovidererrors.ErrInstanceNotFound
-	if err != nil {
-		if err == cloudprovidererrors.ErrInstanceNotFound {
-			return util.RemoveFinalizerOnInstanceNotFound(finalizerVM, machine, data)
-		}
+	ctx, cancel := apiContext(config)
+	defer cancel()
+
+	done, err := p.cleanup(ctx, machine, data, config)
+	return done, apiTimeoutError(ctx, config, err)
+}
+
+func (p *provider) cleanup(ctx context.Context, machine *clusterv1alpha1.Machine, data *cloudprovidertypes.ProviderData, config *config) (bool, error) {
+	_, err := p.get(ctx, machine, config)
+	// VMs which failed to provision are deleted like any other VM
+	vmFailed := cloudprovidererrors.IsInstanceFailed(err)
+	if err != nil && err != cloudprovidererrors.ErrInstanceNotFound && !vmFailed {
 		return false, err
 	}
 
-	klog.Infof("deleting VM %q", machine.Name)
-	if err = deleteVMsByMachineUID(context.TODO(), config, machine.UID); err != nil {
-		return false, fmt.Errorf("failed to delete instance for  machine %q: %v", machine.Name, err)
+	if err == nil || vmFailed {
+		klog.Infof("deleting VM %q", machine.Name)
+		data.RecordEvent(machine, "DeletingVM", "Deleting VM %q", machine.Name)
+		if err = deleteVMsByMachineUID(ctx, config, machine.UID); err != nil {
+			return false, fmt.Errorf("failed to delete instance for  machine %q: %v", machine.Name, err)
+		}
+		cache.Delete(instanceCacheKey(machine.UID))
+		cache.Delete(spotEvictionCacheKey(machine.UID))
 	}
 
 	if err := data.Update(machine, func(updatedMachine *clusterv1alpha1.Machine) {
@@ -751,66 +1543,135 @@ func (p *provider) Cleanup(machine *clusterv1alpha1.Machine, data *cloudprovider
 		return false, err
 	}
 
-	klog.Infof("deleting disks of VM %q", machine.Name)
-	if err := deleteDisksByMachineUID(context.TODO(), config, machine.UID); err != nil {
-		return false, fmt.Errorf("failed to remove disks of machine %q: %v", machine.Name, err)
+	// Retained OS disks lose the machine UID tag, so they are skipped when the disks of the machine get deleted
+	if config.RetainOSDiskOnDelete && kuberneteshelper.HasFinalizer(machine, finalizerDisks) {
+		if err := retainOSDisks(ctx, config, machine); err != nil {
+			return false, err
+		}
 	}
-	if err := data.Update(machine, func(updatedMachine *clusterv1alpha1.Machine) {
-		updatedMachine.Finalizers = kuberneteshelper.RemoveFinalizer(updatedMachine.Finalizers, finalizerDisks)
-	}); err != nil {
+
+	// A failed Create might have left resources behind without a VM, they are found by their UID tag
+	if err := cleanupMachineResources(ctx, config, machine, data, machineResources); err != nil {
 		return false, err
 	}
 
-	klog.Infof("deleting network interfaces of VM %q", machine.Name)
-	if err := deleteInterfacesByMachineUID(context.TODO(), config, machine.UID); err != nil {
-		return false, fmt.Errorf("failed to remove network interfaces of machine %q: %v", machine.Name, err)
+	return true, nil
+}
+
+// machineResource is a kind of resource which is created for a machine next to its VM.
+type machineResource struct {
+	name      string
+	finalizer string
+	delete    func(ctx context.Context, c *config, machineUID types.UID) error
+}
+
+// machineResources are deleted in this order once the VM of a machine is gone.
+var machineResources = []machineResource{
+	{name: "disks", finalizer: finalizerDisks, delete: deleteDisksByMachineUID},
+	{name: "security group associations", finalizer: finalizerSecurityGroups, delete: dissociateSecurityGroupsByMachineUID},
+	{name: "network interfaces", finalizer: finalizerNIC, delete: deleteInterfacesByMachineUID},
+	{name: "public IP addresses", finalizer: finalizerPublicIP, delete: deleteIPAddressesByMachineUID},
+}
+
+// cleanupMachineResources deletes the resources tagged with the UID of the machine. The finalizer of a resource
+// is added before it gets created, so resources without a finalizer on the machine are skipped.
+func cleanupMachineResources(ctx context.Context, c *config, machine *clusterv1alpha1.Machine, data *cloudprovidertypes.ProviderData, resources []machineResource) error {
+	for _, resource := range resources {
+		if !kuberneteshelper.HasFinalizer(machine, resource.finalizer) {
+			continue
+		}
+
+		klog.Infof("deleting %s of VM %q", resource.name, machine.Name)
+		data.RecordEvent(machine, "DeletingResources", "Deleting %s of VM %q", resource.name, machine.Name)
+		if err := resource.delete(ctx, c, machine.UID); err != nil {
+			return fmt.Errorf("failed to remove %s of machine %q: %v", resource.name, machine.Name, err)
+		}
+
+		if err := data.Update(machine, func(updatedMachine *clusterv1alpha1.Machine) {
+			updatedMachine.Finalizers = kuberneteshelper.RemoveFinalizer(updatedMachine.Finalizers, resource.finalizer)
+		}); err != nil {
+			return err
+		}
 	}
-	if err := data.Update(machine, func(updatedMachine *clusterv1alpha1.Machine) {
-		updatedMachine.Finalizers = kuberneteshelper.RemoveFinalizer(updatedMachine.Finalizers, finalizerNIC)
-	}); err != nil {
-		return false, err
+
+	return nil
+}
+
+func getVMByUID(ctx context.Context, c *config, uid types.UID) (*compute.VirtualMachine, error) {
+	vmClient, err := getVMClient(c)
+	if err != nil {
+		return nil, err
 	}
 
-	klog.Infof("deleting public IP addresses of VM %q", machine.Name)
-	if err := deleteIPAddressesByMachineUID(context.TODO(), config, machine.UID); err != nil {
-		return false, fmt.Errorf("failed to remove public IP addresses of machine %q: %v", machine.Name, err)
+	vms, err := getVMsByMachineUID(ctx, vmClient, c, uid)
+	if err != nil {
+		return nil, err
 	}
-	if err := data.Update(machine, func(updatedMachine *clusterv1alpha1.Machine) {
-		updatedMachine.Finalizers = kuberneteshelper.RemoveFinalizer(updatedMachine.Finalizers, finalizerPublicIP)
-	}); err != nil {
-		return false, err
+
+	if len(vms) == 0 {
+		return nil, cloudprovidererrors.ErrInstanceNotFound
 	}
 
-	return true, nil
+	return &vms[0], nil
 }
 
-func getVMByUID(ctx context.Context, c *config, uid types.UID) (*compute.VirtualMachine, error) {
+// ListManagedInstances returns the VMs in the resource group of the spec which are tagged with a machine UID.
+func (p *provider) ListManagedInstances(ctx context.Context, spec clusterv1alpha1.MachineSpec) ([]cloudprovidertypes.ManagedInstance, error) {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.APITimeout)
+	defer cancel()
+
 	vmClient, err := getVMClient(c)
 	if err != nil {
 		return nil, err
 	}
 
-	list, err := vmClient.ListAll(ctx, "", "")
+	list, err := vmClient.List(ctx, c.ResourceGroup, "")
 	if err != nil {
-		return nil, err
+		return nil, apiTimeoutError(ctx, c, fmt.Errorf("failed to list VMs in resource group %q: %v", c.ResourceGroup, err))
 	}
 
-	var allServers []compute.VirtualMachine
+	instances, err := managedInstances(ctx, &list)
+	return instances, apiTimeoutError(ctx, c, err)
+}
 
+// managedInstances iterates all pages of the list and returns the VMs which are tagged with a machine UID.
+func managedInstances(ctx context.Context, list resourcePage[compute.VirtualMachine]) ([]cloudprovidertypes.ManagedInstance, error) {
+	var instances []cloudprovidertypes.ManagedInstance
 	for list.NotDone() {
-		allServers = append(allServers, list.Values()...)
-		if err := list.Next(); err != nil {
-			return nil, fmt.Errorf("failed to iterate the result list: %s", err)
+		for _, vm := range list.Values() {
+			machineUID := to.String(vm.Tags[machineUIDTag])
+			if machineUID == "" {
+				continue
+			}
+			instances = append(instances, cloudprovidertypes.ManagedInstance{
+				ID:         to.String(vm.ID),
+				Name:       to.String(vm.Name),
+				MachineUID: types.UID(machineUID),
+			})
 		}
-	}
-
-	for _, vm := range allServers {
-		if vm.Tags != nil && vm.Tags[machineUIDTag] != nil && *vm.Tags[machineUIDTag] == string(uid) {
-			return &vm, nil
+		if err := list.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("failed to iterate the result list: %v", err)
 		}
 	}
 
-	return nil, cloudprovidererrors.ErrInstanceNotFound
+	return instances, nil
+}
+
+// vmProvisioningFailed tells whether the VM failed to provision.
+func vmProvisioningFailed(vm *compute.VirtualMachine) bool {
+	return vm.VirtualMachineProperties != nil && strings.EqualFold(to.String(vm.ProvisioningState), provisioningStateFailed)
+}
+
+// provisioningFailureIsTerminal tells whether a VM which failed to provision has to be recreated. A failed operation
+// on a VM whose node joined and which is still running, e.g. a failed extension, also puts it into the Failed
+// provisioning state, such VMs are kept.
+func provisioningFailureIsTerminal(joined bool, status instance.Status) bool {
+	return !joined || status != instance.StatusRunning
 }
 
 func getVMStatus(ctx context.Context, c *config, vmName string) (instance.Status, error) {
@@ -855,30 +1716,53 @@ func getVMStatus(ctx context.Context, c *config, vmName string) (instance.Status
 		return instance.StatusUnknown, nil
 	}
 
-	switch *powerStatus.Code {
+	return powerStateStatus(c, *powerStatus.Code), nil
+}
+
+func powerStateStatus(c *config, code string) instance.Status {
+	switch code {
 	case "":
-		return instance.StatusUnknown, nil
+		return instance.StatusUnknown
 	case "PowerState/running":
-		return instance.StatusRunning, nil
+		return instance.StatusRunning
 	case "PowerState/starting":
-		return instance.StatusCreating, nil
-	default:
-		klog.Warningf("unknown Azure power status %q", *powerStatus.Code)
-		return instance.StatusUnknown, nil
+		return instance.StatusCreating
+	case "PowerState/deallocating", "PowerState/deallocated":
+		// Spot VMs are deallocated before they get deleted due to an eviction
+		if c.Spot {
+			return instance.StatusEvicted
+		}
+		return instance.StatusStopped
+	case "PowerState/stopping", "PowerState/stopped":
+		return instance.StatusStopped
 	}
-}
 
-func (p *provider) Get(machine *clusterv1alpha1.Machine, _ *cloudprovidertypes.ProviderData) (instance.Instance, error) {
-	return p.get(machine)
+	klog.Warningf("unknown Azure power status %q", code)
+	return instance.StatusUnknown
 }
 
-func (p *provider) get(machine *clusterv1alpha1.Machine) (*azureVM, error) {
+func (p *provider) Get(machine *clusterv1alpha1.Machine, _ *cloudprovidertypes.ProviderData) (instance.Instance, error) {
 	config, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse MachineSpec: %v", err)
 	}
 
-	vm, err := getVMByUID(context.TODO(), config, machine.UID)
+	ctx, cancel := apiContext(config)
+	defer cancel()
+
+	vm, err := p.get(ctx, machine, config)
+	if err != nil {
+		if err == cloudprovidererrors.ErrInstanceNotFound || cloudprovidererrors.IsInstanceFailed(err) {
+			return nil, err
+		}
+		return nil, apiTimeoutError(ctx, config, err)
+	}
+
+	return vm, nil
+}
+
+func (p *provider) get(ctx context.Context, machine *clusterv1alpha1.Machine, config *config) (*azureVM, error) {
+	vm, err := getVMByUID(ctx, config, machine.UID)
 	if err != nil {
 		if err == cloudprovidererrors.ErrInstanceNotFound {
 			return nil, cloudprovidererrors.ErrInstanceNotFound
@@ -887,171 +1771,1001 @@ func (p *provider) get(machine *clusterv1alpha1.Machine) (*azureVM, error) {
 		return nil, fmt.Errorf("failed to find machine %q by its UID: %v", machine.UID, err)
 	}
 
-	ipAddresses, err := getVMIPAddresses(context.TODO(), config, vm)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve IP addresses for VM %v: %v", vm.Name, err)
+	if vmProvisioningFailed(vm) {
+		joined := machine.Status.NodeRef != nil
+		status := instance.StatusUnknown
+		if joined {
+			if status, err = getVMStatus(ctx, config, machine.Name); err != nil {
+				return nil, fmt.Errorf("failed to retrieve status for VM %v: %v", vm.Name, err)
+			}
+		}
+		if provisioningFailureIsTerminal(joined, status) {
+			return nil, fmt.Errorf("%w: VM %q is in provisioning state %q", cloudprovidererrors.ErrInstanceFailed, to.String(vm.Name), provisioningStateFailed)
+		}
+		klog.V(2).Infof("VM %q is in provisioning state %q, but its node joined and it's running, keeping it", to.String(vm.Name), provisioningStateFailed)
 	}
 
-	status, err := getVMStatus(context.TODO(), config, machine.Name)
+	cached, err := getCachedInstance(config, machine.UID, func() (*cachedInstance, error) {
+		ipAddresses, err := getVMIPAddresses(ctx, config, vm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve IP addresses for VM %v: %v", vm.Name, err)
+		}
+
+		status, err := getVMStatus(ctx, config, machine.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve status for VM %v: %v", vm.Name, err)
+		}
+
+		return &cachedInstance{ipAddresses: ipAddresses, status: status}, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve status for VM %v: %v", vm.Name, err)
+		return nil, err
+	}
+
+	status := cached.status
+	if status == instance.StatusEvicted {
+		eviction := recordSpotEviction(config, machine.UID, time.Now())
+		klog.V(2).Infof("spot VM %q got evicted, it won't be recreated before %s", machine.Name, eviction.cooldownUntil.Format(time.RFC3339))
 	}
 
-	return &azureVM{vm: vm, ipAddresses: ipAddresses, status: status}, nil
+	return &azureVM{vm: vm, ipAddresses: cached.ipAddresses, status: status}, nil
 }
 
-func (p *provider) GetCloudConfig(spec clusterv1alpha1.MachineSpec) (config string, name string, err error) {
-	c, _, err := p.getConfig(spec.ProviderSpec)
+// vmRestarter is the part of the VM client which is required to restart a VM.
+type vmRestarter interface {
+	Restart(ctx context.Context, resourceGroupName string, VMName string) (compute.VirtualMachinesRestartFuture, error)
+}
+
+// Reboot restarts the VM of the machine, which is faster than recreating a VM whose node hangs.
+func (p *provider) Reboot(machine *clusterv1alpha1.Machine, _ *cloudprovidertypes.ProviderData) error {
+	config, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to parse config: %v", err)
+		return fmt.Errorf("failed to parse MachineSpec: %v", err)
 	}
 
-	var avSet string
-	if c.AssignAvailabilitySet == nil && c.AvailabilitySet != "" ||
-		c.AssignAvailabilitySet != nil && *c.AssignAvailabilitySet && c.AvailabilitySet != "" {
-		avSet = c.AvailabilitySet
+	ctx, cancel := apiContext(config)
+	defer cancel()
+
+	vm, err := getVMByUID(ctx, config, machine.UID)
+	if err != nil {
+		if err == cloudprovidererrors.ErrInstanceNotFound {
+			return err
+		}
+		return apiTimeoutError(ctx, config, fmt.Errorf("failed to find machine %q by its UID: %v", machine.UID, err))
 	}
 
-	cc := &azuretypes.CloudConfig{
-		Cloud:                      "AZUREPUBLICCLOUD",
-		TenantID:                   c.TenantID,
-		SubscriptionID:             c.SubscriptionID,
-		AADClientID:                c.ClientID,
-		AADClientSecret:            c.ClientSecret,
-		ResourceGroup:              c.ResourceGroup,
-		VnetResourceGroup:          c.VNetResourceGroup,
-		Location:                   c.Location,
-		VNetName:                   c.VNetName,
-		SubnetName:                 c.SubnetName,
-		LoadBalancerSku:            c.LoadBalancerSku,
-		RouteTableName:             c.RouteTableName,
-		PrimaryAvailabilitySetName: avSet,
-		SecurityGroupName:          c.SecurityGroupName,
-		UseInstanceMetadata:        true,
+	// the instance cache is bypassed on purpose, a VM which got restarted recently may still be cached as running
+	status, err := getVMStatus(ctx, config, to.String(vm.Name))
+	if err != nil {
+		return apiTimeoutError(ctx, config, err)
 	}
 
-	s, err := azuretypes.CloudConfigToString(cc)
+	vmClient, err := getVMClient(config)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to convert cloud-config to string: %v", err)
+		return err
 	}
 
-	return s, "azure", nil
+	return apiTimeoutError(ctx, config, restartVM(ctx, vmClient, config, machine.UID, to.String(vm.Name), status))
 }
 
-func validateDiskSKUs(c *config) error {
-	if c.OSDiskSKU != nil || c.DataDiskSKU != nil {
-		sku, err := getSKU(context.TODO(), c)
-		if err != nil {
-			return fmt.Errorf("failed to get VM SKU: %w", err)
+// restartVM requests the restart of the VM with the given status without waiting for its completion. VMs which are
+// already starting or got stopped deliberately are left alone, VMs which are being deleted or got evicted can't be
+// restarted. Once the restart is requested, the cached instance is dropped so the VM is reported as starting until
+// it's running again.
+func restartVM(ctx context.Context, client vmRestarter, c *config, uid types.UID, vmName string, status instance.Status) error {
+	switch status {
+	case instance.StatusCreating:
+		klog.V(3).Infof("VM %q is already starting", vmName)
+		return nil
+	case instance.StatusStopped:
+		klog.V(3).Infof("VM %q is stopped, it's not restarted", vmName)
+		return nil
+	case instance.StatusDeleting, instance.StatusDeleted, instance.StatusEvicted:
+		return fmt.Errorf("VM %q can't be restarted, its status is %q", vmName, status)
+	}
+
+	if _, err := client.Restart(ctx, c.ResourceGroup, vmName); err != nil {
+		if isNotFound(err) {
+			return cloudprovidererrors.ErrInstanceNotFound
 		}
+		return fmt.Errorf("failed to restart VM %q: %v", vmName, err)
+	}
+	cache.Delete(instanceCacheKey(uid))
 
-		if c.OSDiskSKU != nil {
-			if _, ok := osDiskSKUs[*c.OSDiskSKU]; !ok {
-				return fmt.Errorf("invalid OS disk SKU '%s'", *c.OSDiskSKU)
-			}
+	return nil
+}
 
-			if err := supportsDiskSKU(sku, *c.OSDiskSKU, c.Zones); err != nil {
-				return err
-			}
+// WaitUntilReady blocks until the VM of the machine reports running or the timeout is reached. This allows
+// higher-level orchestration to serialize the creation of machines, e.g. for ordered stateful workloads.
+func (p *provider) WaitUntilReady(ctx context.Context, machine *clusterv1alpha1.Machine, timeout time.Duration) error {
+	config, _, err := p.getConfig(machine.Spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to parse MachineSpec: %v", err)
+	}
+
+	return waitUntilRunning(ctx, readyCheckInterval, timeout, func(ctx context.Context) (instance.Status, error) {
+		if _, err := getVMByUID(ctx, config, machine.UID); err != nil {
+			return instance.StatusUnknown, err
 		}
 
-		if c.DataDiskSKU != nil {
-			if _, ok := dataDiskSKUs[*c.DataDiskSKU]; !ok {
-				return fmt.Errorf("invalid data disk SKU '%s'", *c.DataDiskSKU)
-			}
+		// the instance cache is bypassed on purpose, as a cached status would delay the readiness
+		return getVMStatus(ctx, config, machine.Name)
+	})
+}
 
-			// Ultra SSDs do not support availability sets, see for reference:
-			// https://docs.microsoft.com/en-us/azure/virtual-machines/disks-enable-ultra-ssd#ga-scope-and-limitations
-			if *c.DataDiskSKU == compute.StorageAccountTypesUltraSSDLRS && ((c.AssignAvailabilitySet != nil && *c.AssignAvailabilitySet) || c.AvailabilitySet != "") {
-				return fmt.Errorf("data disk SKU '%s' does not support availability sets", *c.DataDiskSKU)
-			}
+// waitUntilRunning polls the status using getStatus until it reports running. VMs which don't exist yet are waited for,
+// while VMs which are being deleted, got evicted or got stopped will never become ready and end the wait with an error.
+func waitUntilRunning(ctx context.Context, interval, timeout time.Duration, getStatus func(ctx context.Context) (instance.Status, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-			if err := supportsDiskSKU(sku, *c.DataDiskSKU, c.Zones); err != nil {
-				return err
+	var lastStatus instance.Status
+	err := wait.PollImmediateUntilWithContext(ctx, interval, func(ctx context.Context) (bool, error) {
+		status, err := getStatus(ctx)
+		if err != nil {
+			if errors.Is(err, cloudprovidererrors.ErrInstanceNotFound) {
+				lastStatus = instance.StatusUnknown
+				return false, nil
 			}
+			return false, err
 		}
+		lastStatus = status
+
+		switch status {
+		case instance.StatusRunning:
+			return true, nil
+		case instance.StatusDeleting, instance.StatusDeleted, instance.StatusEvicted, instance.StatusStopped:
+			return false, fmt.Errorf("VM will not become ready, its status is %q", status)
+		default:
+			return false, nil
+		}
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		return fmt.Errorf("timed out after %v waiting for VM to be running, last status: %q", timeout, lastStatus)
 	}
 
-	return nil
+	return err
 }
 
-func (p *provider) Validate(spec clusterv1alpha1.MachineSpec) error {
-	c, providerConfig, err := p.getConfig(spec.ProviderSpec)
-	if err != nil {
-		return fmt.Errorf("failed to parse config: %v", err)
+// recordSpotEviction records the eviction of the Spot VM of the machine with the given UID and returns it.
+// Only the first call starts the cooldown, the eviction is kept for a while after the cooldown is over
+// to not start another cooldown if the recreation needs more than a single attempt.
+func recordSpotEviction(c *config, uid types.UID, now time.Time) spotEviction {
+	cacheKey := spotEvictionCacheKey(uid)
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	if cached, found := cache.Get(cacheKey); found {
+		return cached.(spotEviction)
+	}
+
+	cooldown := c.EvictionCooldown
+	if c.EvictionCooldownJitter > 0 {
+		cooldown += time.Duration(rand.Int63nRange(0, int64(c.EvictionCooldownJitter)))
+	}
+
+	eviction := spotEviction{cooldownUntil: now.Add(cooldown)}
+	cache.Set(cacheKey, eviction, cooldown+spotEvictionRetention)
+
+	return eviction
+}
+
+// getCachedInstance returns the cached instance view and addresses of the VM of the machine with the given UID,
+// they are only fetched using lookup if they aren't cached or the cache entry expired.
+func getCachedInstance(c *config, uid types.UID, lookup func() (*cachedInstance, error)) (*cachedInstance, error) {
+	if c.InstanceCacheTTL <= 0 {
+		return lookup()
+	}
+
+	cacheKey := instanceCacheKey(uid)
+	if cached, found := cache.Get(cacheKey); found {
+		return cached.(*cachedInstance), nil
+	}
+
+	result, err := lookup()
+	if err != nil {
+		return nil, err
+	}
+	cache.Set(cacheKey, result, c.InstanceCacheTTL)
+
+	return result, nil
+}
+
+func (p *provider) GetCloudConfig(spec clusterv1alpha1.MachineSpec) (config string, name string, err error) {
+	return p.getCloudConfig(spec, false)
+}
+
+// GetExternalCloudConfig returns the cloud-config in the format of the external Azure cloud controller manager,
+// which additionally sets the VM type and the backoff of API requests.
+func (p *provider) GetExternalCloudConfig(spec clusterv1alpha1.MachineSpec) (config string, name string, err error) {
+	return p.getCloudConfig(spec, true)
+}
+
+func (p *provider) getCloudConfig(spec clusterv1alpha1.MachineSpec, external bool) (string, string, error) {
+	c, _, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	s, err := azuretypes.CloudConfigToString(cloudConfig(c, external))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to convert cloud-config to string: %v", err)
+	}
+
+	return s, "azure", nil
+}
+
+// cloudConfig returns the cloud-config of the in-tree cloud provider or of the external cloud controller manager.
+func cloudConfig(c *config, external bool) *azuretypes.CloudConfig {
+	var avSet string
+	if c.AssignAvailabilitySet == nil && c.AvailabilitySet != "" ||
+		c.AssignAvailabilitySet != nil && *c.AssignAvailabilitySet && c.AvailabilitySet != "" {
+		avSet = c.AvailabilitySet
+	}
+
+	// The subnet given by its ID takes precedence over the configured names, it has been validated already
+	vnetResourceGroup, vnetName, subnetName := c.VNetResourceGroup, c.VNetName, c.SubnetName
+	if subnet, err := configuredSubnet(c); err == nil {
+		vnetResourceGroup, vnetName, subnetName = subnet.resourceGroup, subnet.vnetName, subnet.name
+	}
+
+	cc := &azuretypes.CloudConfig{
+		Cloud:                      "AZUREPUBLICCLOUD",
+		TenantID:                   c.TenantID,
+		SubscriptionID:             c.SubscriptionID,
+		AADClientID:                c.ClientID,
+		AADClientSecret:            c.ClientSecret,
+		ResourceGroup:              c.ResourceGroup,
+		VnetResourceGroup:          vnetResourceGroup,
+		Location:                   c.Location,
+		VNetName:                   vnetName,
+		SubnetName:                 subnetName,
+		LoadBalancerSku:            c.LoadBalancerSku,
+		RouteTableName:             c.RouteTableName,
+		PrimaryAvailabilitySetName: avSet,
+		SecurityGroupName:          c.SecurityGroupName,
+		UseInstanceMetadata:        true,
+	}
+
+	if external {
+		cc.VMType = vmTypeStandard
+		if c.VirtualMachineScaleSet != "" {
+			cc.VMType = vmTypeVMSS
+		}
+		cc.CloudProviderBackoff = true
+		cc.CloudProviderBackoffRetries = cloudProviderBackoffRetries
+		cc.CloudProviderBackoffDuration = cloudProviderBackoffDuration
+		cc.CloudProviderBackoffExponent = cloudProviderBackoffExponent
+		cc.CloudProviderBackoffJitter = cloudProviderBackoffJitter
+	}
+
+	return cc
+}
+
+func validateDiskPerformanceTier(sku *compute.StorageAccountTypes, tier *string) error {
+	if tier == nil {
+		return nil
+	}
+
+	if sku == nil {
+		return errors.New("performance tier requires the disk SKU to be set")
+	}
+
+	tiers, ok := performanceTiers[*sku]
+	if !ok {
+		return fmt.Errorf("disk SKU '%s' does not support setting a performance tier", *sku)
+	}
+
+	for _, t := range tiers {
+		if t == *tier {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid performance tier '%s' for disk SKU '%s', valid tiers are: %s", *tier, *sku, strings.Join(tiers, ", "))
+}
+
+// validateDataDiskSource checks that the data disk source is a snapshot or a managed disk and that it's clear
+// whether the source is copied or attached.
+func validateDataDiskSource(c *config) (azure.Resource, error) {
+	source, err := azure.ParseResourceID(c.DataDiskSourceID)
+	if err != nil {
+		return azure.Resource{}, fmt.Errorf("invalid sourceResourceID: %w", err)
+	}
+
+	if !strings.EqualFold(source.Provider, "Microsoft.Compute") {
+		return azure.Resource{}, fmt.Errorf("sourceResourceID must be a snapshot or a disk, got provider %q", source.Provider)
+	}
+
+	switch strings.ToLower(source.ResourceType) {
+	case "snapshots":
+		if c.DataDiskCreateOption != "" && c.DataDiskCreateOption != compute.DiskCreateOptionCopy {
+			return azure.Resource{}, fmt.Errorf("snapshots can only be copied, got createOption %q", c.DataDiskCreateOption)
+		}
+	case "disks":
+		if c.DataDiskCreateOption != compute.DiskCreateOptionCopy && c.DataDiskCreateOption != compute.DiskCreateOptionAttach {
+			return azure.Resource{}, fmt.Errorf("createOption must be %s or %s for disks, got %q",
+				compute.DiskCreateOptionCopy, compute.DiskCreateOptionAttach, c.DataDiskCreateOption)
+		}
+	default:
+		return azure.Resource{}, fmt.Errorf("sourceResourceID must be a snapshot or a disk, got resource type %q", source.ResourceType)
+	}
+
+	if c.DataDiskCreateOption == compute.DiskCreateOptionAttach &&
+		(c.DataDiskSize != 0 || c.DataDiskSKU != nil || hasDataDiskPerformanceSettings(c) || c.DataDiskPerformanceTier != nil) {
+		return azure.Resource{}, errors.New("the settings of the data disk can't be set when attaching an existing disk")
+	}
+
+	return source, nil
+}
+
+func validateDataDiskPerformance(c *config) error {
+	if !hasDataDiskPerformanceSettings(c) {
+		return nil
+	}
+
+	if c.DataDiskSize == 0 {
+		return errors.New("dataDiskIOPS and dataDiskThroughput require dataDiskSize to be set")
+	}
+
+	if c.DataDiskSKU == nil {
+		return errors.New("dataDiskIOPS and dataDiskThroughput require dataDiskSKU to be set")
+	}
+
+	if _, ok := dataDiskPerformanceSKUs[*c.DataDiskSKU]; !ok {
+		return fmt.Errorf("data disk SKU '%s' does not support setting IOPS or throughput, only %s and %s do",
+			*c.DataDiskSKU, compute.StorageAccountTypesUltraSSDLRS, storageAccountTypesPremiumV2LRS)
+	}
+
+	if c.DataDiskIOPS != nil && *c.DataDiskIOPS <= 0 {
+		return fmt.Errorf("dataDiskIOPS must be greater than 0, got %d", *c.DataDiskIOPS)
+	}
+
+	if c.DataDiskThroughput != nil && *c.DataDiskThroughput <= 0 {
+		return fmt.Errorf("dataDiskThroughput must be greater than 0, got %d", *c.DataDiskThroughput)
+	}
+
+	return nil
+}
+
+// validateVMExtensions checks that the extensions can be installed and have unique names.
+func validateVMExtensions(c *config) error {
+	if len(c.Extensions) == 0 {
+		return nil
+	}
+
+	if c.AllowExtensionOperations != nil && !*c.AllowExtensionOperations {
+		return errors.New("extensions require allowExtensionOperations to not be disabled")
+	}
+
+	names := sets.NewString()
+	if c.InstallGPUDriver {
+		names.Insert(gpuDriverExtensionName)
+	}
+
+	for i, extension := range c.Extensions {
+		switch {
+		case extension.Publisher == "":
+			return fmt.Errorf("publisher of extension %d is missing", i)
+		case extension.Type == "":
+			return fmt.Errorf("type of extension %d is missing", i)
+		case extension.Version == "":
+			return fmt.Errorf("version of extension %d is missing", i)
+		}
+
+		name := vmExtensionName(extension)
+		if names.Has(name) {
+			return fmt.Errorf("extension name %q is used more than once", name)
+		}
+		names.Insert(name)
+	}
+
+	return nil
+}
+
+func validateDiskSKUs(ctx context.Context, c *config) error {
+	if c.OSDiskSKU != nil || c.DataDiskSKU != nil {
+		sku, err := getSKU(ctx, c)
+		if err != nil {
+			return fmt.Errorf("failed to get VM SKU: %w", err)
+		}
+
+		if c.OSDiskSKU != nil {
+			if _, ok := osDiskSKUs[*c.OSDiskSKU]; !ok {
+				return fmt.Errorf("invalid OS disk SKU '%s'", *c.OSDiskSKU)
+			}
+
+			if err := supportsDiskSKU(sku, *c.OSDiskSKU, c.Zones); err != nil {
+				return err
+			}
+		}
+
+		if c.DataDiskSKU != nil {
+			if _, ok := dataDiskSKUs[*c.DataDiskSKU]; !ok {
+				return fmt.Errorf("invalid data disk SKU '%s'", *c.DataDiskSKU)
+			}
+
+			// Ultra SSDs do not support availability sets, see for reference:
+			// https://docs.microsoft.com/en-us/azure/virtual-machines/disks-enable-ultra-ssd#ga-scope-and-limitations
+			if *c.DataDiskSKU == compute.StorageAccountTypesUltraSSDLRS && ((c.AssignAvailabilitySet != nil && *c.AssignAvailabilitySet) || c.AvailabilitySet != "") {
+				return fmt.Errorf("data disk SKU '%s' does not support availability sets", *c.DataDiskSKU)
+			}
+
+			if err := supportsDiskSKU(sku, *c.DataDiskSKU, c.Zones); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *provider) Validate(spec clusterv1alpha1.MachineSpec) error {
+	c, providerConfig, err := p.getConfig(spec.ProviderSpec)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	ctx, cancel := apiContext(c)
+	defer cancel()
+
+	return apiTimeoutError(ctx, c, p.validate(ctx, spec, c, providerConfig))
+}
+
+func (p *provider) validate(ctx context.Context, spec clusterv1alpha1.MachineSpec, c *config, providerConfig *providerconfigtypes.Config) error {
+	if c.SubscriptionID == "" {
+		return errors.New("subscriptionID is missing")
+	}
+
+	if c.TenantID == "" {
+		return errors.New("tenantID is missing")
+	}
+
+	if c.ClientID == "" {
+		return errors.New("clientID is missing")
+	}
+
+	if c.ClientSecret == "" {
+		return errors.New("clientSecret is missing")
+	}
+
+	if c.ResourceGroup == "" {
+		return errors.New("resourceGroup is missing")
+	}
+
+	if c.VMSize == "" {
+		return errors.New("vmSize is missing")
+	}
+
+	if c.ComputerName != "" && c.ComputerNamePrefix != "" {
+		return errors.New("computerName and computerNamePrefix can't be set at the same time")
+	}
+
+	if c.VMCreateTimeout > c.APITimeout || c.VMDeleteTimeout > c.APITimeout {
+		return fmt.Errorf("vmCreateTimeout and vmDeleteTimeout must not exceed apiTimeout of %s", c.APITimeout)
+	}
+
+	if c.PrivateIPAddress != "" {
+		if ip := net.ParseIP(c.PrivateIPAddress); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("privateIPAddress %q is not a valid IPv4 address", c.PrivateIPAddress)
+		}
+	}
+
+	if c.PublicIPIdleTimeoutMinutes != nil {
+		if !c.AssignPublicIP {
+			return errors.New("publicIPIdleTimeoutMinutes requires assignPublicIP")
+		}
+		if timeout := *c.PublicIPIdleTimeoutMinutes; timeout < minPublicIPIdleTimeoutMinutes || timeout > maxPublicIPIdleTimeoutMinutes {
+			return fmt.Errorf("publicIPIdleTimeoutMinutes must be between %d and %d, got %d", minPublicIPIdleTimeoutMinutes, maxPublicIPIdleTimeoutMinutes, timeout)
+		}
+	}
+
+	if c.PreferEphemeralOSDisk && c.RetainOSDiskOnDelete {
+		return errors.New("preferEphemeralOSDisk and retainOSDiskOnDelete can't be set at the same time, ephemeral OS disks are deleted with the VM")
+	}
+
+	if c.Spot {
+		if c.SpotMaxPrice != -1 && c.SpotMaxPrice <= 0 {
+			return fmt.Errorf("spot.maxPrice must be -1 or greater than 0, got %v", c.SpotMaxPrice)
+		}
+		if c.EvictionCooldown < 0 || c.EvictionCooldownJitter < 0 {
+			return errors.New("spot.evictionCooldown and spot.evictionCooldownJitter must not be negative")
+		}
+	}
+
+	if err := validateDedicatedHostConfig(c); err != nil {
+		return err
+	}
+
+	// All operating systems supported on Azure are Linux distributions
+	if err := validateComputerName(computerName(c, spec.Name), compute.OperatingSystemTypesLinux); err != nil {
+		return err
+	}
+
+	if c.AdminUsername != "" {
+		if err := validateAdminUsername(c.AdminUsername); err != nil {
+			return err
+		}
+	}
+
+	if err := validateLicenseType(c.LicenseType, providerConfig.OperatingSystem); err != nil {
+		return err
+	}
+
+	if err := validateTags(c.Tags, reservedTagKeys(c)); err != nil {
+		return err
+	}
+
+	if err := validateGroupingTagKeys(c); err != nil {
+		return err
+	}
+
+	if c.SubnetID != "" {
+		if _, err := parseSubnetID(c.SubnetID); err != nil {
+			return err
+		}
+	} else {
+		if c.VNetName == "" {
+			return errors.New("vnetName is missing")
+		}
+
+		if c.SubnetName == "" {
+			return errors.New("subnetName is missing")
+		}
+	}
+
+	switch f := providerConfig.Network.GetIPFamily(); f {
+	case util.Unspecified, util.IPv4:
+		//noop
+	case util.IPv6:
+		return fmt.Errorf(util.ErrIPv6OnlyUnsupported)
+	case util.DualStack:
+		// validate
+	default:
+		return fmt.Errorf(util.ErrUnknownNetworkFamily, f)
+	}
+
+	vmClient, err := getVMClient(c)
+	if err != nil {
+		return fmt.Errorf("failed to (create) vm client: %v", err.Error())
+	}
+
+	_, err = vmClient.ListAll(ctx, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to list all: %v", err.Error())
+	}
+
+	if c.PlatformFaultDomain != nil && c.VirtualMachineScaleSet == "" {
+		return errors.New("platformFaultDomain requires virtualMachineScaleSet to be set")
+	}
+
+	if c.VirtualMachineScaleSet != "" {
+		if c.AvailabilitySet != "" && (c.AssignAvailabilitySet == nil || *c.AssignAvailabilitySet) {
+			return errors.New("virtualMachineScaleSet and availabilitySet can't be used at the same time")
+		}
+
+		scaleSet, err := getVirtualMachineScaleSet(ctx, c)
+		if err != nil {
+			return fmt.Errorf("failed to get virtual machine scale set: %v", err)
+		}
+
+		if err := validatePlatformFaultDomain(c, scaleSet); err != nil {
+			return err
+		}
+	}
+
+	if c.AvailabilitySet != "" && (c.AssignAvailabilitySet == nil || *c.AssignAvailabilitySet) {
+		if _, err := resolveResourceID(ctx, c, resourceKindAvailabilitySet, c.AvailabilitySet); err != nil {
+			return err
+		}
+	}
+
+	if err := checkDedicatedHost(ctx, c); err != nil {
+		return err
+	}
+
+	for _, applicationSecurityGroup := range c.ApplicationSecurityGroups {
+		if _, err := resolveResourceID(ctx, c, resourceKindApplicationSecurityGroup, applicationSecurityGroup); err != nil {
+			return err
+		}
+	}
+
+	if c.SecurityGroupName != "" {
+		if _, err := resolveResourceID(ctx, c, resourceKindSecurityGroup, c.SecurityGroupName); err != nil {
+			return err
+		}
+	}
+
+	if err := validateResourceGroup(ctx, c); err != nil {
+		if c.EnforceResourceGroupLocation {
+			return err
+		}
+		klog.Warning(err)
+	}
+
+	// The VNet of a subnet given by its ID is only checked with the subnet, it may be in another subscription
+	if c.SubnetID == "" {
+		if _, err := getVirtualNetwork(ctx, c); err != nil {
+			return fmt.Errorf("failed to get virtual network: %v", err)
+		}
+	}
+
+	subnet, err := getSubnet(ctx, c)
+	if err != nil {
+		return fmt.Errorf("failed to get subnet: %v", err)
+	}
+
+	if c.PrivateIPAddress != "" {
+		if err := validatePrivateIPAddress(c.PrivateIPAddress, subnet); err != nil {
+			return err
+		}
+	}
+
+	// Without a public IP, nodes rely on the subnet's NAT gateway or default route or on the outbound rules of a
+	// standard load balancer for egress. We can't reliably detect the latter, so only warn unless egress is required.
+	if !c.AssignPublicIP && !hasOutboundConnectivity(c, subnet, getSubnetRouteTable(ctx, c, subnet)) {
+		if c.RequireEgress {
+			return cloudprovidererrors.TerminalError{
+				Reason:  common.InvalidConfigurationMachineError,
+				Message: noEgressMessage(subnet),
+			}
+		}
+		klog.Warning(noEgressMessage(subnet))
+	}
+
+	skus, err := getVMSKUs(ctx, c)
+	if err != nil {
+		return fmt.Errorf("failed to get VM SKUs: %w", err)
+	}
+
+	if err := validateVMSize(skus, c.VMSize, c.Location, c.Zones); err != nil {
+		return err
+	}
+
+	if c.NetworkMTU != nil && (*c.NetworkMTU < minNetworkMTU || *c.NetworkMTU > maxNetworkMTU) {
+		return fmt.Errorf("networkMTU must be between %d and %d", minNetworkMTU, maxNetworkMTU)
+	}
+
+	if err := validateSecurityProfile(c); err != nil {
+		return err
+	}
+
+	if securityProfile := getSecurityProfile(c); securityProfile != nil {
+		sku, err := getSKU(ctx, c)
+		if err != nil {
+			return fmt.Errorf("failed to get VM SKU: %w", err)
+		}
+		if !skuSupportsHyperVGeneration(sku, compute.HyperVGenerationTypesV2) {
+			return fmt.Errorf("securityType %s requires a gen2 VM size, but VM size %q doesn't support %s", securityProfile.SecurityType, c.VMSize, compute.HyperVGenerationTypesV2)
+		}
+	}
+
+	if err := validateVMExtensions(c); err != nil {
+		return err
+	}
+
+	if c.InstallGPUDriver {
+		if c.AllowExtensionOperations != nil && !*c.AllowExtensionOperations {
+			return errors.New("installGPUDriver requires allowExtensionOperations to not be disabled")
+		}
+
+		sku, err := getSKU(ctx, c)
+		if err != nil {
+			return fmt.Errorf("failed to get VM SKU: %w", err)
+		}
+		if _, err := gpuDriverExtension(sku, c.Location); err != nil {
+			return err
+		}
+	}
+
+	if err := validateDiskSKUs(ctx, c); err != nil {
+		return fmt.Errorf("failed to validate disk SKUs: %w", err)
+	}
+
+	if err := validateDataDiskPerformance(c); err != nil {
+		return fmt.Errorf("failed to validate data disk performance settings: %w", err)
+	}
+
+	if c.DataDiskSourceID != "" {
+		source, err := validateDataDiskSource(c)
+		if err != nil {
+			return fmt.Errorf("failed to validate data disk source: %w", err)
+		}
+		if err := checkDataDiskSourceExists(ctx, c, source); err != nil {
+			return err
+		}
+	}
+
+	if err := validateDiskPerformanceTier(c.OSDiskSKU, c.OSDiskPerformanceTier); err != nil {
+		return fmt.Errorf("failed to validate OS disk performance tier: %w", err)
+	}
+
+	if err := validateDiskPerformanceTier(c.DataDiskSKU, c.DataDiskPerformanceTier); err != nil {
+		return fmt.Errorf("failed to validate data disk performance tier: %w", err)
 	}
 
-	if c.SubscriptionID == "" {
-		return errors.New("subscriptionID is missing")
+	if c.DataDiskPerformanceTier != nil && c.DataDiskSize == 0 {
+		return errors.New("dataDiskPerformanceTier requires dataDiskSize to be set")
 	}
 
-	if c.TenantID == "" {
-		return errors.New("tenantID is missing")
+	imageRef, err := getOSImageReference(c, providerConfig.OperatingSystem)
+	if err != nil {
+		return err
 	}
 
-	if c.ClientID == "" {
-		return errors.New("clientID is missing")
+	if err := checkImageExists(ctx, c, imageRef); err != nil {
+		return err
 	}
 
-	if c.ClientSecret == "" {
-		return errors.New("clientSecret is missing")
+	if hasDataDisk(c) {
+		imageDataDiskLUNs, err := getImageDataDiskLUNs(ctx, c, imageRef)
+		if err != nil {
+			return fmt.Errorf("failed to get data disk LUNs of the image: %w", err)
+		}
+		sku, err := getSKU(ctx, c)
+		if err != nil {
+			return fmt.Errorf("failed to get VM SKU: %w", err)
+		}
+		if err := validateDataDiskCount(imageDataDiskLUNs, 1, sku); err != nil {
+			return cloudprovidererrors.TerminalError{
+				Reason:  common.InvalidConfigurationMachineError,
+				Message: err.Error(),
+			}
+		}
 	}
 
-	if c.ResourceGroup == "" {
-		return errors.New("resourceGroup is missing")
+	// An unsupported ephemeral OS disk only results in a warning, Create falls back to a managed OS disk
+	if _, err := getEphemeralOSDiskPlacement(ctx, c, imageRef); err != nil {
+		return err
 	}
 
-	if c.VMSize == "" {
-		return errors.New("vmSize is missing")
+	if c.OSDiskSize != 0 {
+		imageOSDiskSize, err := getImageOSDiskSize(ctx, c, imageRef)
+		if err != nil {
+			return fmt.Errorf("failed to get OS disk size of the image: %w", err)
+		}
+		if err := validateOSDiskSize(c.OSDiskSize, imageOSDiskSize); err != nil {
+			return cloudprovidererrors.TerminalError{
+				Reason:  common.InvalidConfigurationMachineError,
+				Message: err.Error(),
+			}
+		}
+	}
+
+	return nil
+}
+
+func ifaceName(machine *clusterv1alpha1.Machine) string {
+	return machine.Name + "-netiface"
+}
+
+func dataDiskName(machine *clusterv1alpha1.Machine) string {
+	return machine.Name + "-datadisk"
+}
+
+// hasOutboundConnectivity checks if machines in the subnet have egress without a public IP. The route table of the
+// subnet is optional.
+func hasOutboundConnectivity(c *config, subnet network.Subnet, routeTable *network.RouteTable) bool {
+	if subnet.SubnetPropertiesFormat != nil && subnet.NatGateway != nil {
+		return true
 	}
+	if hasDefaultRoute(routeTable) {
+		return true
+	}
+	return strings.EqualFold(c.LoadBalancerSku, string(network.LoadBalancerSkuNameStandard))
+}
 
-	if c.VNetName == "" {
-		return errors.New("vnetName is missing")
+// hasDefaultRoute checks if the route table routes all IPv4 traffic to the Internet or to a next hop like a
+// firewall appliance or a VPN gateway, which provides egress.
+func hasDefaultRoute(routeTable *network.RouteTable) bool {
+	if routeTable == nil || routeTable.RouteTablePropertiesFormat == nil || routeTable.Routes == nil {
+		return false
 	}
 
-	if c.SubnetName == "" {
-		return errors.New("subnetName is missing")
+	for _, route := range *routeTable.Routes {
+		if route.RoutePropertiesFormat == nil || to.String(route.AddressPrefix) != "0.0.0.0/0" {
+			continue
+		}
+		switch route.NextHopType {
+		case network.RouteNextHopTypeInternet, network.RouteNextHopTypeVirtualAppliance, network.RouteNextHopTypeVirtualNetworkGateway:
+			return true
+		}
 	}
 
-	switch f := providerConfig.Network.GetIPFamily(); f {
-	case util.Unspecified, util.IPv4:
-		//noop
-	case util.IPv6:
-		return fmt.Errorf(util.ErrIPv6OnlyUnsupported)
-	case util.DualStack:
-		// validate
-	default:
-		return fmt.Errorf(util.ErrUnknownNetworkFamily, f)
+	return false
+}
+
+// getSubnetRouteTable returns the route table of the subnet or nil if it has none. The route table is only used to
+// detect egress, so a route table which can't be read, e.g. due to missing permissions, is skipped with a warning.
+func getSubnetRouteTable(ctx context.Context, c *config, subnet network.Subnet) *network.RouteTable {
+	if subnet.SubnetPropertiesFormat == nil || subnet.RouteTable == nil || to.String(subnet.RouteTable.ID) == "" {
+		return nil
 	}
 
-	vmClient, err := getVMClient(c)
+	resource, err := azure.ParseResourceID(*subnet.RouteTable.ID)
 	if err != nil {
-		return fmt.Errorf("failed to (create) vm client: %v", err.Error())
+		klog.Warningf("failed to parse ID of the route table of subnet %q: %v", to.String(subnet.Name), err)
+		return nil
 	}
 
-	_, err = vmClient.ListAll(context.TODO(), "", "")
+	client, err := getRouteTablesClient(c, resource.SubscriptionID)
 	if err != nil {
-		return fmt.Errorf("failed to list all: %v", err.Error())
+		klog.Warningf("failed to create route tables client: %v", err)
+		return nil
 	}
 
-	if _, err := getVirtualNetwork(context.TODO(), c); err != nil {
-		return fmt.Errorf("failed to get virtual network: %v", err)
+	routeTable, err := client.Get(ctx, resource.ResourceGroup, resource.ResourceName, "")
+	if err != nil {
+		klog.Warningf("failed to get route table %q of subnet %q: %v", resource.ResourceName, to.String(subnet.Name), err)
+		return nil
 	}
 
-	if _, err := getSubnet(context.TODO(), c); err != nil {
-		return fmt.Errorf("failed to get subnet: %v", err)
+	return &routeTable
+}
+
+func noEgressMessage(subnet network.Subnet) string {
+	return fmt.Sprintf("assignPublicIP is disabled, but subnet %q has no NAT gateway attached and no default route, and no standard load balancer is configured, nodes might have no outbound connectivity to pull images", to.String(subnet.Name))
+}
+
+// validatePrivateIPAddress ensures that the static private IP address is within one of the prefixes of the subnet.
+func validatePrivateIPAddress(address string, subnet network.Subnet) error {
+	ip := net.ParseIP(address)
+
+	var prefixes []string
+	if subnet.SubnetPropertiesFormat != nil {
+		if subnet.AddressPrefix != nil {
+			prefixes = append(prefixes, *subnet.AddressPrefix)
+		}
+		if subnet.AddressPrefixes != nil {
+			prefixes = append(prefixes, *subnet.AddressPrefixes...)
+		}
 	}
 
-	if err := validateDiskSKUs(c); err != nil {
-		return fmt.Errorf("failed to validate disk SKUs: %w", err)
+	for _, prefix := range prefixes {
+		if _, cidr, err := net.ParseCIDR(prefix); err == nil && cidr.Contains(ip) {
+			return nil
+		}
 	}
 
-	_, err = getOSImageReference(c, providerConfig.OperatingSystem)
-	return err
+	return cloudprovidererrors.TerminalError{
+		Reason:  common.InvalidConfigurationMachineError,
+		Message: fmt.Sprintf("privateIPAddress %q is not within the prefixes [%s] of subnet %q", address, strings.Join(prefixes, ", "), to.String(subnet.Name)),
+	}
 }
 
-func ifaceName(machine *clusterv1alpha1.Machine) string {
-	return machine.Name + "-netiface"
+func hasDataDiskPerformanceSettings(c *config) bool {
+	return c.DataDiskIOPS != nil || c.DataDiskThroughput != nil
+}
+
+// hasDataDisk returns true if a data disk gets attached to the VM, either an empty one or one created from or
+// referencing an existing disk.
+func hasDataDisk(c *config) bool {
+	return c.DataDiskSize != 0 || c.DataDiskSourceID != ""
+}
+
+// assignDataDiskLUNs returns count LUNs for data disks, starting at 0 and skipping the LUNs reserved by the data
+// disks of the image.
+func assignDataDiskLUNs(reserved []int32, count int) ([]int32, error) {
+	used := make(map[int32]bool, len(reserved))
+	for _, lun := range reserved {
+		if lun < 0 || lun > maxDataDiskLUN {
+			return nil, fmt.Errorf("LUN %d of the image's data disks is outside of the valid range 0-%d", lun, maxDataDiskLUN)
+		}
+		if used[lun] {
+			return nil, fmt.Errorf("LUN %d is used by more than one data disk of the image", lun)
+		}
+		used[lun] = true
+	}
+
+	luns := make([]int32, 0, count)
+	for lun := int32(0); lun <= maxDataDiskLUN && len(luns) < count; lun++ {
+		if !used[lun] {
+			luns = append(luns, lun)
+		}
+	}
+	if len(luns) < count {
+		return nil, fmt.Errorf("no free LUN left for %d data disks, the image's data disks use %d of %d LUNs", count, len(reserved), maxDataDiskLUN+1)
+	}
+
+	return luns, nil
+}
+
+// getEphemeralOSDiskPlacement returns where the ephemeral OS disk of the VM is placed. If no ephemeral OS disk is
+// preferred or the VM size doesn't support it, an empty placement is returned and a managed OS disk is used.
+func getEphemeralOSDiskPlacement(ctx context.Context, c *config, imageRef *compute.ImageReference) (compute.DiffDiskPlacement, error) {
+	if !c.PreferEphemeralOSDisk {
+		return "", nil
+	}
+
+	osDiskSize := c.OSDiskSize
+	if osDiskSize == 0 {
+		imageOSDiskSize, err := getImageOSDiskSize(ctx, c, imageRef)
+		if err != nil {
+			return "", fmt.Errorf("failed to get OS disk size of the image: %w", err)
+		}
+		osDiskSize = imageOSDiskSize
+	}
+	if osDiskSize == 0 {
+		osDiskSize = defaultImageOSDiskSize
+	}
+
+	sku, err := getSKU(ctx, c)
+	if err != nil {
+		return "", fmt.Errorf("failed to get VM SKU: %w", err)
+	}
+
+	placement, ok := ephemeralOSDiskPlacement(sku, osDiskSize)
+	if !ok {
+		klog.Warningf("VM size %q doesn't support an ephemeral OS disk of %d GB, falling back to a managed OS disk", c.VMSize, osDiskSize)
+	}
+
+	return placement, nil
+}
+
+// ephemeralOSDiskPlacement returns where an ephemeral OS disk of the size in GB fits on VMs of the SKU. Like Azure,
+// the cache disk is preferred over the temp disk. false is returned if the SKU can't host the disk.
+func ephemeralOSDiskPlacement(sku compute.ResourceSku, osDiskSize int32) (compute.DiffDiskPlacement, bool) {
+	if !strings.EqualFold(skuCapability(sku, CapabilityEphemeralOSDiskSupported), CapabilityValueTrue) {
+		return "", false
+	}
+
+	osDiskBytes := int64(osDiskSize) * 1024 * 1024 * 1024
+	if cachedDiskBytes, _ := strconv.ParseInt(skuCapability(sku, CapabilityCachedDiskBytes), 10, 64); cachedDiskBytes >= osDiskBytes {
+		return compute.DiffDiskPlacementCacheDisk, true
+	}
+	if resourceVolumeMB, _ := strconv.ParseInt(skuCapability(sku, CapabilityMaxResourceVolumeMB), 10, 64); resourceVolumeMB*1024*1024 >= osDiskBytes {
+		return compute.DiffDiskPlacementResourceDisk, true
+	}
+
+	return "", false
+}
+
+// skuCapability returns the value of the capability of the SKU or an empty string if the SKU doesn't have it.
+func skuCapability(sku compute.ResourceSku, name string) string {
+	if sku.Capabilities == nil {
+		return ""
+	}
+
+	for _, capability := range *sku.Capabilities {
+		if capability.Name != nil && *capability.Name == name {
+			return to.String(capability.Value)
+		}
+	}
+
+	return ""
+}
+
+// skuMaxDataDiskCount returns the maximum number of data disks VMs of the SKU can have or 0 if it isn't known.
+func skuMaxDataDiskCount(sku compute.ResourceSku) int {
+	if sku.Capabilities == nil {
+		return 0
+	}
+
+	for _, capability := range *sku.Capabilities {
+		if capability.Name != nil && *capability.Name == CapabilityMaxDataDiskCount && capability.Value != nil {
+			count, _ := strconv.Atoi(*capability.Value)
+			return count
+		}
+	}
+
+	return 0
+}
+
+// validateDataDiskCount checks that LUNs can be assigned to count data disks next to the data disks of the image
+// and that all of them together don't exceed the maximum number of data disks of the VM size.
+func validateDataDiskCount(imageDataDiskLUNs []int32, count int, sku compute.ResourceSku) error {
+	if _, err := assignDataDiskLUNs(imageDataDiskLUNs, count); err != nil {
+		return err
+	}
+
+	maxCount := skuMaxDataDiskCount(sku)
+	if total := len(imageDataDiskLUNs) + count; maxCount != 0 && total > maxCount {
+		return fmt.Errorf("VM size %q supports at most %d data disks, but %d are required (%d from the image, %d configured)",
+			to.String(sku.Name), maxCount, total, len(imageDataDiskLUNs), count)
+	}
+
+	return nil
 }
 
 func publicIPName(ifaceName string) string {
@@ -1063,9 +2777,6 @@ func publicIPv6Name(ifaceName string) string {
 }
 
 func (p *provider) MigrateUID(machine *clusterv1alpha1.Machine, newUID types.UID) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	config, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err != nil {
 		return cloudprovidererrors.TerminalError{
@@ -1074,11 +2785,24 @@ func (p *provider) MigrateUID(machine *clusterv1alpha1.Machine, newUID types.UID
 		}
 	}
 
+	ctx, cancel := apiContext(config)
+	defer cancel()
+
+	return apiTimeoutError(ctx, config, p.migrateUID(ctx, machine, newUID, config))
+}
+
+func (p *provider) migrateUID(ctx context.Context, machine *clusterv1alpha1.Machine, newUID types.UID, config *config) error {
 	vmClient, err := getVMClient(config)
 	if err != nil {
 		return fmt.Errorf("failed to create VM client: %v", err)
 	}
 
+	// The MachineDeployment of the machine can't be looked up during the migration, the grouping tags of the VM
+	// are kept instead
+	if vm, err := vmClient.Get(ctx, config.ResourceGroup, machine.Name, ""); err == nil {
+		config.GroupingTags = existingGroupingTags(config, vm.Tags)
+	}
+
 	var publicIP, publicIPv6 *network.PublicIPAddress
 	sku := network.PublicIPAddressSkuNameBasic
 
@@ -1121,17 +2845,17 @@ func (p *provider) MigrateUID(machine *clusterv1alpha1.Machine, newUID types.UID
 			if err != nil {
 				return fmt.Errorf("failed to update UID for disk %s: %v", *disk.Name, err)
 			}
-			if err := future.WaitForCompletionRef(ctx, disksClient.Client); err != nil {
+			if err := waitForOperation(ctx, future, disksClient.Client, 0, operationTimeout, fmt.Sprintf("update of disk %s", *disk.Name)); err != nil {
 				return fmt.Errorf("failed waiting for completion of update UID operation for disk %s: %v", *disk.Name, err)
 			}
 		}
 	}
 
-	tags := map[string]*string{}
-	for k, v := range config.Tags {
-		tags[k] = to.StringPtr(v)
+	controllerTags := map[string]string{machineUIDTag: string(newUID)}
+	for key, value := range config.GroupingTags {
+		controllerTags[key] = value
 	}
-	tags[machineUIDTag] = to.StringPtr(string(newUID))
+	tags, _ := limitTags(maxVMTags, controllerTags, config.Tags)
 
 	vmSpec := compute.VirtualMachine{Location: &config.Location, Tags: tags}
 	future, err := vmClient.CreateOrUpdate(ctx, config.ResourceGroup, machine.Name, vmSpec)
@@ -1139,7 +2863,7 @@ func (p *provider) MigrateUID(machine *clusterv1alpha1.Machine, newUID types.UID
 		return fmt.Errorf("failed to update UID of the instance: %v", err)
 	}
 
-	if err := future.WaitForCompletionRef(ctx, vmClient.Client); err != nil {
+	if err := waitForOperation(ctx, future, vmClient.Client, config.VMPollInterval, operationTimeout, fmt.Sprintf("update of VM %q", machine.Name)); err != nil {
 		return fmt.Errorf("error waiting for instance to have the updated UID: %v", err)
 	}
 
@@ -1151,17 +2875,197 @@ func (p *provider) MachineMetricsLabels(machine *clusterv1alpha1.Machine) (map[s
 
 	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err == nil {
-		labels["size"] = c.VMSize
-		labels["location"] = c.Location
+		labels = machineMetricsLabels(c)
 	}
 
 	return labels, err
 }
 
+// machineMetricsLabels returns the metrics labels of a VM. All labels are always set, with "none" for settings
+// which aren't used, so the label set of the metrics is stable.
+func machineMetricsLabels(c *config) map[string]string {
+	labels := map[string]string{
+		"size":            c.VMSize,
+		"location":        c.Location,
+		"zone":            "none",
+		"availabilitySet": "none",
+		"priority":        string(compute.VirtualMachinePriorityTypesRegular),
+	}
+
+	if len(c.Zones) > 0 {
+		labels["zone"] = strings.Join(c.Zones, ",")
+	}
+	if c.AvailabilitySet != "" && (c.AssignAvailabilitySet == nil || *c.AssignAvailabilitySet) {
+		labels["availabilitySet"] = c.AvailabilitySet
+	}
+	if c.Spot {
+		labels["priority"] = string(compute.VirtualMachinePriorityTypesSpot)
+	}
+
+	return labels
+}
+
 func (p *provider) SetMetricsForMachines(machines clusterv1alpha1.MachineList) error {
 	return nil
 }
 
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p *provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	return providerconfigtypes.FilterOperatingSystems(func(os providerconfigtypes.OperatingSystem) bool {
+		_, ok := imageReferences[os]
+		return ok
+	})
+}
+
+// setVirtualMachineScaleSet adds the VM to the scale set and pins it to the fault domain, if one is given.
+func setVirtualMachineScaleSet(props *compute.VirtualMachineProperties, scaleSetID string, faultDomain *int32) {
+	props.VirtualMachineScaleSet = &compute.SubResource{ID: to.StringPtr(scaleSetID)}
+	props.PlatformFaultDomain = faultDomain
+}
+
+// validatePlatformFaultDomain checks that the scale set uses flexible orchestration, which is required for single VMs
+// to join it, and that the fault domain exists in the scale set. VMs can only be pinned to a fault domain if the scale
+// set has more than one, otherwise Azure spreads them across fault domains on its own.
+func validatePlatformFaultDomain(c *config, scaleSet compute.VirtualMachineScaleSet) error {
+	if scaleSet.VirtualMachineScaleSetProperties == nil || scaleSet.OrchestrationMode != compute.OrchestrationModeFlexible {
+		return fmt.Errorf("virtual machine scale set %q must use %s orchestration", c.VirtualMachineScaleSet, compute.OrchestrationModeFlexible)
+	}
+
+	if c.PlatformFaultDomain == nil {
+		return nil
+	}
+
+	faultDomainCount := to.Int32(scaleSet.PlatformFaultDomainCount)
+	if faultDomainCount <= 1 {
+		return fmt.Errorf("platformFaultDomain can't be set, virtual machine scale set %q has %d fault domains", c.VirtualMachineScaleSet, faultDomainCount)
+	}
+	if *c.PlatformFaultDomain < 0 || *c.PlatformFaultDomain >= faultDomainCount {
+		return fmt.Errorf("platformFaultDomain must be between 0 and %d, got %d", faultDomainCount-1, *c.PlatformFaultDomain)
+	}
+
+	return nil
+}
+
+// getSecurityProfile returns the security profile of the VM, which is nil for the Standard security type. UEFI settings
+// without a security type use TrustedLaunch, as Azure requires a security type for them.
+func getSecurityProfile(c *config) *compute.SecurityProfile {
+	securityType := c.SecurityType
+	if securityType == "" && c.UefiSettings != nil {
+		securityType = compute.SecurityTypesTrustedLaunch
+	}
+	if securityType == "" || securityType == securityTypesStandard {
+		return nil
+	}
+
+	return &compute.SecurityProfile{
+		SecurityType: securityType,
+		UefiSettings: c.UefiSettings,
+	}
+}
+
+// validateSecurityProfile checks the security type and the UEFI settings against the rules of Azure.
+func validateSecurityProfile(c *config) error {
+	switch c.SecurityType {
+	case "", compute.SecurityTypesTrustedLaunch:
+	case securityTypesStandard:
+		if c.UefiSettings != nil {
+			return fmt.Errorf("uefiSettings can't be used with securityType %s", securityTypesStandard)
+		}
+	case compute.SecurityTypesConfidentialVM:
+		// Confidential VMs always run with a vTPM
+		if c.UefiSettings != nil && c.UefiSettings.VTpmEnabled != nil && !*c.UefiSettings.VTpmEnabled {
+			return fmt.Errorf("uefiSettings.vTpmEnabled can't be disabled with securityType %s", compute.SecurityTypesConfidentialVM)
+		}
+	default:
+		return fmt.Errorf("unknown securityType %q, must be %s, %s or %s", c.SecurityType,
+			securityTypesStandard, compute.SecurityTypesTrustedLaunch, compute.SecurityTypesConfidentialVM)
+	}
+
+	return nil
+}
+
+// licenseTypeOperatingSystems are the Azure Hybrid Benefit license types of Linux distributions and the operating
+// systems they can be used with.
+var licenseTypeOperatingSystems = map[string]providerconfigtypes.OperatingSystem{
+	"RHEL_BYOS": providerconfigtypes.OperatingSystemRHEL,
+	"SLES_BYOS": providerconfigtypes.OperatingSystemSLES,
+}
+
+// windowsLicenseTypes are the Azure Hybrid Benefit license types of Windows images.
+var windowsLicenseTypes = sets.NewString("Windows_Server", "Windows_Client")
+
+// validateLicenseType checks that the license type is known and matches the operating system.
+func validateLicenseType(licenseType string, os providerconfigtypes.OperatingSystem) error {
+	if licenseType == "" {
+		return nil
+	}
+
+	// All operating systems supported on Azure are Linux distributions
+	if windowsLicenseTypes.Has(licenseType) {
+		return fmt.Errorf("licenseType %s requires a Windows image, but operating system %q is a Linux distribution", licenseType, os)
+	}
+
+	licenseOS, ok := licenseTypeOperatingSystems[licenseType]
+	if !ok {
+		var known []string
+		for name := range licenseTypeOperatingSystems {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return fmt.Errorf("unknown licenseType %q, must be one of %s", licenseType, strings.Join(known, ", "))
+	}
+	if licenseOS != os {
+		return fmt.Errorf("licenseType %s can only be used with operating system %q, got %q", licenseType, licenseOS, os)
+	}
+
+	return nil
+}
+
+// skuSupportsHyperVGeneration returns true if VMs of the SKU can run images of the Hyper-V generation.
+func skuSupportsHyperVGeneration(sku compute.ResourceSku, generation compute.HyperVGenerationTypes) bool {
+	if sku.Capabilities == nil {
+		return false
+	}
+
+	for _, capability := range *sku.Capabilities {
+		if capability.Name == nil || *capability.Name != CapabilityHyperVGenerations || capability.Value == nil {
+			continue
+		}
+		for _, supported := range strings.Split(*capability.Value, ",") {
+			if strings.EqualFold(strings.TrimSpace(supported), string(generation)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// validateResourceGroup checks that the resource group can be read and is in the location of the VM.
+func validateResourceGroup(ctx context.Context, c *config) error {
+	resourceGroup, err := getResourceGroup(ctx, c)
+	if err != nil {
+		return fmt.Errorf("failed to get resource group: %w", err)
+	}
+
+	return validateResourceGroupLocation(c.Location, resourceGroup)
+}
+
+// validateResourceGroupLocation checks that the location matches the location of the resource group. Locations are
+// compared by name, ignoring the case and spaces of display names like "West Europe".
+func validateResourceGroupLocation(location string, group resources.Group) error {
+	groupLocation := to.String(group.Location)
+	if normalizeLocation(location) != normalizeLocation(groupLocation) {
+		return fmt.Errorf("location %q differs from location %q of resource group %q", location, groupLocation, to.String(group.Name))
+	}
+
+	return nil
+}
+
+func normalizeLocation(location string) string {
+	return strings.ToLower(strings.ReplaceAll(location, " ", ""))
+}
+
 func getOSUsername(os providerconfigtypes.OperatingSystem) string {
 	switch os {
 	case providerconfigtypes.OperatingSystemFlatcar:
@@ -1171,11 +3075,113 @@ func getOSUsername(os providerconfigtypes.OperatingSystem) string {
 	}
 }
 
+// adminUsername returns the admin user of the VM, which defaults to the default user of the operating system.
+func adminUsername(c *config, os providerconfigtypes.OperatingSystem) string {
+	if c.AdminUsername != "" {
+		return c.AdminUsername
+	}
+	return getOSUsername(os)
+}
+
+// maxAdminUsernameLength is the maximum length of Linux usernames supported by useradd.
+const maxAdminUsernameLength = 32
+
+// adminUsernameRegexp matches the portable Linux usernames, which start with a lowercase letter or an underscore.
+var adminUsernameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+// reservedAdminUsernames are the admin usernames which are rejected by Azure.
+var reservedAdminUsernames = sets.NewString(
+	"1", "123", "a", "actuser", "adm", "admin", "admin1", "admin2", "administrator", "aspnet", "backup", "console",
+	"david", "guest", "john", "owner", "root", "server", "sql", "support", "support_388945a0", "sys", "test", "test1",
+	"test2", "test3", "user", "user1", "user2", "user3", "user4", "user5",
+)
+
+// validateAdminUsername checks that the admin username is a valid Linux username which is accepted by Azure.
+func validateAdminUsername(name string) error {
+	if len(name) > maxAdminUsernameLength {
+		return fmt.Errorf("adminUsername %q is longer than %d characters", name, maxAdminUsernameLength)
+	}
+	if !adminUsernameRegexp.MatchString(name) {
+		return fmt.Errorf("adminUsername %q must start with a lowercase letter or an underscore and contain only lowercase letters, digits, underscores and hyphens", name)
+	}
+	if reservedAdminUsernames.Has(name) {
+		return fmt.Errorf("adminUsername %q is reserved by Azure", name)
+	}
+	return nil
+}
+
 func storageTypePtr(storageType string) *compute.StorageAccountTypes {
 	storage := compute.StorageAccountTypes(storageType)
 	return &storage
 }
 
+// maxSuggestedVMSizes is the number of valid VM sizes listed if an invalid VM size is configured.
+const maxSuggestedVMSizes = 5
+
+// validateVMSize checks that the VM size is offered in the location and zones, and isn't restricted for the subscription.
+func validateVMSize(skus []compute.ResourceSku, vmSize, location string, zones []string) error {
+	var validSizes []string
+	for _, sku := range skus {
+		if skuRestricted(sku, location, zones) {
+			continue
+		}
+		if *sku.Name == vmSize {
+			return nil
+		}
+		validSizes = append(validSizes, *sku.Name)
+	}
+
+	sort.Strings(validSizes)
+	if len(validSizes) > maxSuggestedVMSizes {
+		validSizes = validSizes[:maxSuggestedVMSizes]
+	}
+
+	return cloudprovidererrors.TerminalError{
+		Reason:  common.InvalidConfigurationMachineError,
+		Message: fmt.Sprintf("VM size %q is not available in location %q, valid sizes are e.g. %s", vmSize, location, strings.Join(validSizes, ", ")),
+	}
+}
+
+// skuRestricted returns true if the SKU can't be used in the location or in one of the zones.
+func skuRestricted(sku compute.ResourceSku, location string, zones []string) bool {
+	if sku.Restrictions == nil {
+		return false
+	}
+
+	for _, restriction := range *sku.Restrictions {
+		if restriction.RestrictionInfo == nil {
+			continue
+		}
+
+		switch restriction.Type {
+		case compute.ResourceSkuRestrictionsTypeLocation:
+			if restriction.RestrictionInfo.Locations != nil && containsFold(*restriction.RestrictionInfo.Locations, location) {
+				return true
+			}
+		case compute.ResourceSkuRestrictionsTypeZone:
+			if restriction.RestrictionInfo.Zones == nil {
+				continue
+			}
+			for _, zone := range zones {
+				if containsFold(*restriction.RestrictionInfo.Zones, zone) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
 // supportsDiskSKU validates some disk SKU types against the chosen VM SKU / VM type.
 func supportsDiskSKU(vmSKU compute.ResourceSku, diskSKU compute.StorageAccountTypes, zones []string) error {
 	// sanity check to make sure the Azure API did not return something bad
@@ -1245,3 +3251,46 @@ func supportsDiskSKU(vmSKU compute.ResourceSku, diskSKU compute.StorageAccountTy
 
 	return nil
 }
+
+func getOSProfile(c *config, machineName, adminUserName string, publicKeys []string, userdata string) *compute.OSProfile {
+	sshPublicKeys := make([]compute.SSHPublicKey, 0, len(publicKeys))
+	for _, publicKey := range publicKeys {
+		// Azure appends all keys with the same path to the file
+		sshPublicKeys = append(sshPublicKeys, compute.SSHPublicKey{
+			Path:    to.StringPtr(fmt.Sprintf("/home/%s/.ssh/authorized_keys", adminUserName)),
+			KeyData: to.StringPtr(publicKey),
+		})
+	}
+
+	return &compute.OSProfile{
+		AdminUsername: to.StringPtr(adminUserName),
+		ComputerName:  to.StringPtr(computerName(c, machineName)),
+		LinuxConfiguration: &compute.LinuxConfiguration{
+			DisablePasswordAuthentication: to.BoolPtr(true),
+			SSH: &compute.SSHConfiguration{
+				PublicKeys: &sshPublicKeys,
+			},
+		},
+		CustomData:               to.StringPtr(base64.StdEncoding.EncodeToString([]byte(userdata))),
+		AllowExtensionOperations: c.AllowExtensionOperations,
+	}
+}
+
+// computerName returns the hostname of the VM of the machine with the given name.
+func computerName(c *config, machineName string) string {
+	if c.ComputerName != "" {
+		return c.ComputerName
+	}
+	return c.ComputerNamePrefix + machineName
+}
+
+func validateComputerName(name string, osType compute.OperatingSystemTypes) error {
+	maxLength, ok := maxComputerNameLengths[osType]
+	if !ok {
+		return fmt.Errorf("unsupported OS type %q", osType)
+	}
+	if len(name) > maxLength {
+		return fmt.Errorf("computer name %q is longer than %d characters, which is the maximum for %s", name, maxLength, osType)
+	}
+	return nil
+}
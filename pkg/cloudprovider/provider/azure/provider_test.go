@@ -0,0 +1,3247 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-11-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2021-05-01/network"
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2020-10-01/resources"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
+	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+	azuretypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/azure/types"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/util"
+	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+	testhelper "github.com/kubermatic/machine-controller/pkg/test"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetNetworkInterfaceSpec(t *testing.T) {
+	publicIP := &network.PublicIPAddress{ID: to.StringPtr("public-ip")}
+	publicIPv6 := &network.PublicIPAddress{ID: to.StringPtr("public-ipv6")}
+
+	tests := []struct {
+		name             string
+		assignPublicIP   bool
+		expectedPublicIP bool
+	}{
+		{
+			name:             "public IP is assigned",
+			assignPublicIP:   true,
+			expectedPublicIP: true,
+		},
+		{
+			name:             "no public IP configuration without assignPublicIP",
+			assignPublicIP:   false,
+			expectedPublicIP: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{Location: "westeurope", AssignPublicIP: test.assignPublicIP}
+			ifSpec := getNetworkInterfaceSpec("test-netiface", "uid", c, network.Subnet{}, publicIP, publicIPv6, util.DualStack)
+
+			ipConfigs := *ifSpec.IPConfigurations
+			if len(ipConfigs) != 2 {
+				t.Fatalf("expected 2 IP configurations, got %d", len(ipConfigs))
+			}
+
+			for _, ipConfig := range ipConfigs {
+				hasPublicIP := ipConfig.PublicIPAddress != nil
+				if hasPublicIP != test.expectedPublicIP {
+					t.Errorf("IP configuration %q: expected public IP to be set: %t, got: %t", *ipConfig.Name, test.expectedPublicIP, hasPublicIP)
+				}
+			}
+		})
+	}
+}
+
+func TestGetNetworkInterfaceSpecStaticPrivateIP(t *testing.T) {
+	c := &config{Location: "westeurope", PrivateIPAddress: "10.0.0.10"}
+	ifSpec := getNetworkInterfaceSpec("test-netiface", "uid", c, network.Subnet{}, nil, nil, util.DualStack)
+
+	ipConfigs := *ifSpec.IPConfigurations
+	if method := ipConfigs[0].PrivateIPAllocationMethod; method != network.IPAllocationMethodStatic {
+		t.Errorf("expected static allocation of the primary IP configuration, got %q", method)
+	}
+	if address := to.String(ipConfigs[0].PrivateIPAddress); address != "10.0.0.10" {
+		t.Errorf("expected private IP address %q, got %q", "10.0.0.10", address)
+	}
+	if method := ipConfigs[1].PrivateIPAllocationMethod; method != network.IPAllocationMethodDynamic {
+		t.Errorf("expected dynamic allocation of the IPv6 configuration, got %q", method)
+	}
+}
+
+func TestPublicIPAddressSpecIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  *int32
+		expected *int32
+	}{
+		{
+			name: "azure default",
+		},
+		{
+			name:     "configured timeout",
+			timeout:  to.Int32Ptr(15),
+			expected: to.Int32Ptr(15),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{Location: "westeurope", AssignPublicIP: true, PublicIPIdleTimeoutMinutes: test.timeout}
+			ipSpec := publicIPAddressSpec("test-pubip", network.IPVersionIPv4, network.PublicIPAddressSkuNameStandard, network.IPAllocationMethodStatic, "uid", c)
+
+			timeout := ipSpec.PublicIPAddressPropertiesFormat.IdleTimeoutInMinutes
+			if !reflect.DeepEqual(timeout, test.expected) {
+				t.Errorf("expected idle timeout %v, got %v", to.Int32(test.expected), to.Int32(timeout))
+			}
+		})
+	}
+}
+
+func TestValidatePrivateIPAddress(t *testing.T) {
+	subnet := network.Subnet{
+		Name: to.StringPtr("subnet"),
+		SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+			AddressPrefixes: &[]string{"10.0.0.0/24", "10.0.2.0/24"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		address     string
+		expectError bool
+	}{
+		{
+			name:    "within the first prefix",
+			address: "10.0.0.10",
+		},
+		{
+			name:    "within the second prefix",
+			address: "10.0.2.10",
+		},
+		{
+			name:        "outside of the prefixes",
+			address:     "10.0.1.10",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePrivateIPAddress(test.address, subnet)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if isTerminal, _, _ := cloudprovidererrors.IsTerminalError(err); test.expectError && !isTerminal {
+				t.Errorf("expected a terminal error, got %v", err)
+			}
+		})
+	}
+}
+
+func routeTable(addressPrefix string, nextHopType network.RouteNextHopType) *network.RouteTable {
+	return &network.RouteTable{RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+		Routes: &[]network.Route{{RoutePropertiesFormat: &network.RoutePropertiesFormat{
+			AddressPrefix: to.StringPtr(addressPrefix),
+			NextHopType:   nextHopType,
+		}}},
+	}}
+}
+
+func TestHasOutboundConnectivity(t *testing.T) {
+	tests := []struct {
+		name       string
+		lbSku      string
+		subnet     network.Subnet
+		routeTable *network.RouteTable
+		expected   bool
+	}{
+		{
+			name: "NAT gateway attached to subnet",
+			subnet: network.Subnet{SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+				NatGateway: &network.SubResource{ID: to.StringPtr("nat-gateway")},
+			}},
+			expected: true,
+		},
+		{
+			name:     "standard load balancer",
+			lbSku:    "standard",
+			subnet:   network.Subnet{SubnetPropertiesFormat: &network.SubnetPropertiesFormat{}},
+			expected: true,
+		},
+		{
+			name:       "default route to a firewall appliance",
+			lbSku:      "basic",
+			subnet:     network.Subnet{SubnetPropertiesFormat: &network.SubnetPropertiesFormat{}},
+			routeTable: routeTable("0.0.0.0/0", network.RouteNextHopTypeVirtualAppliance),
+			expected:   true,
+		},
+		{
+			name:       "default route to nowhere",
+			lbSku:      "basic",
+			subnet:     network.Subnet{SubnetPropertiesFormat: &network.SubnetPropertiesFormat{}},
+			routeTable: routeTable("0.0.0.0/0", network.RouteNextHopTypeNone),
+			expected:   false,
+		},
+		{
+			name:       "route table without default route",
+			lbSku:      "basic",
+			subnet:     network.Subnet{SubnetPropertiesFormat: &network.SubnetPropertiesFormat{}},
+			routeTable: routeTable("10.0.0.0/8", network.RouteNextHopTypeVirtualAppliance),
+			expected:   false,
+		},
+		{
+			name:     "no outbound connectivity",
+			lbSku:    "basic",
+			subnet:   network.Subnet{SubnetPropertiesFormat: &network.SubnetPropertiesFormat{}},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := hasOutboundConnectivity(&config{LoadBalancerSku: test.lbSku}, test.subnet, test.routeTable); got != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestGetConfigFieldValidationError(t *testing.T) {
+	p := &provider{configVarResolver: providerconfig.NewConfigVarResolver(context.Background(), fakectrlruntimeclient.NewClientBuilder().Build())}
+
+	spec := clusterv1alpha1.ProviderSpec{
+		Value: &runtime.RawExtension{Raw: []byte(`{
+			"cloudProvider": "azure",
+			"operatingSystem": "ubuntu",
+			"operatingSystemSpec": {},
+			"cloudProviderSpec": {"vmSize": {"secretKeyRef": {"namespace": "kube-system", "name": "missing", "key": "vmSize"}}}
+		}`)},
+	}
+
+	_, _, err := p.getConfig(spec)
+	var fieldErr cloudprovidererrors.FieldValidationError
+	if !errors.As(err, &fieldErr) {
+		t.Fatalf("expected a FieldValidationError, got %v", err)
+	}
+	if fieldErr.Field != "vmSize" {
+		t.Errorf("expected field vmSize, got %q", fieldErr.Field)
+	}
+	if !strings.HasPrefix(err.Error(), `failed to get the value of "vmSize" field, error = `) {
+		t.Errorf("unexpected error message %q", err.Error())
+	}
+}
+
+func TestGetConfigInstanceCacheTTL(t *testing.T) {
+	p := &provider{configVarResolver: providerconfig.NewConfigVarResolver(context.Background(), fakectrlruntimeclient.NewClientBuilder().Build())}
+
+	tests := []struct {
+		name        string
+		ttl         string
+		expected    time.Duration
+		expectError bool
+	}{
+		{
+			name:     "default",
+			expected: defaultInstanceCacheTTL,
+		},
+		{
+			name:     "configured",
+			ttl:      `"2m"`,
+			expected: 2 * time.Minute,
+		},
+		{
+			name:     "caching disabled",
+			ttl:      `"0s"`,
+			expected: 0,
+		},
+		{
+			name:        "negative",
+			ttl:         `"-1s"`,
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cloudProviderSpec := `{"vmSize": "Standard_D2s_v3"}`
+			if test.ttl != "" {
+				cloudProviderSpec = fmt.Sprintf(`{"vmSize": "Standard_D2s_v3", "instanceCacheTTL": %s}`, test.ttl)
+			}
+			spec := clusterv1alpha1.ProviderSpec{
+				Value: &runtime.RawExtension{Raw: []byte(fmt.Sprintf(`{
+					"cloudProvider": "azure",
+					"operatingSystem": "ubuntu",
+					"operatingSystemSpec": {},
+					"cloudProviderSpec": %s
+				}`, cloudProviderSpec))},
+			}
+
+			c, _, err := p.getConfig(spec)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if test.expectError {
+				return
+			}
+			if c.InstanceCacheTTL != test.expected {
+				t.Errorf("expected instance cache TTL %s, got %s", test.expected, c.InstanceCacheTTL)
+			}
+		})
+	}
+}
+
+func TestGetConfigVMTimeouts(t *testing.T) {
+	p := &provider{configVarResolver: providerconfig.NewConfigVarResolver(context.Background(), fakectrlruntimeclient.NewClientBuilder().Build())}
+
+	tests := []struct {
+		name            string
+		timeouts        string
+		expectedCreate  time.Duration
+		expectedPolling time.Duration
+		expectError     bool
+	}{
+		{
+			name: "not configured",
+		},
+		{
+			name:            "configured",
+			timeouts:        `"vmCreateTimeout": "10m", "vmPollInterval": "5s"`,
+			expectedCreate:  10 * time.Minute,
+			expectedPolling: 5 * time.Second,
+		},
+		{
+			name:        "negative timeout",
+			timeouts:    `"vmDeleteTimeout": "-1m"`,
+			expectError: true,
+		},
+		{
+			name:        "malformed poll interval",
+			timeouts:    `"vmPollInterval": "often"`,
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cloudProviderSpec := `{"vmSize": "Standard_D2s_v3"}`
+			if test.timeouts != "" {
+				cloudProviderSpec = fmt.Sprintf(`{"vmSize": "Standard_D2s_v3", %s}`, test.timeouts)
+			}
+			spec := clusterv1alpha1.ProviderSpec{
+				Value: &runtime.RawExtension{Raw: []byte(fmt.Sprintf(`{
+					"cloudProvider": "azure",
+					"operatingSystem": "ubuntu",
+					"operatingSystemSpec": {},
+					"cloudProviderSpec": %s
+				}`, cloudProviderSpec))},
+			}
+
+			c, _, err := p.getConfig(spec)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if test.expectError {
+				return
+			}
+			if c.VMCreateTimeout != test.expectedCreate || c.VMPollInterval != test.expectedPolling {
+				t.Errorf("expected create timeout %s and poll interval %s, got %s and %s", test.expectedCreate, test.expectedPolling, c.VMCreateTimeout, c.VMPollInterval)
+			}
+		})
+	}
+}
+
+func TestGetDataDiskSpecPerformanceTier(t *testing.T) {
+	sku := compute.StorageAccountTypesPremiumLRS
+	c := &config{
+		Location:                "westeurope",
+		DataDiskSize:            4,
+		DataDiskSKU:             &sku,
+		DataDiskPerformanceTier: to.StringPtr("P50"),
+	}
+
+	disk := getDataDiskSpec("uid", c)
+	if disk.Tier == nil || *disk.Tier != "P50" {
+		t.Errorf("expected performance tier P50, got %v", disk.Tier)
+	}
+	if disk.Sku == nil || disk.Sku.Name != compute.DiskStorageAccountTypesPremiumLRS {
+		t.Errorf("expected disk SKU %s, got %v", compute.DiskStorageAccountTypesPremiumLRS, disk.Sku)
+	}
+}
+
+func TestGetDataDiskSpecSource(t *testing.T) {
+	snapshotID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/snapshots/data"
+	c := &config{
+		Location:         "westeurope",
+		DataDiskSourceID: snapshotID,
+	}
+
+	disk := getDataDiskSpec("uid", c)
+	if disk.CreationData.CreateOption != compute.DiskCreateOptionCopy {
+		t.Errorf("expected create option %s, got %s", compute.DiskCreateOptionCopy, disk.CreationData.CreateOption)
+	}
+	if disk.CreationData.SourceResourceID == nil || *disk.CreationData.SourceResourceID != snapshotID {
+		t.Errorf("expected source resource ID %q, got %v", snapshotID, disk.CreationData.SourceResourceID)
+	}
+	if disk.DiskSizeGB != nil {
+		t.Errorf("expected the size to be inherited from the source, got %d", *disk.DiskSizeGB)
+	}
+}
+
+func TestGetDataDiskSpecPerformance(t *testing.T) {
+	ultraSSD := compute.StorageAccountTypesUltraSSDLRS
+	premiumV2 := storageAccountTypesPremiumV2LRS
+
+	tests := []struct {
+		name               string
+		sku                *compute.StorageAccountTypes
+		iops               *int64
+		throughput         *int64
+		expectedSKU        compute.DiskStorageAccountTypes
+		expectedIOPS       *int64
+		expectedThroughput *int64
+	}{
+		{
+			name:               "Ultra disk with IOPS and throughput",
+			sku:                &ultraSSD,
+			iops:               to.Int64Ptr(5000),
+			throughput:         to.Int64Ptr(200),
+			expectedSKU:        compute.DiskStorageAccountTypesUltraSSDLRS,
+			expectedIOPS:       to.Int64Ptr(5000),
+			expectedThroughput: to.Int64Ptr(200),
+		},
+		{
+			name:         "Premium v2 disk with IOPS only",
+			sku:          &premiumV2,
+			iops:         to.Int64Ptr(3000),
+			expectedSKU:  compute.DiskStorageAccountTypes(storageAccountTypesPremiumV2LRS),
+			expectedIOPS: to.Int64Ptr(3000),
+		},
+		{
+			name:        "disk without performance settings",
+			sku:         &ultraSSD,
+			expectedSKU: compute.DiskStorageAccountTypesUltraSSDLRS,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{
+				Location:           "westeurope",
+				DataDiskSize:       64,
+				DataDiskSKU:        test.sku,
+				DataDiskIOPS:       test.iops,
+				DataDiskThroughput: test.throughput,
+			}
+			disk := getDataDiskSpec("uid", c)
+
+			if disk.Sku == nil || disk.Sku.Name != test.expectedSKU {
+				t.Errorf("expected disk SKU %s, got %v", test.expectedSKU, disk.Sku)
+			}
+			if disk.DiskSizeGB == nil || *disk.DiskSizeGB != 64 {
+				t.Errorf("expected disk size 64, got %v", disk.DiskSizeGB)
+			}
+			if !reflect.DeepEqual(disk.DiskIOPSReadWrite, test.expectedIOPS) {
+				t.Errorf("expected IOPS %v, got %v", to.Int64(test.expectedIOPS), to.Int64(disk.DiskIOPSReadWrite))
+			}
+			if !reflect.DeepEqual(disk.DiskMBpsReadWrite, test.expectedThroughput) {
+				t.Errorf("expected throughput %v, got %v", to.Int64(test.expectedThroughput), to.Int64(disk.DiskMBpsReadWrite))
+			}
+			if disk.CreationData.CreateOption != compute.DiskCreateOptionEmpty {
+				t.Errorf("expected create option %s, got %s", compute.DiskCreateOptionEmpty, disk.CreationData.CreateOption)
+			}
+		})
+	}
+}
+
+func TestValidateDataDiskPerformance(t *testing.T) {
+	ultraSSD := compute.StorageAccountTypesUltraSSDLRS
+	premiumV2 := storageAccountTypesPremiumV2LRS
+	premium := compute.StorageAccountTypesPremiumLRS
+
+	tests := []struct {
+		name          string
+		config        *config
+		expectedError bool
+	}{
+		{
+			name:   "no performance settings",
+			config: &config{DataDiskSKU: &premium},
+		},
+		{
+			name:   "Ultra disk with IOPS and throughput",
+			config: &config{DataDiskSize: 64, DataDiskSKU: &ultraSSD, DataDiskIOPS: to.Int64Ptr(5000), DataDiskThroughput: to.Int64Ptr(200)},
+		},
+		{
+			name:   "Premium v2 disk with throughput",
+			config: &config{DataDiskSize: 64, DataDiskSKU: &premiumV2, DataDiskThroughput: to.Int64Ptr(125)},
+		},
+		{
+			name:          "SKU without performance settings",
+			config:        &config{DataDiskSize: 64, DataDiskSKU: &premium, DataDiskIOPS: to.Int64Ptr(5000)},
+			expectedError: true,
+		},
+		{
+			name:          "missing SKU",
+			config:        &config{DataDiskSize: 64, DataDiskIOPS: to.Int64Ptr(5000)},
+			expectedError: true,
+		},
+		{
+			name:          "missing size",
+			config:        &config{DataDiskSKU: &ultraSSD, DataDiskIOPS: to.Int64Ptr(5000)},
+			expectedError: true,
+		},
+		{
+			name:          "zero IOPS",
+			config:        &config{DataDiskSize: 64, DataDiskSKU: &ultraSSD, DataDiskIOPS: to.Int64Ptr(0)},
+			expectedError: true,
+		},
+		{
+			name:          "negative throughput",
+			config:        &config{DataDiskSize: 64, DataDiskSKU: &premiumV2, DataDiskThroughput: to.Int64Ptr(-1)},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateDataDiskPerformance(test.config)
+			if (err != nil) != test.expectedError {
+				t.Errorf("expected error: %t, got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestValidateDataDiskSource(t *testing.T) {
+	snapshotID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/snapshots/data"
+	diskID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/data"
+	sku := compute.StorageAccountTypesPremiumLRS
+
+	tests := []struct {
+		name        string
+		config      *config
+		expectError bool
+	}{
+		{
+			name:   "snapshot defaults to copy",
+			config: &config{DataDiskSourceID: snapshotID},
+		},
+		{
+			name:   "snapshot copy with larger size",
+			config: &config{DataDiskSourceID: snapshotID, DataDiskCreateOption: compute.DiskCreateOptionCopy, DataDiskSize: 100},
+		},
+		{
+			name:        "snapshot can't be attached",
+			config:      &config{DataDiskSourceID: snapshotID, DataDiskCreateOption: compute.DiskCreateOptionAttach},
+			expectError: true,
+		},
+		{
+			name:        "disk requires a create option",
+			config:      &config{DataDiskSourceID: diskID},
+			expectError: true,
+		},
+		{
+			name:   "disk copy",
+			config: &config{DataDiskSourceID: diskID, DataDiskCreateOption: compute.DiskCreateOptionCopy},
+		},
+		{
+			name:   "disk attach",
+			config: &config{DataDiskSourceID: diskID, DataDiskCreateOption: compute.DiskCreateOptionAttach},
+		},
+		{
+			name:        "disk attach with data disk settings",
+			config:      &config{DataDiskSourceID: diskID, DataDiskCreateOption: compute.DiskCreateOptionAttach, DataDiskSKU: &sku},
+			expectError: true,
+		},
+		{
+			name:        "unsupported resource type",
+			config:      &config{DataDiskSourceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/data"},
+			expectError: true,
+		},
+		{
+			name:        "invalid resource ID",
+			config:      &config{DataDiskSourceID: "data"},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := validateDataDiskSource(test.config)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+var update = flag.Bool("update", false, "update testdata files")
+
+func TestCloudConfig(t *testing.T) {
+	c := &config{
+		TenantID:          "tenant",
+		SubscriptionID:    "subscription",
+		ClientID:          "client",
+		ClientSecret:      "secret",
+		ResourceGroup:     "rg",
+		VNetResourceGroup: "network-rg",
+		Location:          "westeurope",
+		VNetName:          "vnet",
+		SubnetName:        "nodes",
+		LoadBalancerSku:   "standard",
+		RouteTableName:    "routes",
+		SecurityGroupName: "nodes-nsg",
+		AvailabilitySet:   "nodes-set",
+	}
+
+	tests := []struct {
+		name     string
+		config   *config
+		external bool
+	}{
+		{
+			name:   "cloud-config-in-tree",
+			config: c,
+		},
+		{
+			name:     "cloud-config-external",
+			config:   c,
+			external: true,
+		},
+		{
+			name: "cloud-config-external-vmss",
+			config: &config{
+				TenantID:               "tenant",
+				SubscriptionID:         "subscription",
+				ClientID:               "client",
+				ClientSecret:           "secret",
+				ResourceGroup:          "rg",
+				Location:               "westeurope",
+				VirtualMachineScaleSet: "nodes-vmss",
+			},
+			external: true,
+		},
+		{
+			name: "cloud-config-subnet-id",
+			config: &config{
+				TenantID:       "tenant",
+				SubscriptionID: "subscription",
+				ClientID:       "client",
+				ClientSecret:   "secret",
+				ResourceGroup:  "rg",
+				Location:       "westeurope",
+				SubnetID:       "/subscriptions/subscription/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub-vnet/subnets/nodes",
+			},
+			external: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := azuretypes.CloudConfigToString(cloudConfig(test.config, test.external))
+			if err != nil {
+				t.Fatal(err)
+			}
+			testhelper.CompareOutput(t, test.name+".golden", s, *update)
+		})
+	}
+}
+
+func TestValidateDiskPerformanceTier(t *testing.T) {
+	premium := compute.StorageAccountTypesPremiumLRS
+	standard := compute.StorageAccountTypesStandardSSDLRS
+
+	tests := []struct {
+		name        string
+		sku         *compute.StorageAccountTypes
+		tier        *string
+		expectError bool
+	}{
+		{
+			name: "no tier",
+			sku:  &standard,
+		},
+		{
+			name: "valid tier",
+			sku:  &premium,
+			tier: to.StringPtr("P50"),
+		},
+		{
+			name:        "invalid tier",
+			sku:         &premium,
+			tier:        to.StringPtr("P5"),
+			expectError: true,
+		},
+		{
+			name:        "unsupported SKU",
+			sku:         &standard,
+			tier:        to.StringPtr("P50"),
+			expectError: true,
+		},
+		{
+			name:        "missing SKU",
+			tier:        to.StringPtr("P50"),
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateDiskPerformanceTier(test.sku, test.tier)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got: %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestDualStackNICAddresses(t *testing.T) {
+	netIf := network.Interface{
+		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+			IPConfigurations: &[]network.InterfaceIPConfiguration{
+				{
+					Name: to.StringPtr("ip-config-ipv6"),
+					InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+						PrivateIPAddress:        to.StringPtr("fd00::4"),
+						PrivateIPAddressVersion: network.IPVersionIPv6,
+					},
+				},
+				{
+					Name: to.StringPtr("ip-config"),
+					InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+						PrivateIPAddress:        to.StringPtr("10.0.0.4"),
+						PrivateIPAddressVersion: network.IPVersionIPv4,
+					},
+				},
+				{
+					Name: to.StringPtr("ip-config-pending"),
+					InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+						PrivateIPAddress:        to.StringPtr(""),
+						PrivateIPAddressVersion: network.IPVersionIPv6,
+					},
+				},
+				{
+					Name: to.StringPtr("ip-config-no-properties"),
+				},
+			},
+		},
+	}
+
+	internalIPs := privateIPAddresses(netIf)
+	if strings.Join(internalIPs, ",") != "10.0.0.4,fd00::4" {
+		t.Fatalf("expected internal addresses [10.0.0.4 fd00::4], got %v", internalIPs)
+	}
+
+	addresses := nodeAddresses(internalIPs, []string{"20.0.0.1"})
+	expected := map[string]v1.NodeAddressType{
+		"10.0.0.4": v1.NodeInternalIP,
+		"fd00::4":  v1.NodeInternalIP,
+		"20.0.0.1": v1.NodeExternalIP,
+	}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected addresses %v, got %v", expected, addresses)
+	}
+}
+
+func TestGetCachedInstance(t *testing.T) {
+	tests := []struct {
+		name            string
+		ttl             time.Duration
+		expectedLookups int
+	}{
+		{
+			name:            "lookups within the TTL are cached",
+			ttl:             time.Minute,
+			expectedLookups: 1,
+		},
+		{
+			name:            "caching is disabled with a zero TTL",
+			ttl:             0,
+			expectedLookups: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uid := types.UID("cache-test-uid")
+			defer cache.Delete(instanceCacheKey(uid))
+
+			lookups := 0
+			lookup := func() (*cachedInstance, error) {
+				lookups++
+				return &cachedInstance{status: instance.StatusRunning}, nil
+			}
+
+			c := &config{InstanceCacheTTL: test.ttl}
+			for i := 0; i < 3; i++ {
+				cached, err := getCachedInstance(c, uid, lookup)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if cached.status != instance.StatusRunning {
+					t.Errorf("expected status %q, got %q", instance.StatusRunning, cached.status)
+				}
+			}
+
+			if lookups != test.expectedLookups {
+				t.Errorf("expected %d lookups, got %d", test.expectedLookups, lookups)
+			}
+		})
+	}
+}
+
+func TestGetCachedInstanceInvalidation(t *testing.T) {
+	uid := types.UID("cache-invalidation-uid")
+	defer cache.Delete(instanceCacheKey(uid))
+
+	lookups := 0
+	lookup := func() (*cachedInstance, error) {
+		lookups++
+		return &cachedInstance{}, nil
+	}
+
+	c := &config{InstanceCacheTTL: time.Minute}
+	if _, err := getCachedInstance(c, uid, lookup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cache.Delete(instanceCacheKey(uid))
+	if _, err := getCachedInstance(c, uid, lookup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if lookups != 2 {
+		t.Errorf("expected the invalidated entry to be looked up again, got %d lookups", lookups)
+	}
+}
+
+func TestComputerName(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        *config
+		osType        compute.OperatingSystemTypes
+		expectedName  string
+		expectedError bool
+	}{
+		{
+			name:         "machine name is used by default",
+			config:       &config{},
+			osType:       compute.OperatingSystemTypesLinux,
+			expectedName: "machine-1",
+		},
+		{
+			name:         "explicit computer name",
+			config:       &config{ComputerName: "node.example.com"},
+			osType:       compute.OperatingSystemTypesLinux,
+			expectedName: "node.example.com",
+		},
+		{
+			name:         "computer name prefix",
+			config:       &config{ComputerNamePrefix: "prod-"},
+			osType:       compute.OperatingSystemTypesLinux,
+			expectedName: "prod-machine-1",
+		},
+		{
+			name:          "computer name too long for Windows",
+			config:        &config{ComputerNamePrefix: "production-"},
+			osType:        compute.OperatingSystemTypesWindows,
+			expectedName:  "production-machine-1",
+			expectedError: true,
+		},
+		{
+			name:          "computer name too long for Linux",
+			config:        &config{ComputerName: strings.Repeat("a", 65)},
+			osType:        compute.OperatingSystemTypesLinux,
+			expectedName:  strings.Repeat("a", 65),
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := computerName(test.config, "machine-1")
+			if name != test.expectedName {
+				t.Errorf("expected computer name %q, got %q", test.expectedName, name)
+			}
+
+			err := validateComputerName(name, test.osType)
+			if (err != nil) != test.expectedError {
+				t.Errorf("expected error: %t, got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestSpotEvictionCooldown(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		config      *config
+		minCooldown time.Duration
+		maxCooldown time.Duration
+	}{
+		{
+			name:   "recreation without cooldown",
+			config: &config{Spot: true},
+		},
+		{
+			name:        "fixed cooldown",
+			config:      &config{Spot: true, EvictionCooldown: 5 * time.Minute},
+			minCooldown: 5 * time.Minute,
+			maxCooldown: 5 * time.Minute,
+		},
+		{
+			name:        "cooldown with jitter",
+			config:      &config{Spot: true, EvictionCooldown: 5 * time.Minute, EvictionCooldownJitter: time.Minute},
+			minCooldown: 5 * time.Minute,
+			maxCooldown: 6 * time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uid := types.UID("spot-eviction-uid")
+			defer cache.Delete(spotEvictionCacheKey(uid))
+
+			eviction := recordSpotEviction(test.config, uid, now)
+			cooldown := eviction.cooldownUntil.Sub(now)
+			if cooldown < test.minCooldown || cooldown > test.maxCooldown {
+				t.Errorf("expected cooldown between %v and %v, got %v", test.minCooldown, test.maxCooldown, cooldown)
+			}
+
+			// Subsequent detections of the same eviction must not restart the cooldown
+			if again := recordSpotEviction(test.config, uid, now.Add(time.Minute)); again != eviction {
+				t.Errorf("expected the cooldown to end at %v, got %v", eviction.cooldownUntil, again.cooldownUntil)
+			}
+		})
+	}
+}
+
+func TestVMProvisioningFailed(t *testing.T) {
+	tests := []struct {
+		name     string
+		vm       compute.VirtualMachine
+		expected bool
+	}{
+		{
+			name: "without properties",
+		},
+		{
+			name: "succeeded",
+			vm: compute.VirtualMachine{
+				VirtualMachineProperties: &compute.VirtualMachineProperties{ProvisioningState: to.StringPtr("Succeeded")},
+			},
+		},
+		{
+			name: "creating",
+			vm: compute.VirtualMachine{
+				VirtualMachineProperties: &compute.VirtualMachineProperties{ProvisioningState: to.StringPtr("Creating")},
+			},
+		},
+		{
+			name: "failed",
+			vm: compute.VirtualMachine{
+				VirtualMachineProperties: &compute.VirtualMachineProperties{ProvisioningState: to.StringPtr("Failed")},
+			},
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if failed := vmProvisioningFailed(&test.vm); failed != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, failed)
+			}
+		})
+	}
+}
+
+func TestProvisioningFailureIsTerminal(t *testing.T) {
+	tests := []struct {
+		name     string
+		joined   bool
+		status   instance.Status
+		expected bool
+	}{
+		{
+			name:     "node didn't join",
+			status:   instance.StatusUnknown,
+			expected: true,
+		},
+		{
+			name:     "node joined and VM is running",
+			joined:   true,
+			status:   instance.StatusRunning,
+			expected: false,
+		},
+		{
+			name:     "node joined and VM is stopped",
+			joined:   true,
+			status:   instance.StatusStopped,
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if terminal := provisioningFailureIsTerminal(test.joined, test.status); terminal != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, terminal)
+			}
+		})
+	}
+}
+
+func TestCreateIfNotExists(t *testing.T) {
+	existingVM := &azureVM{vm: &compute.VirtualMachine{Name: to.StringPtr("node-1")}, status: instance.StatusRunning}
+	createdVM := &azureVM{vm: &compute.VirtualMachine{Name: to.StringPtr("node-1")}, status: instance.StatusCreating}
+	errGet := errors.New("failed to list VMs")
+	errAdopt := errors.New("failed to install extension")
+
+	tests := []struct {
+		name            string
+		existing        *azureVM
+		getErr          error
+		adoptErr        error
+		expected        *azureVM
+		expectError     bool
+		expectedCreates int
+		expectedAdopts  int
+	}{
+		{
+			name:           "VM already exists",
+			existing:       existingVM,
+			expected:       existingVM,
+			expectedAdopts: 1,
+		},
+		{
+			name:           "VM already exists and its setup fails",
+			existing:       existingVM,
+			adoptErr:       errAdopt,
+			expectError:    true,
+			expectedAdopts: 1,
+		},
+		{
+			name:            "VM doesn't exist",
+			getErr:          cloudprovidererrors.ErrInstanceNotFound,
+			expected:        createdVM,
+			expectedCreates: 1,
+		},
+		{
+			name:        "VM lookup fails",
+			getErr:      errGet,
+			expectError: true,
+		},
+		{
+			name:        "existing VM failed",
+			getErr:      fmt.Errorf("%w: VM %q is in provisioning state %q", cloudprovidererrors.ErrInstanceFailed, "node-1", provisioningStateFailed),
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creates, adopts := 0, 0
+			vm, err := createIfNotExists(
+				func() (*azureVM, error) { return test.existing, test.getErr },
+				func() (*azureVM, error) {
+					creates++
+					return createdVM, nil
+				},
+				func(vm *azureVM) error {
+					adopts++
+					if vm != test.existing {
+						t.Errorf("expected the existing VM to be adopted, got %+v", vm)
+					}
+					return test.adoptErr
+				},
+			)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if vm != test.expected {
+				t.Errorf("expected VM %+v, got %+v", test.expected, vm)
+			}
+			if creates != test.expectedCreates {
+				t.Errorf("expected %d creations, got %d", test.expectedCreates, creates)
+			}
+			if adopts != test.expectedAdopts {
+				t.Errorf("expected %d adoptions, got %d", test.expectedAdopts, adopts)
+			}
+		})
+	}
+}
+
+func TestGetVMStatusPowerState(t *testing.T) {
+	tests := []struct {
+		name     string
+		spot     bool
+		code     string
+		expected instance.Status
+	}{
+		{
+			name:     "deallocated spot VM is evicted",
+			spot:     true,
+			code:     "PowerState/deallocated",
+			expected: instance.StatusEvicted,
+		},
+		{
+			name:     "deallocating spot VM is evicted",
+			spot:     true,
+			code:     "PowerState/deallocating",
+			expected: instance.StatusEvicted,
+		},
+		{
+			name:     "deallocated regular VM is stopped",
+			code:     "PowerState/deallocated",
+			expected: instance.StatusStopped,
+		},
+		{
+			name:     "stopped regular VM",
+			code:     "PowerState/stopped",
+			expected: instance.StatusStopped,
+		},
+		{
+			name:     "stopped spot VM",
+			spot:     true,
+			code:     "PowerState/stopped",
+			expected: instance.StatusStopped,
+		},
+		{
+			name:     "deallocating regular VM is stopped",
+			code:     "PowerState/deallocating",
+			expected: instance.StatusStopped,
+		},
+		{
+			name:     "stopping regular VM",
+			code:     "PowerState/stopping",
+			expected: instance.StatusStopped,
+		},
+		{
+			name:     "stopping spot VM",
+			spot:     true,
+			code:     "PowerState/stopping",
+			expected: instance.StatusStopped,
+		},
+		{
+			name:     "unknown power state",
+			code:     "PowerState/unknown",
+			expected: instance.StatusUnknown,
+		},
+		{
+			name:     "running spot VM",
+			spot:     true,
+			code:     "PowerState/running",
+			expected: instance.StatusRunning,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if status := powerStateStatus(&config{Spot: test.spot}, test.code); status != test.expected {
+				t.Errorf("expected status %q, got %q", test.expected, status)
+			}
+		})
+	}
+}
+
+func TestValidateVMSize(t *testing.T) {
+	skus := []compute.ResourceSku{
+		{Name: to.StringPtr("Standard_F2")},
+		{Name: to.StringPtr("Standard_D2s_v3")},
+		{
+			Name: to.StringPtr("Standard_NC6"),
+			Restrictions: &[]compute.ResourceSkuRestrictions{{
+				Type:            compute.ResourceSkuRestrictionsTypeLocation,
+				RestrictionInfo: &compute.ResourceSkuRestrictionInfo{Locations: &[]string{"westeurope"}},
+			}},
+		},
+		{
+			Name: to.StringPtr("Standard_M8ms"),
+			Restrictions: &[]compute.ResourceSkuRestrictions{{
+				Type:            compute.ResourceSkuRestrictionsTypeZone,
+				RestrictionInfo: &compute.ResourceSkuRestrictionInfo{Zones: &[]string{"2"}},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		vmSize        string
+		zones         []string
+		expectedError string
+	}{
+		{
+			name:   "available VM size",
+			vmSize: "Standard_F2",
+		},
+		{
+			name:          "VM size not offered in location",
+			vmSize:        "Standard_A0",
+			expectedError: `VM size "Standard_A0" is not available in location "westeurope", valid sizes are e.g. Standard_D2s_v3, Standard_F2, Standard_M8ms`,
+		},
+		{
+			name:          "VM size restricted in location",
+			vmSize:        "Standard_NC6",
+			expectedError: `VM size "Standard_NC6" is not available in location "westeurope", valid sizes are e.g. Standard_D2s_v3, Standard_F2, Standard_M8ms`,
+		},
+		{
+			name:   "VM size available in zone",
+			vmSize: "Standard_M8ms",
+			zones:  []string{"1"},
+		},
+		{
+			name:          "VM size restricted in zone",
+			vmSize:        "Standard_M8ms",
+			zones:         []string{"2"},
+			expectedError: `VM size "Standard_M8ms" is not available in location "westeurope", valid sizes are e.g. Standard_D2s_v3, Standard_F2`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateVMSize(skus, test.vmSize, "westeurope", test.zones)
+			if test.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var terminalErr cloudprovidererrors.TerminalError
+			if !errors.As(err, &terminalErr) {
+				t.Fatalf("expected a TerminalError, got %v", err)
+			}
+			if terminalErr.Message != test.expectedError {
+				t.Errorf("expected error %q, got %q", test.expectedError, terminalErr.Message)
+			}
+		})
+	}
+}
+
+func TestGetOSProfileAllowExtensionOperations(t *testing.T) {
+	tests := []struct {
+		name                     string
+		allowExtensionOperations *bool
+	}{
+		{
+			name: "Azure default",
+		},
+		{
+			name:                     "extension operations disabled",
+			allowExtensionOperations: to.BoolPtr(false),
+		},
+		{
+			name:                     "extension operations enabled",
+			allowExtensionOperations: to.BoolPtr(true),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{AllowExtensionOperations: test.allowExtensionOperations}
+			osProfile := getOSProfile(c, "machine-1", "ubuntu", []string{"ssh-rsa AAAA"}, "#cloud-config")
+
+			if !reflect.DeepEqual(osProfile.AllowExtensionOperations, test.allowExtensionOperations) {
+				t.Errorf("expected allowExtensionOperations %v, got %v", test.allowExtensionOperations, osProfile.AllowExtensionOperations)
+			}
+		})
+	}
+}
+
+func TestGetOSProfileSSHPublicKeys(t *testing.T) {
+	publicKeys := []string{"ssh-rsa AAAA", "ssh-ed25519 BBBB"}
+	osProfile := getOSProfile(&config{}, "machine-1", "ubuntu", publicKeys, "#cloud-config")
+
+	sshPublicKeys := *osProfile.LinuxConfiguration.SSH.PublicKeys
+	if len(sshPublicKeys) != len(publicKeys) {
+		t.Fatalf("expected %d SSH public keys, got %d", len(publicKeys), len(sshPublicKeys))
+	}
+	for i, key := range sshPublicKeys {
+		if *key.KeyData != publicKeys[i] {
+			t.Errorf("expected SSH public key %q, got %q", publicKeys[i], *key.KeyData)
+		}
+		if *key.Path != "/home/ubuntu/.ssh/authorized_keys" {
+			t.Errorf("expected SSH public key to be added to the authorized_keys of the admin user, got path %q", *key.Path)
+		}
+	}
+}
+
+func TestAdminUsername(t *testing.T) {
+	tests := []struct {
+		name             string
+		config           *config
+		os               providerconfigtypes.OperatingSystem
+		expectedUsername string
+		expectInvalidErr bool
+	}{
+		{
+			name:             "derived from the operating system",
+			config:           &config{},
+			os:               providerconfigtypes.OperatingSystemUbuntu,
+			expectedUsername: "ubuntu",
+		},
+		{
+			name:             "derived from flatcar",
+			config:           &config{},
+			os:               providerconfigtypes.OperatingSystemFlatcar,
+			expectedUsername: "core",
+		},
+		{
+			name:             "configured",
+			config:           &config{AdminUsername: "cloud-user"},
+			os:               providerconfigtypes.OperatingSystemRHEL,
+			expectedUsername: "cloud-user",
+		},
+		{
+			name:             "uppercase letters",
+			config:           &config{AdminUsername: "CloudUser"},
+			expectInvalidErr: true,
+		},
+		{
+			name:             "leading digit",
+			config:           &config{AdminUsername: "1user"},
+			expectInvalidErr: true,
+		},
+		{
+			name:             "too long",
+			config:           &config{AdminUsername: strings.Repeat("a", maxAdminUsernameLength+1)},
+			expectInvalidErr: true,
+		},
+		{
+			name:             "reserved by Azure",
+			config:           &config{AdminUsername: "admin"},
+			expectInvalidErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.config.AdminUsername != "" {
+				err := validateAdminUsername(test.config.AdminUsername)
+				if (err != nil) != test.expectInvalidErr {
+					t.Fatalf("expected error: %t, got %v", test.expectInvalidErr, err)
+				}
+				if err != nil {
+					return
+				}
+			}
+
+			username := adminUsername(test.config, test.os)
+			if username != test.expectedUsername {
+				t.Fatalf("expected admin username %q, got %q", test.expectedUsername, username)
+			}
+
+			osProfile := getOSProfile(test.config, "machine-1", username, []string{"ssh-ed25519 AAAA"}, "")
+			expectedPath := "/home/" + test.expectedUsername + "/.ssh/authorized_keys"
+			if path := *(*osProfile.LinuxConfiguration.SSH.PublicKeys)[0].Path; path != expectedPath {
+				t.Errorf("expected SSH public key path %q, got %q", expectedPath, path)
+			}
+		})
+	}
+}
+
+func TestCleanupMachineResourcesAfterFailedCreate(t *testing.T) {
+	const machineUID = types.UID("machine-uid")
+
+	// Create failed after the public IP and the network interface were created, so the VM and its disks don't exist
+	machine := &clusterv1alpha1.Machine{}
+	machine.Name = "machine"
+	machine.UID = machineUID
+	machine.Finalizers = []string{finalizerPublicIP, finalizerNIC}
+
+	c := &config{Location: "westeurope", AssignPublicIP: true}
+	interfaces := []network.Interface{
+		getNetworkInterfaceSpec("machine-netiface", machineUID, c, network.Subnet{}, &network.PublicIPAddress{}, nil, util.IPv4),
+		getNetworkInterfaceSpec("other-machine-netiface", "other-machine-uid", c, network.Subnet{}, &network.PublicIPAddress{}, nil, util.IPv4),
+	}
+	publicIPs := []network.PublicIPAddress{
+		{Name: to.StringPtr("machine-ip"), Tags: map[string]*string{machineUIDTag: to.StringPtr(string(machineUID))}},
+	}
+
+	resources := []machineResource{
+		{
+			name:      "disks",
+			finalizer: finalizerDisks,
+			delete: func(_ context.Context, _ *config, _ types.UID) error {
+				t.Error("disks must not be deleted, they were never created")
+				return nil
+			},
+		},
+		{
+			name:      "network interfaces",
+			finalizer: finalizerNIC,
+			delete: func(_ context.Context, _ *config, uid types.UID) error {
+				var remaining []network.Interface
+				for _, iface := range interfaces {
+					if !hasMachineUIDTag(iface.Tags, uid) {
+						remaining = append(remaining, iface)
+					}
+				}
+				interfaces = remaining
+				return nil
+			},
+		},
+		{
+			name:      "public IP addresses",
+			finalizer: finalizerPublicIP,
+			delete: func(_ context.Context, _ *config, uid types.UID) error {
+				var remaining []network.PublicIPAddress
+				for _, ip := range publicIPs {
+					if !hasMachineUIDTag(ip.Tags, uid) {
+						remaining = append(remaining, ip)
+					}
+				}
+				publicIPs = remaining
+				return nil
+			},
+		},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	data := &cloudprovidertypes.ProviderData{
+		Update: func(m *clusterv1alpha1.Machine, modifiers ...cloudprovidertypes.MachineModifier) error {
+			for _, modify := range modifiers {
+				modify(m)
+			}
+			return nil
+		},
+		Recorder: recorder,
+	}
+
+	if err := cleanupMachineResources(context.Background(), c, machine, data, resources); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	expectedEvents := []string{
+		`Normal DeletingResources Deleting network interfaces of VM "machine"`,
+		`Normal DeletingResources Deleting public IP addresses of VM "machine"`,
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("expected events %v, got %v", expectedEvents, events)
+	}
+
+	if len(interfaces) != 1 || *interfaces[0].Name != "other-machine-netiface" {
+		t.Errorf("expected only the network interface of the other machine to remain, got %v", interfaces)
+	}
+	if len(publicIPs) != 0 {
+		t.Errorf("expected the public IP to be deleted, got %v", publicIPs)
+	}
+	if len(machine.Finalizers) != 0 {
+		t.Errorf("expected all finalizers to be removed, got %v", machine.Finalizers)
+	}
+}
+
+// fakeAzureAPI is a minimal in-memory Azure Resource Manager API. Created resources are returned as provisioned
+// and can be read back, updates are merged into their properties and the VMs are running.
+type fakeAzureAPI struct {
+	server    *httptest.Server
+	resources map[string]map[string]interface{}
+}
+
+func newFakeAzureAPI(t *testing.T) *fakeAzureAPI {
+	api := &fakeAzureAPI{resources: map[string]map[string]interface{}{}}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serveHTTP))
+	t.Cleanup(api.server.Close)
+
+	// send the requests of all clients to the fake API
+	newAuthorizerOrig := newAuthorizer
+	newAuthorizer = func(*config) (autorest.Authorizer, error) { return api, nil }
+	t.Cleanup(func() { newAuthorizer = newAuthorizerOrig })
+
+	return api
+}
+
+// WithAuthorization redirects the requests to the fake API.
+func (f *fakeAzureAPI) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err == nil {
+				r.URL.Scheme = "http"
+				r.URL.Host = f.server.Listener.Addr().String()
+			}
+			return r, err
+		})
+	}
+}
+
+func (f *fakeAzureAPI) add(id string, resource map[string]interface{}) {
+	resource["id"] = id
+	f.resources[strings.ToLower(id)] = resource
+}
+
+func (f *fakeAzureAPI) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Path
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodPut:
+		resource := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		properties, _ := resource["properties"].(map[string]interface{})
+		if properties == nil {
+			properties = map[string]interface{}{}
+		}
+		properties["provisioningState"] = "Succeeded"
+		resource["properties"] = properties
+		resource["name"] = path.Base(id)
+		f.add(id, resource)
+		_ = json.NewEncoder(w).Encode(resource)
+	case r.Method == http.MethodPatch && f.resources[strings.ToLower(id)] != nil:
+		update := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resource := f.resources[strings.ToLower(id)]
+		properties, _ := resource["properties"].(map[string]interface{})
+		if properties == nil {
+			properties = map[string]interface{}{}
+		}
+		updatedProperties, _ := update["properties"].(map[string]interface{})
+		for key, value := range updatedProperties {
+			properties[key] = value
+		}
+		resource["properties"] = properties
+		_ = json.NewEncoder(w).Encode(resource)
+	case r.Method == http.MethodGet && strings.HasSuffix(id, "/instanceView"):
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"statuses": []map[string]string{{"code": "ProvisioningState/succeeded"}, {"code": "PowerState/running"}},
+		})
+	case r.Method == http.MethodGet && f.resources[strings.ToLower(id)] != nil:
+		_ = json.NewEncoder(w).Encode(f.resources[strings.ToLower(id)])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]string{"code": "NotFound", "message": fmt.Sprintf("%s %s not found", r.Method, id)},
+		})
+	}
+}
+
+func TestCreateEvents(t *testing.T) {
+	api := newFakeAzureAPI(t)
+	subnetID := "/subscriptions/sub/resourceGroups/network-rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/subnet"
+	api.add(subnetID, map[string]interface{}{"name": "subnet", "properties": map[string]interface{}{}})
+
+	c := &config{
+		SubscriptionID:       "sub",
+		ResourceGroup:        "rg",
+		NetworkResourceGroup: "network-rg",
+		Location:             "westeurope",
+		VMSize:               "Standard_D2s_v3",
+		SubnetID:             subnetID,
+	}
+	machine := &clusterv1alpha1.Machine{}
+	machine.Name = "machine"
+	machine.Namespace = "kube-system"
+	machine.UID = "machine-uid"
+	providerCfg := &providerconfigtypes.Config{OperatingSystem: providerconfigtypes.OperatingSystemUbuntu}
+
+	recorder := record.NewFakeRecorder(10)
+	data := &cloudprovidertypes.ProviderData{
+		Update: func(m *clusterv1alpha1.Machine, modifiers ...cloudprovidertypes.MachineModifier) error {
+			for _, modify := range modifiers {
+				modify(m)
+			}
+			return nil
+		},
+		Recorder: recorder,
+	}
+
+	vm, err := (&provider{}).create(context.Background(), machine, data, "userdata", c, providerCfg)
+	if err != nil {
+		t.Fatalf("failed to create VM: %v", err)
+	}
+	if vm.Status() != instance.StatusRunning {
+		t.Errorf("expected the VM to be running, got %q", vm.Status())
+	}
+
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	expectedEvents := []string{
+		"Warning NoEgress " + noEgressMessage(network.Subnet{Name: to.StringPtr("subnet")}),
+		`Normal CreatingNetworkInterface Creating network interface "machine-netiface"`,
+		`Normal CreatingVM Creating VM "machine"`,
+		`Normal WaitingForVM Waiting for VM "machine" to be provisioned`,
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("expected events %v, got %v", expectedEvents, events)
+	}
+}
+
+func TestFinishVMSetup(t *testing.T) {
+	api := newFakeAzureAPI(t)
+	diskID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/disks/machine-os-disk"
+	api.add(diskID, map[string]interface{}{"name": "machine-os-disk", "properties": map[string]interface{}{"tier": "P10"}})
+
+	c := &config{
+		SubscriptionID:        "sub",
+		ResourceGroup:         "rg",
+		Location:              "westeurope",
+		OSDiskPerformanceTier: to.StringPtr("P30"),
+		Extensions:            []azuretypes.VMExtension{{Publisher: "Microsoft.Azure.Extensions", Type: "CustomScript", Version: "2.1"}},
+	}
+	vm := &compute.VirtualMachine{
+		Name: to.StringPtr("machine"),
+		VirtualMachineProperties: &compute.VirtualMachineProperties{
+			StorageProfile: &compute.StorageProfile{OsDisk: &compute.OSDisk{Name: to.StringPtr("machine-os-disk")}},
+		},
+	}
+
+	// An adopted VM already has the tier and the extensions, so the setup has to succeed twice
+	for i := 0; i < 2; i++ {
+		if err := finishVMSetup(context.Background(), c, vm); err != nil {
+			t.Fatalf("failed to finish the setup of the VM: %v", err)
+		}
+	}
+
+	if tier := api.resources[strings.ToLower(diskID)]["properties"].(map[string]interface{})["tier"]; tier != "P30" {
+		t.Errorf("expected the OS disk to have performance tier P30, got %v", tier)
+	}
+	extensionID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/machine/extensions/" + vmExtensionName(c.Extensions[0])
+	if api.resources[strings.ToLower(extensionID)] == nil {
+		t.Errorf("expected extension %s to be installed", extensionID)
+	}
+}
+
+// fakeVMRestarter records the restarted VMs and fails the restart with err.
+type fakeVMRestarter struct {
+	restarted []string
+	err       error
+}
+
+func (f *fakeVMRestarter) Restart(_ context.Context, resourceGroupName string, vmName string) (compute.VirtualMachinesRestartFuture, error) {
+	if f.err != nil {
+		return compute.VirtualMachinesRestartFuture{}, f.err
+	}
+	f.restarted = append(f.restarted, resourceGroupName+"/"+vmName)
+	return compute.VirtualMachinesRestartFuture{}, nil
+}
+
+func TestRestartVM(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            instance.Status
+		restartErr        error
+		expectedRestarted []string
+		expectedErr       error
+		expectError       bool
+		expectCached      bool
+	}{
+		{
+			name:              "running VM is restarted",
+			status:            instance.StatusRunning,
+			expectedRestarted: []string{"rg/machine"},
+		},
+		{
+			name:              "VM with unknown status is restarted",
+			status:            instance.StatusUnknown,
+			expectedRestarted: []string{"rg/machine"},
+		},
+		{
+			name:         "starting VM isn't restarted again",
+			status:       instance.StatusCreating,
+			expectCached: true,
+		},
+		{
+			name:         "deleting VM can't be restarted",
+			status:       instance.StatusDeleting,
+			expectError:  true,
+			expectCached: true,
+		},
+		{
+			name:         "stopped VM isn't restarted",
+			status:       instance.StatusStopped,
+			expectCached: true,
+		},
+		{
+			name:         "evicted VM can't be restarted",
+			status:       instance.StatusEvicted,
+			expectError:  true,
+			expectCached: true,
+		},
+		{
+			name:         "VM is gone",
+			status:       instance.StatusRunning,
+			restartErr:   autorest.DetailedError{StatusCode: http.StatusNotFound, Original: errors.New("not found")},
+			expectedErr:  cloudprovidererrors.ErrInstanceNotFound,
+			expectError:  true,
+			expectCached: true,
+		},
+		{
+			name:         "restart fails",
+			status:       instance.StatusRunning,
+			restartErr:   autorest.DetailedError{StatusCode: http.StatusConflict, Original: errors.New("conflict")},
+			expectError:  true,
+			expectCached: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uid := types.UID("restart-vm-" + strings.ReplaceAll(test.name, " ", "-"))
+			cache.SetDefault(instanceCacheKey(uid), &cachedInstance{status: instance.StatusRunning})
+			defer cache.Delete(instanceCacheKey(uid))
+
+			restarter := &fakeVMRestarter{err: test.restartErr}
+			err := restartVM(context.Background(), restarter, &config{ResourceGroup: "rg"}, uid, "machine", test.status)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if test.expectedErr != nil && !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected error %v, got %v", test.expectedErr, err)
+			}
+			if !reflect.DeepEqual(restarter.restarted, test.expectedRestarted) {
+				t.Errorf("expected restarted VMs %v, got %v", test.expectedRestarted, restarter.restarted)
+			}
+			if _, cached := cache.Get(instanceCacheKey(uid)); cached != test.expectCached {
+				t.Errorf("expected the instance to be cached: %t, got: %t", test.expectCached, cached)
+			}
+		})
+	}
+}
+
+func TestWaitUntilRunning(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []instance.Status
+		timeout       time.Duration
+		expectedCalls int
+		expectedError bool
+	}{
+		{
+			name:          "VM transitions to running",
+			statuses:      []instance.Status{"", instance.StatusCreating, instance.StatusCreating, instance.StatusRunning},
+			timeout:       time.Second,
+			expectedCalls: 4,
+		},
+		{
+			name:          "VM gets deleted while waiting",
+			statuses:      []instance.Status{instance.StatusCreating, instance.StatusDeleting},
+			timeout:       time.Second,
+			expectedCalls: 2,
+			expectedError: true,
+		},
+		{
+			name:          "VM gets stopped while waiting",
+			statuses:      []instance.Status{instance.StatusCreating, instance.StatusStopped},
+			timeout:       time.Second,
+			expectedCalls: 2,
+			expectedError: true,
+		},
+		{
+			name:          "VM never becomes ready",
+			statuses:      []instance.Status{instance.StatusCreating},
+			timeout:       50 * time.Millisecond,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			// the fake VM doesn't exist on the first call and then goes through the given statuses
+			getStatus := func(context.Context) (instance.Status, error) {
+				calls++
+				if calls == 1 && test.statuses[0] == "" {
+					return instance.StatusUnknown, cloudprovidererrors.ErrInstanceNotFound
+				}
+				if calls > len(test.statuses) {
+					return test.statuses[len(test.statuses)-1], nil
+				}
+				return test.statuses[calls-1], nil
+			}
+
+			err := waitUntilRunning(context.Background(), time.Millisecond, test.timeout, getStatus)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("expected error: %t, got: %v", test.expectedError, err)
+			}
+			if test.expectedCalls > 0 && calls != test.expectedCalls {
+				t.Errorf("expected %d status checks, got %d", test.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestGPUDriverExtension(t *testing.T) {
+	tests := []struct {
+		name          string
+		sku           compute.ResourceSku
+		expectedError bool
+	}{
+		{
+			name: "NVIDIA GPU size",
+			sku: compute.ResourceSku{
+				Name:         to.StringPtr("Standard_NC6s_v3"),
+				Family:       to.StringPtr("standardNCSv3Family"),
+				Capabilities: &[]compute.ResourceSkuCapabilities{{Name: to.StringPtr(CapabilityGPUs), Value: to.StringPtr("1")}},
+			},
+		},
+		{
+			name: "AMD GPU size",
+			sku: compute.ResourceSku{
+				Name:         to.StringPtr("Standard_NV4as_v4"),
+				Family:       to.StringPtr("standardNVSv4Family"),
+				Capabilities: &[]compute.ResourceSkuCapabilities{{Name: to.StringPtr(CapabilityGPUs), Value: to.StringPtr("1")}},
+			},
+			expectedError: true,
+		},
+		{
+			name: "size without GPUs",
+			sku: compute.ResourceSku{
+				Name:         to.StringPtr("Standard_D2s_v3"),
+				Family:       to.StringPtr("standardDSv3Family"),
+				Capabilities: &[]compute.ResourceSkuCapabilities{{Name: to.StringPtr(CapabilityPremiumIO), Value: to.StringPtr(CapabilityValueTrue)}},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			extension, err := gpuDriverExtension(test.sku, "westeurope")
+			if (err != nil) != test.expectedError {
+				t.Fatalf("expected error: %t, got: %v", test.expectedError, err)
+			}
+			if test.expectedError {
+				return
+			}
+
+			properties := extension.VirtualMachineExtensionProperties
+			if *properties.Type != gpuDriverExtensionName || *properties.Publisher != gpuDriverExtensionPublisher {
+				t.Errorf("expected extension %s/%s, got %s/%s", gpuDriverExtensionPublisher, gpuDriverExtensionName, *properties.Publisher, *properties.Type)
+			}
+		})
+	}
+}
+
+func TestVMExtensions(t *testing.T) {
+	customScript := azuretypes.VMExtension{
+		Publisher:         "Microsoft.Azure.Extensions",
+		Type:              "CustomScript",
+		Version:           "2.1",
+		Settings:          map[string]interface{}{"commandToExecute": "echo hello"},
+		ProtectedSettings: map[string]interface{}{"storageAccountKey": "secret"},
+	}
+
+	extension := getVMExtension(customScript, "westeurope")
+	properties := extension.VirtualMachineExtensionProperties
+	if *properties.Publisher != customScript.Publisher || *properties.Type != customScript.Type || *properties.TypeHandlerVersion != customScript.Version {
+		t.Errorf("expected extension %s/%s %s, got %s/%s %s", customScript.Publisher, customScript.Type, customScript.Version,
+			*properties.Publisher, *properties.Type, *properties.TypeHandlerVersion)
+	}
+	if !reflect.DeepEqual(properties.ProtectedSettings, customScript.ProtectedSettings) {
+		t.Errorf("expected protected settings to be set, got %v", properties.ProtectedSettings)
+	}
+	if properties := getVMExtension(azuretypes.VMExtension{Type: "OmsAgentForLinux"}, "westeurope").VirtualMachineExtensionProperties; properties.Settings != nil || properties.ProtectedSettings != nil {
+		t.Errorf("expected no settings to be sent, got %v", properties.Settings)
+	}
+
+	tests := []struct {
+		name          string
+		config        *config
+		expectedError bool
+	}{
+		{
+			name:   "extension",
+			config: &config{Extensions: []azuretypes.VMExtension{customScript}},
+		},
+		{
+			name:          "extension operations disabled",
+			config:        &config{Extensions: []azuretypes.VMExtension{customScript}, AllowExtensionOperations: to.BoolPtr(false)},
+			expectedError: true,
+		},
+		{
+			name:          "missing version",
+			config:        &config{Extensions: []azuretypes.VMExtension{{Publisher: "Microsoft.Azure.Extensions", Type: "CustomScript"}}},
+			expectedError: true,
+		},
+		{
+			name:          "duplicate name",
+			config:        &config{Extensions: []azuretypes.VMExtension{customScript, customScript}},
+			expectedError: true,
+		},
+		{
+			name: "same type with different names",
+			config: &config{Extensions: []azuretypes.VMExtension{
+				customScript,
+				{Name: "second-script", Publisher: customScript.Publisher, Type: customScript.Type, Version: customScript.Version},
+			}},
+		},
+		{
+			name: "conflict with GPU driver",
+			config: &config{InstallGPUDriver: true, Extensions: []azuretypes.VMExtension{
+				{Publisher: gpuDriverExtensionPublisher, Type: gpuDriverExtensionName, Version: gpuDriverExtensionVersion},
+			}},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateVMExtensions(test.config); (err != nil) != test.expectedError {
+				t.Errorf("expected error: %t, got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestRemoveSecurityGroupAssociations(t *testing.T) {
+	c := &config{Location: "westeurope"}
+	asgs := []network.ApplicationSecurityGroup{{ID: to.StringPtr("asg-id")}}
+
+	iface := getNetworkInterfaceSpec("machine-netiface", "machine-uid", c, network.Subnet{}, nil, nil, util.DualStack)
+	if removeSecurityGroupAssociations(&iface) {
+		t.Error("expected a NIC without security groups to be unchanged")
+	}
+
+	iface.NetworkSecurityGroup = &network.SecurityGroup{ID: to.StringPtr("nsg-id")}
+	setApplicationSecurityGroups(&iface, asgs)
+	for _, ipConfig := range *iface.IPConfigurations {
+		if !reflect.DeepEqual(*ipConfig.ApplicationSecurityGroups, asgs) {
+			t.Errorf("expected IP configuration %s to join the application security groups, got %v", *ipConfig.Name, ipConfig.ApplicationSecurityGroups)
+		}
+	}
+
+	if !removeSecurityGroupAssociations(&iface) {
+		t.Fatal("expected the security groups to be removed")
+	}
+	if iface.NetworkSecurityGroup != nil {
+		t.Errorf("expected the network security group to be removed, got %v", iface.NetworkSecurityGroup)
+	}
+	for _, ipConfig := range *iface.IPConfigurations {
+		if ipConfig.ApplicationSecurityGroups != nil {
+			t.Errorf("expected the application security groups of IP configuration %s to be removed", *ipConfig.Name)
+		}
+	}
+	if len(*iface.IPConfigurations) != 2 {
+		t.Errorf("expected the IP configurations to be kept, got %d", len(*iface.IPConfigurations))
+	}
+}
+
+func TestSupportedOperatingSystems(t *testing.T) {
+	expected := []providerconfigtypes.OperatingSystem{
+		providerconfigtypes.OperatingSystemUbuntu,
+		providerconfigtypes.OperatingSystemCentOS,
+		providerconfigtypes.OperatingSystemRHEL,
+		providerconfigtypes.OperatingSystemFlatcar,
+		providerconfigtypes.OperatingSystemRockyLinux,
+	}
+
+	p := &provider{}
+	if supported := p.SupportedOperatingSystems(); !reflect.DeepEqual(supported, expected) {
+		t.Errorf("expected supported operating systems %v, got %v", expected, supported)
+	}
+}
+
+func TestGetOSPlan(t *testing.T) {
+	rhel9 := &compute.ImageReference{
+		Publisher: to.StringPtr("RedHat"),
+		Offer:     to.StringPtr("rhel-byos"),
+		Sku:       to.StringPtr("rhel-lvm90"),
+		Version:   to.StringPtr("latest"),
+	}
+
+	tests := []struct {
+		name         string
+		config       *config
+		os           providerconfigtypes.OperatingSystem
+		expectedPlan *compute.Plan
+	}{
+		{
+			name:         "default RHEL 8 plan",
+			config:       &config{},
+			os:           providerconfigtypes.OperatingSystemRHEL,
+			expectedPlan: osPlans[providerconfigtypes.OperatingSystemRHEL],
+		},
+		{
+			name:   "plan derived from RHEL 9 image reference",
+			config: &config{ImageReference: rhel9},
+			os:     providerconfigtypes.OperatingSystemRHEL,
+			expectedPlan: &compute.Plan{
+				Name:      to.StringPtr("rhel-lvm90"),
+				Publisher: to.StringPtr("redhat"),
+				Product:   to.StringPtr("rhel-byos"),
+			},
+		},
+		{
+			name:   "explicit image plan",
+			config: &config{ImageReference: rhel9, ImagePlan: &compute.Plan{Name: to.StringPtr("custom")}},
+			os:     providerconfigtypes.OperatingSystemRHEL,
+			expectedPlan: &compute.Plan{
+				Name: to.StringPtr("custom"),
+			},
+		},
+		{
+			name:   "no plan for image reference of Ubuntu",
+			config: &config{ImageReference: &compute.ImageReference{Sku: to.StringPtr("22_04-lts")}},
+			os:     providerconfigtypes.OperatingSystemUbuntu,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if plan := getOSPlan(test.config, test.os); !reflect.DeepEqual(plan, test.expectedPlan) {
+				t.Errorf("expected plan %+v, got %+v", test.expectedPlan, plan)
+			}
+		})
+	}
+}
+
+func TestLimitTags(t *testing.T) {
+	controllerTags := map[string]string{machineUIDTag: "machine-uid"}
+	userTags := map[string]string{}
+	for i := 0; i < 45; i++ {
+		userTags[fmt.Sprintf("user-%02d", i)] = "value"
+	}
+	derivedTags := map[string]string{
+		"derived-a": "a",
+		"derived-b": "b",
+		"derived-c": "c",
+		"derived-d": "d",
+		"derived-e": "e",
+		"derived-f": "f",
+		// doesn't override the controller-managed tag
+		machineUIDTag: "other-uid",
+	}
+
+	tags, dropped := limitTags(maxVMTags, controllerTags, userTags, derivedTags)
+	if len(tags) != maxVMTags {
+		t.Fatalf("expected %d tags, got %d", maxVMTags, len(tags))
+	}
+	if *tags[machineUIDTag] != "machine-uid" {
+		t.Errorf("expected the machine UID tag to be kept, got %q", *tags[machineUIDTag])
+	}
+	for key := range userTags {
+		if _, ok := tags[key]; !ok {
+			t.Errorf("expected user tag %q to be kept", key)
+		}
+	}
+
+	expectedDropped := []string{"derived-e", "derived-f"}
+	if !reflect.DeepEqual(dropped, expectedDropped) {
+		t.Errorf("expected dropped tags %v, got %v", expectedDropped, dropped)
+	}
+
+	machine := &clusterv1alpha1.Machine{}
+	setDroppedTagsAnnotation(machine, dropped)
+	if annotation := machine.Annotations[droppedTagsAnnotationKey]; annotation != "derived-e,derived-f" {
+		t.Errorf("expected dropped tags annotation %q, got %q", "derived-e,derived-f", annotation)
+	}
+
+	setDroppedTagsAnnotation(machine, nil)
+	if _, ok := machine.Annotations[droppedTagsAnnotationKey]; ok {
+		t.Error("expected stale dropped tags annotation to be removed")
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	tooMany := map[string]string{}
+	for i := 0; i < maxVMTags; i++ {
+		tooMany[fmt.Sprintf("tag-%02d", i)] = "value"
+	}
+	maxTags := map[string]string{}
+	for i := 0; i < maxVMTags-1; i++ {
+		maxTags[fmt.Sprintf("tag-%02d", i)] = "value"
+	}
+
+	tests := []struct {
+		name         string
+		tags         map[string]string
+		reservedKeys []string
+		errContains  string
+	}{
+		{
+			name: "valid tags",
+			tags: map[string]string{"team": "platform", "cost-center": "1234"},
+		},
+		{
+			name: "maximum number of tags",
+			tags: maxTags,
+		},
+		{
+			name:        "too many tags",
+			tags:        tooMany,
+			errContains: "at most 49 tags",
+		},
+		{
+			name:         "too many tags with grouping tags",
+			tags:         maxTags,
+			reservedKeys: []string{machineUIDTag, defaultMachineDeploymentTagKey, defaultClusterTagKey},
+			errContains:  "at most 47 tags",
+		},
+		{
+			name: "maximum key and value length",
+			tags: map[string]string{strings.Repeat("k", maxTagKeyLength): strings.Repeat("v", maxTagValueLength)},
+		},
+		{
+			name:        "key too long",
+			tags:        map[string]string{strings.Repeat("k", maxTagKeyLength+1): "value"},
+			errContains: "exceeds 512 characters",
+		},
+		{
+			name:        "value too long",
+			tags:        map[string]string{"team": strings.Repeat("v", maxTagValueLength+1)},
+			errContains: `value of tag "team" exceeds 256 characters`,
+		},
+		{
+			name:        "invalid character in key",
+			tags:        map[string]string{"team/owner": "platform"},
+			errContains: `key of tag "team/owner" must not contain`,
+		},
+		{
+			name:        "reserved key",
+			tags:        map[string]string{"machine-uid": "uid"},
+			errContains: `tag "machine-uid" is reserved`,
+		},
+		{
+			name:        "empty key",
+			tags:        map[string]string{"": "value"},
+			errContains: "must not be empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reservedKeys := test.reservedKeys
+			if reservedKeys == nil {
+				reservedKeys = []string{machineUIDTag}
+			}
+			err := validateTags(test.tags, reservedKeys)
+			if test.errContains == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			isTerminal, reason, message := cloudprovidererrors.IsTerminalError(err)
+			if !isTerminal || reason != common.InvalidConfigurationMachineError {
+				t.Fatalf("expected a terminal InvalidConfiguration error, got %v", err)
+			}
+			if !strings.Contains(message, test.errContains) {
+				t.Errorf("expected error to contain %q, got %q", test.errContains, message)
+			}
+		})
+	}
+}
+
+func TestValidateResourceGroupLocation(t *testing.T) {
+	tests := []struct {
+		name          string
+		location      string
+		groupLocation string
+		expectError   bool
+	}{
+		{
+			name:          "matching location",
+			location:      "westeurope",
+			groupLocation: "westeurope",
+		},
+		{
+			name:          "matching display name",
+			location:      "West Europe",
+			groupLocation: "westeurope",
+		},
+		{
+			name:          "mismatching location",
+			location:      "eastus",
+			groupLocation: "westeurope",
+			expectError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			group := resources.Group{Name: to.StringPtr("my-rg"), Location: to.StringPtr(test.groupLocation)}
+			err := validateResourceGroupLocation(test.location, group)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestValidateResourceGroup(t *testing.T) {
+	api := newFakeAzureAPI(t)
+	api.add("/subscriptions/sub/resourcegroups/rg", map[string]interface{}{"name": "rg", "location": "westeurope"})
+
+	tests := []struct {
+		name          string
+		resourceGroup string
+		location      string
+		expectError   bool
+	}{
+		{
+			name:          "matching location",
+			resourceGroup: "rg",
+			location:      "westeurope",
+		},
+		{
+			name:          "mismatching location",
+			resourceGroup: "rg",
+			location:      "eastus",
+			expectError:   true,
+		},
+		{
+			name:          "resource group not found",
+			resourceGroup: "missing",
+			location:      "westeurope",
+			expectError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{SubscriptionID: "sub", ResourceGroup: test.resourceGroup, Location: test.location}
+			err := validateResourceGroup(context.Background(), c)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestPlatformFaultDomain(t *testing.T) {
+	flexibleScaleSet := func(faultDomainCount int32) compute.VirtualMachineScaleSet {
+		return compute.VirtualMachineScaleSet{
+			VirtualMachineScaleSetProperties: &compute.VirtualMachineScaleSetProperties{
+				OrchestrationMode:        compute.OrchestrationModeFlexible,
+				PlatformFaultDomainCount: to.Int32Ptr(faultDomainCount),
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		faultDomain *int32
+		scaleSet    compute.VirtualMachineScaleSet
+		expectError bool
+	}{
+		{
+			name:        "fault domain within the fault domain count",
+			faultDomain: to.Int32Ptr(2),
+			scaleSet:    flexibleScaleSet(3),
+		},
+		{
+			name:     "no fault domain",
+			scaleSet: flexibleScaleSet(1),
+		},
+		{
+			name:        "fault domain exceeds the fault domain count",
+			faultDomain: to.Int32Ptr(3),
+			scaleSet:    flexibleScaleSet(3),
+			expectError: true,
+		},
+		{
+			name:        "scale set spreads across fault domains on its own",
+			faultDomain: to.Int32Ptr(0),
+			scaleSet:    flexibleScaleSet(1),
+			expectError: true,
+		},
+		{
+			name:        "uniform orchestration",
+			faultDomain: to.Int32Ptr(0),
+			scaleSet: compute.VirtualMachineScaleSet{
+				VirtualMachineScaleSetProperties: &compute.VirtualMachineScaleSetProperties{
+					OrchestrationMode:        compute.OrchestrationModeUniform,
+					PlatformFaultDomainCount: to.Int32Ptr(3),
+				},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{
+				SubscriptionID:         "sub",
+				ResourceGroup:          "rg",
+				VirtualMachineScaleSet: "vmss",
+				PlatformFaultDomain:    test.faultDomain,
+			}
+
+			err := validatePlatformFaultDomain(c, test.scaleSet)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got %v", test.expectError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			expectedID := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/vmss"
+			props := &compute.VirtualMachineProperties{}
+			setVirtualMachineScaleSet(props, expectedID, c.PlatformFaultDomain)
+			if props.VirtualMachineScaleSet == nil || *props.VirtualMachineScaleSet.ID != expectedID {
+				t.Errorf("expected scale set %q, got %v", expectedID, props.VirtualMachineScaleSet)
+			}
+			if !reflect.DeepEqual(props.PlatformFaultDomain, test.faultDomain) {
+				t.Errorf("expected fault domain %v, got %v", test.faultDomain, props.PlatformFaultDomain)
+			}
+		})
+	}
+}
+
+func TestSecurityProfile(t *testing.T) {
+	gen2SKU := compute.ResourceSku{
+		Name:         to.StringPtr("Standard_D2s_v5"),
+		Capabilities: &[]compute.ResourceSkuCapabilities{{Name: to.StringPtr(CapabilityHyperVGenerations), Value: to.StringPtr("V1,V2")}},
+	}
+	gen1SKU := compute.ResourceSku{
+		Name:         to.StringPtr("Standard_A2_v2"),
+		Capabilities: &[]compute.ResourceSkuCapabilities{{Name: to.StringPtr(CapabilityHyperVGenerations), Value: to.StringPtr("V1")}},
+	}
+	if !skuSupportsHyperVGeneration(gen2SKU, compute.HyperVGenerationTypesV2) {
+		t.Error("expected the gen2 VM size to support gen2 images")
+	}
+	if skuSupportsHyperVGeneration(gen1SKU, compute.HyperVGenerationTypesV2) {
+		t.Error("expected the gen1 VM size to not support gen2 images")
+	}
+
+	vTPMOnly := &compute.UefiSettings{SecureBootEnabled: to.BoolPtr(false), VTpmEnabled: to.BoolPtr(true)}
+
+	tests := []struct {
+		name             string
+		config           *config
+		expectedProfile  *compute.SecurityProfile
+		expectInvalidErr bool
+	}{
+		{
+			name:   "no security settings",
+			config: &config{},
+		},
+		{
+			name:   "standard security type",
+			config: &config{SecurityType: securityTypesStandard},
+		},
+		{
+			name:            "standalone UEFI settings",
+			config:          &config{UefiSettings: vTPMOnly},
+			expectedProfile: &compute.SecurityProfile{SecurityType: compute.SecurityTypesTrustedLaunch, UefiSettings: vTPMOnly},
+		},
+		{
+			name:            "trusted launch without UEFI settings",
+			config:          &config{SecurityType: compute.SecurityTypesTrustedLaunch},
+			expectedProfile: &compute.SecurityProfile{SecurityType: compute.SecurityTypesTrustedLaunch},
+		},
+		{
+			name:             "UEFI settings with standard security type",
+			config:           &config{SecurityType: securityTypesStandard, UefiSettings: vTPMOnly},
+			expectInvalidErr: true,
+		},
+		{
+			name:             "confidential VM without vTPM",
+			config:           &config{SecurityType: compute.SecurityTypesConfidentialVM, UefiSettings: &compute.UefiSettings{VTpmEnabled: to.BoolPtr(false)}},
+			expectInvalidErr: true,
+		},
+		{
+			name:             "unknown security type",
+			config:           &config{SecurityType: "Unknown"},
+			expectInvalidErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateSecurityProfile(test.config)
+			if (err != nil) != test.expectInvalidErr {
+				t.Fatalf("expected error: %t, got %v", test.expectInvalidErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if profile := getSecurityProfile(test.config); !reflect.DeepEqual(profile, test.expectedProfile) {
+				t.Errorf("expected security profile %+v, got %+v", test.expectedProfile, profile)
+			}
+		})
+	}
+}
+
+func TestDedicatedHost(t *testing.T) {
+	const (
+		hostGroupID = "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups/group"
+		hostID      = hostGroupID + "/hosts/host-1"
+	)
+
+	tests := []struct {
+		name             string
+		config           *config
+		expectedHost     *compute.SubResource
+		expectedGroup    *compute.SubResource
+		expectInvalidErr bool
+	}{
+		{
+			name:   "no dedicated host",
+			config: &config{Spot: true, AvailabilitySet: "set", Zones: []string{"1", "2"}},
+		},
+		{
+			name:          "host group",
+			config:        &config{HostGroupID: hostGroupID, Zones: []string{"1"}},
+			expectedGroup: &compute.SubResource{ID: to.StringPtr(hostGroupID)},
+		},
+		{
+			name:         "host",
+			config:       &config{HostID: hostID},
+			expectedHost: &compute.SubResource{ID: to.StringPtr(hostID)},
+		},
+		{
+			name:         "unassigned availability set",
+			config:       &config{HostID: hostID, AvailabilitySet: "set", AssignAvailabilitySet: to.BoolPtr(false)},
+			expectedHost: &compute.SubResource{ID: to.StringPtr(hostID)},
+		},
+		{
+			name:             "host and host group",
+			config:           &config{HostGroupID: hostGroupID, HostID: hostID},
+			expectInvalidErr: true,
+		},
+		{
+			name:             "invalid host ID",
+			config:           &config{HostID: hostGroupID},
+			expectInvalidErr: true,
+		},
+		{
+			name:             "spot",
+			config:           &config{HostGroupID: hostGroupID, Spot: true},
+			expectInvalidErr: true,
+		},
+		{
+			name:             "availability set",
+			config:           &config{HostGroupID: hostGroupID, AvailabilitySet: "set"},
+			expectInvalidErr: true,
+		},
+		{
+			name:             "scale set",
+			config:           &config{HostID: hostID, VirtualMachineScaleSet: "vmss"},
+			expectInvalidErr: true,
+		},
+		{
+			name:             "multiple zones",
+			config:           &config{HostGroupID: hostGroupID, Zones: []string{"1", "2"}},
+			expectInvalidErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateDedicatedHostConfig(test.config)
+			if (err != nil) != test.expectInvalidErr {
+				t.Fatalf("expected error: %t, got %v", test.expectInvalidErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			properties := &compute.VirtualMachineProperties{}
+			setDedicatedHost(properties, test.config)
+			if !reflect.DeepEqual(properties.Host, test.expectedHost) {
+				t.Errorf("expected host %+v, got %+v", test.expectedHost, properties.Host)
+			}
+			if !reflect.DeepEqual(properties.HostGroup, test.expectedGroup) {
+				t.Errorf("expected host group %+v, got %+v", test.expectedGroup, properties.HostGroup)
+			}
+		})
+	}
+}
+
+func TestValidateDedicatedHostGroup(t *testing.T) {
+	zonalGroup := compute.DedicatedHostGroup{
+		Name:                         to.StringPtr("zonal"),
+		Zones:                        &[]string{"2"},
+		DedicatedHostGroupProperties: &compute.DedicatedHostGroupProperties{SupportAutomaticPlacement: to.BoolPtr(true)},
+	}
+	regionalGroup := compute.DedicatedHostGroup{
+		Name:                         to.StringPtr("regional"),
+		DedicatedHostGroupProperties: &compute.DedicatedHostGroupProperties{},
+	}
+
+	tests := []struct {
+		name               string
+		group              compute.DedicatedHostGroup
+		zones              []string
+		automaticPlacement bool
+		expectErr          bool
+	}{
+		{
+			name:               "matching zone",
+			group:              zonalGroup,
+			zones:              []string{"2"},
+			automaticPlacement: true,
+		},
+		{
+			name:      "other zone",
+			group:     zonalGroup,
+			zones:     []string{"1"},
+			expectErr: true,
+		},
+		{
+			name:      "zonal group without zone",
+			group:     zonalGroup,
+			expectErr: true,
+		},
+		{
+			name:  "regional group with zone",
+			group: regionalGroup,
+			zones: []string{"1"},
+		},
+		{
+			name:               "automatic placement not supported",
+			group:              regionalGroup,
+			automaticPlacement: true,
+			expectErr:          true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateDedicatedHostGroup(test.group, test.zones, test.automaticPlacement)
+			if (err != nil) != test.expectErr {
+				t.Errorf("expected error: %t, got %v", test.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateDedicatedHostSKU(t *testing.T) {
+	host := compute.DedicatedHost{
+		Name: to.StringPtr("host-1"),
+		Sku:  &compute.Sku{Name: to.StringPtr("DSv3-Type1")},
+	}
+
+	tests := []struct {
+		name      string
+		host      compute.DedicatedHost
+		vmSKU     compute.ResourceSku
+		expectErr bool
+	}{
+		{
+			name:  "matching series",
+			host:  host,
+			vmSKU: compute.ResourceSku{Name: to.StringPtr("Standard_D4s_v3"), Family: to.StringPtr("standardDSv3Family")},
+		},
+		{
+			name:      "other series",
+			host:      host,
+			vmSKU:     compute.ResourceSku{Name: to.StringPtr("Standard_E4s_v3"), Family: to.StringPtr("standardESv3Family")},
+			expectErr: true,
+		},
+		{
+			name:      "other version of the series",
+			host:      host,
+			vmSKU:     compute.ResourceSku{Name: to.StringPtr("Standard_D4s_v4"), Family: to.StringPtr("standardDSv4Family")},
+			expectErr: true,
+		},
+		{
+			name:  "host without SKU",
+			host:  compute.DedicatedHost{Name: to.StringPtr("host-1")},
+			vmSKU: compute.ResourceSku{Name: to.StringPtr("Standard_E4s_v3"), Family: to.StringPtr("standardESv3Family")},
+		},
+		{
+			name:  "VM size without family",
+			host:  host,
+			vmSKU: compute.ResourceSku{Name: to.StringPtr("Standard_E4s_v3")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateDedicatedHostSKU(test.host, test.vmSKU)
+			if (err != nil) != test.expectErr {
+				t.Errorf("expected error: %t, got %v", test.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestAPITimeout(t *testing.T) {
+	machine := &clusterv1alpha1.Machine{}
+	machine.UID = "machine-uid"
+	machine.Finalizers = []string{finalizerNIC}
+
+	c := &config{APITimeout: 10 * time.Millisecond}
+	resources := []machineResource{
+		{
+			name:      "network interfaces",
+			finalizer: finalizerNIC,
+			// the stalled Azure API only responds once the request is cancelled
+			delete: func(ctx context.Context, _ *config, _ types.UID) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+	}
+	data := &cloudprovidertypes.ProviderData{
+		Update: func(*clusterv1alpha1.Machine, ...cloudprovidertypes.MachineModifier) error {
+			t.Error("finalizer must not be removed if the deletion timed out")
+			return nil
+		},
+	}
+
+	ctx, cancel := apiContext(c)
+	defer cancel()
+
+	err := apiTimeoutError(ctx, c, cleanupMachineResources(ctx, c, machine, data, resources))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+	if _, ok := err.(cloudprovidererrors.TerminalError); ok {
+		t.Errorf("expected timeouts to not be terminal, got %v", err)
+	}
+
+	if err := apiTimeoutError(context.Background(), c, cloudprovidererrors.ErrInstanceNotFound); err != cloudprovidererrors.ErrInstanceNotFound {
+		t.Errorf("expected errors before the timeout to be returned unchanged, got %v", err)
+	}
+}
+
+// slowFuture is a long running operation which only completes after its duration.
+type slowFuture struct {
+	duration     time.Duration
+	pollingDelay time.Duration
+}
+
+func (f *slowFuture) WaitForCompletionRef(ctx context.Context, client autorest.Client) error {
+	f.pollingDelay = client.PollingDelay
+	select {
+	case <-time.After(f.duration):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestWaitForOperation(t *testing.T) {
+	c := &config{VMPollInterval: 5 * time.Second}
+
+	future := &slowFuture{duration: time.Minute}
+	err := waitForOperation(context.Background(), future, autorest.NewClientWithUserAgent(""), c.VMPollInterval, 10*time.Millisecond, "creation of VM")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+	if _, ok := err.(cloudprovidererrors.TerminalError); ok {
+		t.Errorf("expected timeouts to not be terminal, got %v", err)
+	}
+	if future.pollingDelay != c.VMPollInterval {
+		t.Errorf("expected the operation to be polled every %s, got %s", c.VMPollInterval, future.pollingDelay)
+	}
+
+	future = &slowFuture{duration: time.Millisecond}
+	if err := waitForOperation(context.Background(), future, autorest.NewClientWithUserAgent(""), 0, time.Minute, "creation of VM"); err != nil {
+		t.Errorf("expected the operation to complete, got %v", err)
+	}
+	if future.pollingDelay != autorest.DefaultPollingDelay {
+		t.Errorf("expected the default polling delay of the SDK, got %s", future.pollingDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	future = &slowFuture{duration: time.Minute}
+	if err := waitForOperation(ctx, future, autorest.NewClientWithUserAgent(""), 0, 0, "creation of VM"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline of the context to apply without a timeout, got %v", err)
+	}
+
+	// a stuck operation only uses up its own timeout, not the one of the following operations
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := waitForOperation(ctx, &slowFuture{duration: time.Minute}, autorest.NewClientWithUserAgent(""), 0, 10*time.Millisecond, "creation of interface"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the stuck operation to time out, got %v", err)
+	}
+	if err := waitForOperation(ctx, &slowFuture{duration: time.Millisecond}, autorest.NewClientWithUserAgent(""), 0, 10*time.Millisecond, "creation of VM extension"); err != nil {
+		t.Errorf("expected the following operation to complete, got %v", err)
+	}
+}
+
+func TestMachineMetricsLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *config
+		expected map[string]string
+	}{
+		{
+			name:   "regular VM",
+			config: &config{VMSize: "Standard_D2s_v3", Location: "westeurope"},
+			expected: map[string]string{
+				"size":            "Standard_D2s_v3",
+				"location":        "westeurope",
+				"zone":            "none",
+				"availabilitySet": "none",
+				"priority":        "Regular",
+			},
+		},
+		{
+			name:   "spot VM in a zone",
+			config: &config{VMSize: "Standard_D2s_v3", Location: "westeurope", Zones: []string{"2"}, Spot: true},
+			expected: map[string]string{
+				"size":            "Standard_D2s_v3",
+				"location":        "westeurope",
+				"zone":            "2",
+				"availabilitySet": "none",
+				"priority":        "Spot",
+			},
+		},
+		{
+			name:   "VM in an availability set",
+			config: &config{VMSize: "Standard_D2s_v3", Location: "westeurope", AvailabilitySet: "workers"},
+			expected: map[string]string{
+				"size":            "Standard_D2s_v3",
+				"location":        "westeurope",
+				"zone":            "none",
+				"availabilitySet": "workers",
+				"priority":        "Regular",
+			},
+		},
+		{
+			name:   "unassigned availability set",
+			config: &config{VMSize: "Standard_D2s_v3", Location: "westeurope", AvailabilitySet: "workers", AssignAvailabilitySet: to.BoolPtr(false)},
+			expected: map[string]string{
+				"size":            "Standard_D2s_v3",
+				"location":        "westeurope",
+				"zone":            "none",
+				"availabilitySet": "none",
+				"priority":        "Regular",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if labels := machineMetricsLabels(test.config); !reflect.DeepEqual(labels, test.expected) {
+				t.Errorf("expected labels %v, got %v", test.expected, labels)
+			}
+		})
+	}
+}
+
+func TestHostName(t *testing.T) {
+	tests := []struct {
+		name     string
+		vm       *compute.VirtualMachine
+		expected string
+	}{
+		{
+			name:     "computer name",
+			vm:       &compute.VirtualMachine{VirtualMachineProperties: &compute.VirtualMachineProperties{OsProfile: &compute.OSProfile{ComputerName: to.StringPtr("node-1")}}},
+			expected: "node-1",
+		},
+		{
+			name: "no OS profile",
+			vm:   &compute.VirtualMachine{VirtualMachineProperties: &compute.VirtualMachineProperties{}},
+		},
+		{
+			name: "no properties",
+			vm:   &compute.VirtualMachine{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := &azureVM{vm: test.vm}
+			if hostName := vm.HostName(); hostName != test.expected {
+				t.Errorf("expected hostname %q, got %q", test.expected, hostName)
+			}
+		})
+	}
+}
+
+func TestValidateLicenseType(t *testing.T) {
+	tests := []struct {
+		name        string
+		licenseType string
+		os          providerconfigtypes.OperatingSystem
+		expectError bool
+	}{
+		{
+			name: "pay-as-you-go",
+			os:   providerconfigtypes.OperatingSystemUbuntu,
+		},
+		{
+			name:        "RHEL subscription",
+			licenseType: "RHEL_BYOS",
+			os:          providerconfigtypes.OperatingSystemRHEL,
+		},
+		{
+			name:        "SLES subscription",
+			licenseType: "SLES_BYOS",
+			os:          providerconfigtypes.OperatingSystemSLES,
+		},
+		{
+			name:        "RHEL subscription on another distribution",
+			licenseType: "RHEL_BYOS",
+			os:          providerconfigtypes.OperatingSystemRockyLinux,
+			expectError: true,
+		},
+		{
+			name:        "Windows license on a Linux image",
+			licenseType: "Windows_Server",
+			os:          providerconfigtypes.OperatingSystemUbuntu,
+			expectError: true,
+		},
+		{
+			name:        "unknown license type",
+			licenseType: "Ubuntu_Pro",
+			os:          providerconfigtypes.OperatingSystemUbuntu,
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateLicenseType(test.licenseType, test.os)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got: %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestGroupingTags(t *testing.T) {
+	tests := []struct {
+		name                    string
+		machineDeploymentTagKey *string
+		clusterTagKey           *string
+		tags                    map[string]string
+		machineDeploymentName   string
+		expected                map[string]*string
+		expectError             bool
+	}{
+		{
+			name:                  "default keys",
+			machineDeploymentName: "workers",
+			expected: map[string]*string{
+				machineUIDTag:       to.StringPtr("machine-1"),
+				"MachineDeployment": to.StringPtr("workers"),
+				"Cluster":           to.StringPtr("kube-system"),
+			},
+		},
+		{
+			name: "machine without machine deployment",
+			expected: map[string]*string{
+				machineUIDTag: to.StringPtr("machine-1"),
+				"Cluster":     to.StringPtr("kube-system"),
+			},
+		},
+		{
+			name:                    "configured keys",
+			machineDeploymentTagKey: to.StringPtr("node-pool"),
+			clusterTagKey:           to.StringPtr(""),
+			machineDeploymentName:   "workers",
+			expected: map[string]*string{
+				machineUIDTag: to.StringPtr("machine-1"),
+				"node-pool":   to.StringPtr("workers"),
+			},
+		},
+		{
+			name:                  "user-provided tag replaces default key",
+			tags:                  map[string]string{"cluster": "production"},
+			machineDeploymentName: "workers",
+			expected: map[string]*string{
+				machineUIDTag:       to.StringPtr("machine-1"),
+				"MachineDeployment": to.StringPtr("workers"),
+			},
+		},
+		{
+			name:          "configured key collides with user-provided tag",
+			clusterTagKey: to.StringPtr("Environment"),
+			tags:          map[string]string{"environment": "production"},
+			expectError:   true,
+		},
+		{
+			name:          "configured key collides with machine UID tag",
+			clusterTagKey: to.StringPtr(machineUIDTag),
+			expectError:   true,
+		},
+		{
+			name:                    "configured keys collide",
+			machineDeploymentTagKey: to.StringPtr("Group"),
+			clusterTagKey:           to.StringPtr("Group"),
+			expectError:             true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &config{
+				Tags:                    test.tags,
+				MachineDeploymentTagKey: groupingTagKey(test.machineDeploymentTagKey, defaultMachineDeploymentTagKey, test.tags),
+				ClusterTagKey:           groupingTagKey(test.clusterTagKey, defaultClusterTagKey, test.tags),
+			}
+
+			err := validateGroupingTagKeys(c)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			machine := &clusterv1alpha1.Machine{}
+			machine.Namespace = "kube-system"
+			c.GroupingTags = groupingTags(c, machine, test.machineDeploymentName)
+
+			if tags := resourceTags("machine-1", c); !reflect.DeepEqual(tags, test.expected) {
+				t.Errorf("expected tags %v, got %v", test.expected, tags)
+			}
+			if grouping := existingGroupingTags(c, test.expected); !reflect.DeepEqual(grouping, c.GroupingTags) {
+				t.Errorf("expected existing grouping tags %v, got %v", c.GroupingTags, grouping)
+			}
+		})
+	}
+}
+
+func TestGetMachineDeploymentName(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clusterv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add scheme: %v", err)
+	}
+
+	machineSet := &clusterv1alpha1.MachineSet{}
+	machineSet.Name = "workers-abc"
+	machineSet.Namespace = "kube-system"
+	machineSet.OwnerReferences = []metav1.OwnerReference{{Kind: "MachineDeployment", Name: "workers"}}
+	standaloneMachineSet := &clusterv1alpha1.MachineSet{}
+	standaloneMachineSet.Name = "standalone"
+	standaloneMachineSet.Namespace = "kube-system"
+	client := fakectrlruntimeclient.NewClientBuilder().WithScheme(scheme).WithObjects(machineSet, standaloneMachineSet).Build()
+
+	tests := []struct {
+		name        string
+		machineSet  string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:       "machine of a machine deployment",
+			machineSet: "workers-abc",
+			expected:   "workers",
+		},
+		{
+			name:       "machine of a machine set without machine deployment",
+			machineSet: "standalone",
+		},
+		{
+			name: "machine without machine set",
+		},
+		{
+			name:        "machine set not found",
+			machineSet:  "missing",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			machine := &clusterv1alpha1.Machine{}
+			machine.Name = "machine"
+			machine.Namespace = "kube-system"
+			if test.machineSet != "" {
+				machine.OwnerReferences = []metav1.OwnerReference{{Kind: "MachineSet", Name: test.machineSet}}
+			}
+
+			name, err := getMachineDeploymentName(context.Background(), machine, client)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if name != test.expected {
+				t.Errorf("expected machine deployment %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
+
+func TestAssignDataDiskLUNs(t *testing.T) {
+	allLUNs := make([]int32, 0, maxDataDiskLUN+1)
+	for lun := int32(0); lun <= maxDataDiskLUN; lun++ {
+		allLUNs = append(allLUNs, lun)
+	}
+
+	tests := []struct {
+		name         string
+		reserved     []int32
+		count        int
+		expectedLUNs []int32
+		expectError  bool
+	}{
+		{
+			name:         "no reserved LUNs",
+			count:        2,
+			expectedLUNs: []int32{0, 1},
+		},
+		{
+			name:         "reserved LUNs are skipped",
+			reserved:     []int32{0, 2},
+			count:        2,
+			expectedLUNs: []int32{1, 3},
+		},
+		{
+			name:        "duplicate reserved LUN",
+			reserved:    []int32{1, 1},
+			count:       1,
+			expectError: true,
+		},
+		{
+			name:        "reserved LUN out of range",
+			reserved:    []int32{64},
+			count:       1,
+			expectError: true,
+		},
+		{
+			name:        "no free LUN",
+			reserved:    allLUNs,
+			count:       1,
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			luns, err := assignDataDiskLUNs(test.reserved, test.count)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if !test.expectError && !reflect.DeepEqual(luns, test.expectedLUNs) {
+				t.Errorf("expected LUNs %v, got %v", test.expectedLUNs, luns)
+			}
+		})
+	}
+}
+
+func TestValidateDataDiskCount(t *testing.T) {
+	sku := compute.ResourceSku{
+		Name:         to.StringPtr("Standard_B1s"),
+		Capabilities: &[]compute.ResourceSkuCapabilities{{Name: to.StringPtr(CapabilityMaxDataDiskCount), Value: to.StringPtr("2")}},
+	}
+
+	tests := []struct {
+		name        string
+		reserved    []int32
+		sku         compute.ResourceSku
+		expectError bool
+	}{
+		{
+			name: "within the limit",
+			sku:  sku,
+		},
+		{
+			name:     "image data disks within the limit",
+			reserved: []int32{0},
+			sku:      sku,
+		},
+		{
+			name:        "image data disks exceed the limit",
+			reserved:    []int32{0, 1},
+			sku:         sku,
+			expectError: true,
+		},
+		{
+			name:     "unknown limit",
+			reserved: []int32{0, 1},
+			sku:      compute.ResourceSku{Name: to.StringPtr("Standard_B1s")},
+		},
+		{
+			name:        "duplicate image LUNs",
+			reserved:    []int32{0, 0},
+			sku:         sku,
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateDataDiskCount(test.reserved, 1, test.sku)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got: %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestGetStorageProfileDataDiskLUN(t *testing.T) {
+	c := &config{
+		ImageID:      "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/image",
+		DataDiskSize: 10,
+	}
+
+	sp, err := getStorageProfile(c, &providerconfigtypes.Config{OperatingSystem: providerconfigtypes.OperatingSystemUbuntu}, nil, []int32{0, 1})
+	if err != nil {
+		t.Fatalf("failed to get storage profile: %v", err)
+	}
+	if sp.DataDisks == nil || len(*sp.DataDisks) != 1 {
+		t.Fatalf("expected one data disk, got %v", sp.DataDisks)
+	}
+	if lun := to.Int32((*sp.DataDisks)[0].Lun); lun != 2 {
+		t.Errorf("expected the data disk to use LUN 2, got %d", lun)
+	}
+}
+
+func TestEphemeralOSDiskPlacement(t *testing.T) {
+	sku := func(capabilities map[string]string) compute.ResourceSku {
+		var skuCapabilities []compute.ResourceSkuCapabilities
+		for name, value := range capabilities {
+			skuCapabilities = append(skuCapabilities, compute.ResourceSkuCapabilities{Name: to.StringPtr(name), Value: to.StringPtr(value)})
+		}
+		return compute.ResourceSku{Name: to.StringPtr("Standard_D4s_v3"), Capabilities: &skuCapabilities}
+	}
+
+	tests := []struct {
+		name              string
+		sku               compute.ResourceSku
+		osDiskSize        int32
+		expectedPlacement compute.DiffDiskPlacement
+		expectedSupported bool
+	}{
+		{
+			name: "fits on the cache disk",
+			sku: sku(map[string]string{
+				CapabilityEphemeralOSDiskSupported: CapabilityValueTrue,
+				CapabilityCachedDiskBytes:          "107374182400",
+				CapabilityMaxResourceVolumeMB:      "32768",
+			}),
+			osDiskSize:        30,
+			expectedPlacement: compute.DiffDiskPlacementCacheDisk,
+			expectedSupported: true,
+		},
+		{
+			name: "only fits on the temp disk",
+			sku: sku(map[string]string{
+				CapabilityEphemeralOSDiskSupported: CapabilityValueTrue,
+				CapabilityCachedDiskBytes:          "21474836480",
+				CapabilityMaxResourceVolumeMB:      "65536",
+			}),
+			osDiskSize:        30,
+			expectedPlacement: compute.DiffDiskPlacementResourceDisk,
+			expectedSupported: true,
+		},
+		{
+			name: "too large for the cache and temp disks",
+			sku: sku(map[string]string{
+				CapabilityEphemeralOSDiskSupported: CapabilityValueTrue,
+				CapabilityCachedDiskBytes:          "21474836480",
+				CapabilityMaxResourceVolumeMB:      "16384",
+			}),
+			osDiskSize: 30,
+		},
+		{
+			name: "not supported by the VM size",
+			sku: sku(map[string]string{
+				CapabilityEphemeralOSDiskSupported: "False",
+				CapabilityCachedDiskBytes:          "107374182400",
+			}),
+			osDiskSize: 30,
+		},
+		{
+			name:       "no capabilities",
+			sku:        compute.ResourceSku{Name: to.StringPtr("Standard_B1s")},
+			osDiskSize: 30,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			placement, supported := ephemeralOSDiskPlacement(test.sku, test.osDiskSize)
+			if supported != test.expectedSupported {
+				t.Fatalf("expected supported to be %t, got %t", test.expectedSupported, supported)
+			}
+			if placement != test.expectedPlacement {
+				t.Errorf("expected placement %q, got %q", test.expectedPlacement, placement)
+			}
+		})
+	}
+}
+
+func TestGetStorageProfileEphemeralOSDisk(t *testing.T) {
+	c := &config{
+		ImageID:                  "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/image",
+		OSDiskSKU:                storageTypePtr(string(compute.StorageAccountTypesPremiumLRS)),
+		EphemeralOSDiskPlacement: compute.DiffDiskPlacementCacheDisk,
+	}
+
+	sp, err := getStorageProfile(c, &providerconfigtypes.Config{OperatingSystem: providerconfigtypes.OperatingSystemUbuntu}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to get storage profile: %v", err)
+	}
+	if sp.OsDisk == nil || sp.OsDisk.DiffDiskSettings == nil {
+		t.Fatalf("expected an ephemeral OS disk, got %+v", sp.OsDisk)
+	}
+	if sp.OsDisk.DiffDiskSettings.Placement != compute.DiffDiskPlacementCacheDisk || sp.OsDisk.Caching != compute.CachingTypesReadOnly {
+		t.Errorf("expected a read-only cached OS disk on the cache disk, got %+v", sp.OsDisk)
+	}
+	if sp.OsDisk.ManagedDisk != nil {
+		t.Errorf("expected no managed disk parameters for an ephemeral OS disk, got %+v", sp.OsDisk.ManagedDisk)
+	}
+
+	c.EphemeralOSDiskPlacement = ""
+	c.OSDiskSize = 50
+	sp, err = getStorageProfile(c, &providerconfigtypes.Config{OperatingSystem: providerconfigtypes.OperatingSystemUbuntu}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to get storage profile: %v", err)
+	}
+	if sp.OsDisk == nil || sp.OsDisk.DiffDiskSettings != nil || sp.OsDisk.ManagedDisk == nil {
+		t.Errorf("expected a managed OS disk as fallback, got %+v", sp.OsDisk)
+	}
+}
+
+func TestGetOSImageReferenceImageNotFound(t *testing.T) {
+	if _, err := getOSImageReference(&config{}, providerconfigtypes.OperatingSystemUbuntu); err != nil {
+		t.Fatalf("expected the default image of Ubuntu, got: %v", err)
+	}
+
+	_, err := getOSImageReference(&config{}, providerconfigtypes.OperatingSystemSLES)
+	isTerminal, reason, _ := cloudprovidererrors.IsTerminalError(err)
+	if !isTerminal || reason != common.ImageNotFoundMachineError {
+		t.Errorf("expected a terminal %s error, got: %v", common.ImageNotFoundMachineError, err)
+	}
+}
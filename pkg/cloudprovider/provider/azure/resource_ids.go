@@ -0,0 +1,195 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"k8s.io/klog"
+)
+
+// resourceKind is the provider namespace and the type of an Azure resource.
+type resourceKind string
+
+const (
+	resourceKindAvailabilitySet          resourceKind = "Microsoft.Compute/availabilitySets"
+	resourceKindVirtualMachineScaleSet   resourceKind = "Microsoft.Compute/virtualMachineScaleSets"
+	resourceKindSecurityGroup            resourceKind = "Microsoft.Network/networkSecurityGroups"
+	resourceKindApplicationSecurityGroup resourceKind = "Microsoft.Network/applicationSecurityGroups"
+)
+
+// resourceIDGetter fetches a resource by its name in the resource group of the config and returns its ID.
+type resourceIDGetter func(ctx context.Context, c *config, name string) (*string, error)
+
+// resourceIDGetters contains the getters of all resource kinds which can be resolved.
+var resourceIDGetters = map[resourceKind]resourceIDGetter{
+	resourceKindAvailabilitySet: func(ctx context.Context, c *config, name string) (*string, error) {
+		client, err := getAvailabilitySetsClient(c)
+		if err != nil {
+			return nil, err
+		}
+		availabilitySet, err := client.Get(ctx, c.ResourceGroup, name)
+		return availabilitySet.ID, err
+	},
+	resourceKindVirtualMachineScaleSet: func(ctx context.Context, c *config, name string) (*string, error) {
+		client, err := getVirtualMachineScaleSetsClient(c)
+		if err != nil {
+			return nil, err
+		}
+		scaleSet, err := client.Get(ctx, c.ResourceGroup, name, "")
+		return scaleSet.ID, err
+	},
+	resourceKindSecurityGroup: func(ctx context.Context, c *config, name string) (*string, error) {
+		client, err := getSecurityGroupsClient(c)
+		if err != nil {
+			return nil, err
+		}
+		securityGroup, err := client.Get(ctx, c.ResourceGroup, name, "")
+		return securityGroup.ID, err
+	},
+	resourceKindApplicationSecurityGroup: func(ctx context.Context, c *config, name string) (*string, error) {
+		client, err := getApplicationSecurityGroupsClient(c)
+		if err != nil {
+			return nil, err
+		}
+		applicationSecurityGroup, err := client.Get(ctx, c.ResourceGroup, name)
+		return applicationSecurityGroup.ID, err
+	},
+}
+
+// resolveResourceID returns the ID of the resource with the given kind and name in the resource group of the config.
+// Resolved IDs are cached, so the existence of a resource is only checked once per cache period.
+func resolveResourceID(ctx context.Context, c *config, kind resourceKind, name string) (string, error) {
+	getID, ok := resourceIDGetters[kind]
+	if !ok {
+		return "", fmt.Errorf("unsupported resource kind %q", kind)
+	}
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	cacheKey := fmt.Sprintf("%s-%s-%s-%s-resource-id", c.SubscriptionID, c.ResourceGroup, kind, name)
+	if cachedID, found := cache.Get(cacheKey); found {
+		klog.V(3).Infof("found ID of %s %q in cache!", kind, name)
+		return cachedID.(string), nil
+	}
+
+	id, err := getID(ctx, c, name)
+	if err != nil {
+		if isNotFound(err) {
+			return "", fmt.Errorf("%s %q not found in resource group %q", kind, name, c.ResourceGroup)
+		}
+		return "", fmt.Errorf("failed to get %s %q: %v", kind, name, err)
+	}
+	if to.String(id) == "" {
+		return "", fmt.Errorf("%s %q has no ID", kind, name)
+	}
+
+	cache.SetDefault(cacheKey, *id)
+
+	return *id, nil
+}
+
+// subnetResource is a subnet of a virtual network, it's identified by all of its fields.
+type subnetResource struct {
+	subscriptionID string
+	resourceGroup  string
+	vnetName       string
+	name           string
+}
+
+// parseSubnetID parses the resource ID of a subnet, e.g.
+// /subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.Network/virtualNetworks/<vnet>/subnets/<subnet>.
+func parseSubnetID(id string) (subnetResource, error) {
+	resource, err := azure.ParseResourceID(id)
+	// The resource only contains the name of the subnet, the name of its virtual network is taken from the ID
+	parts := strings.Split(strings.TrimPrefix(id, "/"), "/")
+	if err != nil || len(parts) != 10 ||
+		!strings.EqualFold(resource.Provider, "Microsoft.Network") ||
+		!strings.EqualFold(resource.ResourceType, "virtualNetworks") ||
+		!strings.EqualFold(parts[8], "subnets") {
+		return subnetResource{}, fmt.Errorf("invalid subnetID %q, expected /subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.Network/virtualNetworks/<vnet>/subnets/<subnet>", id)
+	}
+	if parts[7] == "" || resource.ResourceName == "" {
+		return subnetResource{}, fmt.Errorf("invalid subnetID %q, the names of the virtual network and the subnet must not be empty", id)
+	}
+
+	return subnetResource{
+		subscriptionID: resource.SubscriptionID,
+		resourceGroup:  resource.ResourceGroup,
+		vnetName:       parts[7],
+		name:           resource.ResourceName,
+	}, nil
+}
+
+// dedicatedHostResource is a dedicated host group or a host within it, host is empty for host groups.
+type dedicatedHostResource struct {
+	subscriptionID string
+	resourceGroup  string
+	hostGroup      string
+	host           string
+}
+
+// parseDedicatedHostID parses the resource ID of a dedicated host group, e.g.
+// /subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.Compute/hostGroups/<hostGroup>, or of a dedicated
+// host, which has the additional segments /hosts/<host>.
+func parseDedicatedHostID(id string, host bool) (dedicatedHostResource, error) {
+	expected := "/subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.Compute/hostGroups/<hostGroup>"
+	length := 8
+	if host {
+		expected += "/hosts/<host>"
+		length = 10
+	}
+
+	resource, err := azure.ParseResourceID(id)
+	// The resource only contains the name of the host, the name of its host group is taken from the ID
+	parts := strings.Split(strings.TrimPrefix(id, "/"), "/")
+	if err != nil || len(parts) != length ||
+		!strings.EqualFold(resource.Provider, "Microsoft.Compute") ||
+		!strings.EqualFold(resource.ResourceType, "hostGroups") ||
+		host && !strings.EqualFold(parts[8], "hosts") {
+		return dedicatedHostResource{}, fmt.Errorf("invalid ID %q, expected %s", id, expected)
+	}
+	if parts[7] == "" || resource.ResourceName == "" {
+		return dedicatedHostResource{}, fmt.Errorf("invalid ID %q, the names of its resources must not be empty", id)
+	}
+
+	dedicatedHost := dedicatedHostResource{
+		subscriptionID: resource.SubscriptionID,
+		resourceGroup:  resource.ResourceGroup,
+		hostGroup:      parts[7],
+	}
+	if host {
+		dedicatedHost.host = resource.ResourceName
+	}
+
+	return dedicatedHost, nil
+}
+
+// isNotFound returns whether the error is caused by a request for a resource which doesn't exist.
+func isNotFound(err error) bool {
+	var detailedErr autorest.DetailedError
+	return errors.As(err, &detailedErr) && detailedErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestResolveResourceID(t *testing.T) {
+	const testKind resourceKind = "Microsoft.Test/resources"
+
+	calls := 0
+	resourceIDGetters[testKind] = func(_ context.Context, c *config, name string) (*string, error) {
+		calls++
+		switch name {
+		case "existing":
+			return to.StringPtr(fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s", c.SubscriptionID, c.ResourceGroup, testKind, name)), nil
+		case "missing":
+			return nil, autorest.DetailedError{StatusCode: http.StatusNotFound, Original: errors.New("not found")}
+		default:
+			return nil, autorest.DetailedError{StatusCode: http.StatusForbidden, Original: errors.New("forbidden")}
+		}
+	}
+	defer delete(resourceIDGetters, testKind)
+
+	c := &config{SubscriptionID: "resolve-resource-id-test", ResourceGroup: "rg"}
+	expectedID := "/subscriptions/resolve-resource-id-test/resourceGroups/rg/providers/Microsoft.Test/resources/existing"
+
+	for i := 0; i < 2; i++ {
+		id, err := resolveResourceID(context.Background(), c, testKind, "existing")
+		if err != nil {
+			t.Fatalf("failed to resolve resource ID: %v", err)
+		}
+		if id != expectedID {
+			t.Errorf("expected ID %q, got %q", expectedID, id)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the second resolution to hit the cache, but the resource was fetched %d times", calls)
+	}
+
+	_, err := resolveResourceID(context.Background(), c, testKind, "missing")
+	expectedErr := `Microsoft.Test/resources "missing" not found in resource group "rg"`
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("expected error %q, got %v", expectedErr, err)
+	}
+
+	// Failed resolutions aren't cached
+	if _, err := resolveResourceID(context.Background(), c, testKind, "missing"); err == nil {
+		t.Error("expected the missing resource to not be resolved from the cache")
+	}
+	if calls != 3 {
+		t.Errorf("expected the missing resource to be fetched twice, got %d fetches in total", calls)
+	}
+
+	if _, err := resolveResourceID(context.Background(), c, testKind, "forbidden"); err == nil || isNotFound(err) {
+		t.Errorf("expected a non not-found error, got %v", err)
+	}
+
+	if _, err := resolveResourceID(context.Background(), c, "Microsoft.Test/unknown", "existing"); err == nil {
+		t.Error("expected an error for an unsupported resource kind")
+	}
+}
+
+func TestParseSubnetID(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		expected subnetResource
+		err      bool
+	}{
+		{
+			name: "subnet",
+			id:   "/subscriptions/hub/resourceGroups/network-rg/providers/Microsoft.Network/virtualNetworks/hub-vnet/subnets/nodes",
+			expected: subnetResource{
+				subscriptionID: "hub",
+				resourceGroup:  "network-rg",
+				vnetName:       "hub-vnet",
+				name:           "nodes",
+			},
+		},
+		{
+			name: "case insensitive segments",
+			id:   "/SUBSCRIPTIONS/hub/resourcegroups/network-rg/providers/microsoft.network/virtualnetworks/hub-vnet/SUBNETS/nodes",
+			expected: subnetResource{
+				subscriptionID: "hub",
+				resourceGroup:  "network-rg",
+				vnetName:       "hub-vnet",
+				name:           "nodes",
+			},
+		},
+		{
+			name: "virtual network",
+			id:   "/subscriptions/hub/resourceGroups/network-rg/providers/Microsoft.Network/virtualNetworks/hub-vnet",
+			err:  true,
+		},
+		{
+			name: "other resource type",
+			id:   "/subscriptions/hub/resourceGroups/network-rg/providers/Microsoft.Compute/virtualMachines/vm/subnets/nodes",
+			err:  true,
+		},
+		{
+			name: "empty subnet name",
+			id:   "/subscriptions/hub/resourceGroups/network-rg/providers/Microsoft.Network/virtualNetworks/hub-vnet/subnets/",
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subnet, err := parseSubnetID(test.id)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %t, got %v", test.err, err)
+			}
+			if subnet != test.expected {
+				t.Errorf("expected subnet %+v, got %+v", test.expected, subnet)
+			}
+		})
+	}
+}
+
+func TestParseDedicatedHostID(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		host     bool
+		expected dedicatedHostResource
+		err      bool
+	}{
+		{
+			name: "host group",
+			id:   "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups/group",
+			expected: dedicatedHostResource{
+				subscriptionID: "sub",
+				resourceGroup:  "hosts-rg",
+				hostGroup:      "group",
+			},
+		},
+		{
+			name: "host",
+			id:   "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups/group/hosts/host-1",
+			host: true,
+			expected: dedicatedHostResource{
+				subscriptionID: "sub",
+				resourceGroup:  "hosts-rg",
+				hostGroup:      "group",
+				host:           "host-1",
+			},
+		},
+		{
+			name: "case insensitive segments",
+			id:   "/SUBSCRIPTIONS/sub/resourcegroups/hosts-rg/providers/microsoft.compute/hostgroups/group/HOSTS/host-1",
+			host: true,
+			expected: dedicatedHostResource{
+				subscriptionID: "sub",
+				resourceGroup:  "hosts-rg",
+				hostGroup:      "group",
+				host:           "host-1",
+			},
+		},
+		{
+			name: "host given as host group",
+			id:   "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups/group/hosts/host-1",
+			err:  true,
+		},
+		{
+			name: "host group given as host",
+			id:   "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups/group",
+			host: true,
+			err:  true,
+		},
+		{
+			name: "other resource type",
+			id:   "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Compute/availabilitySets/group",
+			err:  true,
+		},
+		{
+			name: "empty host name",
+			id:   "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups/group/hosts/",
+			host: true,
+			err:  true,
+		},
+		{
+			name: "empty host group name",
+			id:   "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups//hosts/host-1",
+			host: true,
+			err:  true,
+		},
+		{
+			name: "empty subscription",
+			id:   "/subscriptions//resourceGroups/hosts-rg/providers/Microsoft.Compute/hostGroups/group",
+			err:  true,
+		},
+		{
+			name: "other provider",
+			id:   "/subscriptions/sub/resourceGroups/hosts-rg/providers/Microsoft.Network/hostGroups/group",
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resource, err := parseDedicatedHostID(test.id, test.host)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %t, got %v", test.err, err)
+			}
+			if resource != test.expected {
+				t.Errorf("expected dedicated host %+v, got %+v", test.expected, resource)
+			}
+		})
+	}
+}
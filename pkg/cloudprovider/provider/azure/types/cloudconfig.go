@@ -38,6 +38,14 @@ type CloudConfig struct {
 	VnetResourceGroup          string `json:"vnetResourceGroup"`
 	UseInstanceMetadata        bool   `json:"useInstanceMetadata"`
 	LoadBalancerSku            string `json:"loadBalancerSku"`
+
+	// The following settings are only set for the external cloud controller manager
+	VMType                       string  `json:"vmType,omitempty"`
+	CloudProviderBackoff         bool    `json:"cloudProviderBackoff,omitempty"`
+	CloudProviderBackoffRetries  int     `json:"cloudProviderBackoffRetries,omitempty"`
+	CloudProviderBackoffDuration int     `json:"cloudProviderBackoffDuration,omitempty"`
+	CloudProviderBackoffExponent float64 `json:"cloudProviderBackoffExponent,omitempty"`
+	CloudProviderBackoffJitter   float64 `json:"cloudProviderBackoffJitter,omitempty"`
 }
 
 func CloudConfigToString(c *CloudConfig) (string, error) {
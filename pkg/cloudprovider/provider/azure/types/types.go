@@ -50,6 +50,171 @@ type RawConfig struct {
 	DataDiskSKU    *string                             `json:"dataDiskSKU,omitempty"`
 	AssignPublicIP providerconfigtypes.ConfigVarBool   `json:"assignPublicIP"`
 	Tags           map[string]string                   `json:"tags,omitempty"`
+
+	// DataDiskIOPS and DataDiskThroughput (in MB/s) are only supported for UltraSSD_LRS and PremiumV2_LRS
+	// data disks. OS disks can't use either of those SKUs, so there is no OS disk equivalent.
+	DataDiskIOPS       *int64 `json:"dataDiskIOPS,omitempty"`
+	DataDiskThroughput *int64 `json:"dataDiskThroughput,omitempty"`
+	// OSDiskPerformanceTier and DataDiskPerformanceTier set the performance tier (e.g. P50) of Premium SSDs
+	// independently of the disk size.
+	OSDiskPerformanceTier   *string `json:"osDiskPerformanceTier,omitempty"`
+	DataDiskPerformanceTier *string `json:"dataDiskPerformanceTier,omitempty"`
+
+	// RetainOSDiskOnDelete keeps the OS disk when the machine gets deleted, e.g. for forensics. The disk is tagged
+	// with the name of the machine and the time of the deletion instead of the machine UID.
+	RetainOSDiskOnDelete bool `json:"retainOSDiskOnDelete,omitempty"`
+
+	// PreferEphemeralOSDisk places the OS disk on the cache or temp disk of the VM if the VM size supports an
+	// ephemeral OS disk of that size, otherwise a managed OS disk is used.
+	PreferEphemeralOSDisk bool `json:"preferEphemeralOSDisk,omitempty"`
+
+	// ApplicationSecurityGroups are the names of application security groups in the resource group which the
+	// network interface of the VM joins.
+	ApplicationSecurityGroups []providerconfigtypes.ConfigVarString `json:"applicationSecurityGroups,omitempty"`
+
+	// ComputerName sets the hostname of the VM, the Azure resource is still named after the machine.
+	// ComputerNamePrefix is prepended to the machine name instead, only one of both can be set.
+	ComputerName       providerconfigtypes.ConfigVarString `json:"computerName,omitempty"`
+	ComputerNamePrefix providerconfigtypes.ConfigVarString `json:"computerNamePrefix,omitempty"`
+
+	// AdminUsername overrides the admin user of the VM, which defaults to the default user of the operating system,
+	// e.g. for images which expect another user. The SSH public keys are added to its authorized_keys.
+	AdminUsername providerconfigtypes.ConfigVarString `json:"adminUsername,omitempty"`
+
+	// AllowExtensionOperations can be set to false to prevent any VM extension from being installed.
+	AllowExtensionOperations *bool `json:"allowExtensionOperations,omitempty"`
+
+	// EnableBootDiagnostics enables boot diagnostics with a managed storage account, which is required
+	// to fetch the serial console output of the VM.
+	EnableBootDiagnostics bool `json:"enableBootDiagnostics,omitempty"`
+
+	// InstallGPUDriver installs the NVIDIA GPU driver VM extension if the VM size has GPUs.
+	InstallGPUDriver bool `json:"installGPUDriver,omitempty"`
+
+	// Extensions are installed in the given order once the VM got created, they are deleted together with the VM.
+	Extensions []VMExtension `json:"extensions,omitempty"`
+
+	// NetworkMTU sets the MTU of the network interfaces in the guest, e.g. 9000 for jumbo frames. The MTU must be
+	// supported by the VNet, which requires accelerated networking for jumbo frames.
+	NetworkMTU *int `json:"networkMTU,omitempty"`
+
+	// SecurityType is either Standard, TrustedLaunch or ConfidentialVM, the latter two require a gen2 VM size.
+	SecurityType *string `json:"securityType,omitempty"`
+	// UefiSettings enable secure boot and vTPM on gen2 VMs. Azure requires a security type for them, so
+	// TrustedLaunch is used if no security type is given, with only the configured UEFI features enabled.
+	UefiSettings *UefiSettings `json:"uefiSettings,omitempty"`
+
+	// DataDiskSource creates the data disk from an existing snapshot or managed disk instead of an empty disk.
+	DataDiskSource *DataDiskSource `json:"dataDiskSource,omitempty"`
+
+	// VirtualMachineScaleSet is the name of a virtual machine scale set with flexible orchestration in the resource
+	// group which the VM joins. PlatformFaultDomain pins the VM to a fault domain of the scale set.
+	VirtualMachineScaleSet providerconfigtypes.ConfigVarString `json:"virtualMachineScaleSet,omitempty"`
+	PlatformFaultDomain    *int32                              `json:"platformFaultDomain,omitempty"`
+
+	// HostGroupID and HostID place the VM on a dedicated host, either on a host of the host group which is picked by
+	// Azure or on the given host. Only one of both can be set, the VM uses shared infrastructure by default.
+	// Dedicated hosts can't be combined with Spot VMs, availability sets and scale sets.
+	HostGroupID providerconfigtypes.ConfigVarString `json:"hostGroupID,omitempty"`
+	HostID      providerconfigtypes.ConfigVarString `json:"hostID,omitempty"`
+
+	// EnforceResourceGroupLocation fails the validation if the resource group can't be read or the location differs
+	// from the location of the resource group, otherwise only a warning is logged as resources may intentionally be
+	// placed in another region.
+	EnforceResourceGroupLocation bool `json:"enforceResourceGroupLocation,omitempty"`
+
+	// Spot creates the VM as Azure Spot VM. Evicted Spot VMs are deleted and recreated by the controller.
+	Spot *SpotConfig `json:"spot,omitempty"`
+
+	// InstanceCacheTTL is the duration for which the instance view and the addresses of a VM are cached
+	// between reconciliations. Defaults to 30s, set it to 0s to disable caching.
+	InstanceCacheTTL providerconfigtypes.ConfigVarString `json:"instanceCacheTTL,omitempty"`
+
+	// APITimeout is the maximum duration of the Azure API calls of a single reconciliation, e.g. creating a VM
+	// with all of its resources. Calls which time out are retried in the next reconciliation. Each long running
+	// operation, e.g. creating the network interface, has its own shorter timeout within it. Defaults to 5m.
+	APITimeout providerconfigtypes.ConfigVarString `json:"apiTimeout,omitempty"`
+
+	// VMCreateTimeout and VMDeleteTimeout bound the wait for the creation and the deletion of the VM within the
+	// apiTimeout, VMPollInterval is the interval at which the state of those operations is polled. A VM which
+	// isn't ready in time is checked again in the next reconciliation. By default only the apiTimeout applies and
+	// the polling interval of the Azure SDK is used.
+	VMCreateTimeout providerconfigtypes.ConfigVarString `json:"vmCreateTimeout,omitempty"`
+	VMDeleteTimeout providerconfigtypes.ConfigVarString `json:"vmDeleteTimeout,omitempty"`
+	VMPollInterval  providerconfigtypes.ConfigVarString `json:"vmPollInterval,omitempty"`
+
+	// SubnetID is the resource ID of the subnet of the network interface, which may be in another subscription,
+	// e.g. in a hub-spoke network. If it's set, vnetName, vnetResourceGroup and subnetName aren't used for the VM.
+	SubnetID providerconfigtypes.ConfigVarString `json:"subnetID,omitempty"`
+
+	// LicenseType applies Azure Hybrid Benefit to the VM, e.g. RHEL_BYOS or SLES_BYOS to use existing subscriptions.
+	// It must match the operating system, the VM is billed pay-as-you-go if it's not set.
+	LicenseType providerconfigtypes.ConfigVarString `json:"licenseType,omitempty"`
+
+	// MachineDeploymentTagKey and ClusterTagKey are the keys of the tags which group the VM and its resources by the
+	// MachineDeployment of the machine and by the cluster, i.e. the namespace of the machine. They default to
+	// MachineDeployment and Cluster, an empty key disables the tag. A default key is not used if tags contains it.
+	MachineDeploymentTagKey *string `json:"machineDeploymentTagKey,omitempty"`
+	ClusterTagKey           *string `json:"clusterTagKey,omitempty"`
+
+	// RequireEgress turns the missing egress of machines without a public IP into a validation error. Egress is
+	// provided by a NAT gateway or a default route of the subnet, or by the outbound rules of a standard load
+	// balancer.
+	RequireEgress bool `json:"requireEgress,omitempty"`
+
+	// PrivateIPAddress is the static private IPv4 address of the VM, it has to be within the prefix of the subnet.
+	// If the address is taken, e.g. by a machine which is created concurrently, the creation is retried. It can only
+	// be used by single machines, MachineDeployments with more than one replica are rejected.
+	PrivateIPAddress providerconfigtypes.ConfigVarString `json:"privateIPAddress,omitempty"`
+
+	// PublicIPIdleTimeoutMinutes is the idle timeout of TCP connections of the public IPs, between 4 and 30 minutes.
+	// It requires assignPublicIP, Azure uses a timeout of 4 minutes if it isn't set.
+	PublicIPIdleTimeoutMinutes *int32 `json:"publicIPIdleTimeoutMinutes,omitempty"`
+
+	// NetworkResourceGroup is the resource group of the network interface and the public IPs of the VM, e.g. if
+	// network resources are kept in a dedicated resource group. Defaults to resourceGroup.
+	NetworkResourceGroup providerconfigtypes.ConfigVarString `json:"networkResourceGroup,omitempty"`
+}
+
+// VMExtension is a VM extension, e.g. the custom script extension or a monitoring agent.
+type VMExtension struct {
+	// Name of the extension on the VM, defaults to the type.
+	Name      string `json:"name,omitempty"`
+	Publisher string `json:"publisher"`
+	Type      string `json:"type"`
+	// Version is the major and minor version of the extension handler, e.g. 2.1.
+	Version                 string `json:"version"`
+	AutoUpgradeMinorVersion *bool  `json:"autoUpgradeMinorVersion,omitempty"`
+	// Settings is the public configuration of the extension.
+	Settings map[string]interface{} `json:"settings,omitempty"`
+	// ProtectedSettings is the configuration of the extension which is encrypted by Azure and never logged,
+	// e.g. for credentials.
+	ProtectedSettings map[string]interface{} `json:"protectedSettings,omitempty"`
+}
+
+// UefiSettings contains the UEFI features of the VM.
+type UefiSettings struct {
+	SecureBootEnabled *bool `json:"secureBootEnabled,omitempty"`
+	VTpmEnabled       *bool `json:"vTpmEnabled,omitempty"`
+}
+
+// DataDiskSource contains the source of the data disk.
+type DataDiskSource struct {
+	// SourceResourceID is the resource ID of a snapshot or of a managed disk.
+	SourceResourceID providerconfigtypes.ConfigVarString `json:"sourceResourceID"`
+	// CreateOption is either Copy to create a new disk from the source or Attach to attach an existing disk,
+	// which is kept when the machine gets deleted. Snapshots can only be copied, for disks it must be set.
+	CreateOption string `json:"createOption,omitempty"`
+}
+
+// SpotConfig contains the settings of Azure Spot VMs.
+type SpotConfig struct {
+	// MaxPrice is the maximum price in US dollars per hour, -1 means that the VM isn't evicted for pricing reasons.
+	MaxPrice *float64 `json:"maxPrice,omitempty"`
+	// EvictionCooldown delays the recreation of an evicted VM, e.g. 5m.
+	EvictionCooldown providerconfigtypes.ConfigVarString `json:"evictionCooldown,omitempty"`
+	// EvictionCooldownJitter adds a random delay of up to the given duration to the eviction cooldown.
+	EvictionCooldownJitter providerconfigtypes.ConfigVarString `json:"evictionCooldownJitter,omitempty"`
 }
 
 // ImagePlan contains azure OS Plan fields for the marketplace images
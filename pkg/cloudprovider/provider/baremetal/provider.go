@@ -287,3 +287,9 @@ func (p provider) MigrateUID(machine *clusterv1alpha1.Machine, new types.UID) er
 func (p provider) SetMetricsForMachines(machines clusterv1alpha1.MachineList) error {
 	return nil
 }
+
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	// the operating system is installed by the driver
+	return providerconfigtypes.AllOperatingSystems
+}
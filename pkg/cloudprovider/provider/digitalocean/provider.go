@@ -550,3 +550,11 @@ func doStatusAndErrToTerminalError(status int, err error) error {
 func (p *provider) SetMetricsForMachines(machines clusterv1alpha1.MachineList) error {
 	return nil
 }
+
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p *provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	return providerconfigtypes.FilterOperatingSystems(func(os providerconfigtypes.OperatingSystem) bool {
+		_, err := getSlugForOS(os)
+		return err == nil
+	})
+}
@@ -20,8 +20,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"path"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/packethost/packngo"
 
@@ -37,12 +41,29 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog"
 )
 
 const (
 	machineUIDTag       = "kubermatic-machine-controller:machine-uid"
 	defaultBillingCycle = "hourly"
+
+	defaultFailedDeviceTimeout = 10 * time.Minute
+	// maxEventsInSummary is the number of the latest device events which are used to explain a failed device
+	maxEventsInSummary = 3
+
+	migrateUIDCheckPeriod  = 5 * time.Second
+	migrateUIDCheckTimeout = 2 * time.Minute
+
+	// the network ports can only be converted once the device is provisioned, which takes several minutes
+	activeDeviceCheckPeriod  = 15 * time.Second
+	activeDeviceCheckTimeout = 30 * time.Minute
+
+	// network types of the device ports, layer3 is the network type of new devices
+	networkTypeLayer3       = "layer3"
+	networkTypeHybrid       = "hybrid"
+	networkTypeLayer2Bonded = "layer2-bonded"
 )
 
 // New returns a Equinix Metal provider
@@ -57,6 +78,13 @@ type Config struct {
 	InstanceType string
 	Facilities   []string
 	Tags         []string
+
+	ElasticIPReservationID string
+	FailedDeviceTimeout    time.Duration
+	Storage                string
+
+	NetworkType string
+	VLANs       []string
 }
 
 // because we have both Config and RawConfig, we need to have func for each
@@ -65,6 +93,12 @@ func (c *Config) populateDefaults() {
 	if c.BillingCycle == "" {
 		c.BillingCycle = defaultBillingCycle
 	}
+	if c.FailedDeviceTimeout <= 0 {
+		c.FailedDeviceTimeout = defaultFailedDeviceTimeout
+	}
+	if c.NetworkType == "" {
+		c.NetworkType = networkTypeLayer3
+	}
 }
 
 func populateDefaults(c *equinixmetaltypes.RawConfig) {
@@ -140,16 +174,49 @@ func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*Config, *e
 		c.Facilities = append(c.Facilities, facilityValue)
 	}
 
+	c.ElasticIPReservationID, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.ElasticIPReservationID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get the value of \"elasticIPReservationID\" field, error = %v", err)
+	}
+
+	failedDeviceTimeout, err := p.configVarResolver.GetConfigVarStringValue(rawConfig.FailedDeviceTimeout)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get the value of \"failedDeviceTimeout\" field, error = %v", err)
+	}
+	if failedDeviceTimeout != "" {
+		c.FailedDeviceTimeout, err = time.ParseDuration(failedDeviceTimeout)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to parse \"failedDeviceTimeout\" field, error = %v", err)
+		}
+	}
+
+	c.Storage, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.Storage)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get the value of \"storage\" field, error = %v", err)
+	}
+
+	c.NetworkType, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.NetworkType)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get the value of \"networkType\" field, error = %v", err)
+	}
+	for i, vlan := range rawConfig.VLANs {
+		vlanValue, err := p.configVarResolver.GetConfigVarStringValue(vlan)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read the value for the VLAN at index %d of the \"vlans\" field, error = %v", i, err)
+		}
+		c.VLANs = append(c.VLANs, vlanValue)
+	}
+
 	// ensure we have defaults
 	c.populateDefaults()
 
 	return &c, rawConfig, pconfig, err
 }
 
-func (p *provider) getMetalDevice(machine *clusterv1alpha1.Machine) (*packngo.Device, *packngo.Client, error) {
+func (p *provider) getMetalDevice(machine *clusterv1alpha1.Machine) (*packngo.Device, *packngo.Client, *Config, error) {
 	c, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err != nil {
-		return nil, nil, cloudprovidererrors.TerminalError{
+		return nil, nil, nil, cloudprovidererrors.TerminalError{
 			Reason:  common.InvalidConfigurationMachineError,
 			Message: fmt.Sprintf("Failed to parse MachineSpec, due to %v", err),
 		}
@@ -158,9 +225,9 @@ func (p *provider) getMetalDevice(machine *clusterv1alpha1.Machine) (*packngo.De
 	client := getClient(c.Token)
 	device, err := getDeviceByTag(client, c.ProjectID, generateTag(string(machine.UID)))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	return device, client, nil
+	return device, client, c, nil
 }
 
 func (p *provider) Validate(spec clusterv1alpha1.MachineSpec) error {
@@ -184,20 +251,26 @@ func (p *provider) Validate(spec clusterv1alpha1.MachineSpec) error {
 		return fmt.Errorf("invalid/not supported operating system specified %q: %v", pc.OperatingSystem, err)
 	}
 
+	if err := validateStorage(c.Storage); err != nil {
+		return err
+	}
+
+	if err := validateNetworkPorts(c); err != nil {
+		return err
+	}
+
 	client := getClient(c.Token)
 
 	if len(c.Facilities) == 0 || c.Facilities[0] == "" {
 		return fmt.Errorf("must have at least one non-blank facility")
 	}
 
-	// get all valid facilities
-	facilities, _, err := client.Facilities.List(nil)
-	if err != nil {
-		return fmt.Errorf("failed to list facilities: %v", err)
+	if err := validateFacilities(client, c.ProjectID, c.Facilities); err != nil {
+		return err
 	}
-	// ensure our requested facilities are in those facilities
-	if missingFacilities := itemsNotInList(facilityProp(facilities, "Code"), c.Facilities); len(missingFacilities) > 0 {
-		return fmt.Errorf("unknown facilities: %s", strings.Join(missingFacilities, ","))
+
+	if _, err := vlanFacilities(client, c.VLANs, c.Facilities); err != nil {
+		return err
 	}
 
 	// get all valid plans a.k.a. instance types
@@ -214,6 +287,20 @@ func (p *provider) Validate(spec clusterv1alpha1.MachineSpec) error {
 	return nil
 }
 
+// validateStorage checks that the custom partitioning and RAID layout is a JSON object.
+func validateStorage(storage string) error {
+	if storage == "" {
+		return nil
+	}
+
+	var layout map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(storage), &layout); err != nil {
+		return fmt.Errorf("storage must be a JSON object with the custom partitioning and RAID layout: %v", err)
+	}
+
+	return nil
+}
+
 func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidertypes.ProviderData, userdata string) (instance.Instance, error) {
 	c, _, pc, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err != nil {
@@ -233,17 +320,32 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 		}
 	}
 
+	// The storage layout is submitted as is, malformed JSON would only be rejected after the device got ordered
+	if err := validateStorage(c.Storage); err != nil {
+		return nil, cloudprovidererrors.TerminalError{
+			Reason:  common.InvalidConfigurationMachineError,
+			Message: err.Error(),
+		}
+	}
+
+	// The device has to be placed in the facility of its VLANs
+	facilities, err := vlanFacilities(client, c.VLANs, c.Facilities)
+	if err != nil {
+		return nil, err
+	}
+
 	serverCreateOpts := &packngo.DeviceCreateRequest{
 		Hostname:     machine.Spec.Name,
 		UserData:     userdata,
 		ProjectID:    c.ProjectID,
-		Facility:     c.Facilities,
+		Facility:     facilities,
 		BillingCycle: c.BillingCycle,
 		Plan:         c.InstanceType,
 		OS:           imageName,
 		Tags: []string{
 			generateTag(string(machine.UID)),
 		},
+		Storage: c.Storage,
 	}
 
 	device, res, err := client.Devices.Create(serverCreateOpts)
@@ -251,28 +353,42 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 		return nil, metalErrorToTerminalError(err, res, "failed to create server")
 	}
 
+	if c.ElasticIPReservationID != "" {
+		if err := assignElasticIP(client, c.ElasticIPReservationID, device.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if networkPortsPending(device, c) {
+		device, err = waitForActiveDevice(client, device.ID, activeDeviceCheckPeriod, activeDeviceCheckTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if err := configureNetworkPorts(client, device, c); err != nil {
+			return nil, err
+		}
+	}
+
 	return &metalDevice{device: device}, nil
 }
 
 func (p *provider) Cleanup(machine *clusterv1alpha1.Machine, data *cloudprovidertypes.ProviderData) (bool, error) {
-	instance, err := p.Get(machine, data)
+	// The device is looked up directly, Get returns an error for failed devices which have to be deleted as well
+	device, client, c, err := p.getMetalDevice(machine)
 	if err != nil {
-		if err == cloudprovidererrors.ErrInstanceNotFound {
-			return true, nil
-		}
 		return false, err
 	}
+	if device == nil {
+		return true, nil
+	}
 
-	c, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
-	if err != nil {
-		return false, cloudprovidererrors.TerminalError{
-			Reason:  common.InvalidConfigurationMachineError,
-			Message: fmt.Sprintf("Failed to parse MachineSpec, due to %v", err),
+	if c.ElasticIPReservationID != "" {
+		if err := releaseElasticIP(client, c.ElasticIPReservationID, device.ID); err != nil {
+			return false, err
 		}
 	}
 
-	client := getClient(c.Token)
-	res, err := client.Devices.Delete(instance.(*metalDevice).device.ID)
+	res, err := client.Devices.Delete(device.ID)
 	if err != nil {
 		return false, metalErrorToTerminalError(err, res, "failed to delete the server")
 	}
@@ -294,19 +410,131 @@ func (p *provider) AddDefaults(spec clusterv1alpha1.MachineSpec) (clusterv1alpha
 }
 
 func (p *provider) Get(machine *clusterv1alpha1.Machine, _ *cloudprovidertypes.ProviderData) (instance.Instance, error) {
-	device, _, err := p.getMetalDevice(machine)
+	device, client, c, err := p.getMetalDevice(machine)
 	if err != nil {
 		return nil, err
 	}
-	if device != nil {
-		return &metalDevice{device: device}, nil
+	if device == nil {
+		return nil, cloudprovidererrors.ErrInstanceNotFound
+	}
+
+	// The ports are configured by Create. If that got interrupted, the device is recreated instead of changing its
+	// network while the node may already use it.
+	if device.State == "active" && networkPortsPending(device, c) {
+		return nil, fmt.Errorf("%w: the network ports of device %s weren't configured for networkType %s", cloudprovidererrors.ErrInstanceFailed, device.ID, c.NetworkType)
 	}
 
-	return nil, cloudprovidererrors.ErrInstanceNotFound
+	metalDevice := &metalDevice{device: device}
+	if device.State == "failed" {
+		// The events are only used to explain the failure, the device can still be reported without them
+		events, _, err := client.Devices.ListEvents(device.ID, nil)
+		if err != nil {
+			klog.V(3).Infof("failed to list the events of device %s of machine %s: %v", device.ID, machine.Name, err)
+		}
+		metalDevice.events = events
+
+		if err := failedDeviceError(device, events, time.Now(), c.FailedDeviceTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	return metalDevice, nil
+}
+
+// failedDeviceError returns an ErrInstanceFailed error if the device has been failed for at least the given timeout,
+// so that the device gets recreated. The device failed with its latest failure event, or its last update if there
+// is no such event.
+func failedDeviceError(device *packngo.Device, events []packngo.Event, now time.Time, timeout time.Duration) error {
+	if device.State != "failed" {
+		return nil
+	}
+	failedSince, ok := failureTime(events)
+	if !ok {
+		var err error
+		if failedSince, err = time.Parse(time.RFC3339, device.Updated); err != nil {
+			klog.V(3).Infof("failed to parse the update time %q of device %s: %v", device.Updated, device.ID, err)
+			return nil
+		}
+	}
+	if now.Sub(failedSince) < timeout {
+		return nil
+	}
+
+	message := fmt.Sprintf("device %s failed to provision and has been failed since %s", device.ID, failedSince.Format(time.RFC3339))
+	if summary := eventsSummary(events); summary != "" {
+		message = fmt.Sprintf("%s: %s", message, summary)
+	}
+	return fmt.Errorf("%w: %s", cloudprovidererrors.ErrInstanceFailed, message)
+}
+
+// failureTime returns the time of the latest event which reports a failure.
+func failureTime(events []packngo.Event) (time.Time, bool) {
+	var failedAt time.Time
+	for _, event := range events {
+		if event.CreatedAt == nil || !strings.Contains(strings.ToLower(event.Type+" "+event.Interpolated+" "+event.Body), "fail") {
+			continue
+		}
+		if event.CreatedAt.After(failedAt) {
+			failedAt = event.CreatedAt.Time
+		}
+	}
+	return failedAt, !failedAt.IsZero()
+}
+
+// eventsSummary joins the latest device events, newest first.
+func eventsSummary(events []packngo.Event) string {
+	events = append([]packngo.Event{}, events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].CreatedAt == nil || events[j].CreatedAt == nil {
+			return events[j].CreatedAt == nil && events[i].CreatedAt != nil
+		}
+		return events[i].CreatedAt.After(events[j].CreatedAt.Time)
+	})
+
+	messages := []string{}
+	for _, event := range events {
+		message := event.Interpolated
+		if message == "" {
+			message = event.Body
+		}
+		if message == "" {
+			continue
+		}
+		messages = append(messages, message)
+		if len(messages) == maxEventsInSummary {
+			break
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Reboot reboots the device of the machine, which is faster than recreating a hanging device.
+func (p *provider) Reboot(machine *clusterv1alpha1.Machine, _ *cloudprovidertypes.ProviderData) error {
+	device, client, _, err := p.getMetalDevice(machine)
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return cloudprovidererrors.ErrInstanceNotFound
+	}
+
+	// A device which is already rebooting or powering on would fail the reboot request
+	switch device.State {
+	case "rebooting", "powering_on":
+		klog.V(3).Infof("device %s of machine %s is already %s", device.ID, machine.Name, device.State)
+		return nil
+	}
+
+	res, err := client.Devices.Reboot(device.ID)
+	if err != nil {
+		return metalErrorToTerminalError(err, res, "failed to reboot device")
+	}
+
+	return nil
 }
 
 func (p *provider) MigrateUID(machine *clusterv1alpha1.Machine, newID types.UID) error {
-	device, client, err := p.getMetalDevice(machine)
+	device, client, _, err := p.getMetalDevice(machine)
 	if err != nil {
 		return err
 	}
@@ -315,6 +543,18 @@ func (p *provider) MigrateUID(machine *clusterv1alpha1.Machine, newID types.UID)
 		return nil
 	}
 
+	klog.Infof("Setting UID label for machine %s", machine.Name)
+	if err := migrateDeviceUID(client, device, newID, migrateUIDCheckPeriod, migrateUIDCheckTimeout); err != nil {
+		return err
+	}
+	klog.Infof("Successfully set UID label for machine %s", machine.Name)
+
+	return nil
+}
+
+// migrateDeviceUID replaces the UID tag of the device with one for the new UID and waits until the device carries
+// it, so the device is found by the new UID afterwards.
+func migrateDeviceUID(client *packngo.Client, device *packngo.Device, newID types.UID, checkPeriod, checkTimeout time.Duration) error {
 	// go through existing labels, make sure that no other UID label exists
 	tags := make([]string, 0)
 	for _, t := range device.Tags {
@@ -325,9 +565,9 @@ func (p *provider) MigrateUID(machine *clusterv1alpha1.Machine, newID types.UID)
 	}
 
 	// create a new UID label
-	tags = append(tags, generateTag(string(newID)))
+	newTag := generateTag(string(newID))
+	tags = append(tags, newTag)
 
-	klog.Infof("Setting UID label for machine %s", machine.Name)
 	dur := &packngo.DeviceUpdateRequest{
 		Tags: &tags,
 	}
@@ -335,7 +575,17 @@ func (p *provider) MigrateUID(machine *clusterv1alpha1.Machine, newID types.UID)
 	if err != nil {
 		return metalErrorToTerminalError(err, response, "failed to update UID label")
 	}
-	klog.Infof("Successfully set UID label for machine %s", machine.Name)
+
+	err = wait.PollImmediate(checkPeriod, checkTimeout, func() (bool, error) {
+		updated, response, err := client.Devices.Get(device.ID, nil)
+		if err != nil {
+			return false, metalErrorToTerminalError(err, response, "failed to get device")
+		}
+		return itemInList(updated.Tags, newTag), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to wait for the UID label of device %s: %w", device.ID, err)
+	}
 
 	return nil
 }
@@ -349,19 +599,38 @@ func (p *provider) MachineMetricsLabels(machine *clusterv1alpha1.Machine) (map[s
 
 	c, _, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err == nil {
-		labels["size"] = c.InstanceType
-		labels["facilities"] = strings.Join(c.Facilities, ",")
+		labels = machineMetricsLabels(c)
 	}
 
 	return labels, err
 }
 
+// machineMetricsLabels returns the metrics labels of a device, which only consist of settings with few distinct
+// values to keep the cardinality of the metrics low.
+func machineMetricsLabels(c *Config) map[string]string {
+	return map[string]string{
+		"size":         c.InstanceType,
+		"facilities":   strings.Join(c.Facilities, ","),
+		"billingCycle": c.BillingCycle,
+	}
+}
+
 func (p *provider) SetMetricsForMachines(machines clusterv1alpha1.MachineList) error {
 	return nil
 }
 
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p *provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	return providerconfigtypes.FilterOperatingSystems(func(os providerconfigtypes.OperatingSystem) bool {
+		_, err := getNameForOS(os)
+		return err == nil
+	})
+}
+
 type metalDevice struct {
 	device *packngo.Device
+	// events are only listed for failed devices
+	events []packngo.Event
 }
 
 func (s *metalDevice) Name() string {
@@ -387,11 +656,39 @@ func (s *metalDevice) Addresses() map[string]v1.NodeAddressType {
 }
 
 func (s *metalDevice) Status() instance.Status {
+	return mapDeviceState(s.device.State)
+}
+
+// StatusMessage returns the provisioning progress of the device, or the latest events if it failed.
+func (s *metalDevice) StatusMessage() string {
 	switch s.device.State {
-	case "provisioning":
+	case "queued", "provisioning":
+		return fmt.Sprintf("device is %s, %.0f%% done", s.device.State, s.device.ProvisionPer)
+	case "failed":
+		if summary := eventsSummary(s.events); summary != "" {
+			return fmt.Sprintf("device failed: %s", summary)
+		}
+		return "device failed"
+	default:
+		return ""
+	}
+}
+
+// mapDeviceState maps the state of an Equinix Metal device to an instance status. Failed devices are unknown, Get
+// reports them with failedDeviceError once they have been failed for the FailedDeviceTimeout, so that they get
+// recreated.
+func mapDeviceState(state string) instance.Status {
+	switch state {
+	case "queued", "provisioning", "reinstalling":
 		return instance.StatusCreating
-	case "active":
+	case "active", "rebooting", "powering_on":
 		return instance.StatusRunning
+	case "powering_off", "inactive":
+		return instance.StatusStopped
+	case "deprovisioning":
+		return instance.StatusDeleting
+	case "failed":
+		return instance.StatusUnknown
 	default:
 		return instance.StatusUnknown
 	}
@@ -438,6 +735,245 @@ func getDeviceByTag(client *packngo.Client, projectID, tag string) (*packngo.Dev
 	return nil, nil
 }
 
+// validateFacilities ensures that all requested facility codes are available for the project.
+func validateFacilities(client *packngo.Client, projectID string, requested []string) error {
+	// packngo only supports listing all facilities, so we query the project scoped endpoint directly
+	root := struct {
+		Facilities []packngo.Facility `json:"facilities"`
+	}{}
+	response, err := client.DoRequest("GET", fmt.Sprintf("/projects/%s/facilities", projectID), nil, &root)
+	if err != nil {
+		return metalErrorToTerminalError(err, response, "failed to list facilities")
+	}
+
+	validFacilities := facilityProp(root.Facilities, "Code")
+	if missingFacilities := itemsNotInList(validFacilities, requested); len(missingFacilities) > 0 {
+		return fmt.Errorf("unknown facilities: %s, valid facilities: %s", strings.Join(missingFacilities, ","), strings.Join(validFacilities, ","))
+	}
+
+	return nil
+}
+
+// validateNetworkPorts checks the network type of the device ports and that they can carry the VLANs. layer2-bonded
+// isn't supported, the devices would lose their layer 3 addresses while the userdata doesn't configure the VLAN
+// interfaces, so they would be unreachable.
+func validateNetworkPorts(c *Config) error {
+	switch c.NetworkType {
+	case networkTypeLayer3:
+		if len(c.VLANs) > 0 {
+			return fmt.Errorf("vlans require networkType %s", networkTypeHybrid)
+		}
+	case networkTypeHybrid:
+	case networkTypeLayer2Bonded:
+		return fmt.Errorf("networkType %s is not supported, the VLAN interfaces of the operating system aren't configured, so the device would have no network", networkTypeLayer2Bonded)
+	default:
+		return fmt.Errorf("unsupported networkType %q, supported types are %s and %s", c.NetworkType, networkTypeLayer3, networkTypeHybrid)
+	}
+
+	return nil
+}
+
+// vlanFacilities returns the requested facilities which contain all VLANs. VLANs are bound to a facility, so the
+// device has to be placed in one of them. Metro scoped VLANs have no facility, they aren't supported as the ports
+// can only be assigned to VLANs of the facility of the device.
+func vlanFacilities(client *packngo.Client, vlans, facilities []string) ([]string, error) {
+	matching := facilities
+	for _, id := range vlans {
+		vlan, response, err := client.ProjectVirtualNetworks.Get(id, nil)
+		if err != nil {
+			if response != nil && response.Response != nil && response.Response.StatusCode == http.StatusNotFound {
+				return nil, fmt.Errorf("VLAN %s not found", id)
+			}
+			return nil, metalErrorToTerminalError(err, response, "failed to get VLAN")
+		}
+
+		if vlan.FacilityCode == "" {
+			return nil, fmt.Errorf("VLAN %s is not bound to a facility, metro scoped VLANs are not supported", id)
+		}
+		if !itemInList(facilities, vlan.FacilityCode) {
+			return nil, fmt.Errorf("VLAN %s is in facility %s, which is not one of the requested facilities %s", id, vlan.FacilityCode, strings.Join(facilities, ","))
+		}
+		if !itemInList(matching, vlan.FacilityCode) {
+			return nil, fmt.Errorf("the VLANs are in different facilities, VLAN %s is in facility %s, the others in %s", id, vlan.FacilityCode, strings.Join(matching, ","))
+		}
+		matching = []string{vlan.FacilityCode}
+	}
+
+	return matching, nil
+}
+
+// networkPortsPending tells whether the ports of the device differ from the configured network type or miss any of
+// the VLANs. Devices are always created with layer3 ports.
+func networkPortsPending(device *packngo.Device, c *Config) bool {
+	if c.NetworkType == networkTypeLayer3 {
+		return false
+	}
+	if device.NetworkType != c.NetworkType {
+		return true
+	}
+
+	port := findPort(device, vlanPortName(c.NetworkType))
+	if port == nil {
+		return true
+	}
+	return len(missingVLANs(port, c.VLANs)) > 0
+}
+
+// waitForActiveDevice waits until the device is provisioned and returns it.
+func waitForActiveDevice(client *packngo.Client, deviceID string, checkPeriod, checkTimeout time.Duration) (*packngo.Device, error) {
+	var device *packngo.Device
+	err := wait.PollImmediate(checkPeriod, checkTimeout, func() (bool, error) {
+		var (
+			response *packngo.Response
+			err      error
+		)
+		device, response, err = client.Devices.Get(deviceID, nil)
+		if err != nil {
+			return false, metalErrorToTerminalError(err, response, "failed to get device")
+		}
+		if device.State == "failed" {
+			return false, fmt.Errorf("%w: device %s failed to provision", cloudprovidererrors.ErrInstanceFailed, deviceID)
+		}
+		return device.State == "active", nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for device %s to become active: %w", deviceID, err)
+	}
+
+	return device, nil
+}
+
+// configureNetworkPorts converts the ports of the active device to the configured network type and assigns the
+// missing VLANs. The device is updated with the converted ports. Only the ports are configured, the VLAN interfaces
+// of the operating system have to be configured out of band, e.g. by a DaemonSet.
+func configureNetworkPorts(client *packngo.Client, device *packngo.Device, c *Config) error {
+	if device.NetworkType != c.NetworkType {
+		klog.Infof("Converting network ports of device %s from %s to %s", device.ID, device.NetworkType, c.NetworkType)
+		converted, err := client.DevicePorts.DeviceToNetworkType(device.ID, c.NetworkType)
+		if err != nil {
+			return fmt.Errorf("failed to convert network ports of device %s to %s: %v", device.ID, c.NetworkType, err)
+		}
+		*device = *converted
+	}
+
+	portName := vlanPortName(c.NetworkType)
+	port := findPort(device, portName)
+	if port == nil {
+		return fmt.Errorf("device %s has no port %s to assign VLANs to", device.ID, portName)
+	}
+
+	for _, vlan := range missingVLANs(port, c.VLANs) {
+		klog.Infof("Assigning VLAN %s to port %s of device %s", vlan, portName, device.ID)
+		assigned, response, err := client.DevicePorts.Assign(&packngo.PortAssignRequest{PortID: port.ID, VirtualNetworkID: vlan})
+		if err != nil {
+			return metalErrorToTerminalError(err, response, fmt.Sprintf("failed to assign VLAN %s", vlan))
+		}
+		port.AttachedVirtualNetworks = assigned.AttachedVirtualNetworks
+	}
+
+	return nil
+}
+
+// vlanPortName returns the port which carries the VLANs in the given network type. In hybrid mode eth1 is taken
+// out of the bond, while bond0 keeps the layer 3 addresses.
+func vlanPortName(networkType string) string {
+	if networkType == networkTypeHybrid {
+		return "eth1"
+	}
+	return "bond0"
+}
+
+func findPort(device *packngo.Device, name string) *packngo.Port {
+	for i := range device.NetworkPorts {
+		if device.NetworkPorts[i].Name == name {
+			return &device.NetworkPorts[i]
+		}
+	}
+	return nil
+}
+
+// missingVLANs returns the VLANs which aren't attached to the port yet.
+func missingVLANs(port *packngo.Port, vlans []string) []string {
+	attached := make([]string, 0, len(port.AttachedVirtualNetworks))
+	for _, vlan := range port.AttachedVirtualNetworks {
+		id := vlan.ID
+		if id == "" {
+			id = path.Base(vlan.Href)
+		}
+		attached = append(attached, id)
+	}
+	return itemsNotInList(attached, vlans)
+}
+
+// assignElasticIP assigns the address of the elastic IP reservation to the device. Existing assignments
+// to other devices are removed first, so the address floats to the new device.
+func assignElasticIP(client *packngo.Client, reservationID, deviceID string) error {
+	reservation, response, err := client.ProjectIPs.Get(reservationID, nil)
+	if err != nil {
+		return metalErrorToTerminalError(err, response, "failed to get elastic IP reservation")
+	}
+
+	for _, assignment := range reservation.Assignments {
+		assignmentID := path.Base(assignment.Href)
+		assignedDeviceID, err := getAssignedDeviceID(client, assignmentID)
+		if err != nil {
+			return err
+		}
+		if assignedDeviceID == deviceID {
+			return nil
+		}
+
+		// Only the assignment gets removed here, the reservation must stay as it is shared between devices.
+		klog.Infof("Unassigning elastic IP %s from device %s", reservation.Address, assignedDeviceID)
+		if response, err := client.DeviceIPs.Unassign(assignmentID); err != nil {
+			return metalErrorToTerminalError(err, response, "failed to unassign elastic IP")
+		}
+	}
+
+	klog.Infof("Assigning elastic IP %s to device %s", reservation.Address, deviceID)
+	address := &packngo.AddressStruct{Address: fmt.Sprintf("%s/%d", reservation.Address, reservation.CIDR)}
+	if _, response, err := client.DeviceIPs.Assign(deviceID, address); err != nil {
+		return metalErrorToTerminalError(err, response, "failed to assign elastic IP")
+	}
+
+	return nil
+}
+
+// releaseElasticIP removes the assignment of the elastic IP reservation to the device, if there is any.
+// The reservation itself is left untouched.
+func releaseElasticIP(client *packngo.Client, reservationID, deviceID string) error {
+	reservation, response, err := client.ProjectIPs.Get(reservationID, nil)
+	if err != nil {
+		return metalErrorToTerminalError(err, response, "failed to get elastic IP reservation")
+	}
+
+	for _, assignment := range reservation.Assignments {
+		assignmentID := path.Base(assignment.Href)
+		assignedDeviceID, err := getAssignedDeviceID(client, assignmentID)
+		if err != nil {
+			return err
+		}
+		if assignedDeviceID != deviceID {
+			continue
+		}
+
+		klog.Infof("Releasing elastic IP %s from device %s", reservation.Address, deviceID)
+		if response, err := client.DeviceIPs.Unassign(assignmentID); err != nil {
+			return metalErrorToTerminalError(err, response, "failed to release elastic IP")
+		}
+	}
+
+	return nil
+}
+
+func getAssignedDeviceID(client *packngo.Client, assignmentID string) (string, error) {
+	assignment, response, err := client.DeviceIPs.Get(assignmentID, nil)
+	if err != nil {
+		return "", metalErrorToTerminalError(err, response, "failed to get elastic IP assignment")
+	}
+	return path.Base(assignment.AssignedTo.Href), nil
+}
+
 // given a defined Kubermatic constant for an operating system, return the canonical slug for Equinix Metal
 func getNameForOS(os providerconfigtypes.OperatingSystem) (string, error) {
 	switch os {
@@ -460,11 +996,12 @@ func generateTag(ID string) string {
 }
 
 func getTagUID(tag string) (string, error) {
-	parts := strings.Split(tag, ":")
-	if len(parts) < 2 || parts[0] != machineUIDTag {
+	// the prefix of the tag contains a colon itself
+	uid := strings.TrimPrefix(tag, machineUIDTag+":")
+	if uid == tag || uid == "" {
 		return "", fmt.Errorf("not a machine UID tag")
 	}
-	return parts[1], nil
+	return uid, nil
 }
 
 // metalErrorToTerminalError judges if the given error
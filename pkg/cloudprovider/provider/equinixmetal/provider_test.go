@@ -0,0 +1,805 @@
+/*
+Copyright 2019 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package equinixmetal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/packethost/packngo"
+
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+)
+
+const testReservationID = "reservation-1"
+
+// fakeMetalAPI serves the subset of the Equinix Metal IP API used for elastic IPs.
+type fakeMetalAPI struct {
+	lock sync.Mutex
+	// assignments maps assignment IDs to device IDs
+	assignments        map[string]string
+	reservationRemoved bool
+	nextAssignmentID   int
+}
+
+func (f *fakeMetalAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/ips/"+testReservationID:
+		reservation := packngo.IPAddressReservation{}
+		reservation.ID = testReservationID
+		reservation.Address = "147.75.0.1"
+		reservation.CIDR = 32
+		for id := range f.assignments {
+			reservation.Assignments = append(reservation.Assignments, packngo.Href{Href: "/ips/" + id})
+		}
+		writeJSON(w, reservation)
+
+	case r.Method == http.MethodDelete && r.URL.Path == "/ips/"+testReservationID:
+		f.reservationRemoved = true
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/ips/"):
+		id := strings.TrimPrefix(r.URL.Path, "/ips/")
+		deviceID, ok := f.assignments[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assignment := packngo.IPAddressAssignment{AssignedTo: packngo.Href{Href: "/devices/" + deviceID}}
+		assignment.ID = id
+		writeJSON(w, assignment)
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/ips/"):
+		delete(f.assignments, strings.TrimPrefix(r.URL.Path, "/ips/"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/devices/") && strings.HasSuffix(r.URL.Path, "/ips"):
+		address := packngo.AddressStruct{}
+		if err := json.NewDecoder(r.Body).Decode(&address); err != nil || address.Address != "147.75.0.1/32" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		deviceID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/devices/"), "/ips")
+		f.nextAssignmentID++
+		id := fmt.Sprintf("assignment-%d", f.nextAssignmentID)
+		f.assignments[id] = deviceID
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, packngo.IPAddressAssignment{AssignedTo: packngo.Href{Href: "/devices/" + deviceID}})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestElasticIP(t *testing.T) {
+	tests := []struct {
+		name                string
+		initialAssignments  map[string]string
+		release             bool
+		expectedAssignments []string
+	}{
+		{
+			name:                "assign on create",
+			initialAssignments:  map[string]string{},
+			expectedAssignments: []string{"device-1"},
+		},
+		{
+			name:                "reassign from another device on create",
+			initialAssignments:  map[string]string{"assignment-old": "device-2"},
+			expectedAssignments: []string{"device-1"},
+		},
+		{
+			name:                "assign on create is idempotent",
+			initialAssignments:  map[string]string{"assignment-old": "device-1"},
+			expectedAssignments: []string{"device-1"},
+		},
+		{
+			name:                "release on delete",
+			initialAssignments:  map[string]string{"assignment-old": "device-1"},
+			release:             true,
+			expectedAssignments: []string{},
+		},
+		{
+			name:                "release on delete keeps assignment of another device",
+			initialAssignments:  map[string]string{"assignment-old": "device-2"},
+			release:             true,
+			expectedAssignments: []string{"device-2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &fakeMetalAPI{assignments: test.initialAssignments}
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			client, err := packngo.NewClientWithBaseURL("kubermatic", "token", nil, server.URL+"/")
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			if test.release {
+				err = releaseElasticIP(client, testReservationID, "device-1")
+			} else {
+				err = assignElasticIP(client, testReservationID, "device-1")
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if api.reservationRemoved {
+				t.Errorf("elastic IP reservation must not be removed")
+			}
+
+			devices := []string{}
+			for _, deviceID := range api.assignments {
+				devices = append(devices, deviceID)
+			}
+			if strings.Join(devices, ",") != strings.Join(test.expectedAssignments, ",") {
+				t.Errorf("expected elastic IP to be assigned to %v, got %v", test.expectedAssignments, devices)
+			}
+		})
+	}
+}
+
+func TestValidateFacilities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/projects/project-1/facilities" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string][]packngo.Facility{
+			"facilities": {{Code: "ams1"}, {Code: "ny5"}},
+		})
+	}))
+	defer server.Close()
+
+	client, err := packngo.NewClientWithBaseURL("kubermatic", "token", nil, server.URL+"/")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		facilities    []string
+		expectedError string
+	}{
+		{
+			name:       "valid facilities",
+			facilities: []string{"ams1", "ny5"},
+		},
+		{
+			name:          "invalid facility code",
+			facilities:    []string{"ams1", "ny7"},
+			expectedError: "unknown facilities: ny7, valid facilities: ams1,ny5",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateFacilities(client, "project-1", test.facilities)
+			if test.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Fatalf("expected error %q, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestMapDeviceState(t *testing.T) {
+	tests := []struct {
+		state    string
+		expected instance.Status
+	}{
+		{state: "queued", expected: instance.StatusCreating},
+		{state: "provisioning", expected: instance.StatusCreating},
+		{state: "reinstalling", expected: instance.StatusCreating},
+		{state: "active", expected: instance.StatusRunning},
+		{state: "rebooting", expected: instance.StatusRunning},
+		{state: "powering_on", expected: instance.StatusRunning},
+		{state: "powering_off", expected: instance.StatusStopped},
+		{state: "inactive", expected: instance.StatusStopped},
+		{state: "deprovisioning", expected: instance.StatusDeleting},
+		{state: "failed", expected: instance.StatusUnknown},
+		{state: "", expected: instance.StatusUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.state, func(t *testing.T) {
+			if status := mapDeviceState(test.state); status != test.expected {
+				t.Errorf("expected status %q for device state %q, got %q", test.expected, test.state, status)
+			}
+		})
+	}
+}
+
+func TestMachineMetricsLabels(t *testing.T) {
+	c := &Config{
+		InstanceType: "c3.small.x86",
+		Facilities:   []string{"ams1", "fra2"},
+		BillingCycle: "hourly",
+		ProjectID:    "project-1",
+		Tags:         []string{"foo"},
+	}
+
+	expected := map[string]string{
+		"size":         "c3.small.x86",
+		"facilities":   "ams1,fra2",
+		"billingCycle": "hourly",
+	}
+	if labels := machineMetricsLabels(c); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+}
+
+func testEvents() []packngo.Event {
+	at := func(minute int) *packngo.Timestamp {
+		return &packngo.Timestamp{Time: time.Date(2022, 6, 1, 12, minute, 0, 0, time.UTC)}
+	}
+	return []packngo.Event{
+		{Interpolated: "Provisioning started", CreatedAt: at(0)},
+		{Body: "Provisioning failed: no hardware available", CreatedAt: at(20)},
+		{Interpolated: "Network configured", CreatedAt: at(5)},
+		{CreatedAt: at(30)},
+		{Interpolated: "Deployed OS", CreatedAt: at(10)},
+	}
+}
+
+func TestEventsSummary(t *testing.T) {
+	expected := "Provisioning failed: no hardware available; Deployed OS; Network configured"
+	if summary := eventsSummary(testEvents()); summary != expected {
+		t.Errorf("expected summary %q, got %q", expected, summary)
+	}
+	if summary := eventsSummary(nil); summary != "" {
+		t.Errorf("expected an empty summary without events, got %q", summary)
+	}
+}
+
+func TestStatusMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   *metalDevice
+		expected string
+	}{
+		{
+			name:     "provisioning",
+			device:   &metalDevice{device: &packngo.Device{DeviceRaw: packngo.DeviceRaw{State: "provisioning", ProvisionPer: 42.5}}},
+			expected: "device is provisioning, 42% done",
+		},
+		{
+			name:     "failed",
+			device:   &metalDevice{device: &packngo.Device{DeviceRaw: packngo.DeviceRaw{State: "failed"}}, events: testEvents()[:2]},
+			expected: "device failed: Provisioning failed: no hardware available; Provisioning started",
+		},
+		{
+			name:     "failed without events",
+			device:   &metalDevice{device: &packngo.Device{DeviceRaw: packngo.DeviceRaw{State: "failed"}}},
+			expected: "device failed",
+		},
+		{
+			name:   "active",
+			device: &metalDevice{device: &packngo.Device{DeviceRaw: packngo.DeviceRaw{State: "active"}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if message := test.device.StatusMessage(); message != test.expected {
+				t.Errorf("expected status message %q, got %q", test.expected, message)
+			}
+		})
+	}
+}
+
+func TestFailedDeviceError(t *testing.T) {
+	now := time.Date(2022, 6, 1, 13, 0, 0, 0, time.UTC)
+	recentFailure := []packngo.Event{
+		{Interpolated: "Provisioning started", CreatedAt: &packngo.Timestamp{Time: time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)}},
+		{Type: "instance.provisioning.failed", Body: "Provisioning failed", CreatedAt: &packngo.Timestamp{Time: time.Date(2022, 6, 1, 12, 55, 0, 0, time.UTC)}},
+	}
+	tests := []struct {
+		name   string
+		device *packngo.Device
+		events []packngo.Event
+		failed bool
+	}{
+		{
+			name:   "failure event older than the timeout",
+			device: &packngo.Device{DeviceRaw: packngo.DeviceRaw{ID: "device-1", State: "failed", Updated: "2022-06-01T12:55:00Z"}},
+			events: testEvents(),
+			failed: true,
+		},
+		{
+			name:   "failure event within the timeout",
+			device: &packngo.Device{DeviceRaw: packngo.DeviceRaw{ID: "device-1", State: "failed", Updated: "2022-06-01T12:30:00Z"}},
+			events: recentFailure,
+		},
+		{
+			name:   "failed for longer than the timeout without events",
+			device: &packngo.Device{DeviceRaw: packngo.DeviceRaw{ID: "device-1", State: "failed", Updated: "2022-06-01T12:30:00Z"}},
+			failed: true,
+		},
+		{
+			name:   "failed with an unknown time",
+			device: &packngo.Device{DeviceRaw: packngo.DeviceRaw{ID: "device-1", State: "failed"}},
+		},
+		{
+			name:   "provisioning for longer than the timeout",
+			device: &packngo.Device{DeviceRaw: packngo.DeviceRaw{ID: "device-1", State: "provisioning", Updated: "2022-06-01T12:30:00Z"}},
+			events: testEvents(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := failedDeviceError(test.device, test.events, now, 10*time.Minute)
+			if !test.failed {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if !cloudprovidererrors.IsInstanceFailed(err) {
+				t.Fatalf("expected an instance failed error, got %v", err)
+			}
+			if len(test.events) > 0 && !strings.Contains(err.Error(), "no hardware available") {
+				t.Errorf("expected the error message to contain the latest event, got %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateStorage(t *testing.T) {
+	tests := []struct {
+		name        string
+		storage     string
+		expectError bool
+	}{
+		{
+			name: "no storage layout",
+		},
+		{
+			name:    "RAID layout",
+			storage: `{"disks":[{"device":"/dev/sda","partitions":[{"label":"ROOT","number":1,"size":0}]}],"raid":[{"devices":["/dev/sda1","/dev/sdb1"],"level":"1","name":"/dev/md/ROOT"}],"filesystems":[{"mount":{"device":"/dev/md/ROOT","format":"ext4","point":"/"}}]}`,
+		},
+		{
+			name:        "malformed JSON",
+			storage:     `{"disks":[`,
+			expectError: true,
+		},
+		{
+			name:        "no JSON object",
+			storage:     `["/dev/sda"]`,
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateStorage(test.storage)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got: %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+// fakeDeviceAPI serves the subset of the Equinix Metal device API used to migrate the UID of a device. Updates of
+// the tags only become visible after the next read, like in the eventually consistent API.
+type fakeDeviceAPI struct {
+	lock        sync.Mutex
+	device      packngo.Device
+	pendingTags *[]string
+}
+
+func (f *fakeDeviceAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/projects/project-1/devices":
+		writeJSON(w, map[string][]packngo.Device{"devices": {f.device}})
+
+	case r.Method == http.MethodGet && r.URL.Path == "/devices/"+f.device.ID:
+		writeJSON(w, f.device)
+		if f.pendingTags != nil {
+			f.device.Tags = *f.pendingTags
+			f.pendingTags = nil
+		}
+
+	case r.Method == http.MethodPut && r.URL.Path == "/devices/"+f.device.ID:
+		update := packngo.DeviceUpdateRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil || update.Tags == nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		f.pendingTags = update.Tags
+		writeJSON(w, f.device)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestMigrateDeviceUID(t *testing.T) {
+	api := &fakeDeviceAPI{}
+	api.device.ID = "device-1"
+	api.device.Tags = []string{"team-a", generateTag("old-uid")}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	client, err := packngo.NewClientWithBaseURL("kubermatic", "token", nil, server.URL+"/")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	device, err := getDeviceByTag(client, "project-1", generateTag("old-uid"))
+	if err != nil || device == nil {
+		t.Fatalf("expected to find the device by its old UID, got %v, %v", device, err)
+	}
+
+	if err := migrateDeviceUID(client, device, "new-uid", time.Millisecond, time.Second); err != nil {
+		t.Fatalf("failed to migrate UID: %v", err)
+	}
+
+	if device, err := getDeviceByTag(client, "project-1", generateTag("new-uid")); err != nil || device == nil {
+		t.Errorf("expected to find the device by its new UID, got %v, %v", device, err)
+	}
+	if device, err := getDeviceByTag(client, "project-1", generateTag("old-uid")); err != nil || device != nil {
+		t.Errorf("expected to not find the device by its old UID, got %v, %v", device, err)
+	}
+	if !itemInList(api.device.Tags, "team-a") {
+		t.Errorf("expected other tags to be kept, got %v", api.device.Tags)
+	}
+}
+
+func TestWaitForActiveDevice(t *testing.T) {
+	tests := []struct {
+		name          string
+		states        []string
+		expectedError bool
+	}{
+		{
+			name:   "provisioned device",
+			states: []string{"queued", "provisioning", "active"},
+		},
+		{
+			name:          "failed device",
+			states:        []string{"provisioning", "failed"},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			states := test.states
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != "/devices/device-1" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				writeJSON(w, packngo.Device{DeviceRaw: packngo.DeviceRaw{ID: "device-1", State: states[0]}})
+				if len(states) > 1 {
+					states = states[1:]
+				}
+			}))
+			defer server.Close()
+
+			client, err := packngo.NewClientWithBaseURL("kubermatic", "token", nil, server.URL+"/")
+			if err != nil {
+				t.Fatalf("failed to create client: %v", err)
+			}
+
+			device, err := waitForActiveDevice(client, "device-1", time.Millisecond, time.Second)
+			if test.expectedError {
+				if !cloudprovidererrors.IsInstanceFailed(err) {
+					t.Fatalf("expected an instance failed error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to wait for the device: %v", err)
+			}
+			if device.State != "active" {
+				t.Errorf("expected device to be active, got %s", device.State)
+			}
+		})
+	}
+}
+
+func TestGetTagUID(t *testing.T) {
+	if uid, err := getTagUID(generateTag("uid-1")); err != nil || uid != "uid-1" {
+		t.Errorf("expected UID uid-1, got %q, %v", uid, err)
+	}
+	for _, tag := range []string{"team-a", "kubermatic-machine-controller:other", machineUIDTag + ":"} {
+		if _, err := getTagUID(tag); err == nil {
+			t.Errorf("expected %q to not be a UID tag", tag)
+		}
+	}
+}
+
+func TestValidateNetworkPorts(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *Config
+		expectError bool
+	}{
+		{
+			name:   "layer3",
+			config: &Config{NetworkType: "layer3"},
+		},
+		{
+			name:   "hybrid without VLANs",
+			config: &Config{NetworkType: "hybrid"},
+		},
+		{
+			name:   "hybrid with VLANs and elastic IP",
+			config: &Config{NetworkType: "hybrid", VLANs: []string{"vlan-1"}, ElasticIPReservationID: testReservationID},
+		},
+		{
+			name:        "layer3 with VLANs",
+			config:      &Config{NetworkType: "layer3", VLANs: []string{"vlan-1"}},
+			expectError: true,
+		},
+		{
+			name:        "layer2-bonded",
+			config:      &Config{NetworkType: "layer2-bonded", VLANs: []string{"vlan-1"}},
+			expectError: true,
+		},
+		{
+			name:        "unknown network type",
+			config:      &Config{NetworkType: "layer2-individual"},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateNetworkPorts(test.config)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got: %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestVLANFacilities(t *testing.T) {
+	vlans := map[string]packngo.VirtualNetwork{
+		"vlan-1": {ID: "vlan-1", FacilityCode: "ams1"},
+		"vlan-2": {ID: "vlan-2", FacilityCode: "ams1"},
+		"vlan-3": {ID: "vlan-3", FacilityCode: "ny5"},
+		"vlan-4": {ID: "vlan-4"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vlan, ok := vlans[strings.TrimPrefix(r.URL.Path, "/virtual-networks/")]
+		if r.Method != http.MethodGet || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, vlan)
+	}))
+	defer server.Close()
+
+	client, err := packngo.NewClientWithBaseURL("kubermatic", "token", nil, server.URL+"/")
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	tests := []struct {
+		name               string
+		vlans              []string
+		facilities         []string
+		expectedFacilities []string
+		expectedError      string
+	}{
+		{
+			name:               "no VLANs",
+			facilities:         []string{"ams1", "ny5"},
+			expectedFacilities: []string{"ams1", "ny5"},
+		},
+		{
+			name:               "VLAN in the facility",
+			vlans:              []string{"vlan-1"},
+			facilities:         []string{"ams1"},
+			expectedFacilities: []string{"ams1"},
+		},
+		{
+			name:               "VLANs in one of the facilities",
+			vlans:              []string{"vlan-1", "vlan-2"},
+			facilities:         []string{"ny5", "ams1"},
+			expectedFacilities: []string{"ams1"},
+		},
+		{
+			name:          "VLAN in another facility",
+			vlans:         []string{"vlan-3"},
+			facilities:    []string{"ams1", "sv15"},
+			expectedError: "VLAN vlan-3 is in facility ny5, which is not one of the requested facilities ams1,sv15",
+		},
+		{
+			name:          "VLANs in different facilities",
+			vlans:         []string{"vlan-1", "vlan-3"},
+			facilities:    []string{"ams1", "ny5"},
+			expectedError: "the VLANs are in different facilities, VLAN vlan-3 is in facility ny5, the others in ams1",
+		},
+		{
+			name:          "metro VLAN",
+			vlans:         []string{"vlan-4"},
+			facilities:    []string{"ams1"},
+			expectedError: "VLAN vlan-4 is not bound to a facility, metro scoped VLANs are not supported",
+		},
+		{
+			name:          "unknown VLAN",
+			vlans:         []string{"vlan-1", "vlan-5"},
+			facilities:    []string{"ams1"},
+			expectedError: "VLAN vlan-5 not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			facilities, err := vlanFacilities(client, test.vlans, test.facilities)
+			if test.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(facilities, test.expectedFacilities) {
+					t.Errorf("expected facilities %v, got %v", test.expectedFacilities, facilities)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Fatalf("expected error %q, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+// fakeDevicePorts implements the port operations used to configure the network of a device.
+type fakeDevicePorts struct {
+	packngo.DevicePortService
+	device    packngo.Device
+	converted []string
+	assigned  []string
+}
+
+func (f *fakeDevicePorts) DeviceToNetworkType(deviceID, networkType string) (*packngo.Device, error) {
+	f.converted = append(f.converted, networkType)
+	f.device.NetworkType = networkType
+	device := f.device
+	return &device, nil
+}
+
+func (f *fakeDevicePorts) Assign(request *packngo.PortAssignRequest) (*packngo.Port, *packngo.Response, error) {
+	f.assigned = append(f.assigned, request.PortID+"/"+request.VirtualNetworkID)
+	for i, port := range f.device.NetworkPorts {
+		if port.ID == request.PortID {
+			// the API only returns the href of attached VLANs
+			port.AttachedVirtualNetworks = append(port.AttachedVirtualNetworks, packngo.VirtualNetwork{Href: "/virtual-networks/" + request.VirtualNetworkID})
+			f.device.NetworkPorts[i] = port
+			return &port, nil, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("port %s not found", request.PortID)
+}
+
+func TestConfigureNetworkPorts(t *testing.T) {
+	ports := func(attached ...string) []packngo.Port {
+		eth1 := packngo.Port{ID: "eth1-id", Name: "eth1"}
+		for _, vlan := range attached {
+			eth1.AttachedVirtualNetworks = append(eth1.AttachedVirtualNetworks, packngo.VirtualNetwork{ID: vlan})
+		}
+		return []packngo.Port{{ID: "bond0-id", Name: "bond0"}, eth1}
+	}
+
+	tests := []struct {
+		name              string
+		networkType       string
+		attached          []string
+		config            *Config
+		expectedPending   bool
+		expectedConverted []string
+		expectedAssigned  []string
+	}{
+		{
+			name:        "layer3 is left alone",
+			networkType: "layer3",
+			config:      &Config{NetworkType: "layer3"},
+		},
+		{
+			name:              "convert to hybrid and assign VLANs to eth1",
+			networkType:       "layer3",
+			config:            &Config{NetworkType: "hybrid", VLANs: []string{"vlan-1", "vlan-2"}},
+			expectedPending:   true,
+			expectedConverted: []string{"hybrid"},
+			expectedAssigned:  []string{"eth1-id/vlan-1", "eth1-id/vlan-2"},
+		},
+		{
+			name:              "convert to layer2-bonded and assign VLANs to bond0",
+			networkType:       "layer3",
+			config:            &Config{NetworkType: "layer2-bonded", VLANs: []string{"vlan-1"}},
+			expectedPending:   true,
+			expectedConverted: []string{"layer2-bonded"},
+			expectedAssigned:  []string{"bond0-id/vlan-1"},
+		},
+		{
+			name:             "assign missing VLAN only",
+			networkType:      "hybrid",
+			attached:         []string{"vlan-1"},
+			config:           &Config{NetworkType: "hybrid", VLANs: []string{"vlan-1", "vlan-2"}},
+			expectedPending:  true,
+			expectedAssigned: []string{"eth1-id/vlan-2"},
+		},
+		{
+			name:        "already configured",
+			networkType: "hybrid",
+			attached:    []string{"vlan-1"},
+			config:      &Config{NetworkType: "hybrid", VLANs: []string{"vlan-1"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := &packngo.Device{DeviceRaw: packngo.DeviceRaw{ID: "device-1", State: "active", NetworkPorts: ports(test.attached...)}, NetworkType: test.networkType}
+			fake := &fakeDevicePorts{device: *device}
+			client := &packngo.Client{DevicePorts: fake}
+
+			if pending := networkPortsPending(device, test.config); pending != test.expectedPending {
+				t.Fatalf("expected network ports pending: %t, got: %t", test.expectedPending, pending)
+			}
+			if !test.expectedPending {
+				return
+			}
+
+			if err := configureNetworkPorts(client, device, test.config); err != nil {
+				t.Fatalf("failed to configure network ports: %v", err)
+			}
+			if !reflect.DeepEqual(fake.converted, test.expectedConverted) {
+				t.Errorf("expected conversions %v, got %v", test.expectedConverted, fake.converted)
+			}
+			if !reflect.DeepEqual(fake.assigned, test.expectedAssigned) {
+				t.Errorf("expected VLAN assignments %v, got %v", test.expectedAssigned, fake.assigned)
+			}
+			if device.NetworkType != test.config.NetworkType {
+				t.Errorf("expected device to have network type %s, got %s", test.config.NetworkType, device.NetworkType)
+			}
+			if networkPortsPending(device, test.config) {
+				t.Error("expected network ports to be configured")
+			}
+		})
+	}
+}
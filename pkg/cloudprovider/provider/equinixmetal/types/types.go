@@ -28,6 +28,22 @@ type RawConfig struct {
 	InstanceType providerconfigtypes.ConfigVarString   `json:"instanceType"`
 	Facilities   []providerconfigtypes.ConfigVarString `json:"facilities"`
 	Tags         []providerconfigtypes.ConfigVarString `json:"tags,omitempty"`
+	// ElasticIPReservationID is the ID of a reserved elastic IP block, whose address gets assigned to the device.
+	// If the address is assigned to a different device, it gets reassigned. The reservation itself is never removed.
+	ElasticIPReservationID providerconfigtypes.ConfigVarString `json:"elasticIPReservationID,omitempty"`
+	// FailedDeviceTimeout is the duration after which a failed device gets deleted, so that it's recreated.
+	// Defaults to 10m.
+	FailedDeviceTimeout providerconfigtypes.ConfigVarString `json:"failedDeviceTimeout,omitempty"`
+	// Storage is the custom partitioning and RAID (CPR) layout of the device as JSON object, e.g. to set up RAID
+	// arrays and mount points during the provisioning.
+	Storage providerconfigtypes.ConfigVarString `json:"storage,omitempty"`
+	// NetworkType is the network mode of the device ports: layer3 (default) or hybrid. The ports are converted
+	// while the device is created, once it is provisioned. The VLAN interfaces of the operating system aren't
+	// configured by machine-controller.
+	NetworkType providerconfigtypes.ConfigVarString `json:"networkType,omitempty"`
+	// VLANs are the IDs of the virtual networks which get assigned to eth1 of the device in hybrid mode. They have to
+	// be in one of the facilities, the device is placed in their facility. Metro scoped VLANs are not supported.
+	VLANs []providerconfigtypes.ConfigVarString `json:"vlans,omitempty"`
 }
 
 func GetConfig(pconfig providerconfigtypes.Config) (*RawConfig, error) {
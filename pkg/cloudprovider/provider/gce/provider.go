@@ -39,6 +39,7 @@ import (
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/util"
 	"github.com/kubermatic/machine-controller/pkg/providerconfig"
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
 
 	"k8s.io/apimachinery/pkg/types"
 )
@@ -406,6 +407,14 @@ func (p *Provider) SetMetricsForMachines(machines clusterv1alpha1.MachineList) e
 	return nil
 }
 
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p *Provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	return providerconfigtypes.FilterOperatingSystems(func(os providerconfigtypes.OperatingSystem) bool {
+		_, ok := imageProjects[os]
+		return ok
+	})
+}
+
 // newError creates a terminal error matching to the provider interface.
 func newError(reason common.MachineStatusError, msg string, args ...interface{}) error {
 	return errors.TerminalError{
@@ -0,0 +1,85 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubevirt
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+)
+
+// defaultNetworkDataTemplate is a cloud-init network config (version 2) for the default bridge interface.
+const defaultNetworkDataTemplate = `version: 2
+ethernets:
+  eth0:
+{{- if .MACAddress }}
+    match:
+      macaddress: "{{ .MACAddress }}"
+    set-name: eth0
+{{- end }}
+{{- if .CIDR }}
+    addresses:
+    - {{ .CIDR }}
+{{- end }}
+{{- if .Gateway }}
+    gateway4: {{ .Gateway }}
+{{- end }}
+{{- if .DNSServers }}
+    nameservers:
+      addresses:
+{{- range .DNSServers }}
+      - {{ . }}
+{{- end }}
+{{- end }}
+`
+
+// networkDataTemplateData is passed to the network data template.
+type networkDataTemplateData struct {
+	MACAddress string
+	CIDR       string
+	Gateway    string
+	DNSServers []string
+}
+
+// renderNetworkData renders the cloud-init network data for the given network config. If networkDataTemplate
+// is empty, the default template is used.
+func renderNetworkData(networkDataTemplate string, network *providerconfigtypes.NetworkConfig, macAddress string) (string, error) {
+	if networkDataTemplate == "" {
+		networkDataTemplate = defaultNetworkDataTemplate
+	}
+
+	tmpl, err := template.New("networkdata").Parse(networkDataTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse network data template: %v", err)
+	}
+
+	data := networkDataTemplateData{
+		MACAddress: macAddress,
+		CIDR:       network.CIDR,
+		Gateway:    network.Gateway,
+		DNSServers: network.DNS.Servers,
+	}
+
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, data); err != nil {
+		return "", fmt.Errorf("failed to execute network data template: %v", err)
+	}
+
+	return b.String(), nil
+}
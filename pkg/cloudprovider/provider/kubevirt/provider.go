@@ -41,10 +41,14 @@ import (
 	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
 
 	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -65,6 +69,18 @@ const (
 	// machineDeploymentLabelKey defines the label key used to contains as value the MachineDeployment name
 	// which machine comes from.
 	machineDeploymentLabelKey = "md"
+	// vmLabelKey selects the VMI and the pod of a VM.
+	vmLabelKey = "kubevirt.io/vm"
+	// ignitionDataAnnotationKey holds the userdata of Flatcar VMs.
+	ignitionDataAnnotationKey = "kubevirt.io/ignitiondata"
+	// serviceAccountDiskName is the name of the disk and the volume holding the service account token.
+	serviceAccountDiskName = "serviceaccountdisk"
+)
+
+// reservedLabelKeys and reservedAnnotationKeys are managed by the machine-controller.
+var (
+	reservedLabelKeys      = sets.NewString(vmLabelKey, machineDeploymentLabelKey)
+	reservedAnnotationKeys = sets.NewString(ignitionDataAnnotationKey)
 )
 
 var supportedOS = map[providerconfigtypes.OperatingSystem]*struct{}{
@@ -91,15 +107,48 @@ type Config struct {
 	DNSPolicy             corev1.DNSPolicy
 	CPUs                  string
 	Memory                string
+	CPURequest            string
+	MemoryRequest         string
+	EphemeralStorage      string
+	Hugepages             string
 	Namespace             string
 	OsImage               OSImage
 	StorageClassName      string
 	PVCSize               resource.Quantity
+	VolumeMode            *corev1.PersistentVolumeMode
+	Preallocation         *bool
 	FlavorName            string
 	SecondaryDisks        []SecondaryDisks
 	PodAffinityPreset     AffinityType
 	PodAntiAffinityPreset AffinityType
 	NodeAffinityPreset    NodeAffinityPreset
+	NodeSelector          map[string]string
+	NetworkData           string
+	InstancetypeRef       *ResourceRef
+	LivenessProbe         *kubevirtv1.Probe
+	Firmware              *kubevirtv1.Firmware
+	Labels                map[string]string
+	Annotations           map[string]string
+	ServiceAccountName    string
+	PriorityClassName     string
+}
+
+// ResourceRef references a namespaced or cluster scoped KubeVirt resource.
+type ResourceRef struct {
+	Name string
+	Kind string
+}
+
+const (
+	instancetypeKind        = "VirtualMachineInstancetype"
+	clusterInstancetypeKind = "VirtualMachineClusterInstancetype"
+)
+
+// instancetypeFlavorKinds maps instancetype kinds onto the flavor kinds of the KubeVirt API version we use,
+// as instancetypes were called flavors before.
+var instancetypeFlavorKinds = map[string]string{
+	instancetypeKind:        "VirtualMachineFlavor",
+	clusterInstancetypeKind: "VirtualMachineClusterFlavor",
 }
 
 type AffinityType string
@@ -131,6 +180,22 @@ func (p *provider) affinityType(affinityType providerconfigtypes.ConfigVarString
 	return "", fmt.Errorf("unknown affinityType: %s", affinityType)
 }
 
+// volumeMode returns the volume mode of a disk, which is nil if the default of the storage class should be used.
+func (p *provider) volumeMode(volumeMode providerconfigtypes.ConfigVarString) (*corev1.PersistentVolumeMode, error) {
+	volumeModeString, err := p.configVarResolver.GetConfigVarStringValue(volumeMode)
+	if err != nil {
+		return nil, err
+	}
+	switch mode := corev1.PersistentVolumeMode(volumeModeString); mode {
+	case "":
+		return nil, nil
+	case corev1.PersistentVolumeBlock, corev1.PersistentVolumeFilesystem:
+		return &mode, nil
+	}
+
+	return nil, fmt.Errorf("unknown volumeMode %q, must be %s or %s", volumeModeString, corev1.PersistentVolumeBlock, corev1.PersistentVolumeFilesystem)
+}
+
 // NodeAffinityPreset
 type NodeAffinityPreset struct {
 	Type   AffinityType
@@ -141,6 +206,8 @@ type NodeAffinityPreset struct {
 type SecondaryDisks struct {
 	Size             resource.Quantity
 	StorageClassName string
+	VolumeMode       *corev1.PersistentVolumeMode
+	Preallocation    *bool
 }
 
 type OSImage struct {
@@ -211,6 +278,22 @@ func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*Config, *p
 	if err != nil {
 		return nil, nil, fmt.Errorf(`failed to get value of "memory" field: %v`, err)
 	}
+	config.CPURequest, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.VirtualMachine.Template.CPURequest)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`failed to get value of "cpuRequest" field: %v`, err)
+	}
+	config.MemoryRequest, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.VirtualMachine.Template.MemoryRequest)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`failed to get value of "memoryRequest" field: %v`, err)
+	}
+	config.EphemeralStorage, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.VirtualMachine.Template.EphemeralStorageRequest)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`failed to get value of "ephemeralStorageRequest" field: %v`, err)
+	}
+	config.Hugepages, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.VirtualMachine.Template.Hugepages)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`failed to get value of "hugepages" field: %v`, err)
+	}
 	config.Namespace = getNamespace()
 	osImage, err := p.configVarResolver.GetConfigVarStringValue(rawConfig.VirtualMachine.Template.PrimaryDisk.OsImage)
 	if err != nil {
@@ -232,6 +315,11 @@ func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*Config, *p
 	if err != nil {
 		return nil, nil, fmt.Errorf(`failed to get value of "storageClassName" field: %v`, err)
 	}
+	config.VolumeMode, err = p.volumeMode(rawConfig.VirtualMachine.Template.PrimaryDisk.VolumeMode)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`failed to parse "volumeMode" field: %v`, err)
+	}
+	config.Preallocation = rawConfig.VirtualMachine.Template.PrimaryDisk.Preallocation
 	config.RestConfig, err = clientcmd.RESTConfigFromKubeConfig([]byte(config.Kubeconfig))
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to decode kubeconfig: %v", err)
@@ -241,6 +329,19 @@ func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*Config, *p
 		return nil, nil, fmt.Errorf(`failed to get value of "flavor.name" field: %v`, err)
 	}
 
+	if ref := rawConfig.VirtualMachine.InstancetypeRef; ref != nil {
+		config.InstancetypeRef, err = p.resourceRef(ref.Name, ref.Kind)
+		if err != nil {
+			return nil, nil, fmt.Errorf(`failed to parse "instancetypeRef" field: %v`, err)
+		}
+		if config.InstancetypeRef.Kind == "" {
+			config.InstancetypeRef.Kind = clusterInstancetypeKind
+		}
+		if err := validateInstancetypeRef(&config); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	dnsPolicyString, err := p.configVarResolver.GetConfigVarStringValue(rawConfig.VirtualMachine.DNSPolicy)
 	if err != nil {
 		return nil, nil, fmt.Errorf(`failed to parse "dnsPolicy" field: %v`, err)
@@ -254,6 +355,28 @@ func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*Config, *p
 	if rawConfig.VirtualMachine.DNSConfig != nil {
 		config.DNSConfig = rawConfig.VirtualMachine.DNSConfig
 	}
+	config.NetworkData, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.VirtualMachine.NetworkData)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`failed to get value of "networkData" field: %v`, err)
+	}
+	config.LivenessProbe, err = getLivenessProbe(rawConfig.VirtualMachine.HealthProbe)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`failed to parse "healthProbe" field: %v`, err)
+	}
+	config.Firmware, err = getFirmware(rawConfig.VirtualMachine.Firmware)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`failed to parse "firmware" field: %v`, err)
+	}
+	config.Labels = rawConfig.VirtualMachine.Labels
+	config.Annotations = rawConfig.VirtualMachine.Annotations
+	config.ServiceAccountName, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.VirtualMachine.ServiceAccountName)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`failed to get value of "serviceAccountName" field: %v`, err)
+	}
+	config.PriorityClassName, err = p.configVarResolver.GetConfigVarStringValue(rawConfig.VirtualMachine.PriorityClassName)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`failed to get value of "priorityClassName" field: %v`, err)
+	}
 	config.SecondaryDisks = make([]SecondaryDisks, 0, len(rawConfig.VirtualMachine.Template.SecondaryDisks))
 	for _, sd := range rawConfig.VirtualMachine.Template.SecondaryDisks {
 
@@ -270,9 +393,15 @@ func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*Config, *p
 		if err != nil {
 			return nil, nil, fmt.Errorf(`failed to parse value of "secondaryDisks.storageClass" field: %v`, err)
 		}
+		volumeMode, err := p.volumeMode(sd.VolumeMode)
+		if err != nil {
+			return nil, nil, fmt.Errorf(`failed to parse "secondaryDisks.volumeMode" field: %v`, err)
+		}
 		config.SecondaryDisks = append(config.SecondaryDisks, SecondaryDisks{
 			Size:             pvc,
 			StorageClassName: scString,
+			VolumeMode:       volumeMode,
+			Preallocation:    sd.Preallocation,
 		})
 	}
 
@@ -289,10 +418,39 @@ func (p *provider) getConfig(provSpec clusterv1alpha1.ProviderSpec) (*Config, *p
 	if err != nil {
 		return nil, nil, fmt.Errorf(`failed to parse "nodeAffinityPreset" field: %v`, err)
 	}
+	config.NodeSelector = rawConfig.Affinity.NodeSelector
 
 	return &config, pconfig, nil
 }
 
+func (p *provider) resourceRef(name, kind providerconfigtypes.ConfigVarString) (*ResourceRef, error) {
+	ref := &ResourceRef{}
+	var err error
+	ref.Name, err = p.configVarResolver.GetConfigVarStringValue(name)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to get value of "name" field: %v`, err)
+	}
+	if ref.Name == "" {
+		return nil, errors.New("name must be set")
+	}
+	ref.Kind, err = p.configVarResolver.GetConfigVarStringValue(kind)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to get value of "kind" field: %v`, err)
+	}
+	return ref, nil
+}
+
+// validateInstancetypeRef checks the kind of the instancetype and that the size of the VM isn't also set otherwise.
+func validateInstancetypeRef(c *Config) error {
+	if _, ok := instancetypeFlavorKinds[c.InstancetypeRef.Kind]; !ok {
+		return fmt.Errorf("unknown instancetype kind %q", c.InstancetypeRef.Kind)
+	}
+	if c.CPUs != "" || c.Memory != "" || c.FlavorName != "" {
+		return errors.New("instancetypeRef can't be used together with cpus, memory or flavor")
+	}
+	return nil
+}
+
 func (p *provider) parseNodeAffinityPreset(nodeAffinityPreset kubevirttypes.NodeAffinityPreset) (NodeAffinityPreset, error) {
 	nodeAffinity := NodeAffinityPreset{}
 	var err error
@@ -394,11 +552,21 @@ func (p *provider) Validate(spec clusterv1alpha1.MachineSpec) error {
 	if err != nil {
 		return fmt.Errorf("failed to parse config: %v", err)
 	}
-	// If VMIPreset is specified, skip CPU and Memory validation
-	if c.FlavorName == "" {
-		if _, err := parseResources(c.CPUs, c.Memory); err != nil {
+	// If VMIPreset or instancetype is specified, skip CPU and Memory validation
+	if c.FlavorName == "" && c.InstancetypeRef == nil {
+		if _, err := getResourceRequirements(c); err != nil {
 			return err
 		}
+		if err := validateHugepages(c); err != nil {
+			return err
+		}
+	} else if c.CPURequest != "" || c.MemoryRequest != "" || c.Hugepages != "" {
+		return errors.New("cpuRequest, memoryRequest and hugepages can't be used together with a flavor or an instancetype")
+	}
+	if c.EphemeralStorage != "" {
+		if _, err := resource.ParseQuantity(c.EphemeralStorage); err != nil {
+			return fmt.Errorf("failed to parse ephemeral storage request: %v", err)
+		}
 	}
 
 	sigClient, err := client.New(c.RestConfig, client.Options{})
@@ -413,6 +581,30 @@ func (p *provider) Validate(spec clusterv1alpha1.MachineSpec) error {
 			return fmt.Errorf("dns config must be specified when dns policy is None")
 		}
 	}
+	if err := validateNodeSelector(c.NodeSelector); err != nil {
+		return fmt.Errorf("invalid nodeSelector: %v", err)
+	}
+	if err := validateMetadata(c.Labels, c.Annotations); err != nil {
+		return err
+	}
+	if c.ServiceAccountName != "" {
+		if errs := validation.IsDNS1123Subdomain(c.ServiceAccountName); len(errs) > 0 {
+			return fmt.Errorf("invalid serviceAccountName %q: %s", c.ServiceAccountName, strings.Join(errs, ", "))
+		}
+	}
+	if c.PriorityClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(c.PriorityClassName); len(errs) > 0 {
+			return fmt.Errorf("invalid priorityClassName %q: %s", c.PriorityClassName, strings.Join(errs, ", "))
+		}
+	}
+	if c.NetworkData != "" {
+		if !pc.Network.IsStaticIPConfig() {
+			return errors.New("networkData can only be set when static networking is configured")
+		}
+		if _, err := renderNetworkData(c.NetworkData, pc.Network, ""); err != nil {
+			return fmt.Errorf("invalid networkData: %v", err)
+		}
+	}
 	// Check if we can reach the API of the target cluster
 	vmi := &kubevirtv1.VirtualMachineInstance{}
 	if err := sigClient.Get(context.Background(), types.NamespacedName{Namespace: c.Namespace, Name: "not-expected-to-exist"}, vmi); err != nil && !kerrors.IsNotFound(err) {
@@ -422,6 +614,68 @@ func (p *provider) Validate(spec clusterv1alpha1.MachineSpec) error {
 	return nil
 }
 
+// validateNodeSelector checks that the node selector only consists of valid label keys with non-empty values.
+func validateNodeSelector(nodeSelector map[string]string) error {
+	for key, value := range nodeSelector {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid key %q: %s", key, strings.Join(errs, ", "))
+		}
+		if value == "" {
+			return fmt.Errorf("value of key %q must not be empty", key)
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value %q of key %q: %s", value, key, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// validateMetadata checks that the labels and annotations of the VM are valid and don't use the keys managed by
+// the machine-controller.
+func validateMetadata(labels, annotations map[string]string) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %q: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value %q of label %q: %s", value, key, strings.Join(errs, ", "))
+		}
+		if reservedLabelKeys.Has(key) {
+			return fmt.Errorf("label %q is managed by the machine-controller and can't be set", key)
+		}
+	}
+
+	for key := range annotations {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid annotation key %q: %s", key, strings.Join(errs, ", "))
+		}
+		if reservedAnnotationKeys.Has(key) {
+			return fmt.Errorf("annotation %q is managed by the machine-controller and can't be set", key)
+		}
+	}
+
+	return nil
+}
+
+// mergeMetadata returns the labels or annotations of the config merged with the ones managed by the
+// machine-controller, which take precedence.
+func mergeMetadata(configured, managed map[string]string) map[string]string {
+	if len(configured) == 0 && len(managed) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]string, len(configured)+len(managed))
+	for key, value := range configured {
+		merged[key] = value
+	}
+	for key, value := range managed {
+		merged[key] = value
+	}
+
+	return merged
+}
+
 func (p *provider) AddDefaults(spec clusterv1alpha1.MachineSpec) (clusterv1alpha1.MachineSpec, error) {
 	return spec, nil
 }
@@ -444,14 +698,32 @@ func (p *provider) MachineMetricsLabels(machine *clusterv1alpha1.Machine) (map[s
 
 	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err == nil {
-		labels["cpus"] = c.CPUs
-		labels["memoryMIB"] = c.Memory
-		labels["osImage"] = c.OsImage.URL
+		labels = machineMetricsLabels(c)
 	}
 
 	return labels, err
 }
 
+// machineMetricsLabels returns the metrics labels of a VM. The size is the name of the instancetype or flavor if
+// one is used, otherwise it's derived from the CPUs and memory, to be comparable with the sizes of other providers.
+func machineMetricsLabels(c *Config) map[string]string {
+	size := fmt.Sprintf("%scpu-%s", c.CPUs, c.Memory)
+	switch {
+	case c.InstancetypeRef != nil:
+		size = c.InstancetypeRef.Name
+	case c.FlavorName != "":
+		size = c.FlavorName
+	}
+
+	return map[string]string{
+		"size":      size,
+		"cpus":      c.CPUs,
+		"memoryMIB": c.Memory,
+		"osImage":   c.OsImage.URL,
+		"namespace": c.Namespace,
+	}
+}
+
 func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidertypes.ProviderData, userdata string) (instance.Instance, error) {
 	c, pc, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err != nil {
@@ -464,11 +736,10 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 	// We add the timestamp because the secret name must be different when we recreate the VMI
 	// because its pod got deleted
 	// The secret has an ownerRef on the VMI so garbace collection will take care of cleaning up
-	terminationGracePeriodSeconds := int64(30)
 	userDataSecretName := fmt.Sprintf("userdata-%s-%s", machine.Name, strconv.Itoa(int(time.Now().Unix())))
 
 	resourceRequirements := kubevirtv1.ResourceRequirements{}
-	labels := map[string]string{"kubevirt.io/vm": machine.Name}
+	labels := map[string]string{vmLabelKey: machine.Name}
 	// Add a common label to all VirtualMachines spawned by the same MachineDeployment (= MachineDeployment name)
 	if mdName, err := controllerutil.GetMachineDeploymentNameForMachine(context.Background(), machine, data.Client); err == nil {
 		labels[machineDeploymentLabelKey] = mdName
@@ -489,13 +760,20 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 		for key, val := range vmiPreset.Spec.Selector.MatchLabels {
 			labels[key] = val
 		}
-	} else {
-		requestsAndLimits, err := parseResources(c.CPUs, c.Memory)
+	} else if c.InstancetypeRef == nil {
+		resourceRequirements, err = getResourceRequirements(c)
 		if err != nil {
 			return nil, err
 		}
-		resourceRequirements.Requests = *requestsAndLimits
-		resourceRequirements.Limits = *requestsAndLimits
+	}
+	if err := setEphemeralStorageRequest(&resourceRequirements, c.EphemeralStorage); err != nil {
+		return nil, err
+	}
+	if err := checkServiceAccountExists(ctx, sigClient, c.Namespace, c.ServiceAccountName); err != nil {
+		return nil, err
+	}
+	if err := checkPriorityClassExists(ctx, sigClient, c.PriorityClassName); err != nil {
+		return nil, err
 	}
 
 	var (
@@ -505,7 +783,7 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 
 	if pc.OperatingSystem == providerconfigtypes.OperatingSystemFlatcar {
 		annotations = map[string]string{
-			"kubevirt.io/ignitiondata": userdata,
+			ignitionDataAnnotationKey: userdata,
 		}
 	}
 
@@ -520,41 +798,42 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 		return nil, fmt.Errorf("could not compute a random MAC address")
 	}
 
+	var networkData string
+	if pc.Network.IsStaticIPConfig() {
+		networkData, err = renderNetworkData(c.NetworkData, pc.Network, defaultBridgeNetwork.MacAddress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render network data: %v", err)
+		}
+	}
+
 	virtualMachine := &kubevirtv1.VirtualMachine{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      machine.Name,
-			Namespace: c.Namespace,
-			Labels:    labels,
+			Name:        machine.Name,
+			Namespace:   c.Namespace,
+			Labels:      mergeMetadata(c.Labels, labels),
+			Annotations: mergeMetadata(c.Annotations, nil),
 		},
 		Spec: kubevirtv1.VirtualMachineSpec{
 			Running: utilpointer.BoolPtr(true),
+			Flavor:  getFlavorMatcher(c),
 			Template: &kubevirtv1.VirtualMachineInstanceTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Annotations: annotations,
-					Labels:      labels,
-				},
-				Spec: kubevirtv1.VirtualMachineInstanceSpec{
-					Networks: []kubevirtv1.Network{
-						*kubevirtv1.DefaultPodNetwork(),
-					},
-					Domain: kubevirtv1.DomainSpec{
-						Devices: kubevirtv1.Devices{
-							Disks:      getVMDisks(c),
-							Interfaces: []kubevirtv1.Interface{*defaultBridgeNetwork},
-						},
-						Resources: resourceRequirements,
-					},
-					Affinity:                      getAffinity(c, machineDeploymentLabelKey, labels[machineDeploymentLabelKey]),
-					TerminationGracePeriodSeconds: &terminationGracePeriodSeconds,
-					Volumes:                       getVMVolumes(c, dataVolumeName, userDataSecretName),
-					DNSPolicy:                     c.DNSPolicy,
-					DNSConfig:                     c.DNSConfig,
+					Annotations: mergeMetadata(c.Annotations, annotations),
+					Labels:      mergeMetadata(c.Labels, labels),
 				},
+				Spec: getVMISpec(c, *defaultBridgeNetwork, resourceRequirements, labels[machineDeploymentLabelKey], dataVolumeName, userDataSecretName, networkData != ""),
 			},
 			DataVolumeTemplates: getDataVolumeTemplates(c, dataVolumeName),
 		},
 	}
 
+	if c.LivenessProbe != nil {
+		// A VMI which fails its liveness probe is stopped and restarted by the RunStrategy Always. It's equivalent
+		// to running: true, but states explicitly that failed VMIs are restarted. Both fields can't be set at once.
+		virtualMachine.Spec.Running = nil
+		virtualMachine.Spec.RunStrategy = runStrategyPtr(kubevirtv1.RunStrategyAlways)
+	}
+
 	if err := sigClient.Create(ctx, virtualMachine); err != nil {
 		return nil, fmt.Errorf("failed to create vmi: %v", err)
 	}
@@ -567,6 +846,9 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 		},
 		Data: map[string][]byte{"userdata": []byte(userdata)},
 	}
+	if networkData != "" {
+		secret.Data["networkdata"] = []byte(networkData)
+	}
 	if err := sigClient.Create(ctx, secret); err != nil {
 		return nil, fmt.Errorf("failed to create secret for userdata: %v", err)
 	}
@@ -574,6 +856,35 @@ func (p *provider) Create(machine *clusterv1alpha1.Machine, data *cloudprovidert
 
 }
 
+// getVMISpec returns the spec of the VMI template of the VirtualMachine.
+func getVMISpec(c *Config, iface kubevirtv1.Interface, resourceRequirements kubevirtv1.ResourceRequirements, machineDeploymentName, dataVolumeName, userDataSecretName string, withNetworkData bool) kubevirtv1.VirtualMachineInstanceSpec {
+	terminationGracePeriodSeconds := int64(30)
+
+	return kubevirtv1.VirtualMachineInstanceSpec{
+		Networks: []kubevirtv1.Network{
+			*kubevirtv1.DefaultPodNetwork(),
+		},
+		Domain: kubevirtv1.DomainSpec{
+			Devices: kubevirtv1.Devices{
+				Disks:      getVMDisks(c),
+				Interfaces: []kubevirtv1.Interface{iface},
+			},
+			Resources: resourceRequirements,
+			Memory:    getMemory(c, resourceRequirements),
+			Firmware:  c.Firmware,
+			Features:  getFeatures(c),
+		},
+		Affinity:                      getAffinity(c, machineDeploymentLabelKey, machineDeploymentName),
+		NodeSelector:                  c.NodeSelector,
+		TerminationGracePeriodSeconds: &terminationGracePeriodSeconds,
+		Volumes:                       getVMVolumes(c, dataVolumeName, userDataSecretName, withNetworkData),
+		DNSPolicy:                     c.DNSPolicy,
+		DNSConfig:                     c.DNSConfig,
+		LivenessProbe:                 c.LivenessProbe,
+		PriorityClassName:             c.PriorityClassName,
+	}
+}
+
 func (p *provider) Cleanup(machine *clusterv1alpha1.Machine, _ *cloudprovidertypes.ProviderData) (bool, error) {
 	c, _, err := p.getConfig(machine.Spec.ProviderSpec)
 	if err != nil {
@@ -615,10 +926,214 @@ func parseResources(cpus, memory string) (*corev1.ResourceList, error) {
 	}, nil
 }
 
+// getResourceRequirements returns the CPUs and memory of the VM as limits. The requests are equal to the limits,
+// which results in the guaranteed QoS class, unless lower requests are configured to overcommit the infra cluster.
+func getResourceRequirements(c *Config) (kubevirtv1.ResourceRequirements, error) {
+	limits, err := parseResources(c.CPUs, c.Memory)
+	if err != nil {
+		return kubevirtv1.ResourceRequirements{}, err
+	}
+
+	requests := limits.DeepCopy()
+	for name, request := range map[corev1.ResourceName]string{corev1.ResourceCPU: c.CPURequest, corev1.ResourceMemory: c.MemoryRequest} {
+		if request == "" {
+			continue
+		}
+
+		quantity, err := resource.ParseQuantity(request)
+		if err != nil {
+			return kubevirtv1.ResourceRequirements{}, fmt.Errorf("failed to parse %s request: %v", name, err)
+		}
+		if limit := (*limits)[name]; quantity.Cmp(limit) > 0 {
+			return kubevirtv1.ResourceRequirements{}, fmt.Errorf("%s request %s must not exceed the limit %s", name, quantity.String(), limit.String())
+		}
+		requests[name] = quantity
+	}
+
+	return kubevirtv1.ResourceRequirements{Requests: requests, Limits: *limits}, nil
+}
+
+// validHugepageSizes are the hugepage sizes supported by KubeVirt on x86_64 nodes.
+var validHugepageSizes = sets.NewString("2Mi", "1Gi")
+
+// validateHugepages checks that the memory of the VM can be backed by hugepages of the configured size. Hugepages
+// can't be overcommitted, so a lower memory request isn't allowed.
+func validateHugepages(c *Config) error {
+	if c.Hugepages == "" {
+		return nil
+	}
+	if !validHugepageSizes.Has(c.Hugepages) {
+		return fmt.Errorf("hugepages must be one of %s, got %q", strings.Join(validHugepageSizes.List(), ", "), c.Hugepages)
+	}
+	if c.MemoryRequest != "" {
+		return errors.New("memoryRequest can't be used together with hugepages")
+	}
+
+	memory, err := resource.ParseQuantity(c.Memory)
+	if err != nil {
+		return fmt.Errorf("failed to parse memory: %v", err)
+	}
+	pageSize := resource.MustParse(c.Hugepages)
+	if memory.Value()%pageSize.Value() != 0 {
+		return fmt.Errorf("memory %s must be a multiple of the hugepage size %s", memory.String(), c.Hugepages)
+	}
+
+	return nil
+}
+
+// getMemory returns the memory settings of the VM, which are needed for hugepages and overcommitted memory. KubeVirt
+// gives the guest the requested memory by default, so the guest memory is set to the limit if the request is lower.
+func getMemory(c *Config, resourceRequirements kubevirtv1.ResourceRequirements) *kubevirtv1.Memory {
+	if c.Hugepages != "" {
+		return &kubevirtv1.Memory{Hugepages: &kubevirtv1.Hugepages{PageSize: c.Hugepages}}
+	}
+
+	request, hasRequest := resourceRequirements.Requests[corev1.ResourceMemory]
+	limit, hasLimit := resourceRequirements.Limits[corev1.ResourceMemory]
+	if !hasRequest || !hasLimit || request.Cmp(limit) == 0 {
+		return nil
+	}
+
+	return &kubevirtv1.Memory{Guest: &limit}
+}
+
+// setEphemeralStorageRequest adds the ephemeral storage request to the resources of the VM, KubeVirt passes it on
+// to the virt-launcher pod.
+func setEphemeralStorageRequest(requirements *kubevirtv1.ResourceRequirements, request string) error {
+	if request == "" {
+		return nil
+	}
+
+	quantity, err := resource.ParseQuantity(request)
+	if err != nil {
+		return fmt.Errorf("failed to parse ephemeral storage request: %v", err)
+	}
+	if requirements.Requests == nil {
+		requirements.Requests = corev1.ResourceList{}
+	}
+	requirements.Requests[corev1.ResourceEphemeralStorage] = quantity
+
+	return nil
+}
+
+// getLivenessProbe maps the health probe onto the liveness probe of the VMI, it returns nil if no probe is configured.
+func getLivenessProbe(healthProbe *kubevirttypes.HealthProbe) (*kubevirtv1.Probe, error) {
+	if healthProbe == nil {
+		return nil, nil
+	}
+
+	probe := &kubevirtv1.Probe{
+		InitialDelaySeconds: healthProbe.InitialDelaySeconds,
+		PeriodSeconds:       healthProbe.PeriodSeconds,
+		TimeoutSeconds:      healthProbe.TimeoutSeconds,
+		FailureThreshold:    healthProbe.FailureThreshold,
+	}
+	for name, value := range map[string]int32{
+		"initialDelaySeconds": probe.InitialDelaySeconds,
+		"periodSeconds":       probe.PeriodSeconds,
+		"timeoutSeconds":      probe.TimeoutSeconds,
+		"failureThreshold":    probe.FailureThreshold,
+	} {
+		if value < 0 {
+			return nil, fmt.Errorf("%s must not be negative", name)
+		}
+	}
+
+	var probeTypes []string
+	if tcp := healthProbe.TCP; tcp != nil {
+		probeTypes = append(probeTypes, "tcp")
+		if err := validatePort(tcp.Port); err != nil {
+			return nil, fmt.Errorf("invalid tcp probe: %v", err)
+		}
+		probe.TCPSocket = &corev1.TCPSocketAction{Port: intstr.FromInt(int(tcp.Port))}
+	}
+	if http := healthProbe.HTTP; http != nil {
+		probeTypes = append(probeTypes, "http")
+		if err := validatePort(http.Port); err != nil {
+			return nil, fmt.Errorf("invalid http probe: %v", err)
+		}
+		scheme := corev1.URISchemeHTTP
+		if http.Scheme != "" {
+			scheme = corev1.URIScheme(strings.ToUpper(http.Scheme))
+		}
+		if scheme != corev1.URISchemeHTTP && scheme != corev1.URISchemeHTTPS {
+			return nil, fmt.Errorf("invalid http probe: scheme must be HTTP or HTTPS, got %q", http.Scheme)
+		}
+		probe.HTTPGet = &corev1.HTTPGetAction{Path: http.Path, Port: intstr.FromInt(int(http.Port)), Scheme: scheme}
+	}
+	if healthProbe.GuestAgentPing {
+		probeTypes = append(probeTypes, "guestAgentPing")
+		probe.GuestAgentPing = &kubevirtv1.GuestAgentPing{}
+	}
+
+	if len(probeTypes) != 1 {
+		return nil, fmt.Errorf("exactly one of tcp, http and guestAgentPing must be set, got %d", len(probeTypes))
+	}
+
+	return probe, nil
+}
+
+const (
+	bootloaderBIOS = "bios"
+	bootloaderEFI  = "efi"
+)
+
+// getFirmware maps the firmware onto the firmware of the VMI, it returns nil for the default BIOS bootloader.
+func getFirmware(firmware *kubevirttypes.Firmware) (*kubevirtv1.Firmware, error) {
+	if firmware == nil {
+		return nil, nil
+	}
+
+	switch strings.ToLower(firmware.Bootloader) {
+	case "", bootloaderBIOS:
+		if firmware.SecureBoot {
+			return nil, fmt.Errorf("secureBoot requires the %s bootloader", bootloaderEFI)
+		}
+		return nil, nil
+	case bootloaderEFI:
+		// KubeVirt enables secure boot by default, so it's always set explicitly
+		return &kubevirtv1.Firmware{
+			Bootloader: &kubevirtv1.Bootloader{
+				EFI: &kubevirtv1.EFI{SecureBoot: utilpointer.BoolPtr(firmware.SecureBoot)},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown bootloader %q, must be %s or %s", firmware.Bootloader, bootloaderBIOS, bootloaderEFI)
+	}
+}
+
+// getFeatures returns the features of the VMI, secure boot requires the System Management Mode.
+func getFeatures(c *Config) *kubevirtv1.Features {
+	if c.Firmware == nil || c.Firmware.Bootloader == nil || c.Firmware.Bootloader.EFI == nil ||
+		!utilpointer.BoolDeref(c.Firmware.Bootloader.EFI.SecureBoot, false) {
+		return nil
+	}
+	return &kubevirtv1.Features{SMM: &kubevirtv1.FeatureState{Enabled: utilpointer.BoolPtr(true)}}
+}
+
+func validatePort(port int32) error {
+	if errs := validation.IsValidPortNum(int(port)); len(errs) > 0 {
+		return fmt.Errorf("invalid port %d: %s", port, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func runStrategyPtr(runStrategy kubevirtv1.VirtualMachineRunStrategy) *kubevirtv1.VirtualMachineRunStrategy {
+	return &runStrategy
+}
+
 func (p *provider) SetMetricsForMachines(machines clusterv1alpha1.MachineList) error {
 	return nil
 }
 
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p *provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	return providerconfigtypes.FilterOperatingSystems(func(os providerconfigtypes.OperatingSystem) bool {
+		_, ok := supportedOS[os]
+		return ok
+	})
+}
+
 func dnsPolicy(policy string) (corev1.DNSPolicy, error) {
 	switch policy {
 	case string(corev1.DNSClusterFirstWithHostNet):
@@ -651,9 +1166,25 @@ func getVMDisks(config *Config) []kubevirtv1.Disk {
 			DiskDevice: kubevirtv1.DiskDevice{Disk: &kubevirtv1.DiskTarget{Bus: "virtio"}},
 		})
 	}
+	if config.ServiceAccountName != "" {
+		disks = append(disks, kubevirtv1.Disk{
+			Name:       serviceAccountDiskName,
+			DiskDevice: kubevirtv1.DiskDevice{Disk: &kubevirtv1.DiskTarget{Bus: "virtio"}},
+		})
+	}
 	return disks
 }
 
+func getFlavorMatcher(config *Config) *kubevirtv1.FlavorMatcher {
+	if config.InstancetypeRef == nil {
+		return nil
+	}
+	return &kubevirtv1.FlavorMatcher{
+		Name: config.InstancetypeRef.Name,
+		Kind: instancetypeFlavorKinds[config.InstancetypeRef.Kind],
+	}
+}
+
 func defaultBridgeNetwork() (*kubevirtv1.Interface, error) {
 	defaultBridgeNetwork := kubevirtv1.DefaultBridgeNetworkInterface()
 	mac, err := netutil.GenerateRandMAC()
@@ -664,7 +1195,7 @@ func defaultBridgeNetwork() (*kubevirtv1.Interface, error) {
 	return defaultBridgeNetwork, nil
 }
 
-func getVMVolumes(config *Config, dataVolumeName string, userDataSecretName string) []kubevirtv1.Volume {
+func getVMVolumes(config *Config, dataVolumeName string, userDataSecretName string, withNetworkData bool) []kubevirtv1.Volume {
 	volumes := []kubevirtv1.Volume{
 		{
 			Name: "datavolumedisk",
@@ -685,6 +1216,12 @@ func getVMVolumes(config *Config, dataVolumeName string, userDataSecretName stri
 			},
 		},
 	}
+	if withNetworkData {
+		// the network data is stored in the same secret as the user data
+		volumes[1].CloudInitNoCloud.NetworkDataSecretRef = &corev1.LocalObjectReference{
+			Name: userDataSecretName,
+		}
+	}
 	for i := range config.SecondaryDisks {
 		volumes = append(volumes, kubevirtv1.Volume{
 			Name: "secondarydisk" + strconv.Itoa(i),
@@ -694,9 +1231,52 @@ func getVMVolumes(config *Config, dataVolumeName string, userDataSecretName stri
 				}},
 		})
 	}
+	if config.ServiceAccountName != "" {
+		volumes = append(volumes, kubevirtv1.Volume{
+			Name: serviceAccountDiskName,
+			VolumeSource: kubevirtv1.VolumeSource{
+				ServiceAccount: &kubevirtv1.ServiceAccountVolumeSource{
+					ServiceAccountName: config.ServiceAccountName,
+				}},
+		})
+	}
 	return volumes
 }
 
+// checkServiceAccountExists ensures that the service account whose token is attached to the VM exists, KubeVirt
+// would otherwise fail to start the VMI. The creation is retried as the service account might be created later.
+func checkServiceAccountExists(ctx context.Context, c client.Client, namespace, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &corev1.ServiceAccount{}); err != nil {
+		if kerrors.IsNotFound(err) {
+			return fmt.Errorf("service account %q doesn't exist in namespace %q", name, namespace)
+		}
+		return fmt.Errorf("failed to get service account %q: %v", name, err)
+	}
+
+	return nil
+}
+
+// checkPriorityClassExists ensures that the priority class of the virt-launcher pod exists, the pod would otherwise
+// be rejected. The creation is retried as the priority class might be created later.
+func checkPriorityClassExists(ctx context.Context, c client.Client, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	if err := c.Get(ctx, types.NamespacedName{Name: name}, &schedulingv1.PriorityClass{}); err != nil {
+		if kerrors.IsNotFound(err) {
+			return fmt.Errorf("priority class %q doesn't exist", name)
+		}
+		return fmt.Errorf("failed to get priority class %q: %v", name, err)
+	}
+
+	return nil
+}
+
 func getDataVolumeTemplates(config *Config, dataVolumeName string) []kubevirtv1.DataVolumeTemplateSpec {
 	dataVolumeSource := getDataVolumeSource(config.OsImage)
 	pvcRequest := corev1.ResourceList{corev1.ResourceStorage: config.PVCSize}
@@ -714,8 +1294,10 @@ func getDataVolumeTemplates(config *Config, dataVolumeName string) []kubevirtv1.
 					Resources: corev1.ResourceRequirements{
 						Requests: pvcRequest,
 					},
+					VolumeMode: config.VolumeMode,
 				},
-				Source: dataVolumeSource,
+				Source:        dataVolumeSource,
+				Preallocation: config.Preallocation,
 			},
 		},
 	}
@@ -733,8 +1315,10 @@ func getDataVolumeTemplates(config *Config, dataVolumeName string) []kubevirtv1.
 					Resources: corev1.ResourceRequirements{
 						Requests: corev1.ResourceList{corev1.ResourceStorage: sd.Size},
 					},
+					VolumeMode: sd.VolumeMode,
 				},
-				Source: dataVolumeSource,
+				Source:        dataVolumeSource,
+				Preallocation: sd.Preallocation,
 			},
 		})
 	}
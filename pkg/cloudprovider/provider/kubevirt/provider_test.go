@@ -0,0 +1,684 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubevirt
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	kubevirttypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/kubevirt/types"
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	utilpointer "k8s.io/utils/pointer"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMachineMetricsLabels(t *testing.T) {
+	osImage := OSImage{URL: "http://images.example.com/ubuntu-22.04.img"}
+
+	tests := []struct {
+		name     string
+		config   *Config
+		expected map[string]string
+	}{
+		{
+			name:   "CPUs and memory",
+			config: &Config{CPUs: "2", Memory: "4096M", Namespace: "cluster-1", OsImage: osImage},
+			expected: map[string]string{
+				"size":      "2cpu-4096M",
+				"cpus":      "2",
+				"memoryMIB": "4096M",
+				"osImage":   osImage.URL,
+				"namespace": "cluster-1",
+			},
+		},
+		{
+			name:   "instancetype",
+			config: &Config{InstancetypeRef: &ResourceRef{Name: "u1.medium", Kind: clusterInstancetypeKind}, Namespace: "cluster-1", OsImage: osImage},
+			expected: map[string]string{
+				"size":      "u1.medium",
+				"cpus":      "",
+				"memoryMIB": "",
+				"osImage":   osImage.URL,
+				"namespace": "cluster-1",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if labels := machineMetricsLabels(test.config); !reflect.DeepEqual(labels, test.expected) {
+				t.Errorf("expected labels %v, got %v", test.expected, labels)
+			}
+		})
+	}
+}
+
+func TestValidateNodeSelector(t *testing.T) {
+	tests := []struct {
+		name          string
+		nodeSelector  map[string]string
+		expectedError bool
+	}{
+		{
+			name: "no node selector",
+		},
+		{
+			name:         "valid node selector",
+			nodeSelector: map[string]string{"node-role.kubernetes.io/hypervisor": "bare-metal", "topology.kubernetes.io/zone": "zone-a"},
+		},
+		{
+			name:          "empty key",
+			nodeSelector:  map[string]string{"": "bare-metal"},
+			expectedError: true,
+		},
+		{
+			name:          "empty value",
+			nodeSelector:  map[string]string{"node-role.kubernetes.io/hypervisor": ""},
+			expectedError: true,
+		},
+		{
+			name:          "invalid value",
+			nodeSelector:  map[string]string{"hypervisor": "bare metal"},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateNodeSelector(test.nodeSelector); (err != nil) != test.expectedError {
+				t.Errorf("expected error: %t, got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestGetResourceRequirements(t *testing.T) {
+	tests := []struct {
+		name                string
+		config              *Config
+		expectedRequests    corev1.ResourceList
+		expectedGuestMemory string
+		expectedError       bool
+	}{
+		{
+			name:             "guaranteed",
+			config:           &Config{CPUs: "2", Memory: "4Gi"},
+			expectedRequests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("4Gi")},
+		},
+		{
+			name:                "overcommitted",
+			config:              &Config{CPUs: "2", Memory: "4Gi", CPURequest: "500m", MemoryRequest: "2Gi"},
+			expectedRequests:    corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("2Gi")},
+			expectedGuestMemory: "4Gi",
+		},
+		{
+			name:             "only CPU overcommitted",
+			config:           &Config{CPUs: "2", Memory: "4Gi", CPURequest: "1"},
+			expectedRequests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("4Gi")},
+		},
+		{
+			name:          "request exceeds limit",
+			config:        &Config{CPUs: "2", Memory: "4Gi", MemoryRequest: "8Gi"},
+			expectedError: true,
+		},
+		{
+			name:          "invalid request",
+			config:        &Config{CPUs: "2", Memory: "4Gi", CPURequest: "two"},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requirements, err := getResourceRequirements(test.config)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("expected error: %t, got: %v", test.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+
+			for name, expected := range test.expectedRequests {
+				if request := requirements.Requests[name]; request.Cmp(expected) != 0 {
+					t.Errorf("expected %s request %s, got %s", name, expected.String(), request.String())
+				}
+			}
+			if cpus := requirements.Limits[corev1.ResourceCPU]; cpus.Cmp(resource.MustParse(test.config.CPUs)) != 0 {
+				t.Errorf("expected CPU limit %s, got %s", test.config.CPUs, cpus.String())
+			}
+			if memory := requirements.Limits[corev1.ResourceMemory]; memory.Cmp(resource.MustParse(test.config.Memory)) != 0 {
+				t.Errorf("expected memory limit %s, got %s", test.config.Memory, memory.String())
+			}
+
+			// The guest has to see the memory limit, not the lower request
+			spec := getVMISpec(test.config, kubevirtv1.Interface{Name: "default"}, requirements, "md", "data-volume", "userdata", false)
+			var guestMemory string
+			if spec.Domain.Memory != nil && spec.Domain.Memory.Guest != nil {
+				guestMemory = spec.Domain.Memory.Guest.String()
+			}
+			if guestMemory != test.expectedGuestMemory {
+				t.Errorf("expected guest memory %q, got %q", test.expectedGuestMemory, guestMemory)
+			}
+		})
+	}
+}
+
+func TestValidateHugepages(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        *Config
+		expectedError string
+	}{
+		{
+			name:   "no hugepages",
+			config: &Config{Memory: "2048M"},
+		},
+		{
+			name:   "2Mi hugepages",
+			config: &Config{Memory: "4Gi", Hugepages: "2Mi"},
+		},
+		{
+			name:   "1Gi hugepages",
+			config: &Config{Memory: "4Gi", Hugepages: "1Gi"},
+		},
+		{
+			name:          "invalid page size",
+			config:        &Config{Memory: "4Gi", Hugepages: "4Mi"},
+			expectedError: `hugepages must be one of 1Gi, 2Mi, got "4Mi"`,
+		},
+		{
+			name:          "memory is no multiple of the page size",
+			config:        &Config{Memory: "2500Mi", Hugepages: "1Gi"},
+			expectedError: "memory 2500Mi must be a multiple of the hugepage size 1Gi",
+		},
+		{
+			name:          "memory request",
+			config:        &Config{Memory: "4Gi", MemoryRequest: "2Gi", Hugepages: "2Mi"},
+			expectedError: "memoryRequest can't be used together with hugepages",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateHugepages(test.config)
+			if test.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Fatalf("expected error %q, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestValidateInstancetypeRef(t *testing.T) {
+	instancetype := &ResourceRef{Name: "u1.medium", Kind: clusterInstancetypeKind}
+
+	tests := []struct {
+		name          string
+		config        *Config
+		expectedError string
+	}{
+		{
+			name:   "cluster instancetype",
+			config: &Config{InstancetypeRef: instancetype},
+		},
+		{
+			name:   "namespaced instancetype",
+			config: &Config{InstancetypeRef: &ResourceRef{Name: "u1.medium", Kind: instancetypeKind}},
+		},
+		{
+			name:          "unknown kind",
+			config:        &Config{InstancetypeRef: &ResourceRef{Name: "u1.medium", Kind: "VirtualMachineFlavor"}},
+			expectedError: `unknown instancetype kind "VirtualMachineFlavor"`,
+		},
+		{
+			name:          "cpus",
+			config:        &Config{InstancetypeRef: instancetype, CPUs: "2"},
+			expectedError: "instancetypeRef can't be used together with cpus, memory or flavor",
+		},
+		{
+			name:          "memory",
+			config:        &Config{InstancetypeRef: instancetype, Memory: "4Gi"},
+			expectedError: "instancetypeRef can't be used together with cpus, memory or flavor",
+		},
+		{
+			name:          "flavor",
+			config:        &Config{InstancetypeRef: instancetype, FlavorName: "small"},
+			expectedError: "instancetypeRef can't be used together with cpus, memory or flavor",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateInstancetypeRef(test.config)
+			if test.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Fatalf("expected error %q, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestGetMemory(t *testing.T) {
+	if memory := getMemory(&Config{}, kubevirtv1.ResourceRequirements{}); memory != nil {
+		t.Errorf("expected no memory settings without hugepages, got %v", memory)
+	}
+
+	expected := &kubevirtv1.Memory{Hugepages: &kubevirtv1.Hugepages{PageSize: "1Gi"}}
+	if memory := getMemory(&Config{Hugepages: "1Gi"}, kubevirtv1.ResourceRequirements{}); !reflect.DeepEqual(memory, expected) {
+		t.Errorf("expected memory settings %v, got %v", expected, memory)
+	}
+}
+
+func TestSetEphemeralStorageRequest(t *testing.T) {
+	requirements, err := getResourceRequirements(&Config{CPUs: "2", Memory: "4Gi"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := setEphemeralStorageRequest(&requirements, "10Gi"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if request := requirements.Requests[corev1.ResourceEphemeralStorage]; request.Cmp(resource.MustParse("10Gi")) != 0 {
+		t.Errorf("expected ephemeral storage request 10Gi, got %s", request.String())
+	}
+	if _, ok := requirements.Limits[corev1.ResourceEphemeralStorage]; ok {
+		t.Error("expected no ephemeral storage limit")
+	}
+
+	// VMs with an instancetype have no resource requirements
+	requirements = kubevirtv1.ResourceRequirements{}
+	if err := setEphemeralStorageRequest(&requirements, "10Gi"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := requirements.Requests[corev1.ResourceEphemeralStorage]; !ok {
+		t.Error("expected an ephemeral storage request")
+	}
+
+	if err := setEphemeralStorageRequest(&requirements, "ten gigabytes"); err == nil {
+		t.Error("expected an error for an invalid quantity")
+	}
+}
+
+func TestGetLivenessProbe(t *testing.T) {
+	tests := []struct {
+		name        string
+		healthProbe *kubevirttypes.HealthProbe
+		expected    *kubevirtv1.Probe
+		expectError bool
+	}{
+		{
+			name: "no probe",
+		},
+		{
+			name:        "tcp probe",
+			healthProbe: &kubevirttypes.HealthProbe{TCP: &kubevirttypes.TCPProbe{Port: 22}, PeriodSeconds: 30, FailureThreshold: 5},
+			expected: &kubevirtv1.Probe{
+				Handler:          kubevirtv1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(22)}},
+				PeriodSeconds:    30,
+				FailureThreshold: 5,
+			},
+		},
+		{
+			name:        "http probe",
+			healthProbe: &kubevirttypes.HealthProbe{HTTP: &kubevirttypes.HTTPProbe{Port: 10248, Path: "/healthz", Scheme: "https"}},
+			expected: &kubevirtv1.Probe{
+				Handler: kubevirtv1.Handler{HTTPGet: &corev1.HTTPGetAction{Port: intstr.FromInt(10248), Path: "/healthz", Scheme: corev1.URISchemeHTTPS}},
+			},
+		},
+		{
+			name:        "guest agent ping",
+			healthProbe: &kubevirttypes.HealthProbe{GuestAgentPing: true, InitialDelaySeconds: 120, TimeoutSeconds: 5},
+			expected: &kubevirtv1.Probe{
+				Handler:             kubevirtv1.Handler{GuestAgentPing: &kubevirtv1.GuestAgentPing{}},
+				InitialDelaySeconds: 120,
+				TimeoutSeconds:      5,
+			},
+		},
+		{
+			name:        "no probe type",
+			healthProbe: &kubevirttypes.HealthProbe{PeriodSeconds: 30},
+			expectError: true,
+		},
+		{
+			name:        "multiple probe types",
+			healthProbe: &kubevirttypes.HealthProbe{TCP: &kubevirttypes.TCPProbe{Port: 22}, GuestAgentPing: true},
+			expectError: true,
+		},
+		{
+			name:        "invalid port",
+			healthProbe: &kubevirttypes.HealthProbe{TCP: &kubevirttypes.TCPProbe{}},
+			expectError: true,
+		},
+		{
+			name:        "invalid scheme",
+			healthProbe: &kubevirttypes.HealthProbe{HTTP: &kubevirttypes.HTTPProbe{Port: 80, Scheme: "ftp"}},
+			expectError: true,
+		},
+		{
+			name:        "negative threshold",
+			healthProbe: &kubevirttypes.HealthProbe{GuestAgentPing: true, FailureThreshold: -1},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			probe, err := getLivenessProbe(test.healthProbe)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if !reflect.DeepEqual(probe, test.expected) {
+				t.Errorf("expected probe %+v, got %+v", test.expected, probe)
+			}
+		})
+	}
+}
+
+func TestGetFirmware(t *testing.T) {
+	tests := []struct {
+		name             string
+		firmware         *kubevirttypes.Firmware
+		expected         *kubevirtv1.Firmware
+		expectedFeatures *kubevirtv1.Features
+		expectError      bool
+	}{
+		{
+			name: "no firmware",
+		},
+		{
+			name:     "bios",
+			firmware: &kubevirttypes.Firmware{Bootloader: "bios"},
+		},
+		{
+			name:     "efi",
+			firmware: &kubevirttypes.Firmware{Bootloader: "EFI"},
+			expected: &kubevirtv1.Firmware{
+				Bootloader: &kubevirtv1.Bootloader{EFI: &kubevirtv1.EFI{SecureBoot: utilpointer.BoolPtr(false)}},
+			},
+		},
+		{
+			name:     "efi with secure boot",
+			firmware: &kubevirttypes.Firmware{Bootloader: "efi", SecureBoot: true},
+			expected: &kubevirtv1.Firmware{
+				Bootloader: &kubevirtv1.Bootloader{EFI: &kubevirtv1.EFI{SecureBoot: utilpointer.BoolPtr(true)}},
+			},
+			expectedFeatures: &kubevirtv1.Features{SMM: &kubevirtv1.FeatureState{Enabled: utilpointer.BoolPtr(true)}},
+		},
+		{
+			name:        "secure boot without efi",
+			firmware:    &kubevirttypes.Firmware{SecureBoot: true},
+			expectError: true,
+		},
+		{
+			name:        "unknown bootloader",
+			firmware:    &kubevirttypes.Firmware{Bootloader: "uboot"},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			firmware, err := getFirmware(test.firmware)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if !reflect.DeepEqual(firmware, test.expected) {
+				t.Errorf("expected firmware %+v, got %+v", test.expected, firmware)
+			}
+			if features := getFeatures(&Config{Firmware: firmware}); !reflect.DeepEqual(features, test.expectedFeatures) {
+				t.Errorf("expected features %+v, got %+v", test.expectedFeatures, features)
+			}
+		})
+	}
+}
+
+func TestFirmwareStrictUnmarshal(t *testing.T) {
+	_, err := kubevirttypes.GetConfig(providerconfigtypes.Config{
+		CloudProviderSpec: runtime.RawExtension{Raw: []byte(`{"virtualMachine":{"firmware":{"bootloader":"efi","secureBootEnabled":true}}}`)},
+	})
+	if err == nil {
+		t.Error("expected an error for an unknown firmware field")
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		expectError bool
+	}{
+		{
+			name:        "valid labels and annotations",
+			labels:      map[string]string{"app.example.com/role": "worker", "empty": ""},
+			annotations: map[string]string{"example.com/owner": "team a"},
+		},
+		{
+			name:        "invalid label key",
+			labels:      map[string]string{"-role": "worker"},
+			expectError: true,
+		},
+		{
+			name:        "invalid label value",
+			labels:      map[string]string{"role": "worker node"},
+			expectError: true,
+		},
+		{
+			name:        "reserved label",
+			labels:      map[string]string{machineDeploymentLabelKey: "other"},
+			expectError: true,
+		},
+		{
+			name:        "invalid annotation key",
+			annotations: map[string]string{"example.com/": "value"},
+			expectError: true,
+		},
+		{
+			name:        "reserved annotation",
+			annotations: map[string]string{ignitionDataAnnotationKey: "{}"},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateMetadata(test.labels, test.annotations)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got: %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	configured := map[string]string{"role": "worker", vmLabelKey: "other"}
+	managed := map[string]string{vmLabelKey: "machine", machineDeploymentLabelKey: "md"}
+
+	expected := map[string]string{"role": "worker", vmLabelKey: "machine", machineDeploymentLabelKey: "md"}
+	if merged := mergeMetadata(configured, managed); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected %v, got %v", expected, merged)
+	}
+	if merged := mergeMetadata(nil, nil); merged != nil {
+		t.Errorf("expected no metadata, got %v", merged)
+	}
+}
+
+func TestMetadataStrictUnmarshal(t *testing.T) {
+	rawConfig, err := kubevirttypes.GetConfig(providerconfigtypes.Config{
+		CloudProviderSpec: runtime.RawExtension{Raw: []byte(`{"virtualMachine":{"labels":{"role":"worker"},"annotations":{"example.com/owner":"team-a"}}}`)},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+	if rawConfig.VirtualMachine.Labels["role"] != "worker" || rawConfig.VirtualMachine.Annotations["example.com/owner"] != "team-a" {
+		t.Errorf("expected labels and annotations to be parsed, got %v and %v", rawConfig.VirtualMachine.Labels, rawConfig.VirtualMachine.Annotations)
+	}
+
+	_, err = kubevirttypes.GetConfig(providerconfigtypes.Config{
+		CloudProviderSpec: runtime.RawExtension{Raw: []byte(`{"virtualMachine":{"label":{"role":"worker"}}}`)},
+	})
+	if err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func TestServiceAccountVolume(t *testing.T) {
+	c := &Config{ServiceAccountName: "guest-agent"}
+
+	disks := getVMDisks(c)
+	if disks[len(disks)-1].Name != serviceAccountDiskName {
+		t.Errorf("expected the last disk to be %q, got %q", serviceAccountDiskName, disks[len(disks)-1].Name)
+	}
+
+	volumes := getVMVolumes(c, "data-volume", "userdata", false)
+	volume := volumes[len(volumes)-1]
+	if volume.Name != serviceAccountDiskName || volume.ServiceAccount == nil || volume.ServiceAccount.ServiceAccountName != "guest-agent" {
+		t.Errorf("expected a service account volume for %q, got %+v", "guest-agent", volume)
+	}
+
+	for _, volume := range getVMVolumes(&Config{}, "data-volume", "userdata", false) {
+		if volume.ServiceAccount != nil {
+			t.Errorf("expected no service account volume by default, got %+v", volume)
+		}
+	}
+}
+
+func TestCheckServiceAccountExists(t *testing.T) {
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "kubevirt-vms", Name: "guest-agent"}}
+	c := fakectrlruntimeclient.NewClientBuilder().WithObjects(serviceAccount).Build()
+
+	tests := []struct {
+		name        string
+		namespace   string
+		account     string
+		expectError bool
+	}{
+		{
+			name:      "no service account",
+			namespace: "kubevirt-vms",
+		},
+		{
+			name:      "existing service account",
+			namespace: "kubevirt-vms",
+			account:   "guest-agent",
+		},
+		{
+			name:        "service account in another namespace",
+			namespace:   "other",
+			account:     "guest-agent",
+			expectError: true,
+		},
+		{
+			name:        "missing service account",
+			namespace:   "kubevirt-vms",
+			account:     "missing",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkServiceAccountExists(context.Background(), c, test.namespace, test.account)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got: %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestServiceAccountStrictUnmarshal(t *testing.T) {
+	rawConfig, err := kubevirttypes.GetConfig(providerconfigtypes.Config{
+		CloudProviderSpec: runtime.RawExtension{Raw: []byte(`{"virtualMachine":{"serviceAccountName":"guest-agent"}}`)},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+	if rawConfig.VirtualMachine.ServiceAccountName.Value != "guest-agent" {
+		t.Errorf("expected service account name %q, got %q", "guest-agent", rawConfig.VirtualMachine.ServiceAccountName.Value)
+	}
+
+	_, err = kubevirttypes.GetConfig(providerconfigtypes.Config{
+		CloudProviderSpec: runtime.RawExtension{Raw: []byte(`{"virtualMachine":{"serviceAccount":"guest-agent"}}`)},
+	})
+	if err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func TestVMISpecPriorityClass(t *testing.T) {
+	c := &Config{PriorityClassName: "kubevirt-vms"}
+	spec := getVMISpec(c, kubevirtv1.Interface{Name: "default"}, kubevirtv1.ResourceRequirements{}, "md", "data-volume", "userdata", false)
+	if spec.PriorityClassName != "kubevirt-vms" {
+		t.Errorf("expected priority class %q, got %q", "kubevirt-vms", spec.PriorityClassName)
+	}
+
+	if spec := getVMISpec(&Config{}, kubevirtv1.Interface{Name: "default"}, kubevirtv1.ResourceRequirements{}, "md", "data-volume", "userdata", false); spec.PriorityClassName != "" {
+		t.Errorf("expected no priority class by default, got %q", spec.PriorityClassName)
+	}
+}
+
+func TestCheckPriorityClassExists(t *testing.T) {
+	priorityClass := &schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "kubevirt-vms"}, Value: 1000000}
+	c := fakectrlruntimeclient.NewClientBuilder().WithObjects(priorityClass).Build()
+
+	tests := []struct {
+		name          string
+		priorityClass string
+		expectError   bool
+	}{
+		{
+			name: "no priority class",
+		},
+		{
+			name:          "existing priority class",
+			priorityClass: "kubevirt-vms",
+		},
+		{
+			name:          "missing priority class",
+			priorityClass: "missing",
+			expectError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkPriorityClassExists(context.Background(), c, test.priorityClass)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got: %v", test.expectError, err)
+			}
+		})
+	}
+}
@@ -36,10 +36,63 @@ type Auth struct {
 
 // VirtualMachine
 type VirtualMachine struct {
-	Flavor    Flavor                              `json:"flavor,omitempty"`
-	Template  Template                            `json:"template,omitempty"`
-	DNSPolicy providerconfigtypes.ConfigVarString `json:"dnsPolicy,omitempty"`
-	DNSConfig *corev1.PodDNSConfig                `json:"dnsConfig,omitempty"`
+	Flavor          Flavor                              `json:"flavor,omitempty"`
+	InstancetypeRef *InstancetypeRef                    `json:"instancetypeRef,omitempty"`
+	Template        Template                            `json:"template,omitempty"`
+	DNSPolicy       providerconfigtypes.ConfigVarString `json:"dnsPolicy,omitempty"`
+	DNSConfig       *corev1.PodDNSConfig                `json:"dnsConfig,omitempty"`
+	// NetworkData is a template for the cloud-init network data of the VM. It's rendered with the static
+	// network configuration of the machine and can only be used together with it.
+	NetworkData providerconfigtypes.ConfigVarString `json:"networkData,omitempty"`
+	// HealthProbe is the liveness probe of the VM, KubeVirt restarts VMs which fail it.
+	HealthProbe *HealthProbe `json:"healthProbe,omitempty"`
+	// Firmware selects the bootloader of the VM, BIOS is used if it's not set.
+	Firmware *Firmware `json:"firmware,omitempty"`
+	// Labels and Annotations are added to the VirtualMachine and the template of its VMI, e.g. to select the VMs
+	// in NetworkPolicies. The labels and annotations managed by the machine-controller can't be overridden.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// ServiceAccountName attaches the token of the service account to the VM as a disk, e.g. for agents in the
+	// guest which access the infra cluster. The service account has to exist in the namespace of the VM.
+	ServiceAccountName providerconfigtypes.ConfigVarString `json:"serviceAccountName,omitempty"`
+	// PriorityClassName is the priority class of the virt-launcher pod, e.g. to protect VMs from being evicted
+	// before less important pods under node pressure. The priority class has to exist in the infra cluster.
+	PriorityClassName providerconfigtypes.ConfigVarString `json:"priorityClassName,omitempty"`
+}
+
+// Firmware configures the bootloader of the VM.
+type Firmware struct {
+	// Bootloader is either bios or efi, it defaults to bios.
+	Bootloader string `json:"bootloader,omitempty"`
+	// SecureBoot enables secure boot, which requires the efi bootloader.
+	SecureBoot bool `json:"secureBoot,omitempty"`
+}
+
+// HealthProbe checks the health of the VM. Exactly one of TCP, HTTP and GuestAgentPing must be set, the thresholds
+// default to the ones of KubeVirt.
+type HealthProbe struct {
+	TCP  *TCPProbe  `json:"tcp,omitempty"`
+	HTTP *HTTPProbe `json:"http,omitempty"`
+	// GuestAgentPing checks the availability of the qemu-guest-agent, which has to be installed in the OS image.
+	GuestAgentPing bool `json:"guestAgentPing,omitempty"`
+
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int32 `json:"periodSeconds,omitempty"`
+	TimeoutSeconds      int32 `json:"timeoutSeconds,omitempty"`
+	FailureThreshold    int32 `json:"failureThreshold,omitempty"`
+}
+
+// TCPProbe succeeds if a TCP connection to the port of the VM can be opened.
+type TCPProbe struct {
+	Port int32 `json:"port,omitempty"`
+}
+
+// HTTPProbe succeeds if a GET request to the path and port of the VM returns a status code below 400.
+type HTTPProbe struct {
+	Port int32  `json:"port,omitempty"`
+	Path string `json:"path,omitempty"`
+	// Scheme is either HTTP or HTTPS, it defaults to HTTP.
+	Scheme string `json:"scheme,omitempty"`
 }
 
 // Flavor
@@ -48,12 +101,28 @@ type Flavor struct {
 	Profile providerconfigtypes.ConfigVarString `json:"profile,omitempty"`
 }
 
+// InstancetypeRef references a VirtualMachineInstancetype or VirtualMachineClusterInstancetype.
+type InstancetypeRef struct {
+	Name providerconfigtypes.ConfigVarString `json:"name,omitempty"`
+	Kind providerconfigtypes.ConfigVarString `json:"kind,omitempty"`
+}
+
 // Template
 type Template struct {
 	CPUs           providerconfigtypes.ConfigVarString `json:"cpus,omitempty"`
 	Memory         providerconfigtypes.ConfigVarString `json:"memory,omitempty"`
 	PrimaryDisk    PrimaryDisk                         `json:"primaryDisk,omitempty"`
 	SecondaryDisks []SecondaryDisks                    `json:"secondaryDisks,omitempty"`
+	// CPURequest and MemoryRequest lower the requests of the VM below its CPUs and memory, which are its limits,
+	// to overcommit the resources of the infra cluster. Requests and limits are equal if they aren't set.
+	CPURequest    providerconfigtypes.ConfigVarString `json:"cpuRequest,omitempty"`
+	MemoryRequest providerconfigtypes.ConfigVarString `json:"memoryRequest,omitempty"`
+	// EphemeralStorageRequest is requested for the virt-launcher pod of the VM, e.g. for its logs and the
+	// container disks.
+	EphemeralStorageRequest providerconfigtypes.ConfigVarString `json:"ephemeralStorageRequest,omitempty"`
+	// Hugepages is the page size of the hugepages backing the memory of the VM, either 2Mi or 1Gi. The memory
+	// must be a multiple of the page size, the pod requests the hugepages instead of regular memory.
+	Hugepages providerconfigtypes.ConfigVarString `json:"hugepages,omitempty"`
 }
 
 // PrimaryDisk
@@ -71,6 +140,10 @@ type SecondaryDisks struct {
 type Disk struct {
 	Size             providerconfigtypes.ConfigVarString `json:"size,omitempty"`
 	StorageClassName providerconfigtypes.ConfigVarString `json:"storageClassName,omitempty"`
+	// VolumeMode is either Block or Filesystem, the default of the storage class is used if it's not set.
+	VolumeMode providerconfigtypes.ConfigVarString `json:"volumeMode,omitempty"`
+	// Preallocation allocates the whole storage of the DataVolume in advance.
+	Preallocation *bool `json:"preallocation,omitempty"`
 }
 
 // Affinity
@@ -78,6 +151,9 @@ type Affinity struct {
 	PodAffinityPreset     providerconfigtypes.ConfigVarString `json:"podAffinityPreset,omitempty"`
 	PodAntiAffinityPreset providerconfigtypes.ConfigVarString `json:"podAntiAffinityPreset,omitempty"`
 	NodeAffinityPreset    NodeAffinityPreset                  `json:"nodeAffinityPreset,omitempty"`
+	// NodeSelector restricts the nodes of the infra cluster on which the virt-launcher pods of the VMs can run,
+	// e.g. to bare-metal hypervisor nodes.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
 }
 
 // NodeAffinityPreset
@@ -1108,3 +1108,11 @@ func assignFloatingIPToInstance(machineUpdater cloudprovidertypes.MachineUpdater
 func (p *provider) SetMetricsForMachines(machines clusterv1alpha1.MachineList) error {
 	return nil
 }
+
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p *provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	// images are provided by the user, only SLES is rejected
+	return providerconfigtypes.FilterOperatingSystems(func(os providerconfigtypes.OperatingSystem) bool {
+		return os != providerconfigtypes.OperatingSystemSLES
+	})
+}
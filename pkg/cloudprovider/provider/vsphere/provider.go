@@ -618,6 +618,14 @@ func (p *provider) SetMetricsForMachines(machines clusterv1alpha1.MachineList) e
 	return nil
 }
 
+// SupportedOperatingSystems returns the operating systems which are supported by the provider.
+func (p *provider) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	// templates are provided by the user, only SLES is rejected
+	return providerconfigtypes.FilterOperatingSystems(func(os providerconfigtypes.OperatingSystem) bool {
+		return os != providerconfigtypes.OperatingSystemSLES
+	})
+}
+
 func (p *provider) get(ctx context.Context, folder string, spec clusterv1alpha1.MachineSpec, datacenterFinder *find.Finder) (*object.VirtualMachine, error) {
 	path := fmt.Sprintf("%s/%s", folder, spec.Name)
 	virtualMachineList, err := datacenterFinder.VirtualMachineList(ctx, path)
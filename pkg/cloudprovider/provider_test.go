@@ -0,0 +1,205 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
+	"github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/fake"
+	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+)
+
+func TestProvidersListSupportedOperatingSystems(t *testing.T) {
+	for name, newProvider := range providers {
+		if _, ok := newProvider(nil).(OperatingSystemsLister); !ok {
+			t.Errorf("provider %q doesn't list its supported operating systems", name)
+		}
+	}
+}
+
+func TestSupportedOperatingSystems(t *testing.T) {
+	for _, p := range providerconfigtypes.AllCloudProviders {
+		operatingSystems, err := SupportedOperatingSystems(p)
+		if err != nil {
+			t.Fatalf("failed to get supported operating systems of provider %q: %v", p, err)
+		}
+		if len(operatingSystems) == 0 {
+			t.Errorf("provider %q supports no operating system", p)
+		}
+	}
+
+	if _, err := SupportedOperatingSystems("unknown"); err != ErrProviderNotFound {
+		t.Errorf("expected error %v for unknown provider, got %v", ErrProviderNotFound, err)
+	}
+}
+
+func TestPrice(t *testing.T) {
+	provider, err := ForProvider(providerconfigtypes.CloudProviderFake, nil)
+	if err != nil {
+		t.Fatalf("failed to get provider: %v", err)
+	}
+
+	pricer, ok := provider.(cloudprovidertypes.Pricer)
+	if !ok {
+		t.Fatal("expected the wrapped provider to implement Pricer")
+	}
+	if _, err := pricer.Price(clusterv1alpha1.MachineSpec{}); !errors.Is(err, cloudprovidertypes.ErrPriceNotImplemented) {
+		t.Errorf("expected error %v, got %v", cloudprovidertypes.ErrPriceNotImplemented, err)
+	}
+}
+
+func TestValidatePermissions(t *testing.T) {
+	provider, err := ForProvider(providerconfigtypes.CloudProviderFake, nil)
+	if err != nil {
+		t.Fatalf("failed to get provider: %v", err)
+	}
+
+	validator, ok := provider.(cloudprovidertypes.PermissionsValidator)
+	if !ok {
+		t.Fatal("expected the wrapped provider to implement PermissionsValidator")
+	}
+	if _, err := validator.ValidatePermissions(clusterv1alpha1.MachineSpec{}); !errors.Is(err, cloudprovidertypes.ErrPermissionsValidationNotImplemented) {
+		t.Errorf("expected error %v, got %v", cloudprovidertypes.ErrPermissionsValidationNotImplemented, err)
+	}
+}
+
+func TestListManagedInstances(t *testing.T) {
+	provider, err := ForProvider(providerconfigtypes.CloudProviderFake, nil)
+	if err != nil {
+		t.Fatalf("failed to get provider: %v", err)
+	}
+
+	lister, ok := provider.(cloudprovidertypes.ManagedInstancesLister)
+	if !ok {
+		t.Fatal("expected the wrapped provider to implement ManagedInstancesLister")
+	}
+	if _, err := lister.ListManagedInstances(context.Background(), clusterv1alpha1.MachineSpec{}); !errors.Is(err, cloudprovidertypes.ErrListManagedInstancesNotImplemented) {
+		t.Errorf("expected error %v, got %v", cloudprovidertypes.ErrListManagedInstancesNotImplemented, err)
+	}
+}
+
+// blockingProvider records the number of concurrent creates, which block until release is closed.
+type blockingProvider struct {
+	cloudprovidertypes.Provider
+
+	lock          sync.Mutex
+	running       int
+	maxConcurrent int
+	started       chan struct{}
+	release       chan struct{}
+}
+
+func (p *blockingProvider) Create(_ *clusterv1alpha1.Machine, _ *cloudprovidertypes.ProviderData, _ string) (instance.Instance, error) {
+	p.lock.Lock()
+	p.running++
+	if p.running > p.maxConcurrent {
+		p.maxConcurrent = p.running
+	}
+	p.lock.Unlock()
+
+	p.started <- struct{}{}
+	<-p.release
+
+	p.lock.Lock()
+	p.running--
+	p.lock.Unlock()
+	return nil, nil
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	const limit, creates = 3, 10
+
+	actualProvider := &blockingProvider{
+		Provider: fake.New(nil),
+		started:  make(chan struct{}, creates),
+		release:  make(chan struct{}),
+	}
+	provider := &cachingValidationWrapper{actualProvider: actualProvider, limiter: newConcurrencyLimiter(limit)}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = provider.Create(&clusterv1alpha1.Machine{}, nil, "")
+		}()
+	}
+
+	// Wait until the limit is reached, the remaining creates have to wait for a free slot
+	for i := 0; i < limit; i++ {
+		<-actualProvider.started
+	}
+	close(actualProvider.release)
+	wg.Wait()
+
+	if actualProvider.maxConcurrent != limit {
+		t.Errorf("expected at most %d concurrent creates, got %d", limit, actualProvider.maxConcurrent)
+	}
+	if len(actualProvider.started) != creates-limit {
+		t.Errorf("expected all %d creates to proceed, got %d", creates, len(actualProvider.started)+limit)
+	}
+}
+
+func TestSharedConcurrencyLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider providerconfigtypes.CloudProvider
+		opts     []Option
+		expected int
+	}{
+		{
+			name:     "default",
+			provider: providerconfigtypes.CloudProviderAWS,
+			expected: defaultConcurrencyLimit,
+		},
+		{
+			name:     "provider default",
+			provider: providerconfigtypes.CloudProviderAzure,
+			expected: 10,
+		},
+		{
+			name:     "configured limit",
+			provider: providerconfigtypes.CloudProviderAzure,
+			opts:     []Option{WithConcurrencyLimit(5)},
+			expected: 5,
+		},
+		{
+			name:     "invalid limit",
+			provider: providerconfigtypes.CloudProviderAWS,
+			opts:     []Option{WithConcurrencyLimit(0)},
+			expected: defaultConcurrencyLimit,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := sharedConcurrencyLimiter(test.provider, test.opts...)
+			if limit := cap(limiter.slots); limit != test.expected {
+				t.Errorf("expected a limit of %d, got %d", test.expected, limit)
+			}
+			if sharedConcurrencyLimiter(test.provider, test.opts...) != limiter {
+				t.Error("expected the limiter to be shared")
+			}
+		})
+	}
+}
@@ -18,12 +18,15 @@ package types
 
 import (
 	"context"
+	"errors"
 
 	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -46,6 +49,10 @@ type Provider interface {
 	// See v1alpha1.MachineStatus for more info and TerminalError type
 	//
 	// In case the instance cannot be found, github.com/kubermatic/machine-controller/pkg/cloudprovider/errors/ErrInstanceNotFound will be returned
+	//
+	// In case the instance exists but is in a failed state it won't recover from, an error wrapping
+	// github.com/kubermatic/machine-controller/pkg/cloudprovider/errors/ErrInstanceFailed should be returned.
+	// The instance then gets deleted via Cleanup and recreated, so Cleanup must handle failed instances.
 	Get(machine *clusterv1alpha1.Machine, data *ProviderData) (instance.Instance, error)
 
 	// GetCloudConfig will return the cloud provider specific cloud-config, which gets consumed by the kubelet
@@ -87,6 +94,25 @@ type ProviderData struct {
 	Ctx    context.Context
 	Update MachineUpdater
 	Client ctrlruntimeclient.Client
+	// Recorder records events on the machine, e.g. to report the progress of operations with many steps.
+	// It's optional, providers record events via RecordEvent.
+	Recorder record.EventRecorder
+}
+
+// RecordEvent records a normal event on the machine, it's a no-op if no event recorder is set.
+func (d *ProviderData) RecordEvent(machine *clusterv1alpha1.Machine, reason, messageFmt string, args ...interface{}) {
+	if d == nil || d.Recorder == nil {
+		return
+	}
+	d.Recorder.Eventf(machine, corev1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+// RecordWarningEvent records a warning event on the machine, it's a no-op if no event recorder is set.
+func (d *ProviderData) RecordWarningEvent(machine *clusterv1alpha1.Machine, reason, messageFmt string, args ...interface{}) {
+	if d == nil || d.Recorder == nil {
+		return
+	}
+	d.Recorder.Eventf(machine, corev1.EventTypeWarning, reason, messageFmt, args...)
 }
 
 // GetMachineUpdater returns an MachineUpdater based on the passed in context and ctrlruntimeclient.Client
@@ -116,3 +142,67 @@ func GetMachineUpdater(ctx context.Context, client ctrlruntimeclient.Client) Mac
 		})
 	}
 }
+
+// ErrPriceNotImplemented is returned if a cloud provider can't estimate the cost of an instance
+var ErrPriceNotImplemented = errors.New("price estimation is not implemented by the cloud provider")
+
+// Pricer is implemented by cloud providers which can estimate the cost of an instance, callers have to
+// type-assert the provider.
+type Pricer interface {
+	// Price returns the estimated hourly price in USD of an instance created according to the given spec
+	Price(spec clusterv1alpha1.MachineSpec) (hourlyUSD float64, err error)
+}
+
+// ErrPermissionsValidationNotImplemented is returned if a cloud provider can't validate its permissions
+var ErrPermissionsValidationNotImplemented = errors.New("permissions validation is not implemented by the cloud provider")
+
+// PermissionsValidator is implemented by cloud providers which can check upfront whether their credentials are
+// allowed to manage the instances of a spec, callers have to type-assert the provider.
+type PermissionsValidator interface {
+	// ValidatePermissions returns the actions which are required to manage instances created according to the
+	// given spec but aren't permitted for the configured credentials. Nothing is created or modified.
+	ValidatePermissions(spec clusterv1alpha1.MachineSpec) (missing []string, err error)
+}
+
+// ErrListManagedInstancesNotImplemented is returned if a cloud provider can't list the instances it manages
+var ErrListManagedInstancesNotImplemented = errors.New("listing managed instances is not implemented by the cloud provider")
+
+// ManagedInstance is an instance which is tagged with the UID of the machine it was created for.
+type ManagedInstance struct {
+	ID         string
+	Name       string
+	MachineUID types.UID
+}
+
+// ManagedInstancesLister is implemented by cloud providers which can list all instances they created for machines,
+// e.g. to find instances whose machine is gone. Callers have to type-assert the provider.
+type ManagedInstancesLister interface {
+	// ListManagedInstances returns the instances carrying a machine UID in the scope of the given spec, e.g. its
+	// resource group, using the credentials of the spec. The instances are not filtered by the machine of the spec.
+	ListManagedInstances(ctx context.Context, spec clusterv1alpha1.MachineSpec) ([]ManagedInstance, error)
+}
+
+// ErrExternalCloudConfigNotImplemented is returned if a cloud provider has no separate cloud-config for its external
+// cloud controller manager
+var ErrExternalCloudConfigNotImplemented = errors.New("external cloud-config is not implemented by the cloud provider")
+
+// ExternalCloudConfigProvider is implemented by cloud providers whose external cloud controller manager expects a
+// different cloud-config than the in-tree cloud provider. Callers have to type-assert the provider and fall back to
+// GetCloudConfig.
+type ExternalCloudConfigProvider interface {
+	// GetExternalCloudConfig returns the cloud-config for the external cloud controller manager and the name of the
+	// cloud provider, like GetCloudConfig does for the in-tree cloud provider.
+	GetExternalCloudConfig(spec clusterv1alpha1.MachineSpec) (config string, name string, err error)
+}
+
+// ErrRebootNotImplemented is returned if a cloud provider can't reboot instances
+var ErrRebootNotImplemented = errors.New("reboot is not implemented by the cloud provider")
+
+// Rebooter is implemented by cloud providers which can reboot an instance, which is cheaper than recreating it
+// for instances which hang, e.g. bare metal servers. Callers have to type-assert the provider.
+type Rebooter interface {
+	// Reboot reboots the instance of the given machine.
+	//
+	// In case the instance cannot be found, github.com/kubermatic/machine-controller/pkg/cloudprovider/errors/ErrInstanceNotFound will be returned
+	Reboot(machine *clusterv1alpha1.Machine, data *ProviderData) error
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// RetryOptions configure the retries of RetryWithBackoff.
+type RetryOptions struct {
+	// MaxAttempts is the maximum number of calls, including the first one. Values below 1 are treated as 1.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it's doubled for every further retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts before the jitter is applied, it's not capped if it's 0.
+	MaxDelay time.Duration
+	// Jitter adds a random delay of up to Jitter times the delay, e.g. 0.5 adds up to 50%.
+	Jitter float64
+	// Retryable decides whether an error is retried, all errors are retried if it's nil.
+	Retryable func(error) bool
+}
+
+// RetryWithBackoff calls fn until it succeeds, returns an error which isn't retryable or the maximum number of
+// attempts is reached. The delay between the attempts grows exponentially. If ctx is done while waiting for the
+// next attempt, the last error of fn is returned together with the error of the context.
+//
+// wait.ExponentialBackoffWithContext isn't used since it stops retrying once the delay reaches the cap and drops
+// the last error of fn.
+func RetryWithBackoff(ctx context.Context, opts RetryOptions, fn func() error) error {
+	backoff := newBackoff(opts)
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt >= opts.MaxAttempts || (opts.Retryable != nil && !opts.Retryable(err)) {
+			return err
+		}
+
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w; last error: %v", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// newBackoff returns the backoff for the delays between the attempts. The base delay is capped as well, since the
+// backoff only caps the delays following the first one.
+func newBackoff(opts RetryOptions) wait.Backoff {
+	backoff := wait.Backoff{
+		Duration: opts.BaseDelay,
+		Factor:   2,
+		Jitter:   opts.Jitter,
+		Steps:    opts.MaxAttempts,
+		Cap:      opts.MaxDelay,
+	}
+	if opts.MaxDelay > 0 && backoff.Duration > opts.MaxDelay {
+		backoff.Duration = opts.MaxDelay
+	}
+
+	return backoff
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     RetryOptions
+		expected []time.Duration
+	}{
+		{
+			name:     "exponential",
+			opts:     RetryOptions{BaseDelay: time.Second},
+			expected: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			name:     "capped",
+			opts:     RetryOptions{BaseDelay: time.Second, MaxDelay: 3 * time.Second},
+			expected: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name:     "base delay above the cap",
+			opts:     RetryOptions{BaseDelay: 5 * time.Second, MaxDelay: 3 * time.Second},
+			expected: []time.Duration{3 * time.Second, 3 * time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.MaxAttempts = len(test.expected) + 1
+			backoff := newBackoff(test.opts)
+			for attempt, expected := range test.expected {
+				if delay := backoff.Step(); delay != expected {
+					t.Errorf("attempt %d: expected delay %s, got %s", attempt, expected, delay)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	errRetryable := errors.New("retryable")
+	errTerminal := errors.New("terminal")
+
+	tests := []struct {
+		name             string
+		maxAttempts      int
+		retryable        func(error) bool
+		errs             []error
+		expectedErr      error
+		expectedAttempts int
+	}{
+		{
+			name:             "success",
+			maxAttempts:      3,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "success after retries",
+			maxAttempts:      3,
+			errs:             []error{errRetryable, errRetryable},
+			expectedAttempts: 3,
+		},
+		{
+			name:             "max attempts reached",
+			maxAttempts:      3,
+			errs:             []error{errRetryable, errRetryable, errRetryable, errRetryable},
+			expectedErr:      errRetryable,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "no retries below one attempt",
+			errs:             []error{errRetryable},
+			expectedErr:      errRetryable,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "error isn't retryable",
+			maxAttempts:      3,
+			retryable:        func(err error) bool { return errors.Is(err, errRetryable) },
+			errs:             []error{errRetryable, errTerminal},
+			expectedErr:      errTerminal,
+			expectedAttempts: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			opts := RetryOptions{MaxAttempts: test.maxAttempts, BaseDelay: time.Millisecond, Jitter: 0.5, Retryable: test.retryable}
+			err := RetryWithBackoff(context.Background(), opts, func() error {
+				attempts++
+				if attempts <= len(test.errs) {
+					return test.errs[attempts-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, test.expectedErr) || (err == nil) != (test.expectedErr == nil) {
+				t.Errorf("expected error %v, got %v", test.expectedErr, err)
+			}
+			if attempts != test.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d", test.expectedAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestRetryWithBackoffContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errRetryable := errors.New("retryable")
+	attempts := 0
+	err := RetryWithBackoff(ctx, RetryOptions{MaxAttempts: 3, BaseDelay: time.Hour}, func() error {
+		attempts++
+		return errRetryable
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), errRetryable.Error()) {
+		t.Errorf("expected the last error, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected a single attempt, got %d", attempts)
+	}
+}
@@ -17,11 +17,13 @@ limitations under the License.
 package cloudprovider
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
 
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
@@ -29,6 +31,8 @@ import (
 
 type cachingValidationWrapper struct {
 	actualProvider cloudprovidertypes.Provider
+	// limiter limits the concurrent Create and Cleanup calls, it is nil if they are unlimited
+	limiter *concurrencyLimiter
 }
 
 // NewValidationCacheWrappingCloudProvider returns a wrapped cloudprovider
@@ -72,13 +76,27 @@ func (w *cachingValidationWrapper) GetCloudConfig(spec v1alpha1.MachineSpec) (st
 	return w.actualProvider.GetCloudConfig(spec)
 }
 
-// Create just calls the underlying cloudproviders Create
+// GetExternalCloudConfig just calls the underlying cloudproviders GetExternalCloudConfig if it implements
+// cloudprovidertypes.ExternalCloudConfigProvider
+func (w *cachingValidationWrapper) GetExternalCloudConfig(spec v1alpha1.MachineSpec) (string, string, error) {
+	provider, ok := w.actualProvider.(cloudprovidertypes.ExternalCloudConfigProvider)
+	if !ok {
+		return "", "", cloudprovidertypes.ErrExternalCloudConfigNotImplemented
+	}
+	return provider.GetExternalCloudConfig(spec)
+}
+
+// Create calls the underlying cloudproviders Create once the concurrency limit allows it
 func (w *cachingValidationWrapper) Create(machine *v1alpha1.Machine, data *cloudprovidertypes.ProviderData, userdata string) (instance.Instance, error) {
+	release := w.limiter.acquire()
+	defer release()
 	return w.actualProvider.Create(machine, data, userdata)
 }
 
-// Cleanup just calls the underlying cloudproviders Cleanup
+// Cleanup calls the underlying cloudproviders Cleanup once the concurrency limit allows it
 func (w *cachingValidationWrapper) Cleanup(m *v1alpha1.Machine, mcd *cloudprovidertypes.ProviderData) (bool, error) {
+	release := w.limiter.acquire()
+	defer release()
 	return w.actualProvider.Cleanup(m, mcd)
 }
 
@@ -95,3 +113,46 @@ func (w *cachingValidationWrapper) MachineMetricsLabels(machine *v1alpha1.Machin
 func (w *cachingValidationWrapper) SetMetricsForMachines(machines v1alpha1.MachineList) error {
 	return w.actualProvider.SetMetricsForMachines(machines)
 }
+
+// SupportedOperatingSystems just calls the underlying cloudproviders SupportedOperatingSystems
+func (w *cachingValidationWrapper) SupportedOperatingSystems() []providerconfigtypes.OperatingSystem {
+	return w.actualProvider.(OperatingSystemsLister).SupportedOperatingSystems()
+}
+
+// Reboot just calls the underlying cloudproviders Reboot if it implements cloudprovidertypes.Rebooter
+func (w *cachingValidationWrapper) Reboot(machine *v1alpha1.Machine, data *cloudprovidertypes.ProviderData) error {
+	rebooter, ok := w.actualProvider.(cloudprovidertypes.Rebooter)
+	if !ok {
+		return cloudprovidertypes.ErrRebootNotImplemented
+	}
+	return rebooter.Reboot(machine, data)
+}
+
+// Price just calls the underlying cloudproviders Price if it implements cloudprovidertypes.Pricer
+func (w *cachingValidationWrapper) Price(spec v1alpha1.MachineSpec) (float64, error) {
+	pricer, ok := w.actualProvider.(cloudprovidertypes.Pricer)
+	if !ok {
+		return 0, cloudprovidertypes.ErrPriceNotImplemented
+	}
+	return pricer.Price(spec)
+}
+
+// ValidatePermissions just calls the underlying cloudproviders ValidatePermissions if it implements
+// cloudprovidertypes.PermissionsValidator
+func (w *cachingValidationWrapper) ValidatePermissions(spec v1alpha1.MachineSpec) ([]string, error) {
+	validator, ok := w.actualProvider.(cloudprovidertypes.PermissionsValidator)
+	if !ok {
+		return nil, cloudprovidertypes.ErrPermissionsValidationNotImplemented
+	}
+	return validator.ValidatePermissions(spec)
+}
+
+// ListManagedInstances just calls the underlying cloudproviders ListManagedInstances if it implements
+// cloudprovidertypes.ManagedInstancesLister
+func (w *cachingValidationWrapper) ListManagedInstances(ctx context.Context, spec v1alpha1.MachineSpec) ([]cloudprovidertypes.ManagedInstance, error) {
+	lister, ok := w.actualProvider.(cloudprovidertypes.ManagedInstancesLister)
+	if !ok {
+		return nil, cloudprovidertypes.ErrListManagedInstancesNotImplemented
+	}
+	return lister.ListManagedInstances(ctx, spec)
+}
@@ -22,6 +22,9 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
+
+	"github.com/kubermatic/machine-controller/pkg/userdata/helper"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -34,7 +37,14 @@ type Opts struct {
 	RegistryMirrors           string
 	RegistryCredentialsSecret string
 	PauseImage                string
+	PauseImageRegistry        string
 	ContainerdRegistryMirrors RegistryMirrorsFlags
+
+	ContainerdImagePullProgressTimeout string
+	ContainerdMaxConcurrentDownloads   int
+
+	DockerCgroupDriver  string
+	DockerStorageDriver string
 }
 
 func BuildConfig(opts Opts) (Config, error) {
@@ -75,11 +85,38 @@ func BuildConfig(opts Opts) (Config, error) {
 		}
 	}
 
+	if opts.ContainerdImagePullProgressTimeout != "" {
+		timeout, err := time.ParseDuration(opts.ContainerdImagePullProgressTimeout)
+		if err != nil {
+			return Config{}, fmt.Errorf("-node-containerd-image-pull-progress-timeout is in incorrect format %q: %v", opts.ContainerdImagePullProgressTimeout, err)
+		}
+		if timeout < 0 {
+			return Config{}, fmt.Errorf("-node-containerd-image-pull-progress-timeout must not be negative, got %q", opts.ContainerdImagePullProgressTimeout)
+		}
+	}
+
+	if opts.ContainerdMaxConcurrentDownloads < 0 {
+		return Config{}, fmt.Errorf("-node-containerd-max-concurrent-downloads must not be negative, got %d", opts.ContainerdMaxConcurrentDownloads)
+	}
+
+	pauseImage, err := helper.PauseImage(opts.PauseImage, opts.PauseImageRegistry)
+	if err != nil {
+		return Config{}, fmt.Errorf("-node-pause-image-registry is invalid: %w", err)
+	}
+
+	switch opts.DockerCgroupDriver {
+	case "", helper.CgroupDriverSystemd, helper.CgroupDriverCgroupfs:
+	default:
+		return Config{}, fmt.Errorf("-node-docker-cgroup-driver must be either %q or %q, got %q", helper.CgroupDriverSystemd, helper.CgroupDriverCgroupfs, opts.DockerCgroupDriver)
+	}
+
 	return get(
 		opts.ContainerRuntime,
 		withInsecureRegistries(insecureRegistries),
 		withRegistryMirrors(opts.ContainerdRegistryMirrors),
-		withSandboxImage(opts.PauseImage),
+		withSandboxImage(pauseImage),
+		withImagePullSettings(opts.ContainerdImagePullProgressTimeout, opts.ContainerdMaxConcurrentDownloads),
+		withDockerDrivers(opts.DockerCgroupDriver, opts.DockerStorageDriver),
 	), nil
 }
 
@@ -24,6 +24,7 @@ import (
 	"github.com/BurntSushi/toml"
 
 	"github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+	"github.com/kubermatic/machine-controller/pkg/userdata/helper"
 )
 
 const (
@@ -37,6 +38,9 @@ type Containerd struct {
 	sandboxImage        string
 	registryCredentials map[string]AuthConfig
 	version             string
+
+	imagePullProgressTimeout string
+	maxConcurrentDownloads   int
 }
 
 func (eng *Containerd) ConfigFileName() string {
@@ -175,6 +179,11 @@ systemctl enable --now containerd
 `))
 )
 
+// CgroupDriver returns the cgroup driver of containerd, which always uses systemd.
+func (eng *Containerd) CgroupDriver() string {
+	return helper.CgroupDriverSystemd
+}
+
 func (eng *Containerd) String() string {
 	return containerdName
 }
@@ -193,6 +202,9 @@ type containerdCRIPlugin struct {
 	Containerd   *containerdCRISettings `toml:"containerd"`
 	Registry     *containerdCRIRegistry `toml:"registry"`
 	SandboxImage string                 `toml:"sandbox_image,omitempty"`
+
+	ImagePullProgressTimeout string `toml:"image_pull_progress_timeout,omitempty"`
+	MaxConcurrentDownloads   *int   `toml:"max_concurrent_downloads,omitempty"`
 }
 
 type containerdCRISettings struct {
@@ -243,13 +255,14 @@ type AuthConfig struct {
 
 func (eng *Containerd) Config() (string, error) {
 	criPlugin := containerdCRIPlugin{
-		SandboxImage: eng.sandboxImage,
+		SandboxImage:             eng.sandboxImage,
+		ImagePullProgressTimeout: eng.imagePullProgressTimeout,
 		Containerd: &containerdCRISettings{
 			Runtimes: map[string]containerdCRIRuntime{
 				"runc": {
 					RuntimeType: "io.containerd.runc.v2",
 					Options: containerdCRIRuncOptions{
-						SystemdCgroup: true,
+						SystemdCgroup: eng.CgroupDriver() == helper.CgroupDriverSystemd,
 					},
 				},
 			},
@@ -263,6 +276,10 @@ func (eng *Containerd) Config() (string, error) {
 		},
 	}
 
+	if eng.maxConcurrentDownloads > 0 {
+		criPlugin.MaxConcurrentDownloads = &eng.maxConcurrentDownloads
+	}
+
 	for registryName := range eng.registryMirrors {
 		registry := criPlugin.Registry.Mirrors[registryName]
 		registry.Endpoint = eng.registryMirrors[registryName]
@@ -0,0 +1,169 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerruntime
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/kubermatic/machine-controller/pkg/userdata/helper"
+)
+
+func TestContainerdImagePullSettings(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             Opts
+		expectedError    bool
+		expectedLines    []string
+		notExpectedLines []string
+	}{
+		{
+			name: "image pull settings are rendered",
+			opts: Opts{
+				ContainerRuntime:                   containerdName,
+				ContainerdImagePullProgressTimeout: "10m",
+				ContainerdMaxConcurrentDownloads:   2,
+			},
+			expectedLines: []string{
+				`image_pull_progress_timeout = "10m"`,
+				`max_concurrent_downloads = 2`,
+			},
+		},
+		{
+			name: "image pull settings are omitted by default",
+			opts: Opts{ContainerRuntime: containerdName},
+			notExpectedLines: []string{
+				"image_pull_progress_timeout",
+				"max_concurrent_downloads",
+			},
+		},
+		{
+			name: "invalid timeout",
+			opts: Opts{
+				ContainerRuntime:                   containerdName,
+				ContainerdImagePullProgressTimeout: "10 minutes",
+			},
+			expectedError: true,
+		},
+		{
+			name: "negative timeout",
+			opts: Opts{
+				ContainerRuntime:                   containerdName,
+				ContainerdImagePullProgressTimeout: "-1m",
+			},
+			expectedError: true,
+		},
+		{
+			name: "negative concurrent downloads",
+			opts: Opts{
+				ContainerRuntime:                 containerdName,
+				ContainerdMaxConcurrentDownloads: -1,
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := BuildConfig(test.opts)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("expected error: %t, got: %v", test.expectedError, err)
+			}
+			if test.expectedError {
+				return
+			}
+
+			config, err := cfg.Engine(semver.MustParse("1.24.0")).Config()
+			if err != nil {
+				t.Fatalf("failed to render containerd config: %v", err)
+			}
+
+			for _, line := range test.expectedLines {
+				if !strings.Contains(config, line) {
+					t.Errorf("expected containerd config to contain %q, got:\n%s", line, config)
+				}
+			}
+			for _, line := range test.notExpectedLines {
+				if strings.Contains(config, line) {
+					t.Errorf("expected containerd config to not contain %q, got:\n%s", line, config)
+				}
+			}
+		})
+	}
+}
+
+func TestContainerdSandboxImageMatchesKubelet(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          Opts
+		expectedImage string
+	}{
+		{
+			name: "pause image",
+			opts: Opts{
+				ContainerRuntime: containerdName,
+				PauseImage:       "192.168.1.1:5000/kubernetes/pause:3.1",
+			},
+			expectedImage: "192.168.1.1:5000/kubernetes/pause:3.1",
+		},
+		{
+			name: "pause image from registry mirror",
+			opts: Opts{
+				ContainerRuntime:   containerdName,
+				PauseImage:         "k8s.gcr.io/pause:3.1",
+				PauseImageRegistry: "registry.internal/k8s",
+			},
+			expectedImage: "registry.internal/k8s/pause:3.1",
+		},
+		{
+			name: "default pause image from registry mirror",
+			opts: Opts{
+				ContainerRuntime:   containerdName,
+				PauseImageRegistry: "registry.internal",
+			},
+			expectedImage: "registry.internal/pause:3.9",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := BuildConfig(test.opts)
+			if err != nil {
+				t.Fatalf("failed to build config: %v", err)
+			}
+
+			config, err := cfg.Engine(semver.MustParse("1.24.0")).Config()
+			if err != nil {
+				t.Fatalf("failed to render containerd config: %v", err)
+			}
+			if line := fmt.Sprintf("sandbox_image = %q", test.expectedImage); !strings.Contains(config, line) {
+				t.Errorf("expected containerd config to contain %q, got:\n%s", line, config)
+			}
+
+			unit, err := helper.KubeletSystemdUnit(containerdName, "1.24.0", "", "node", nil, false, cfg.SandboxImage, nil, nil, true)
+			if err != nil {
+				t.Fatalf("failed to render kubelet systemd unit: %v", err)
+			}
+			if flag := "--pod-infra-container-image=" + test.expectedImage; !strings.Contains(unit, flag) {
+				t.Errorf("expected kubelet systemd unit to contain %q, got:\n%s", flag, unit)
+			}
+		})
+	}
+}
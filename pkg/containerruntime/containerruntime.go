@@ -32,6 +32,7 @@ type Engine interface {
 	ScriptFor(os types.OperatingSystem) (string, error)
 	ConfigFileName() string
 	Config() (string, error)
+	CgroupDriver() string
 	String() string
 }
 
@@ -55,6 +56,20 @@ func withSandboxImage(image string) Opt {
 	}
 }
 
+func withImagePullSettings(progressTimeout string, maxConcurrentDownloads int) Opt {
+	return func(cfg *Config) {
+		cfg.ImagePullProgressTimeout = progressTimeout
+		cfg.MaxConcurrentDownloads = maxConcurrentDownloads
+	}
+}
+
+func withDockerDrivers(cgroupDriver, storageDriver string) Opt {
+	return func(cfg *Config) {
+		cfg.DockerCgroupDriver = cgroupDriver
+		cfg.DockerStorageDriver = storageDriver
+	}
+}
+
 func get(containerRuntimeName string, opts ...Opt) Config {
 	cfg := Config{}
 
@@ -86,6 +101,15 @@ type Config struct {
 	SandboxImage         string                `json:",omitempty"`
 	ContainerLogMaxFiles string                `json:",omitempty"`
 	ContainerLogMaxSize  string                `json:",omitempty"`
+
+	// ImagePullProgressTimeout and MaxConcurrentDownloads are only supported by containerd
+	ImagePullProgressTimeout string `json:",omitempty"`
+	MaxConcurrentDownloads   int    `json:",omitempty"`
+
+	// DockerCgroupDriver and DockerStorageDriver are only supported by docker, they default to systemd and overlay2. The
+	// kubelet uses the cgroup driver of the container runtime.
+	DockerCgroupDriver  string `json:",omitempty"`
+	DockerStorageDriver string `json:",omitempty"`
 }
 
 func (cfg Config) String() string {
@@ -105,6 +129,8 @@ func (cfg Config) Engine(kubeletVersion *semver.Version) Engine {
 		registryMirrors:      cfg.RegistryMirrors["docker.io"],
 		containerLogMaxFiles: cfg.ContainerLogMaxFiles,
 		containerLogMaxSize:  cfg.ContainerLogMaxSize,
+		cgroupDriver:         cfg.DockerCgroupDriver,
+		storageDriver:        cfg.DockerStorageDriver,
 	}
 
 	containerd := &Containerd{
@@ -112,6 +138,9 @@ func (cfg Config) Engine(kubeletVersion *semver.Version) Engine {
 		registryMirrors:     cfg.RegistryMirrors,
 		sandboxImage:        cfg.SandboxImage,
 		registryCredentials: cfg.RegistryCredentials,
+
+		imagePullProgressTimeout: cfg.ImagePullProgressTimeout,
+		maxConcurrentDownloads:   cfg.MaxConcurrentDownloads,
 	}
 
 	moreThan124, _ := semver.NewConstraint(">= 1.24")
@@ -36,10 +36,20 @@ type Docker struct {
 	registryMirrors      []string
 	containerLogMaxFiles string
 	containerLogMaxSize  string
+	cgroupDriver         string
+	storageDriver        string
 }
 
 func (eng *Docker) Config() (string, error) {
-	return helper.DockerConfig(eng.insecureRegistries, eng.registryMirrors, eng.containerLogMaxFiles, eng.containerLogMaxSize)
+	return helper.DockerConfigWithDrivers(eng.insecureRegistries, eng.registryMirrors, eng.containerLogMaxFiles, eng.containerLogMaxSize, eng.CgroupDriver(), eng.storageDriver)
+}
+
+// CgroupDriver returns the configured cgroup driver, systemd by default.
+func (eng *Docker) CgroupDriver() string {
+	if eng.cgroupDriver == "" {
+		return helper.CgroupDriverSystemd
+	}
+	return eng.cgroupDriver
 }
 
 func (eng *Docker) ConfigFileName() string {
@@ -0,0 +1,93 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package containerruntime
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+func TestDockerDrivers(t *testing.T) {
+	tests := []struct {
+		name                 string
+		opts                 Opts
+		expectedError        bool
+		expectedLines        []string
+		expectedCgroupDriver string
+	}{
+		{
+			name: "default drivers",
+			opts: Opts{ContainerRuntime: dockerName},
+			expectedLines: []string{
+				`"native.cgroupdriver=systemd"`,
+				`"storage-driver":"overlay2"`,
+			},
+			expectedCgroupDriver: "systemd",
+		},
+		{
+			name: "cgroupfs and btrfs",
+			opts: Opts{
+				ContainerRuntime:    dockerName,
+				DockerCgroupDriver:  "cgroupfs",
+				DockerStorageDriver: "btrfs",
+			},
+			expectedLines: []string{
+				`"native.cgroupdriver=cgroupfs"`,
+				`"storage-driver":"btrfs"`,
+			},
+			expectedCgroupDriver: "cgroupfs",
+		},
+		{
+			name: "invalid cgroup driver",
+			opts: Opts{
+				ContainerRuntime:   dockerName,
+				DockerCgroupDriver: "cgroupv2",
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := BuildConfig(test.opts)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("expected error: %t, got: %v", test.expectedError, err)
+			}
+			if test.expectedError {
+				return
+			}
+
+			engine := cfg.Engine(semver.MustParse("1.23.0"))
+			if driver := engine.CgroupDriver(); driver != test.expectedCgroupDriver {
+				t.Errorf("expected cgroup driver %q for the kubelet, got %q", test.expectedCgroupDriver, driver)
+			}
+
+			config, err := engine.Config()
+			if err != nil {
+				t.Fatalf("failed to render docker config: %v", err)
+			}
+
+			for _, line := range test.expectedLines {
+				if !strings.Contains(config, line) {
+					t.Errorf("expected docker config to contain %q, got:\n%s", line, config)
+				}
+			}
+		})
+	}
+}
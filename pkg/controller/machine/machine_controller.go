@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -96,6 +97,22 @@ const (
 	AnnotationAutoscalerIdentifier = "cluster.k8s.io/machine"
 
 	provisioningSuffix = "osc-provisioning"
+
+	// AnnotationRebootedAt is the time the instance of a machine was rebooted last because its node was NotReady
+	AnnotationRebootedAt = "machine-controller.kubermatic.io/rebooted-at"
+
+	// AnnotationFailedInstanceRecreations is the number of times a failed instance of the machine got deleted to
+	// recreate it, and AnnotationFailedInstanceDeletedAt is the time the last one got deleted. Both are removed once
+	// an instance doesn't fail.
+	AnnotationFailedInstanceRecreations = "machine-controller.kubermatic.io/failed-instance-recreations"
+	AnnotationFailedInstanceDeletedAt   = "machine-controller.kubermatic.io/failed-instance-deleted-at"
+
+	// maxFailedInstanceRecreations is the number of times a failed instance is recreated before the machine gets a
+	// terminal error, failedInstanceBackoff is the delay before the first recreation, which doubles with every
+	// further recreation up to maxFailedInstanceBackoff.
+	maxFailedInstanceRecreations = 5
+	failedInstanceBackoff        = time.Minute
+	maxFailedInstanceBackoff     = 30 * time.Minute
 )
 
 // Reconciler is the controller implementation for machine resources
@@ -110,6 +127,7 @@ type Reconciler struct {
 	providerData                     *cloudprovidertypes.ProviderData
 	userDataManager                  *userdatamanager.Manager
 	joinClusterTimeout               *time.Duration
+	rebootNotReadyNodeAfter          *time.Duration
 	name                             string
 	bootstrapTokenServiceAccountName *types.NamespacedName
 	skipEvictionAfter                time.Duration
@@ -119,6 +137,9 @@ type Reconciler struct {
 
 	useOSM        bool
 	nodePortRange string
+
+	// providerConcurrencyLimit overrides the number of concurrent Create and Cleanup calls of the cloud provider
+	providerConcurrencyLimit int
 }
 
 type NodeSettings struct {
@@ -128,6 +149,8 @@ type NodeSettings struct {
 	HTTPProxy string
 	// If set this will be set as NO_PROXY on the node.
 	NoProxy string
+	// If set, these pod and service CIDRs will be added to NO_PROXY on the node.
+	ClusterCIDRs []string
 	// If set, those registries will be configured as insecure on the container runtime.
 	InsecureRegistries []string
 	// If set, these mirrors will be take for pulling all required images on the node.
@@ -170,12 +193,14 @@ func Add(
 	kubeconfigProvider KubeconfigProvider,
 	providerData *cloudprovidertypes.ProviderData,
 	joinClusterTimeout *time.Duration,
+	rebootNotReadyNodeAfter *time.Duration,
 	name string,
 	bootstrapTokenServiceAccountName *types.NamespacedName,
 	skipEvictionAfter time.Duration,
 	nodeSettings NodeSettings,
 	useOSM bool,
 	nodePortRange string,
+	providerConcurrencyLimit int,
 ) error {
 	reconciler := &Reconciler{
 		kubeClient:                       kubeClient,
@@ -185,6 +210,7 @@ func Add(
 		kubeconfigProvider:               kubeconfigProvider,
 		providerData:                     providerData,
 		joinClusterTimeout:               joinClusterTimeout,
+		rebootNotReadyNodeAfter:          rebootNotReadyNodeAfter,
 		name:                             name,
 		bootstrapTokenServiceAccountName: bootstrapTokenServiceAccountName,
 		skipEvictionAfter:                skipEvictionAfter,
@@ -194,6 +220,8 @@ func Add(
 
 		useOSM:        useOSM,
 		nodePortRange: nodePortRange,
+
+		providerConcurrencyLimit: providerConcurrencyLimit,
 	}
 	m, err := userdatamanager.New()
 	if err != nil {
@@ -348,6 +376,13 @@ func (r *Reconciler) createProviderInstance(prov cloudprovidertypes.Provider, ma
 	if err != nil {
 		return nil, err
 	}
+	if i != nil {
+		if err := r.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+			common.SetProviderInstanceID(m, i.ID())
+		}); err != nil {
+			return nil, fmt.Errorf("failed to update machine after setting the provider instance ID: %v", err)
+		}
+	}
 	return i, nil
 }
 
@@ -400,7 +435,7 @@ func (r *Reconciler) reconcile(ctx context.Context, machine *clusterv1alpha1.Mac
 		return nil, fmt.Errorf("failed to get provider config: %v", err)
 	}
 	skg := providerconfig.NewConfigVarResolver(ctx, r.client)
-	prov, err := cloudprovider.ForProvider(providerConfig.CloudProvider, skg)
+	prov, err := cloudprovider.ForProvider(providerConfig.CloudProvider, skg, cloudprovider.WithConcurrencyLimit(r.providerConcurrencyLimit))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get cloud provider %q: %v", providerConfig.CloudProvider, err)
 	}
@@ -440,6 +475,10 @@ func (r *Reconciler) reconcile(ctx context.Context, machine *clusterv1alpha1.Mac
 			return nil, fmt.Errorf("failed to set nodeReady condition on machine: %v", err)
 		}
 	} else {
+		if err := r.rebootNotReadyNode(prov, machine, node); err != nil {
+			return nil, err
+		}
+
 		// Node is not ready anymore? Maybe it got deleted
 		return r.ensureInstanceExistsForMachine(ctx, prov, machine, userdataPlugin, providerConfig)
 	}
@@ -448,6 +487,57 @@ func (r *Reconciler) reconcile(ctx context.Context, machine *clusterv1alpha1.Mac
 	return nil, r.ensureNodeLabelsAnnotationsAndTaints(ctx, node, machine)
 }
 
+// rebootNotReadyNode reboots the instance of the machine if its node has been NotReady for longer than
+// rebootNotReadyNodeAfter and the cloud provider supports reboots. This is much faster than recreating
+// a hanging instance, e.g. a bare metal server. The instance is rebooted only once while the node is NotReady,
+// it's up to the owner of the machine to recreate it if the reboot doesn't help.
+func (r *Reconciler) rebootNotReadyNode(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, node *corev1.Node) error {
+	if r.rebootNotReadyNodeAfter == nil {
+		return nil
+	}
+
+	notReadySince := nodeNotReadySince(node)
+	if notReadySince.IsZero() || time.Since(notReadySince) < *r.rebootNotReadyNodeAfter {
+		return nil
+	}
+
+	if rebootedAt, err := time.Parse(time.RFC3339, machine.Annotations[AnnotationRebootedAt]); err == nil && rebootedAt.After(notReadySince) {
+		return nil
+	}
+
+	rebooter, ok := prov.(cloudprovidertypes.Rebooter)
+	if !ok {
+		return nil
+	}
+
+	if err := rebooter.Reboot(machine, r.providerData); err != nil {
+		if errors.Is(err, cloudprovidertypes.ErrRebootNotImplemented) || errors.Is(err, cloudprovidererrors.ErrInstanceNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to reboot instance of machine %s: %v", machine.Name, err)
+	}
+
+	klog.V(3).Infof("Rebooted instance of machine %s, its node %s has been NotReady since %s", machine.Name, node.Name, notReadySince.Format(time.RFC3339))
+	r.recorder.Eventf(machine, corev1.EventTypeNormal, "Rebooted", "Rebooted instance, node %s has been NotReady since %s", node.Name, notReadySince.Format(time.RFC3339))
+
+	return r.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+		if m.Annotations == nil {
+			m.Annotations = map[string]string{}
+		}
+		m.Annotations[AnnotationRebootedAt] = time.Now().UTC().Format(time.RFC3339)
+	})
+}
+
+// nodeNotReadySince returns the time the node became NotReady, which is zero if it's unknown.
+func nodeNotReadySince(node *corev1.Node) time.Time {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status != corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
+
 func (r *Reconciler) ensureMachineHasNodeReadyCondition(machine *clusterv1alpha1.Machine) error {
 	for _, condition := range machine.Status.Conditions {
 		if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
@@ -725,6 +815,20 @@ func (r *Reconciler) deleteNodeForMachine(ctx context.Context, nodes []*corev1.N
 	})
 }
 
+// getCloudConfig renders the cloud-config of the provider. Nodes using an external cloud controller manager get its
+// variant of the cloud-config if the provider has one.
+func getCloudConfig(prov cloudprovidertypes.Provider, spec clusterv1alpha1.MachineSpec, externalCloudProvider bool) (string, string, error) {
+	if externalCloudProvider {
+		if externalProvider, ok := prov.(cloudprovidertypes.ExternalCloudConfigProvider); ok {
+			config, name, err := externalProvider.GetExternalCloudConfig(spec)
+			if !errors.Is(err, cloudprovidertypes.ErrExternalCloudConfigNotImplemented) {
+				return config, name, err
+			}
+		}
+	}
+	return prov.GetCloudConfig(spec)
+}
+
 func (r *Reconciler) ensureInstanceExistsForMachine(
 	ctx context.Context,
 	prov cloudprovidertypes.Provider,
@@ -748,11 +852,6 @@ func (r *Reconciler) ensureInstanceExistsForMachine(
 				return nil, fmt.Errorf("failed to create bootstrap kubeconfig: %v", err)
 			}
 
-			cloudConfig, kubeletCloudProviderName, err := prov.GetCloudConfig(machine.Spec)
-			if err != nil {
-				return nil, fmt.Errorf("failed to render cloud config: %v", err)
-			}
-
 			// grab kubelet featureGates from the annotations
 			kubeletFeatureGates := common.GetKubeletFeatureGates(machine.GetAnnotations())
 			if len(kubeletFeatureGates) == 0 {
@@ -770,6 +869,11 @@ func (r *Reconciler) ensureInstanceExistsForMachine(
 				externalCloudProvider, _ = strconv.ParseBool(val)
 			}
 
+			cloudConfig, kubeletCloudProviderName, err := getCloudConfig(prov, machine.Spec, externalCloudProvider)
+			if err != nil {
+				return nil, fmt.Errorf("failed to render cloud config: %v", err)
+			}
+
 			registryCredentials, err := containerruntime.GetContainerdAuthConfig(ctx, r.client, r.nodeSettings.RegistryCredentialsSecretRef)
 			if err != nil {
 				return nil, fmt.Errorf("failed to get containerd auth config: %v", err)
@@ -798,6 +902,7 @@ func (r *Reconciler) ensureInstanceExistsForMachine(
 				KubeletFeatureGates:      kubeletFeatureGates,
 				KubeletConfigs:           kubeletConfigs,
 				NoProxy:                  r.nodeSettings.NoProxy,
+				ClusterCIDRs:             r.nodeSettings.ClusterCIDRs,
 				HTTPProxy:                r.nodeSettings.HTTPProxy,
 				ContainerRuntime:         crRuntime,
 				NodePortRange:            r.nodePortRange,
@@ -857,13 +962,18 @@ func (r *Reconciler) ensureInstanceExistsForMachine(
 			return &reconcile.Result{RequeueAfter: 30 * time.Second}, nil
 		}
 
-		// case 2.2: terminal error was returned and manual interaction is required to recover
+		// case 2.2: instance exists but failed, delete it so it gets recreated
+		if cloudprovidererrors.IsInstanceFailed(err) {
+			return r.deleteFailedInstance(prov, machine, err)
+		}
+
+		// case 2.3: terminal error was returned and manual interaction is required to recover
 		if ok, _, _ := cloudprovidererrors.IsTerminalError(err); ok {
 			message := fmt.Sprintf("%v. Unable to create a machine.", err)
 			return nil, r.updateMachineErrorIfTerminalError(machine, common.CreateMachineError, message, err, "failed to get instance from provider")
 		}
 
-		// case 2.3: transient error was returned, requeue the request and try again in the future
+		// case 2.4: transient error was returned, requeue the request and try again in the future
 		return nil, fmt.Errorf("failed to get instance from provider: %v", err)
 	}
 	// Instance exists, so ensure finalizer does as well
@@ -874,21 +984,131 @@ func (r *Reconciler) ensureInstanceExistsForMachine(
 
 	// case 3: retrieving the instance from cloudprovider was successful
 	// Emit an event and update .Status.Addresses
+	if _, found := machine.Annotations[AnnotationFailedInstanceRecreations]; found {
+		if err := r.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+			delete(m.Annotations, AnnotationFailedInstanceRecreations)
+			delete(m.Annotations, AnnotationFailedInstanceDeletedAt)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to reset the recreations of failed instances: %v", err)
+		}
+	}
 	addresses := providerInstance.Addresses()
 	eventMessage := fmt.Sprintf("Found instance at cloud provider, addresses: %v", addresses)
-	r.recorder.Event(machine, corev1.EventTypeNormal, "InstanceFound", eventMessage)
-	machineAddresses := []corev1.NodeAddress{}
-	for address, addressType := range addresses {
-		machineAddresses = append(machineAddresses, corev1.NodeAddress{Address: address, Type: addressType})
+	if statusMessager, ok := providerInstance.(instance.StatusMessager); ok {
+		if statusMessage := statusMessager.StatusMessage(); statusMessage != "" {
+			eventMessage = fmt.Sprintf("%s, status: %s", eventMessage, statusMessage)
+		}
 	}
+	r.recorder.Event(machine, corev1.EventTypeNormal, "InstanceFound", eventMessage)
+	machineAddresses := getMachineAddresses(providerInstance)
 	if err := r.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
 		m.Status.Addresses = machineAddresses
+		common.SetProviderInstanceID(m, providerInstance.ID())
 	}); err != nil {
 		return nil, fmt.Errorf("failed to update machine after setting .status.addresses: %v", err)
 	}
 	return r.ensureNodeOwnerRefAndConfigSource(ctx, providerInstance, machine, providerConfig)
 }
 
+// deleteFailedInstance deletes the instance of the machine which is in a failed state it won't recover from. Once
+// it's gone, the machine gets requeued and a new instance is created.
+func (r *Reconciler) deleteFailedInstance(prov cloudprovidertypes.Provider, machine *clusterv1alpha1.Machine, failedErr error) (*reconcile.Result, error) {
+	recreations, deletedAt := failedInstanceRecreations(machine)
+	if recreations >= maxFailedInstanceRecreations {
+		message := fmt.Sprintf("%v. The instance failed after %d recreations, it won't be recreated again.", failedErr, recreations)
+		return nil, r.updateMachineError(machine, common.CreateMachineError, message)
+	}
+	if wait := failedInstanceRecreationBackoff(recreations) - time.Since(deletedAt); wait > 0 {
+		klog.V(3).Infof("Instance of machine %s failed, delaying its recreation by %s: %v", machine.Name, wait.Round(time.Second), failedErr)
+		return &reconcile.Result{RequeueAfter: wait}, nil
+	}
+
+	klog.V(2).Infof("Instance of machine %s failed, deleting it to recreate it: %v", machine.Name, failedErr)
+	r.recorder.Eventf(machine, corev1.EventTypeWarning, "InstanceFailed", "Deleting failed instance to recreate it: %v", failedErr)
+
+	completelyGone, err := prov.Cleanup(machine, r.providerData)
+	if err != nil {
+		message := fmt.Sprintf("%v. Unable to delete the failed instance.", err)
+		return nil, r.updateMachineErrorIfTerminalError(machine, common.DeleteMachineError, message, err, "failed to delete failed instance at cloud provider")
+	}
+
+	if !completelyGone {
+		return &reconcile.Result{RequeueAfter: deletionRetryWaitPeriod}, nil
+	}
+
+	if err := r.updateMachine(machine, func(m *clusterv1alpha1.Machine) {
+		if m.Annotations == nil {
+			m.Annotations = map[string]string{}
+		}
+		m.Annotations[AnnotationFailedInstanceRecreations] = strconv.Itoa(recreations + 1)
+		m.Annotations[AnnotationFailedInstanceDeletedAt] = time.Now().UTC().Format(time.RFC3339)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to record the recreation of the failed instance: %v", err)
+	}
+
+	return &reconcile.Result{Requeue: true}, nil
+}
+
+// failedInstanceRecreations returns how often a failed instance of the machine got recreated and when the last one
+// got deleted.
+func failedInstanceRecreations(machine *clusterv1alpha1.Machine) (int, time.Time) {
+	recreations, err := strconv.Atoi(machine.Annotations[AnnotationFailedInstanceRecreations])
+	if err != nil {
+		return 0, time.Time{}
+	}
+	deletedAt, _ := time.Parse(time.RFC3339, machine.Annotations[AnnotationFailedInstanceDeletedAt])
+	return recreations, deletedAt
+}
+
+// failedInstanceRecreationBackoff returns the delay between the deletion of the last failed instance and the
+// deletion of the next one.
+func failedInstanceRecreationBackoff(recreations int) time.Duration {
+	if recreations == 0 {
+		return 0
+	}
+
+	backoff := failedInstanceBackoff
+	for i := 1; i < recreations && backoff < maxFailedInstanceBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxFailedInstanceBackoff {
+		return maxFailedInstanceBackoff
+	}
+	return backoff
+}
+
+// getMachineAddresses returns the sorted addresses of the instance, including its hostname if the cloud provider
+// assigned one.
+func getMachineAddresses(providerInstance instance.Instance) []corev1.NodeAddress {
+	addresses := providerInstance.Addresses()
+	machineAddresses := []corev1.NodeAddress{}
+	for address, addressType := range addresses {
+		machineAddresses = append(machineAddresses, corev1.NodeAddress{Address: address, Type: addressType})
+	}
+	if hostNamer, ok := providerInstance.(instance.HostNamer); ok {
+		if hostName := hostNamer.HostName(); hostName != "" {
+			if _, found := addresses[hostName]; !found {
+				machineAddresses = append(machineAddresses, corev1.NodeAddress{Address: hostName, Type: corev1.NodeHostName})
+			}
+		}
+	}
+
+	// Addresses are returned as a map, sort them to not update the machine on every reconciliation
+	sort.Slice(machineAddresses, func(i, j int) bool {
+		if machineAddresses[i].Type != machineAddresses[j].Type {
+			return machineAddresses[i].Type < machineAddresses[j].Type
+		}
+		iIsV4 := net.ParseIP(machineAddresses[i].Address).To4() != nil
+		jIsV4 := net.ParseIP(machineAddresses[j].Address).To4() != nil
+		if iIsV4 != jIsV4 {
+			return iIsV4
+		}
+		return machineAddresses[i].Address < machineAddresses[j].Address
+	})
+
+	return machineAddresses
+}
+
 func (r *Reconciler) ensureNodeOwnerRefAndConfigSource(ctx context.Context, providerInstance instance.Instance, machine *clusterv1alpha1.Machine, providerConfig *providerconfigtypes.Config) (*reconcile.Result, error) {
 	node, exists, err := r.getNode(ctx, providerInstance, providerConfig.CloudProvider)
 	if err != nil {
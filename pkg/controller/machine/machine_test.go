@@ -19,12 +19,15 @@ package controller
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-test/deep"
 
 	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+	cloudprovidererrors "github.com/kubermatic/machine-controller/pkg/cloudprovider/errors"
 	"github.com/kubermatic/machine-controller/pkg/cloudprovider/instance"
 	cloudprovidertypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/types"
 	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
@@ -39,6 +42,7 @@ import (
 	"k8s.io/klog"
 	ctrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client"
 	ctrlruntimefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 func init() {
@@ -70,6 +74,15 @@ func (i *fakeInstance) Addresses() map[string]corev1.NodeAddressType {
 	return i.addresses
 }
 
+type fakeHostNamerInstance struct {
+	fakeInstance
+	hostName string
+}
+
+func (i *fakeHostNamerInstance) HostName() string {
+	return i.hostName
+}
+
 func getTestNode(id, provider string) corev1.Node {
 	providerID := ""
 	if provider != "" {
@@ -634,3 +647,398 @@ func TestControllerDeleteNodeForMachine(t *testing.T) {
 		})
 	}
 }
+
+type fakeRebootingProvider struct {
+	cloudprovidertypes.Provider
+	reboots int
+}
+
+func (p *fakeRebootingProvider) Reboot(_ *clusterv1alpha1.Machine, _ *cloudprovidertypes.ProviderData) error {
+	p.reboots++
+	return nil
+}
+
+func TestControllerRebootNotReadyNode(t *testing.T) {
+	notReadySince := time.Now().Add(-10 * time.Minute)
+
+	tests := []struct {
+		name                    string
+		rebootNotReadyNodeAfter *time.Duration
+		nodeReadyStatus         corev1.ConditionStatus
+		rebootedAt              *time.Time
+		expectedReboots         int
+	}{
+		{
+			name:                    "node NotReady past the threshold gets rebooted",
+			rebootNotReadyNodeAfter: durationPtr(5 * time.Minute),
+			nodeReadyStatus:         corev1.ConditionFalse,
+			expectedReboots:         1,
+		},
+		{
+			name:                    "node with unknown readiness past the threshold gets rebooted",
+			rebootNotReadyNodeAfter: durationPtr(5 * time.Minute),
+			nodeReadyStatus:         corev1.ConditionUnknown,
+			expectedReboots:         1,
+		},
+		{
+			name:                    "node NotReady within the threshold does not get rebooted",
+			rebootNotReadyNodeAfter: durationPtr(15 * time.Minute),
+			nodeReadyStatus:         corev1.ConditionFalse,
+		},
+		{
+			name:            "nil rebootNotReadyNodeAfter results in no reboots",
+			nodeReadyStatus: corev1.ConditionFalse,
+		},
+		{
+			name:                    "node rebooted while NotReady does not get rebooted again",
+			rebootNotReadyNodeAfter: durationPtr(5 * time.Minute),
+			nodeReadyStatus:         corev1.ConditionFalse,
+			rebootedAt:              timePtr(notReadySince.Add(6 * time.Minute)),
+		},
+		{
+			name:                    "node rebooted during a previous NotReady period gets rebooted",
+			rebootNotReadyNodeAfter: durationPtr(5 * time.Minute),
+			nodeReadyStatus:         corev1.ConditionFalse,
+			rebootedAt:              timePtr(notReadySince.Add(-time.Hour)),
+			expectedReboots:         1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-machine"},
+			}
+			if test.rebootedAt != nil {
+				machine.Annotations = map[string]string{AnnotationRebootedAt: test.rebootedAt.UTC().Format(time.RFC3339)}
+			}
+
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-node"},
+				Status: corev1.NodeStatus{
+					Conditions: []corev1.NodeCondition{{
+						Type:               corev1.NodeReady,
+						Status:             test.nodeReadyStatus,
+						LastTransitionTime: metav1.NewTime(notReadySince),
+					}},
+				},
+			}
+
+			client := ctrlruntimefake.NewFakeClient(machine)
+			prov := &fakeRebootingProvider{}
+			reconciler := Reconciler{
+				client:   client,
+				recorder: &record.FakeRecorder{},
+				providerData: &cloudprovidertypes.ProviderData{
+					Ctx:    ctx,
+					Update: cloudprovidertypes.GetMachineUpdater(ctx, client),
+					Client: client,
+				},
+				rebootNotReadyNodeAfter: test.rebootNotReadyNodeAfter,
+			}
+
+			if err := reconciler.rebootNotReadyNode(prov, machine, node); err != nil {
+				t.Fatalf("failed to call rebootNotReadyNode: %v", err)
+			}
+			if prov.reboots != test.expectedReboots {
+				t.Errorf("expected %d reboots, got %d", test.expectedReboots, prov.reboots)
+			}
+
+			if test.expectedReboots > 0 {
+				updatedMachine := &clusterv1alpha1.Machine{}
+				if err := client.Get(ctx, types.NamespacedName{Name: machine.Name}, updatedMachine); err != nil {
+					t.Fatalf("failed to get machine: %v", err)
+				}
+				rebootedAt, err := time.Parse(time.RFC3339, updatedMachine.Annotations[AnnotationRebootedAt])
+				if err != nil || !rebootedAt.After(notReadySince) {
+					t.Errorf("expected the reboot to be recorded on the machine, got annotation %q", updatedMachine.Annotations[AnnotationRebootedAt])
+				}
+
+				// A second reconciliation during the same NotReady period must not reboot again
+				if err := reconciler.rebootNotReadyNode(prov, updatedMachine, node); err != nil {
+					t.Fatalf("failed to call rebootNotReadyNode: %v", err)
+				}
+				if prov.reboots != test.expectedReboots {
+					t.Errorf("expected no additional reboot, got %d reboots", prov.reboots)
+				}
+			}
+		})
+	}
+}
+
+type fakeFailedInstanceProvider struct {
+	cloudprovidertypes.Provider
+	completelyGone bool
+	cleanups       int
+}
+
+func (p *fakeFailedInstanceProvider) Get(_ *clusterv1alpha1.Machine, _ *cloudprovidertypes.ProviderData) (instance.Instance, error) {
+	return nil, fmt.Errorf("%w: provisioning failed", cloudprovidererrors.ErrInstanceFailed)
+}
+
+func (p *fakeFailedInstanceProvider) Cleanup(_ *clusterv1alpha1.Machine, _ *cloudprovidertypes.ProviderData) (bool, error) {
+	p.cleanups++
+	return p.completelyGone, nil
+}
+
+func TestControllerDeletesFailedInstance(t *testing.T) {
+	recentlyDeleted := time.Now().Add(-30 * time.Second).UTC().Format(time.RFC3339)
+	longAgoDeleted := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name                string
+		annotations         map[string]string
+		completelyGone      bool
+		expectedResult      *reconcile.Result
+		expectedCleanups    int
+		expectedRecreations string
+		expectMachineError  bool
+	}{
+		{
+			name:                "failed instance got deleted",
+			completelyGone:      true,
+			expectedResult:      &reconcile.Result{Requeue: true},
+			expectedCleanups:    1,
+			expectedRecreations: "1",
+		},
+		{
+			name:             "failed instance is being deleted",
+			expectedResult:   &reconcile.Result{RequeueAfter: deletionRetryWaitPeriod},
+			expectedCleanups: 1,
+		},
+		{
+			name: "recreation is delayed by the backoff",
+			annotations: map[string]string{
+				AnnotationFailedInstanceRecreations: "1",
+				AnnotationFailedInstanceDeletedAt:   recentlyDeleted,
+			},
+			completelyGone:      true,
+			expectedRecreations: "1",
+		},
+		{
+			name: "failed instance got deleted after the backoff",
+			annotations: map[string]string{
+				AnnotationFailedInstanceRecreations: "2",
+				AnnotationFailedInstanceDeletedAt:   longAgoDeleted,
+			},
+			completelyGone:      true,
+			expectedResult:      &reconcile.Result{Requeue: true},
+			expectedCleanups:    1,
+			expectedRecreations: "3",
+		},
+		{
+			name: "failed instance isn't recreated anymore",
+			annotations: map[string]string{
+				AnnotationFailedInstanceRecreations: strconv.Itoa(maxFailedInstanceRecreations),
+				AnnotationFailedInstanceDeletedAt:   longAgoDeleted,
+			},
+			completelyGone:      true,
+			expectedRecreations: strconv.Itoa(maxFailedInstanceRecreations),
+			expectMachineError:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			machine := &clusterv1alpha1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-machine", Annotations: test.annotations},
+			}
+
+			client := ctrlruntimefake.NewFakeClient(machine)
+			recorder := record.NewFakeRecorder(1)
+			prov := &fakeFailedInstanceProvider{completelyGone: test.completelyGone}
+			reconciler := Reconciler{
+				client:   client,
+				recorder: recorder,
+				providerData: &cloudprovidertypes.ProviderData{
+					Ctx:    ctx,
+					Update: cloudprovidertypes.GetMachineUpdater(ctx, client),
+					Client: client,
+				},
+			}
+
+			result, err := reconciler.ensureInstanceExistsForMachine(ctx, prov, machine, nil, nil)
+			if err != nil {
+				t.Fatalf("failed to ensure the instance exists: %v", err)
+			}
+			if prov.cleanups != test.expectedCleanups {
+				t.Errorf("expected the failed instance to be cleaned up %d times, got %d cleanups", test.expectedCleanups, prov.cleanups)
+			}
+			switch {
+			case test.expectedResult == nil && result != nil && result.Requeue:
+				t.Errorf("expected no immediate requeue, got %+v", result)
+			case test.expectedResult == nil && result != nil && result.RequeueAfter <= 0:
+				t.Errorf("expected a delayed requeue, got %+v", result)
+			case test.expectedResult != nil && (result == nil || *result != *test.expectedResult):
+				t.Errorf("expected result %+v, got %+v", test.expectedResult, result)
+			}
+
+			if recreations := machine.Annotations[AnnotationFailedInstanceRecreations]; recreations != test.expectedRecreations {
+				t.Errorf("expected %q recreations, got %q", test.expectedRecreations, recreations)
+			}
+			if hasError := machine.Status.ErrorReason != nil; hasError != test.expectMachineError {
+				t.Errorf("expected machine error to be set: %t, got %t", test.expectMachineError, hasError)
+			}
+
+			select {
+			case event := <-recorder.Events:
+				if test.expectedCleanups == 0 {
+					t.Errorf("expected no event, got %q", event)
+				} else if !strings.Contains(event, "InstanceFailed") {
+					t.Errorf("expected an InstanceFailed event, got %q", event)
+				}
+			default:
+				if test.expectedCleanups > 0 {
+					t.Error("expected an InstanceFailed event")
+				}
+			}
+		})
+	}
+}
+
+func TestFailedInstanceRecreationBackoff(t *testing.T) {
+	tests := []struct {
+		recreations int
+		expected    time.Duration
+	}{
+		{recreations: 0, expected: 0},
+		{recreations: 1, expected: failedInstanceBackoff},
+		{recreations: 3, expected: 4 * failedInstanceBackoff},
+		{recreations: 10, expected: maxFailedInstanceBackoff},
+	}
+
+	for _, test := range tests {
+		if backoff := failedInstanceRecreationBackoff(test.recreations); backoff != test.expected {
+			t.Errorf("expected a backoff of %s after %d recreations, got %s", test.expected, test.recreations, backoff)
+		}
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func TestGetMachineAddresses(t *testing.T) {
+	addresses := map[string]corev1.NodeAddressType{
+		"10.0.0.2":        corev1.NodeInternalIP,
+		"172.16.0.1":      corev1.NodeExternalIP,
+		"node-1.internal": corev1.NodeInternalDNS,
+	}
+
+	tests := []struct {
+		name     string
+		instance instance.Instance
+		expected []corev1.NodeAddress
+	}{
+		{
+			name:     "instance without hostname",
+			instance: &fakeInstance{addresses: addresses},
+			expected: []corev1.NodeAddress{
+				{Address: "172.16.0.1", Type: corev1.NodeExternalIP},
+				{Address: "node-1.internal", Type: corev1.NodeInternalDNS},
+				{Address: "10.0.0.2", Type: corev1.NodeInternalIP},
+			},
+		},
+		{
+			name:     "instance with hostname",
+			instance: &fakeHostNamerInstance{fakeInstance: fakeInstance{addresses: addresses}, hostName: "node-1"},
+			expected: []corev1.NodeAddress{
+				{Address: "172.16.0.1", Type: corev1.NodeExternalIP},
+				{Address: "node-1", Type: corev1.NodeHostName},
+				{Address: "node-1.internal", Type: corev1.NodeInternalDNS},
+				{Address: "10.0.0.2", Type: corev1.NodeInternalIP},
+			},
+		},
+		{
+			name:     "hostname which is already an address",
+			instance: &fakeHostNamerInstance{fakeInstance: fakeInstance{addresses: addresses}, hostName: "node-1.internal"},
+			expected: []corev1.NodeAddress{
+				{Address: "172.16.0.1", Type: corev1.NodeExternalIP},
+				{Address: "node-1.internal", Type: corev1.NodeInternalDNS},
+				{Address: "10.0.0.2", Type: corev1.NodeInternalIP},
+			},
+		},
+		{
+			name:     "empty hostname",
+			instance: &fakeHostNamerInstance{fakeInstance: fakeInstance{addresses: addresses}},
+			expected: []corev1.NodeAddress{
+				{Address: "172.16.0.1", Type: corev1.NodeExternalIP},
+				{Address: "node-1.internal", Type: corev1.NodeInternalDNS},
+				{Address: "10.0.0.2", Type: corev1.NodeInternalIP},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := deep.Equal(getMachineAddresses(test.instance), test.expected); diff != nil {
+				t.Errorf("unexpected addresses, diff: %v", diff)
+			}
+		})
+	}
+}
+
+type fakeCloudConfigProvider struct {
+	cloudprovidertypes.Provider
+}
+
+func (p *fakeCloudConfigProvider) GetCloudConfig(_ clusterv1alpha1.MachineSpec) (string, string, error) {
+	return "in-tree", "fake", nil
+}
+
+type fakeExternalCloudConfigProvider struct {
+	fakeCloudConfigProvider
+	err error
+}
+
+func (p *fakeExternalCloudConfigProvider) GetExternalCloudConfig(_ clusterv1alpha1.MachineSpec) (string, string, error) {
+	return "external", "fake", p.err
+}
+
+func TestGetCloudConfig(t *testing.T) {
+	tests := []struct {
+		name                  string
+		provider              cloudprovidertypes.Provider
+		externalCloudProvider bool
+		expectedConfig        string
+	}{
+		{
+			name:           "in-tree cloud provider",
+			provider:       &fakeExternalCloudConfigProvider{},
+			expectedConfig: "in-tree",
+		},
+		{
+			name:                  "external cloud provider",
+			provider:              &fakeExternalCloudConfigProvider{},
+			externalCloudProvider: true,
+			expectedConfig:        "external",
+		},
+		{
+			name:                  "external cloud provider without external cloud-config",
+			provider:              &fakeCloudConfigProvider{},
+			externalCloudProvider: true,
+			expectedConfig:        "in-tree",
+		},
+		{
+			name:                  "external cloud-config not implemented by the wrapped provider",
+			provider:              &fakeExternalCloudConfigProvider{err: cloudprovidertypes.ErrExternalCloudConfigNotImplemented},
+			externalCloudProvider: true,
+			expectedConfig:        "in-tree",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, _, err := getCloudConfig(test.provider, clusterv1alpha1.MachineSpec{}, test.externalCloudProvider)
+			if err != nil {
+				t.Fatalf("failed to get cloud config: %v", err)
+			}
+			if config != test.expectedConfig {
+				t.Errorf("expected cloud config %q, got %q", test.expectedConfig, config)
+			}
+		})
+	}
+}
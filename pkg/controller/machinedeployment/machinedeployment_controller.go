@@ -198,6 +198,8 @@ func (r *ReconcileMachineDeployment) reconcile(ctx context.Context, d *v1alpha1.
 	switch d.Spec.Strategy.Type {
 	case common.RollingUpdateMachineDeploymentStrategyType:
 		return reconcile.Result{}, r.rolloutRolling(ctx, d, msList, machineMap)
+	case common.OnDeleteMachineDeploymentStrategyType:
+		return reconcile.Result{}, r.rolloutOnDelete(ctx, d, msList, machineMap)
 	}
 
 	return reconcile.Result{}, errors.Errorf("unexpected deployment strategy type: %s", d.Spec.Strategy.Type)
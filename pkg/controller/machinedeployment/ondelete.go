@@ -0,0 +1,147 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machinedeployment
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+	dutil "github.com/kubermatic/machine-controller/pkg/controller/util"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"k8s.io/utils/integer"
+)
+
+// rolloutOnDelete implements the logic for the OnDelete strategy. A new machine set is created, but machines of the
+// old machine sets are only replaced once they got deleted manually.
+func (r *ReconcileMachineDeployment) rolloutOnDelete(ctx context.Context, d *v1alpha1.MachineDeployment, msList []*v1alpha1.MachineSet, machineMap map[types.UID]*v1alpha1.MachineList) error {
+	newMS, oldMSs, err := r.getAllMachineSetsAndSyncRevision(ctx, d, msList, machineMap, true)
+	if err != nil {
+		return err
+	}
+
+	// newMS can be nil in case there is already a MachineSet associated with this deployment,
+	// but there are only either changes in annotations or MinReadySeconds. Or in other words,
+	// this can be nil if there are changes, but no replacement of existing machines is needed.
+	if newMS == nil {
+		return nil
+	}
+
+	allMSs := append(oldMSs, newMS)
+
+	// Scale down, only by the machines which got deleted.
+	if err := r.reconcileOldMachineSetsOnDelete(ctx, oldMSs, machineMap, d); err != nil {
+		return err
+	}
+
+	if err := r.syncDeploymentStatus(ctx, allMSs, newMS, d); err != nil {
+		return err
+	}
+
+	// Scale up, to replace the deleted machines.
+	if err := r.reconcileNewMachineSet(ctx, allMSs, newMS, d); err != nil {
+		return err
+	}
+
+	if err := r.syncDeploymentStatus(ctx, allMSs, newMS, d); err != nil {
+		return err
+	}
+
+	if dutil.DeploymentComplete(d, &d.Status) {
+		if err := r.cleanupDeployment(ctx, oldMSs, d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reconcileOldMachineSetsOnDelete scales down old machine sets to the number of their machines which are not deleted,
+// so they don't get replaced by the old machine set. Old machine sets are only scaled down further if the deployment
+// got scaled down below the number of old machines.
+func (r *ReconcileMachineDeployment) reconcileOldMachineSetsOnDelete(ctx context.Context, oldMSs []*v1alpha1.MachineSet, machineMap map[types.UID]*v1alpha1.MachineList, deployment *v1alpha1.MachineDeployment) error {
+	if deployment.Spec.Replicas == nil {
+		return errors.Errorf("spec replicas for deployment set %v is nil, this is unexpected", deployment.Name)
+	}
+
+	sort.Sort(dutil.MachineSetsByCreationTimestamp(oldMSs))
+
+	newReplicasCounts, err := oldMachineSetReplicasOnDelete(oldMSs, machineMap, *(deployment.Spec.Replicas))
+	if err != nil {
+		return err
+	}
+
+	for i, targetMS := range oldMSs {
+		oldMSReplicas := *(targetMS.Spec.Replicas)
+		if newReplicasCounts[i] == oldMSReplicas {
+			continue
+		}
+
+		klog.V(4).Infof("Scaling down old MS %s/%s from %d to %d replicas", targetMS.Namespace, targetMS.Name, oldMSReplicas, newReplicasCounts[i])
+		if _, err := r.scaleMachineSet(ctx, targetMS, newReplicasCounts[i], deployment); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// oldMachineSetReplicasOnDelete returns the replicas of the old machine sets, which are sorted from oldest to newest.
+// Machines which got deleted are not replaced by the old machine sets, and the oldest machine sets are scaled down
+// first if there are more old machines than desired replicas.
+func oldMachineSetReplicasOnDelete(oldMSs []*v1alpha1.MachineSet, machineMap map[types.UID]*v1alpha1.MachineList, desiredReplicas int32) ([]int32, error) {
+	replicas := make([]int32, len(oldMSs))
+	oldMachinesCount := int32(0)
+	for i, ms := range oldMSs {
+		if ms.Spec.Replicas == nil {
+			return nil, errors.Errorf("spec replicas for machine set %v is nil, this is unexpected", ms.Name)
+		}
+		replicas[i] = integer.Int32Min(*(ms.Spec.Replicas), activeMachineCount(machineMap[ms.UID]))
+		oldMachinesCount += replicas[i]
+	}
+
+	excessCount := oldMachinesCount - desiredReplicas
+	for i := range oldMSs {
+		if excessCount <= 0 {
+			break
+		}
+		scaleDownCount := integer.Int32Min(replicas[i], excessCount)
+		replicas[i] -= scaleDownCount
+		excessCount -= scaleDownCount
+	}
+
+	return replicas, nil
+}
+
+// activeMachineCount returns the number of machines which are not being deleted.
+func activeMachineCount(machines *v1alpha1.MachineList) int32 {
+	if machines == nil {
+		return 0
+	}
+
+	count := int32(0)
+	for _, machine := range machines.Items {
+		if machine.DeletionTimestamp == nil {
+			count++
+		}
+	}
+	return count
+}
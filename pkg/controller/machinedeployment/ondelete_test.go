@@ -0,0 +1,182 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machinedeployment
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+	dutil "github.com/kubermatic/machine-controller/pkg/controller/util"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	utilpointer "k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOldMachineSetReplicasOnDelete(t *testing.T) {
+	tests := []struct {
+		name            string
+		replicas        []int32
+		activeMachines  []int
+		desiredReplicas int32
+		expected        []int32
+	}{
+		{
+			name:            "no machine deleted",
+			replicas:        []int32{3},
+			activeMachines:  []int{3},
+			desiredReplicas: 3,
+			expected:        []int32{3},
+		},
+		{
+			name:            "machine deleted",
+			replicas:        []int32{3},
+			activeMachines:  []int{2},
+			desiredReplicas: 3,
+			expected:        []int32{2},
+		},
+		{
+			name:            "deployment scaled down",
+			replicas:        []int32{2, 2},
+			activeMachines:  []int{2, 2},
+			desiredReplicas: 3,
+			expected:        []int32{1, 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var oldMSs []*v1alpha1.MachineSet
+			machineMap := map[types.UID]*v1alpha1.MachineList{}
+			for i, replicas := range test.replicas {
+				ms := &v1alpha1.MachineSet{
+					ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("ms-%d", i), UID: types.UID(fmt.Sprintf("ms-%d", i))},
+					Spec:       v1alpha1.MachineSetSpec{Replicas: utilpointer.Int32Ptr(replicas)},
+				}
+				machines := &v1alpha1.MachineList{}
+				for j := 0; j < test.activeMachines[i]; j++ {
+					machines.Items = append(machines.Items, v1alpha1.Machine{})
+				}
+				// Machines which are being deleted don't count
+				machines.Items = append(machines.Items, v1alpha1.Machine{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &metav1.Time{}}})
+				oldMSs = append(oldMSs, ms)
+				machineMap[ms.UID] = machines
+			}
+
+			replicas, err := oldMachineSetReplicasOnDelete(oldMSs, machineMap, test.desiredReplicas)
+			if err != nil {
+				t.Fatalf("failed to get replicas: %v", err)
+			}
+			if !reflect.DeepEqual(replicas, test.expected) {
+				t.Errorf("expected replicas %v, got %v", test.expected, replicas)
+			}
+		})
+	}
+}
+
+// TestRolloutOnDeleteReplacesDeletedMachine deletes a machine of the old MachineSet and expects it to be replaced by
+// the new MachineSet.
+func TestRolloutOnDeleteReplacesDeletedMachine(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add the cluster API to the scheme: %v", err)
+	}
+
+	labels := map[string]string{"name": "md"}
+	d := &v1alpha1.MachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "md", Namespace: "kube-system", UID: "md-uid", Finalizers: []string{metav1.FinalizerDeleteDependents}},
+		Spec: v1alpha1.MachineDeploymentSpec{
+			Replicas: utilpointer.Int32Ptr(2),
+			Selector: metav1.LabelSelector{MatchLabels: labels},
+			Strategy: &v1alpha1.MachineDeploymentStrategy{Type: common.OnDeleteMachineDeploymentStrategyType},
+			Template: v1alpha1.MachineTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       v1alpha1.MachineSpec{Versions: v1alpha1.MachineVersionInfo{Kubelet: "1.24.2"}},
+			},
+		},
+	}
+
+	oldTemplate := *d.Spec.Template.DeepCopy()
+	oldTemplate.Spec.Versions.Kubelet = "1.23.8"
+	oldTemplate.Labels = dutil.CloneAndAddLabel(labels, dutil.DefaultMachineDeploymentUniqueLabelKey, "old")
+	oldMS := &v1alpha1.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "md-old",
+			Namespace:       d.Namespace,
+			UID:             "md-old-uid",
+			Labels:          oldTemplate.Labels,
+			Annotations:     map[string]string{dutil.RevisionAnnotation: "1"},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, controllerKind)},
+		},
+		Spec: v1alpha1.MachineSetSpec{
+			Replicas: utilpointer.Int32Ptr(2),
+			Selector: metav1.LabelSelector{MatchLabels: oldTemplate.Labels},
+			Template: oldTemplate,
+		},
+	}
+
+	// The second machine of the old MachineSet got deleted
+	machine := &v1alpha1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "md-old-1",
+			Namespace:       d.Namespace,
+			Labels:          oldTemplate.Labels,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(oldMS, v1alpha1.SchemeGroupVersion.WithKind("MachineSet"))},
+		},
+		Spec: oldTemplate.Spec,
+	}
+
+	c := fakectrlruntimeclient.NewClientBuilder().WithScheme(scheme).WithObjects(d, oldMS, machine).Build()
+	r := &ReconcileMachineDeployment{Client: c, scheme: scheme, recorder: record.NewFakeRecorder(10)}
+
+	ctx := context.Background()
+	// The new MachineSet gets created and its annotations synced in separate reconciliations
+	for i := 0; i < 3; i++ {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(d), d); err != nil {
+			t.Fatalf("failed to get MachineDeployment: %v", err)
+		}
+		if _, err := r.reconcile(ctx, d); err != nil {
+			t.Fatalf("failed to reconcile MachineDeployment: %v", err)
+		}
+	}
+
+	machineSets := &v1alpha1.MachineSetList{}
+	if err := c.List(ctx, machineSets); err != nil {
+		t.Fatalf("failed to list MachineSets: %v", err)
+	}
+	if len(machineSets.Items) != 2 {
+		t.Fatalf("expected an old and a new MachineSet, got %d MachineSets", len(machineSets.Items))
+	}
+
+	for _, ms := range machineSets.Items {
+		expectedReplicas := int32(1)
+		if ms.Name != oldMS.Name && !dutil.EqualIgnoreHash(&ms.Spec.Template, &d.Spec.Template) {
+			t.Errorf("expected MachineSet %q to have the template of the MachineDeployment", ms.Name)
+		}
+		if replicas := *ms.Spec.Replicas; replicas != expectedReplicas {
+			t.Errorf("expected MachineSet %q to have %d replicas, got %d", ms.Name, expectedReplicas, replicas)
+		}
+	}
+}
@@ -25,7 +25,9 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
 	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+	dutil "github.com/kubermatic/machine-controller/pkg/controller/util"
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -249,6 +251,16 @@ func (r *ReconcileMachineSet) syncReplicas(ctx context.Context, ms *clusterv1alp
 	diff := len(machines) - int(*(ms.Spec.Replicas))
 
 	if diff < 0 {
+		replace, err := r.replacesMissingMachines(ctx, ms)
+		if err != nil {
+			return err
+		}
+		if !replace {
+			klog.V(4).Infof("Not replacing %d missing machines of old %v %s/%s, its MachineDeployment uses the OnDelete strategy",
+				-diff, controllerKind, ms.Namespace, ms.Name)
+			return nil
+		}
+
 		diff *= -1
 		klog.Infof("Too few replicas for %v %s/%s, need %d, creating %d",
 			controllerKind, ms.Namespace, ms.Name, *(ms.Spec.Replicas), diff)
@@ -317,6 +329,30 @@ func (r *ReconcileMachineSet) syncReplicas(ctx context.Context, ms *clusterv1alp
 	return nil
 }
 
+// replacesMissingMachines returns whether the MachineSet creates machines to reach its replicas. Old MachineSets of a
+// MachineDeployment with the OnDelete strategy don't replace deleted machines, the MachineDeployment scales them down
+// instead and replaces the machines from its newest MachineSet.
+func (r *ReconcileMachineSet) replacesMissingMachines(ctx context.Context, ms *clusterv1alpha1.MachineSet) (bool, error) {
+	ref := metav1.GetControllerOf(ms)
+	if ref == nil || ref.Kind != "MachineDeployment" {
+		return true, nil
+	}
+
+	d := &clusterv1alpha1.MachineDeployment{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: ms.Namespace, Name: ref.Name}, d); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, errors.Wrapf(err, "failed to get MachineDeployment %q", ref.Name)
+	}
+
+	if d.UID != ref.UID || d.Spec.Strategy == nil || d.Spec.Strategy.Type != common.OnDeleteMachineDeploymentStrategyType {
+		return true, nil
+	}
+
+	return dutil.EqualIgnoreHash(&d.Spec.Template, &ms.Spec.Template), nil
+}
+
 // createMachine creates a Machine resource. The name of the newly created resource is going
 // to be created by the API server, we set the generateName field.
 func (r *ReconcileMachineSet) createMachine(machineSet *clusterv1alpha1.MachineSet) *clusterv1alpha1.Machine {
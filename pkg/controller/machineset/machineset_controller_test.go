@@ -0,0 +1,135 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package machineset
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
+	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilpointer "k8s.io/utils/pointer"
+	fakectrlruntimeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReplacesMissingMachines(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clusterv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to add the cluster API to the scheme: %v", err)
+	}
+
+	newDeployment := func(strategy common.MachineDeploymentStrategyType) *clusterv1alpha1.MachineDeployment {
+		return &clusterv1alpha1.MachineDeployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "md", Namespace: "kube-system", UID: "md-uid"},
+			Spec: clusterv1alpha1.MachineDeploymentSpec{
+				Strategy: &clusterv1alpha1.MachineDeploymentStrategy{Type: strategy},
+				Template: clusterv1alpha1.MachineTemplateSpec{
+					Spec: clusterv1alpha1.MachineSpec{Versions: clusterv1alpha1.MachineVersionInfo{Kubelet: "1.24.2"}},
+				},
+			},
+		}
+	}
+	newMachineSet := func(d *clusterv1alpha1.MachineDeployment, kubeletVersion string) *clusterv1alpha1.MachineSet {
+		ms := &clusterv1alpha1.MachineSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "md-ms", Namespace: "kube-system"},
+			Spec: clusterv1alpha1.MachineSetSpec{
+				Replicas: utilpointer.Int32Ptr(2),
+				Template: clusterv1alpha1.MachineTemplateSpec{
+					Spec: clusterv1alpha1.MachineSpec{Versions: clusterv1alpha1.MachineVersionInfo{Kubelet: kubeletVersion}},
+				},
+			},
+		}
+		if d != nil {
+			ms.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(d, clusterv1alpha1.SchemeGroupVersion.WithKind("MachineDeployment"))}
+		}
+		return ms
+	}
+
+	onDelete := newDeployment(common.OnDeleteMachineDeploymentStrategyType)
+	rollingUpdate := newDeployment(common.RollingUpdateMachineDeploymentStrategyType)
+
+	tests := []struct {
+		name       string
+		deployment *clusterv1alpha1.MachineDeployment
+		machineSet *clusterv1alpha1.MachineSet
+		expected   bool
+	}{
+		{
+			name:       "MachineSet without MachineDeployment",
+			machineSet: newMachineSet(nil, "1.23.8"),
+			expected:   true,
+		},
+		{
+			name:       "old MachineSet of a RollingUpdate MachineDeployment",
+			deployment: rollingUpdate,
+			machineSet: newMachineSet(rollingUpdate, "1.23.8"),
+			expected:   true,
+		},
+		{
+			name:       "new MachineSet of an OnDelete MachineDeployment",
+			deployment: onDelete,
+			machineSet: newMachineSet(onDelete, "1.24.2"),
+			expected:   true,
+		},
+		{
+			name:       "old MachineSet of an OnDelete MachineDeployment",
+			deployment: onDelete,
+			machineSet: newMachineSet(onDelete, "1.23.8"),
+			expected:   false,
+		},
+		{
+			name:       "MachineDeployment is gone",
+			machineSet: newMachineSet(onDelete, "1.23.8"),
+			expected:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder := fakectrlruntimeclient.NewClientBuilder().WithScheme(scheme).WithObjects(test.machineSet)
+			if test.deployment != nil {
+				builder = builder.WithObjects(test.deployment)
+			}
+			r := &ReconcileMachineSet{Client: builder.Build(), scheme: scheme}
+
+			replace, err := r.replacesMissingMachines(context.Background(), test.machineSet)
+			if err != nil {
+				t.Fatalf("failed to check whether missing machines are replaced: %v", err)
+			}
+			if replace != test.expected {
+				t.Errorf("expected missing machines to be replaced: %t, got %t", test.expected, replace)
+			}
+
+			// Old MachineSets of OnDelete MachineDeployments don't create machines for their missing replicas
+			if !test.expected {
+				if err := r.syncReplicas(context.Background(), test.machineSet, nil); err != nil {
+					t.Fatalf("failed to sync replicas: %v", err)
+				}
+				machines := &clusterv1alpha1.MachineList{}
+				if err := r.Client.List(context.Background(), machines); err != nil {
+					t.Fatalf("failed to list machines: %v", err)
+				}
+				if len(machines.Items) != 0 {
+					t.Errorf("expected no machines to be created, got %d", len(machines.Items))
+				}
+			}
+		})
+	}
+}
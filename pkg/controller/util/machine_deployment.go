@@ -530,6 +530,10 @@ func NewMSNewReplicas(deployment *v1alpha1.MachineDeployment, allMSs []*v1alpha1
 		// Do not exceed the number of desired replicas.
 		scaleUpCount = integer.Int32Min(scaleUpCount, *(deployment.Spec.Replicas)-*(newMS.Spec.Replicas))
 		return *(newMS.Spec.Replicas) + scaleUpCount, nil
+	case common.OnDeleteMachineDeploymentStrategyType:
+		// The new MS only replaces the machines which are gone from the old MSs.
+		oldMachineCount := GetReplicaCountForMachineSets(allMSs) - *(newMS.Spec.Replicas)
+		return integer.Int32Max(*(deployment.Spec.Replicas)-oldMachineCount, 0), nil
 	default:
 		// Check if we can scale up.
 		maxSurge, err := intstrutil.GetValueFromIntOrPercent(deployment.Spec.Strategy.RollingUpdate.MaxSurge, int(*(deployment.Spec.Replicas)), true)
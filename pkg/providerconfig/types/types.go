@@ -102,6 +102,18 @@ var (
 	}
 )
 
+// FilterOperatingSystems returns the operating systems for which supported returns true, in the order of AllOperatingSystems.
+func FilterOperatingSystems(supported func(os OperatingSystem) bool) []OperatingSystem {
+	var operatingSystems []OperatingSystem
+	for _, os := range AllOperatingSystems {
+		if supported(os) {
+			operatingSystems = append(operatingSystems, os)
+		}
+	}
+
+	return operatingSystems
+}
+
 // DNSConfig contains a machine's DNS configuration
 type DNSConfig struct {
 	Servers []string `json:"servers"`
@@ -146,6 +158,56 @@ type Config struct {
 
 	// +optional
 	OverwriteCloudConfig *string `json:"overwriteCloudConfig,omitempty"`
+
+	// ExtraFiles are written to the node in addition to the files of the operating system template.
+	// +optional
+	ExtraFiles []ExtraFile `json:"extraFiles,omitempty"`
+
+	// CACertificates are PEM encoded CA bundles which are added to the trust store of the operating system, e.g. the
+	// CA of a TLS intercepting proxy or of a private registry.
+	// +optional
+	CACertificates []string `json:"caCertificates,omitempty"`
+
+	// TemplateOverrides replace named snippets of the operating system template, keyed by the snippet name.
+	// Each override is a Go template, which is executed with the same data as the operating system template.
+	// +optional
+	TemplateOverrides map[string]string `json:"templateOverrides,omitempty"`
+
+	// KubeletHealthCheck configures the health check which restarts the kubelet once its healthz endpoint stops
+	// responding, e.g. if the healthz address or port of the kubelet is customized.
+	// +optional
+	KubeletHealthCheck *KubeletHealthCheck `json:"kubeletHealthCheck,omitempty"`
+}
+
+// KubeletHealthCheck configures the kubelet health check, unset fields keep their defaults.
+type KubeletHealthCheck struct {
+	// Host of the kubelet healthz endpoint, defaults to 127.0.0.1
+	// +optional
+	Host string `json:"host,omitempty"`
+	// Port of the kubelet healthz endpoint, defaults to 10248
+	// +optional
+	Port int32 `json:"port,omitempty"`
+	// TimeoutSeconds of a single check, defaults to 10
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// PeriodSeconds between two checks, defaults to 10
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// FailureThreshold is the number of consecutive failed checks after which the kubelet is restarted, defaults to 1
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// ExtraFile is a file which is written to the node.
+type ExtraFile struct {
+	Path string `json:"path"`
+	// Permissions in octal notation, e.g. "0644"
+	// +optional
+	Permissions string `json:"permissions,omitempty"`
+	Content     string `json:"content"`
+	// Encoding of the content, either empty for plain text or "base64" for binary content
+	// +optional
+	Encoding string `json:"encoding,omitempty"`
 }
 
 // GlobalObjectKeySelector is needed as we can not use v1.SecretKeySelector
@@ -53,6 +53,11 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		return "", fmt.Errorf("failed to get provider config: %w", err)
 	}
 
+	req.DNSIPs, err = userdatahelper.NormalizeDNSIPs(req.DNSIPs)
+	if err != nil {
+		return "", fmt.Errorf("invalid cluster DNS IPs: %w", err)
+	}
+
 	if pconfig.OverwriteCloudConfig != nil {
 		req.CloudConfig = *pconfig.OverwriteCloudConfig
 	}
@@ -106,6 +111,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		ContainerRuntimeConfigFileName string
 		ContainerRuntimeConfig         string
 		ContainerRuntimeName           string
+		CgroupDriver                   string
 	}{
 		UserDataRequest:                req,
 		ProviderSpec:                   pconfig,
@@ -120,6 +126,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		ContainerRuntimeConfigFileName: crEngine.ConfigFileName(),
 		ContainerRuntimeConfig:         crConfig,
 		ContainerRuntimeName:           crEngine.String(),
+		CgroupDriver:                   crEngine.CgroupDriver(),
 	}
 
 	buf := strings.Builder{}
@@ -155,7 +162,7 @@ write_files:
 {{- if .HTTPProxy }}
 - path: "/etc/environment"
   content: |
-{{ proxyEnvironment .HTTPProxy .NoProxy | indent 4 }}
+{{ proxyEnvironment .HTTPProxy .NoProxy .CloudProviderName .ClusterCIDRs .ServerAddr | indent 4 }}
 {{- end }}
 
 - path: "/etc/systemd/journald.conf.d/max_disk_use.conf"
@@ -258,6 +265,11 @@ write_files:
   content: |
 {{ .CloudConfig | indent 4 }}
 
+- path: "/opt/bin/health-monitor.sh"
+  permissions: "0755"
+  content: |
+{{ healthMonitorScript | indent 4 }}
+
 - path: "/opt/bin/setup_net_env.sh"
   permissions: "0755"
   content: |
@@ -270,7 +282,11 @@ write_files:
 
 - path: "/etc/kubernetes/kubelet.conf"
   content: |
-{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName | indent 4 }}
+{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName .CgroupDriver | indent 4 }}
+{{- with reservedCgroupsWriteFiles .KubeletConfigs .ContainerRuntimeName }}
+
+{{ . }}
+{{- end }}
 
 - path: "/etc/kubernetes/pki/ca.crt"
   content: |
@@ -305,7 +321,7 @@ write_files:
 - path: /etc/systemd/system/kubelet-healthcheck.service
   permissions: "0644"
   content: |
-{{ kubeletHealthCheckSystemdUnit | indent 4 }}
+{{ kubeletHealthCheckSystemdUnit .ProviderSpec.KubeletHealthCheck | indent 4 }}
 
 {{- with .ProviderSpec.CAPublicKey }}
 
@@ -318,6 +334,10 @@ write_files:
 {{ sshConfigAddendum | indent 4 }}
   append: true
 {{- end }}
+{{- with .ProviderSpec.ExtraFiles }}
+
+{{ extraWriteFiles . }}
+{{- end }}
 
 runcmd:
 - systemctl start setup.service
@@ -53,6 +53,11 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		return "", fmt.Errorf("failed to get provider config: %w", err)
 	}
 
+	req.DNSIPs, err = userdatahelper.NormalizeDNSIPs(req.DNSIPs)
+	if err != nil {
+		return "", fmt.Errorf("invalid cluster DNS IPs: %w", err)
+	}
+
 	if pconfig.OverwriteCloudConfig != nil {
 		req.CloudConfig = *pconfig.OverwriteCloudConfig
 	}
@@ -106,6 +111,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		ContainerRuntimeConfigFileName string
 		ContainerRuntimeConfig         string
 		ContainerRuntimeName           string
+		CgroupDriver                   string
 	}{
 		UserDataRequest:                req,
 		ProviderSpec:                   pconfig,
@@ -120,6 +126,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		ContainerRuntimeConfigFileName: crEngine.ConfigFileName(),
 		ContainerRuntimeConfig:         crConfig,
 		ContainerRuntimeName:           crEngine.String(),
+		CgroupDriver:                   crEngine.CgroupDriver(),
 	}
 
 	buf := strings.Builder{}
@@ -155,7 +162,7 @@ write_files:
 {{- if .HTTPProxy }}
 - path: "/etc/environment"
   content: |
-{{ proxyEnvironment .HTTPProxy .NoProxy | indent 4 }}
+{{ proxyEnvironment .HTTPProxy .NoProxy .CloudProviderName .ClusterCIDRs .ServerAddr | indent 4 }}
 {{- end }}
 
 - path: "/etc/systemd/journald.conf.d/max_disk_use.conf"
@@ -196,6 +203,9 @@ write_files:
 {{- /* As we added some modules and don't want to reboot, restart the service */}}
     systemctl restart systemd-modules-load.service
     sysctl --system
+{{- if networkMTU .CloudProviderName .ProviderSpec }}
+    udevadm trigger --subsystem-match=net --action=change
+{{- end }}
 
     {{ if ne .CloudProviderName "aws" }}
 {{- /*  The normal way of setting it via cloud-init is broken, see */}}
@@ -276,6 +286,11 @@ write_files:
   content: |
 {{ .CloudConfig | indent 4 }}
 
+- path: "/opt/bin/health-monitor.sh"
+  permissions: "0755"
+  content: |
+{{ healthMonitorScript | indent 4 }}
+
 - path: "/opt/bin/setup_net_env.sh"
   permissions: "0755"
   content: |
@@ -288,7 +303,11 @@ write_files:
 
 - path: "/etc/kubernetes/kubelet.conf"
   content: |
-{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName | indent 4 }}
+{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName .CgroupDriver | indent 4 }}
+{{- with reservedCgroupsWriteFiles .KubeletConfigs .ContainerRuntimeName }}
+
+{{ . }}
+{{- end }}
 
 - path: "/etc/kubernetes/pki/ca.crt"
   content: |
@@ -323,7 +342,7 @@ write_files:
 - path: /etc/systemd/system/kubelet-healthcheck.service
   permissions: "0644"
   content: |
-{{ kubeletHealthCheckSystemdUnit | indent 4 }}
+{{ kubeletHealthCheckSystemdUnit .ProviderSpec.KubeletHealthCheck | indent 4 }}
 
 {{- with .ProviderSpec.CAPublicKey }}
 
@@ -372,6 +391,16 @@ write_files:
     [Install]
     WantedBy=multi-user.target
 {{- end }}
+{{- with networkMTU .CloudProviderName .ProviderSpec }}
+
+- path: "/etc/udev/rules.d/99-network-mtu.rules"
+  content: |
+    {{ networkMTUUdevRule . }}
+{{- end }}
+{{- with .ProviderSpec.ExtraFiles }}
+
+{{ extraWriteFiles . }}
+{{- end }}
 
 runcmd:
 - systemctl start setup.service
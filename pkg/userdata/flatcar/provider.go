@@ -43,6 +43,11 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		return "", fmt.Errorf("failed to get provider config: %v", err)
 	}
 
+	req.DNSIPs, err = userdatahelper.NormalizeDNSIPs(req.DNSIPs)
+	if err != nil {
+		return "", fmt.Errorf("invalid cluster DNS IPs: %w", err)
+	}
+
 	if pconfig.OverwriteCloudConfig != nil {
 		req.CloudConfig = *pconfig.OverwriteCloudConfig
 	}
@@ -67,6 +72,11 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		return "", fmt.Errorf("invalid kubelet version: %v", err)
 	}
 
+	serverAddr, err := userdatahelper.GetServerAddressFromKubeconfig(req.Kubeconfig)
+	if err != nil {
+		return "", fmt.Errorf("error extracting server address from kubeconfig: %v", err)
+	}
+
 	kubeconfigString, err := userdatahelper.StringifyKubeconfig(req.Kubeconfig)
 	if err != nil {
 		return "", err
@@ -97,6 +107,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		plugin.UserDataRequest
 		ProviderSpec                   *providerconfigtypes.Config
 		FlatcarConfig                  *Config
+		ServerAddr                     string
 		Kubeconfig                     string
 		KubernetesCACert               string
 		KubeletVersion                 string
@@ -106,10 +117,12 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		ContainerRuntimeConfigFileName string
 		ContainerRuntimeConfig         string
 		ContainerRuntimeName           string
+		CgroupDriver                   string
 	}{
 		UserDataRequest:                req,
 		ProviderSpec:                   pconfig,
 		FlatcarConfig:                  flatcarConfig,
+		ServerAddr:                     serverAddr,
 		Kubeconfig:                     kubeconfigString,
 		KubernetesCACert:               kubernetesCACert,
 		KubeletVersion:                 kubeletVersion.String(),
@@ -119,6 +132,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		ContainerRuntimeConfigFileName: crEngine.ConfigFileName(),
 		ContainerRuntimeConfig:         crConfig,
 		ContainerRuntimeName:           crEngine.String(),
+		CgroupDriver:                   crEngine.CgroupDriver(),
 	}
 
 	b := &bytes.Buffer{}
@@ -244,7 +258,7 @@ systemd:
           Requires=download-script.service
           After=download-script.service
       contents: |
-{{ kubeletHealthCheckSystemdUnit | indent 10 }}
+{{ kubeletHealthCheckSystemdUnit .ProviderSpec.KubeletHealthCheck | indent 10 }}
 
     - name: nodeip.service
       enabled: true
@@ -302,7 +316,7 @@ storage:
       mode: 0644
       contents:
         inline: |
-{{ proxyEnvironment .HTTPProxy .NoProxy | indent 10 }}
+{{ proxyEnvironment .HTTPProxy .NoProxy .CloudProviderName .ClusterCIDRs .ServerAddr | indent 10 }}
 {{- end }}
 
     - path: "/etc/systemd/journald.conf.d/max_disk_use.conf"
@@ -317,7 +331,16 @@ storage:
       mode: 0644
       contents:
         inline: |
-{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName | indent 10 }}
+{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName .CgroupDriver | indent 10 }}
+{{- range $path, $content := reservedCgroupsFiles .KubeletConfigs .ContainerRuntimeName }}
+
+    - path: "{{ $path }}"
+      filesystem: root
+      mode: 0644
+      contents:
+        inline: |
+{{ $content | trim | indent 10 }}
+{{- end }}
 
     - path: /opt/load-kernel-modules.sh
       filesystem: root
@@ -354,6 +377,13 @@ storage:
         inline: |
           1
 
+    - path: "/opt/bin/health-monitor.sh"
+      filesystem: root
+      mode: 0755
+      contents:
+        inline: |
+{{ healthMonitorScript | indent 10 }}
+
     - path: "/opt/bin/setup_net_env.sh"
       filesystem: root
       mode: 0755
@@ -572,7 +602,7 @@ coreos:
         Requires=download-script.service
         After=download-script.service
     content: |
-{{ kubeletHealthCheckSystemdUnit | indent 6 }}
+{{ kubeletHealthCheckSystemdUnit .ProviderSpec.KubeletHealthCheck | indent 6 }}
 
   - name: nodeip.service
     enable: true
@@ -643,7 +673,7 @@ write_files:
 - path: /etc/environment
   permissions: "0644"
   content: |
-{{ proxyEnvironment .HTTPProxy .NoProxy | indent 4 }}
+{{ proxyEnvironment .HTTPProxy .NoProxy .CloudProviderName .ClusterCIDRs .ServerAddr | indent 4 }}
 {{- end }}
 
 - path: "/etc/systemd/journald.conf.d/max_disk_use.conf"
@@ -654,7 +684,11 @@ write_files:
 - path: "/etc/kubernetes/kubelet.conf"
   permissions: "0644"
   content: |
-{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName | indent 4 }}
+{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName .CgroupDriver | indent 4 }}
+{{- with reservedCgroupsWriteFiles .KubeletConfigs .ContainerRuntimeName }}
+
+{{ . }}
+{{- end }}
 
 - path: /opt/load-kernel-modules.sh
   permissions: "0755"
@@ -666,6 +700,11 @@ write_files:
   content: |
 {{ kernelSettings | indent 4 }}
 
+- path: "/opt/bin/health-monitor.sh"
+  permissions: "0755"
+  content: |
+{{ healthMonitorScript | indent 4 }}
+
 - path: "/opt/bin/setup_net_env.sh"
   permissions: "0755"
   content: |
@@ -783,4 +822,8 @@ write_files:
       touch /etc/kubelet_needs_restart
     fi
 {{- end }}
+{{- with .ProviderSpec.ExtraFiles }}
+
+{{ extraWriteFiles . }}
+{{- end }}
 `
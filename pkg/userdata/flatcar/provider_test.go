@@ -26,6 +26,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
 	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
 	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
 	"github.com/kubermatic/machine-controller/pkg/containerruntime"
@@ -113,6 +114,7 @@ type userDataTestCase struct {
 	registryMirrors       string
 	pauseImage            string
 	containerruntime      string
+	kubeletConfigs        map[string]string
 }
 
 // TestUserDataGeneration runs the data generation for different
@@ -423,6 +425,32 @@ func TestUserDataGeneration(t *testing.T) {
 				ProvisioningUtility: CloudInit,
 			},
 		},
+		{
+			name:             "ignition-enforced-reservations",
+			containerruntime: "containerd",
+			providerSpec: &providerconfigtypes.Config{
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: "v1.24.0",
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{},
+			DNSIPs:     []net.IP{net.ParseIP("10.10.10.10")},
+			osConfig: &Config{
+				DisableAutoUpdate:   true,
+				ProvisioningUtility: Ignition,
+			},
+			kubeletConfigs: map[string]string{
+				common.EnforceNodeAllocatableKubeletConfig: "pods,system-reserved,kube-reserved",
+				common.SystemReservedCgroupKubeletConfig:   "/system-reserved.slice",
+				common.KubeReservedCgroupKubeletConfig:     "/kube-reserved.slice",
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -475,6 +503,7 @@ func TestUserDataGeneration(t *testing.T) {
 				PauseImage:               test.pauseImage,
 				KubeletFeatureGates:      kubeletFeatureGates,
 				ContainerRuntime:         containerRuntimeConfig,
+				KubeletConfigs:           test.kubeletConfigs,
 			}
 
 			s, err := provider.UserData(req)
@@ -0,0 +1,102 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Layouts of the CA trust store of the operating systems
+const (
+	// CATrustStoreRHEL is the trust store of RHEL and derived distributions, which is updated by update-ca-trust
+	CATrustStoreRHEL = "rhel"
+	// CATrustStoreDebian is the trust store of Debian and derived distributions, which is updated by
+	// update-ca-certificates
+	CATrustStoreDebian = "debian"
+)
+
+// caTrustStores are the directories of additional CA certificates and the command which adds them to the trust store
+var caTrustStores = map[string]struct {
+	dir    string
+	update string
+}{
+	CATrustStoreRHEL:   {dir: "/etc/pki/ca-trust/source/anchors", update: "update-ca-trust extract"},
+	CATrustStoreDebian: {dir: "/usr/local/share/ca-certificates", update: "update-ca-certificates"},
+}
+
+// CACertificatesWriteFiles renders the PEM encoded CA bundles as cloud-init write_files entries in the directory of
+// additional CA certificates of the given trust store. update-ca-certificates only picks up files with the .crt
+// extension, so all bundles are written as such.
+func CACertificatesWriteFiles(bundles []string, trustStore string) (string, error) {
+	store, ok := caTrustStores[trustStore]
+	if !ok {
+		return "", fmt.Errorf("unknown CA trust store %q", trustStore)
+	}
+
+	entries := make([]string, 0, len(bundles))
+	for i, bundle := range bundles {
+		if err := validateCABundle(bundle); err != nil {
+			return "", fmt.Errorf("invalid CA certificate bundle at index %d: %v", i, err)
+		}
+
+		filePath := path.Join(store.dir, fmt.Sprintf("machine-controller-ca-%d.crt", i))
+		entries = append(entries, fmt.Sprintf("- path: %s\n  permissions: \"0644\"\n  content: %s", quoteYAML(filePath), quoteYAML(bundle)))
+	}
+
+	return strings.Join(entries, "\n\n"), nil
+}
+
+// UpdateCATrustCommand returns the command which adds the additional CA certificates to the given trust store.
+func UpdateCATrustCommand(trustStore string) (string, error) {
+	store, ok := caTrustStores[trustStore]
+	if !ok {
+		return "", fmt.Errorf("unknown CA trust store %q", trustStore)
+	}
+	return store.update, nil
+}
+
+// validateCABundle checks that the bundle consists of at least one PEM encoded certificate.
+func validateCABundle(bundle string) error {
+	rest := []byte(bundle)
+	certificates := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("unexpected PEM block of type %q", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("failed to parse certificate: %v", err)
+		}
+		certificates++
+	}
+
+	if strings.TrimSpace(string(rest)) != "" {
+		return fmt.Errorf("bundle contains data which isn't PEM encoded")
+	}
+	if certificates == 0 {
+		return fmt.Errorf("bundle contains no certificate")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+)
+
+const (
+	CgroupDriverSystemd  = "systemd"
+	CgroupDriverCgroupfs = "cgroupfs"
+)
+
+// CgroupDriver returns the cgroup driver the kubelet and the container runtime should use by default, a cgroup driver
+// configured for the container runtime takes precedence. All operating systems supported by machine-controller boot
+// with systemd, so the systemd driver is used for them with every container runtime and kubelet version, as systemd
+// must be the only cgroup manager then. Unknown operating systems fall back to cgroupfs, which is the default of the
+// kubelet.
+func CgroupDriver(os providerconfigtypes.OperatingSystem, containerRuntime, kubeletVersion string) string {
+	for _, supportedOS := range providerconfigtypes.AllOperatingSystems {
+		if os == supportedOS {
+			return CgroupDriverSystemd
+		}
+	}
+
+	return CgroupDriverCgroupfs
+}
+
+// CgroupDriverScript returns a script which prints the cgroup driver to use on the node. With cgroup v2 the systemd
+// driver is required, even if cgroupfs is configured, otherwise the given default driver is used.
+func CgroupDriverScript(defaultDriver string) string {
+	return fmt.Sprintf(`#!/bin/bash
+set -euo pipefail
+
+if [ "$(stat -fc %%T /sys/fs/cgroup/)" = "cgroup2fs" ]; then
+  echo %s
+else
+  echo %s
+fi`, CgroupDriverSystemd, defaultDriver)
+}
+
+// ApplyCgroupDriverScript returns the commands which set the detected cgroup driver in the configuration of the
+// kubelet and of the container runtime.
+func ApplyCgroupDriverScript(containerRuntimeConfigFileName string) string {
+	return fmt.Sprintf(`CGROUP_DRIVER="$(/opt/bin/detect-cgroup-driver)"
+sed -i "s/^cgroupDriver: .*/cgroupDriver: ${CGROUP_DRIVER}/" /etc/kubernetes/kubelet.conf
+if [ "${CGROUP_DRIVER}" = "%s" ]; then SYSTEMD_CGROUP=true; else SYSTEMD_CGROUP=false; fi
+sed -i -e "s/native.cgroupdriver=[a-z]*/native.cgroupdriver=${CGROUP_DRIVER}/" -e "s/SystemdCgroup = [a-z]*/SystemdCgroup = ${SYSTEMD_CGROUP}/" %s`, CgroupDriverSystemd, containerRuntimeConfigFileName)
+}
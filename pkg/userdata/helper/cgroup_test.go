@@ -0,0 +1,84 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+)
+
+func TestCgroupDriver(t *testing.T) {
+	tests := []struct {
+		name             string
+		os               providerconfigtypes.OperatingSystem
+		containerRuntime string
+		kubeletVersion   string
+		expected         string
+	}{
+		{
+			name:             "ubuntu with containerd",
+			os:               providerconfigtypes.OperatingSystemUbuntu,
+			containerRuntime: "containerd",
+			kubeletVersion:   "1.24.0",
+			expected:         CgroupDriverSystemd,
+		},
+		{
+			name:             "rhel with docker",
+			os:               providerconfigtypes.OperatingSystemRHEL,
+			containerRuntime: "docker",
+			kubeletVersion:   "1.21.10",
+			expected:         CgroupDriverSystemd,
+		},
+		{
+			name:             "flatcar with containerd",
+			os:               providerconfigtypes.OperatingSystemFlatcar,
+			containerRuntime: "containerd",
+			kubeletVersion:   "1.23.5",
+			expected:         CgroupDriverSystemd,
+		},
+		{
+			name:             "unknown operating system",
+			os:               providerconfigtypes.OperatingSystem("gentoo"),
+			containerRuntime: "containerd",
+			kubeletVersion:   "1.24.0",
+			expected:         CgroupDriverCgroupfs,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if driver := CgroupDriver(test.os, test.containerRuntime, test.kubeletVersion); driver != test.expected {
+				t.Errorf("expected cgroup driver %q, got %q", test.expected, driver)
+			}
+		})
+	}
+}
+
+func TestCgroupDriverScript(t *testing.T) {
+	script := CgroupDriverScript(CgroupDriverCgroupfs)
+
+	for _, expected := range []string{
+		`if [ "$(stat -fc %T /sys/fs/cgroup/)" = "cgroup2fs" ]; then`,
+		"  echo systemd\nelse\n  echo cgroupfs\nfi",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("expected script to contain %q, got:\n%s", expected, script)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"net"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// NormalizeDNSIPs validates the cluster DNS IPs and returns them without duplicates, the IPv4 addresses before the
+// IPv6 addresses. The order within each family is kept.
+func NormalizeDNSIPs(ips []net.IP) ([]net.IP, error) {
+	var ipv4, ipv6 []net.IP
+	seen := sets.NewString()
+
+	for _, ip := range ips {
+		if ip.To16() == nil {
+			return nil, fmt.Errorf("invalid DNS IP %q, it's neither an IPv4 nor an IPv6 address", ip.String())
+		}
+		if ip.IsUnspecified() {
+			return nil, fmt.Errorf("invalid DNS IP %q, it's the unspecified address", ip.String())
+		}
+
+		if seen.Has(ip.String()) {
+			continue
+		}
+		seen.Insert(ip.String())
+
+		if ip4 := ip.To4(); ip4 != nil {
+			ipv4 = append(ipv4, ip4)
+		} else {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+
+	return append(ipv4, ipv6...), nil
+}
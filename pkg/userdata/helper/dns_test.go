@@ -0,0 +1,96 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeDNSIPs(t *testing.T) {
+	tests := []struct {
+		name          string
+		ips           []net.IP
+		expected      []net.IP
+		expectedError bool
+	}{
+		{
+			name: "no IPs",
+		},
+		{
+			name:     "single IPv4",
+			ips:      []net.IP{net.ParseIP("10.10.10.10")},
+			expected: []net.IP{net.ParseIP("10.10.10.10").To4()},
+		},
+		{
+			name: "dual-stack",
+			ips: []net.IP{
+				net.ParseIP("fd00::10"),
+				net.ParseIP("10.10.10.10"),
+				net.ParseIP("fd00::20"),
+				net.ParseIP("10.10.10.20"),
+			},
+			expected: []net.IP{
+				net.ParseIP("10.10.10.10").To4(),
+				net.ParseIP("10.10.10.20").To4(),
+				net.ParseIP("fd00::10"),
+				net.ParseIP("fd00::20"),
+			},
+		},
+		{
+			name: "duplicates",
+			ips: []net.IP{
+				net.ParseIP("10.10.10.10"),
+				net.ParseIP("10.10.10.10").To4(),
+				net.ParseIP("fd00::10"),
+				net.ParseIP("fd00:0::10"),
+			},
+			expected: []net.IP{
+				net.ParseIP("10.10.10.10").To4(),
+				net.ParseIP("fd00::10"),
+			},
+		},
+		{
+			name:          "unparseable IP",
+			ips:           []net.IP{net.ParseIP("10.10.10.300")},
+			expectedError: true,
+		},
+		{
+			name:          "invalid length",
+			ips:           []net.IP{{10, 10, 10}},
+			expectedError: true,
+		},
+		{
+			name:          "unspecified address",
+			ips:           []net.IP{net.ParseIP("::")},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ips, err := NormalizeDNSIPs(test.ips)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("expected error: %t, got: %v", test.expectedError, err)
+			}
+			if !reflect.DeepEqual(ips, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, ips)
+			}
+		})
+	}
+}
@@ -121,11 +121,6 @@ for bin in kubelet kubeadm kubectl; do
     {{- /* link kube binaries from verioned dir to $opt_bin */}}
     ln -sf "$kube_dir/$bin" "$opt_bin"/$bin
 done
-
-if [[ ! -x /opt/bin/health-monitor.sh ]]; then
-    curl -Lfo /opt/bin/health-monitor.sh https://raw.githubusercontent.com/kubermatic/machine-controller/7967a0af2b75f29ad2ab227eeaa26ea7b0f2fbde/pkg/userdata/scripts/health-monitor.sh
-    chmod +x /opt/bin/health-monitor.sh
-fi
 `
 
 	downloadBinariesTpl = `{{- /*setup some common directories */ -}}
@@ -147,11 +142,6 @@ if [ ! -f /opt/bin/kubelet ]; then
     chmod +x /opt/bin/kubelet
 fi
 {{- end }}
-
-if [[ ! -x /opt/bin/health-monitor.sh ]]; then
-    curl -Lfo /opt/bin/health-monitor.sh https://raw.githubusercontent.com/kubermatic/machine-controller/8b5b66e4910a6228dfaecccaa0a3b05ec4902f8e/pkg/userdata/scripts/health-monitor.sh
-    chmod +x /opt/bin/health-monitor.sh
-fi
 `
 )
 
@@ -0,0 +1,75 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+)
+
+const extraFileEncodingBase64 = "base64"
+
+var filePermissionsRegex = regexp.MustCompile(`^0?[0-7]{3}$`)
+
+// ExtraWriteFiles renders the extra files of the provider config as cloud-init write_files entries.
+func ExtraWriteFiles(files []providerconfigtypes.ExtraFile) (string, error) {
+	entries := make([]string, 0, len(files))
+
+	for _, file := range files {
+		if !filepath.IsAbs(file.Path) {
+			return "", fmt.Errorf("path of extra file %q must be absolute", file.Path)
+		}
+
+		var entry strings.Builder
+		fmt.Fprintf(&entry, "- path: %s\n", quoteYAML(file.Path))
+
+		if file.Permissions != "" {
+			if !filePermissionsRegex.MatchString(file.Permissions) {
+				return "", fmt.Errorf("permissions %q of extra file %q must be in octal notation, e.g. 0644", file.Permissions, file.Path)
+			}
+			fmt.Fprintf(&entry, "  permissions: %s\n", quoteYAML(file.Permissions))
+		}
+
+		switch file.Encoding {
+		case "":
+		case extraFileEncodingBase64:
+			if _, err := base64.StdEncoding.DecodeString(file.Content); err != nil {
+				return "", fmt.Errorf("content of extra file %q is not base64 encoded: %v", file.Path, err)
+			}
+			entry.WriteString("  encoding: b64\n")
+		default:
+			return "", fmt.Errorf("unsupported encoding %q of extra file %q, only %q is supported", file.Encoding, file.Path, extraFileEncodingBase64)
+		}
+
+		fmt.Fprintf(&entry, "  content: %s", quoteYAML(file.Content))
+		entries = append(entries, entry.String())
+	}
+
+	return strings.Join(entries, "\n\n"), nil
+}
+
+// quoteYAML quotes the string as JSON string, which is a valid double-quoted YAML scalar.
+func quoteYAML(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
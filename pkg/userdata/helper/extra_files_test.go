@@ -0,0 +1,112 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+)
+
+func TestExtraWriteFiles(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         []providerconfigtypes.ExtraFile
+		expected      string
+		expectedError bool
+	}{
+		{
+			name: "plain content with permissions",
+			files: []providerconfigtypes.ExtraFile{{
+				Path:        "/etc/example/config.conf",
+				Permissions: "0600",
+				Content:     "key: \"value\"\nother: 1\n",
+			}},
+			expected: `- path: "/etc/example/config.conf"
+  permissions: "0600"
+  content: "key: \"value\"\nother: 1\n"`,
+		},
+		{
+			name: "base64 content",
+			files: []providerconfigtypes.ExtraFile{{
+				Path:     "/usr/local/share/ca-certificates/ca.crt",
+				Content:  "AAEC/w==",
+				Encoding: "base64",
+			}},
+			expected: `- path: "/usr/local/share/ca-certificates/ca.crt"
+  encoding: b64
+  content: "AAEC/w=="`,
+		},
+		{
+			name: "multiple files",
+			files: []providerconfigtypes.ExtraFile{
+				{Path: "/etc/a", Content: "a"},
+				{Path: "/etc/b", Permissions: "755", Content: "b"},
+			},
+			expected: `- path: "/etc/a"
+  content: "a"
+
+- path: "/etc/b"
+  permissions: "755"
+  content: "b"`,
+		},
+		{
+			name: "invalid permissions",
+			files: []providerconfigtypes.ExtraFile{{
+				Path:        "/etc/example",
+				Permissions: "rw-r--r--",
+			}},
+			expectedError: true,
+		},
+		{
+			name: "invalid base64 content",
+			files: []providerconfigtypes.ExtraFile{{
+				Path:     "/etc/example",
+				Content:  "not base64!",
+				Encoding: "base64",
+			}},
+			expectedError: true,
+		},
+		{
+			name: "unsupported encoding",
+			files: []providerconfigtypes.ExtraFile{{
+				Path:     "/etc/example",
+				Encoding: "gzip",
+			}},
+			expectedError: true,
+		},
+		{
+			name: "relative path",
+			files: []providerconfigtypes.ExtraFile{{
+				Path: "etc/example",
+			}},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered, err := ExtraWriteFiles(test.files)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("expected error: %t, got: %v", test.expectedError, err)
+			}
+			if rendered != test.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.expected, rendered)
+			}
+		})
+	}
+}
@@ -19,8 +19,10 @@ package helper
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"strings"
 
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
@@ -28,6 +30,7 @@ import (
 const (
 	DefaultDockerContainerLogMaxFiles = "5"
 	DefaultDockerContainerLogMaxSize  = "100m"
+	DefaultDockerStorageDriver        = "overlay2"
 )
 
 func GetServerAddressFromKubeconfig(kubeconfig *clientcmdapi.Config) (string, error) {
@@ -117,8 +120,14 @@ type dockerConfig struct {
 	RegistryMirrors    []string          `json:"registry-mirrors,omitempty"`
 }
 
-// DockerConfig returns the docker daemon.json.
+// DockerConfig returns the docker daemon.json with the systemd cgroup driver and the overlay2 storage driver.
 func DockerConfig(insecureRegistries, registryMirrors []string, logMaxFiles string, logMaxSize string) (string, error) {
+	return DockerConfigWithDrivers(insecureRegistries, registryMirrors, logMaxFiles, logMaxSize, "", "")
+}
+
+// DockerConfigWithDrivers returns the docker daemon.json with the given cgroup and storage drivers, which default to
+// systemd and overlay2 if they are empty.
+func DockerConfigWithDrivers(insecureRegistries, registryMirrors []string, logMaxFiles, logMaxSize, cgroupDriver, storageDriver string) (string, error) {
 	if len(logMaxSize) > 0 {
 		// Parse log max size to ensure that it has the correct units
 		logMaxSize = strings.ToLower(logMaxSize)
@@ -134,9 +143,17 @@ func DockerConfig(insecureRegistries, registryMirrors []string, logMaxFiles stri
 		logMaxFiles = DefaultDockerContainerLogMaxFiles
 	}
 
+	if len(cgroupDriver) == 0 {
+		cgroupDriver = CgroupDriverSystemd
+	}
+
+	if len(storageDriver) == 0 {
+		storageDriver = DefaultDockerStorageDriver
+	}
+
 	cfg := dockerConfig{
-		ExecOpts:      []string{"native.cgroupdriver=systemd"},
-		StorageDriver: "overlay2",
+		ExecOpts:      []string{fmt.Sprintf("native.cgroupdriver=%s", cgroupDriver)},
+		StorageDriver: storageDriver,
 		LogDriver:     "json-file",
 		LogOpts: map[string]string{
 			"max-size": logMaxSize,
@@ -150,7 +167,42 @@ func DockerConfig(insecureRegistries, registryMirrors []string, logMaxFiles stri
 	return string(b), err
 }
 
-func ProxyEnvironment(proxy, noProxy string) string {
+// defaultNoProxy are always excluded from the proxy.
+var defaultNoProxy = []string{"localhost", "127.0.0.1"}
+
+// NoProxy returns the comma-separated NO_PROXY list extended by localhost, the metadata endpoints of the cloud, the
+// pod and service CIDRs of the cluster and the host of the API server. Duplicate entries are removed.
+func NoProxy(noProxy, cloudProviderName string, clusterCIDRs []string, serverAddr string) string {
+	entries := append(strings.Split(noProxy, ","), defaultNoProxy...)
+	entries = append(entries, metadataNoProxy(cloudProviderName)...)
+	entries = append(entries, clusterCIDRs...)
+	entries = append(entries, serverHost(serverAddr))
+
+	var noProxyEntries []string
+	seen := sets.NewString()
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || seen.Has(entry) {
+			continue
+		}
+		seen.Insert(entry)
+		noProxyEntries = append(noProxyEntries, entry)
+	}
+
+	return strings.Join(noProxyEntries, ",")
+}
+
+// serverHost returns the host of the API server address, which is given as host:port without a scheme.
+func serverHost(serverAddr string) string {
+	host, _, err := net.SplitHostPort(serverAddr)
+	if err != nil {
+		return strings.Trim(serverAddr, "[]")
+	}
+	return host
+}
+
+func ProxyEnvironment(proxy, noProxy, cloudProviderName string, clusterCIDRs []string, serverAddr string) string {
+	noProxy = NoProxy(noProxy, cloudProviderName, clusterCIDRs, serverAddr)
 	return fmt.Sprintf(`HTTP_PROXY=%s
 http_proxy=%s
 HTTPS_PROXY=%s
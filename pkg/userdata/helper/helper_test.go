@@ -0,0 +1,146 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestProxyEnvironmentNoProxy(t *testing.T) {
+	tests := []struct {
+		name          string
+		noProxy       string
+		cloudProvider string
+		clusterCIDRs  []string
+		serverAddr    string
+		expected      string
+	}{
+		{
+			name:     "empty no proxy",
+			expected: "localhost,127.0.0.1,169.254.169.254",
+		},
+		{
+			name:         "cluster CIDRs",
+			noProxy:      ".svc,.cluster.local,localhost,127.0.0.1",
+			clusterCIDRs: []string{"172.25.0.0/16", "10.240.16.0/20"},
+			expected:     ".svc,.cluster.local,localhost,127.0.0.1,169.254.169.254,172.25.0.0/16,10.240.16.0/20",
+		},
+		{
+			name:       "API server with port",
+			serverAddr: "api.cluster.example.com:6443",
+			expected:   "localhost,127.0.0.1,169.254.169.254,api.cluster.example.com",
+		},
+		{
+			name:         "IPv6 API server",
+			clusterCIDRs: []string{"fd00::/104"},
+			serverAddr:   "[fd00::1]:6443",
+			expected:     "localhost,127.0.0.1,169.254.169.254,fd00::/104,fd00::1",
+		},
+		{
+			name:         "duplicate entries",
+			noProxy:      "169.254.169.254, 10.240.16.0/20,10.0.0.1",
+			clusterCIDRs: []string{"10.240.16.0/20"},
+			serverAddr:   "10.0.0.1:6443",
+			expected:     "169.254.169.254,10.240.16.0/20,10.0.0.1,localhost,127.0.0.1",
+		},
+		{
+			name:          "cloud metadata hosts",
+			noProxy:       ".svc",
+			cloudProvider: "gce",
+			expected:      ".svc,localhost,127.0.0.1,169.254.169.254,metadata.google.internal",
+		},
+		{
+			name:          "cloud without additional metadata hosts",
+			cloudProvider: "openstack",
+			expected:      "localhost,127.0.0.1,169.254.169.254",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := ProxyEnvironment("http://192.168.1.1:3128", test.noProxy, test.cloudProvider, test.clusterCIDRs, test.serverAddr)
+			for _, variable := range []string{"NO_PROXY", "no_proxy"} {
+				if expected := variable + "=" + test.expected; !strings.Contains(env, expected+"\n") && !strings.HasSuffix(env, expected) {
+					t.Errorf("expected %q in proxy environment, got:\n%s", expected, env)
+				}
+			}
+		})
+	}
+}
+
+func TestNoProxyMetadataEndpoint(t *testing.T) {
+	for _, cloudProvider := range []string{"", "aws", "azure", "gce", "openstack", "unknown"} {
+		for _, noProxy := range []string{"", "10.0.0.0/8", " 169.254.169.254 ,.internal", ","} {
+			entries := strings.Split(NoProxy(noProxy, cloudProvider, nil, ""), ",")
+			expected := append([]string{"169.254.169.254"}, cloudMetadataHosts[providerconfigtypes.CloudProvider(cloudProvider)]...)
+			for _, host := range expected {
+				if !sets.NewString(entries...).Has(host) {
+					t.Errorf("cloud provider %q, no proxy %q: expected %q in %v", cloudProvider, noProxy, host, entries)
+				}
+			}
+		}
+	}
+}
+
+func TestDockerConfigDrivers(t *testing.T) {
+	tests := []struct {
+		name          string
+		cgroupDriver  string
+		storageDriver string
+		expected      string
+	}{
+		{
+			name:     "defaults",
+			expected: `{"exec-opts":["native.cgroupdriver=systemd"],"storage-driver":"overlay2","log-driver":"json-file","log-opts":{"max-file":"5","max-size":"100m"}}`,
+		},
+		{
+			name:         "cgroupfs",
+			cgroupDriver: CgroupDriverCgroupfs,
+			expected:     `{"exec-opts":["native.cgroupdriver=cgroupfs"],"storage-driver":"overlay2","log-driver":"json-file","log-opts":{"max-file":"5","max-size":"100m"}}`,
+		},
+		{
+			name:          "btrfs storage driver",
+			storageDriver: "btrfs",
+			expected:      `{"exec-opts":["native.cgroupdriver=systemd"],"storage-driver":"btrfs","log-driver":"json-file","log-opts":{"max-file":"5","max-size":"100m"}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := DockerConfigWithDrivers(nil, nil, "", "", test.cgroupDriver, test.storageDriver)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg != test.expected {
+				t.Errorf("expected docker config\n%s\ngot\n%s", test.expected, cfg)
+			}
+		})
+	}
+
+	cfg, err := DockerConfig(nil, nil, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg != tests[0].expected {
+		t.Errorf("expected DockerConfig to use the default drivers, got %s", cfg)
+	}
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package helper
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -26,9 +29,13 @@ import (
 	"github.com/Masterminds/semver/v3"
 
 	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/klog"
 	kubeletv1b1 "k8s.io/kubelet/config/v1beta1"
 	"k8s.io/utils/pointer"
@@ -39,6 +46,17 @@ const (
 	defaultKubeletContainerLogMaxSize = "100Mi"
 )
 
+// Valid values for the enforceNodeAllocatable kubelet setting.
+const (
+	nodeAllocatableNoneKey        = "none"
+	nodeAllocatableEnforcementKey = "pods"
+	systemReservedEnforcementKey  = "system-reserved"
+	kubeReservedEnforcementKey    = "kube-reserved"
+)
+
+// builtinSystemdSlices are created by systemd itself and must not be overwritten.
+var builtinSystemdSlices = sets.NewString("-.slice", "system.slice", "user.slice", "machine.slice")
+
 const (
 	kubeletFlagsTpl = `--bootstrap-kubeconfig=/etc/kubernetes/bootstrap-kubelet.conf \
 --kubeconfig=/var/lib/kubelet/kubeconfig \
@@ -93,6 +111,20 @@ ExecStart=/opt/bin/kubelet $KUBELET_EXTRA_ARGS \
 [Install]
 WantedBy=multi-user.target`
 
+	kubeletHealthCheckSystemdUnitTpl = `[Unit]
+Requires=kubelet.service
+After=kubelet.service
+
+[Service]
+{{- range .Environment }}
+Environment="{{ . }}"
+{{- end }}
+ExecStart=/opt/bin/health-monitor.sh kubelet
+
+[Install]
+WantedBy=multi-user.target
+`
+
 	containerRuntimeHealthCheckSystemdUnitTpl = `[Unit]
 Requires={{ .ContainerRuntime }}.service
 After={{ .ContainerRuntime }}.service
@@ -104,6 +136,15 @@ ExecStart=/opt/bin/health-monitor.sh container-runtime
 WantedBy=multi-user.target`
 )
 
+// Defaults of the kubelet health check, they match the defaults of the health monitor script.
+const (
+	defaultKubeletHealthzHost                 = "127.0.0.1"
+	defaultKubeletHealthzPort                 = 10248
+	defaultKubeletHealthCheckTimeoutSeconds   = 10
+	defaultKubeletHealthCheckPeriodSeconds    = 10
+	defaultKubeletHealthCheckFailureThreshold = 1
+)
+
 const cpFlags = `--cloud-provider=%s \
 --cloud-config=/etc/kubernetes/cloud-config`
 
@@ -174,7 +215,7 @@ func KubeletSystemdUnit(containerRuntime, kubeletVersion, cloudProvider, hostnam
 }
 
 // kubeletConfiguration returns marshaled kubelet.config.k8s.io/v1beta1 KubeletConfiguration
-func kubeletConfiguration(clusterDomain string, clusterDNS []net.IP, featureGates map[string]bool, kubeletConfigs map[string]string, containerRuntime string) (string, error) {
+func kubeletConfiguration(clusterDomain string, clusterDNS []net.IP, featureGates map[string]bool, kubeletConfigs map[string]string, containerRuntime, cgroupDriver string) (string, error) {
 	clusterDNSstr := make([]string, 0, len(clusterDNS))
 	for _, ip := range clusterDNS {
 		clusterDNSstr = append(clusterDNSstr, ip.String())
@@ -199,7 +240,7 @@ func kubeletConfiguration(clusterDomain string, clusterDNS []net.IP, featureGate
 		Authorization: kubeletv1b1.KubeletAuthorization{
 			Mode: kubeletv1b1.KubeletAuthorizationModeWebhook,
 		},
-		CgroupDriver:          "systemd",
+		CgroupDriver:          cgroupDriver,
 		ClusterDNS:            clusterDNSstr,
 		ClusterDomain:         clusterDomain,
 		FeatureGates:          featureGates,
@@ -208,43 +249,18 @@ func kubeletConfiguration(clusterDomain string, clusterDNS []net.IP, featureGate
 		RotateCertificates:    true,
 		ServerTLSBootstrap:    true,
 		StaticPodPath:         "/etc/kubernetes/manifests",
-		KubeReserved:          map[string]string{"cpu": "200m", "memory": "200Mi", "ephemeral-storage": "1Gi"},
-		SystemReserved:        map[string]string{"cpu": "200m", "memory": "200Mi", "ephemeral-storage": "1Gi"},
-		EvictionHard:          map[string]string{"memory.available": "100Mi", "nodefs.available": "10%", "nodefs.inodesFree": "5%", "imagefs.available": "15%"},
 		VolumePluginDir:       "/var/lib/kubelet/volumeplugins",
 		TLSCipherSuites:       kubeletTLSCipherSuites,
 		ContainerLogMaxSize:   defaultKubeletContainerLogMaxSize,
 	}
 
-	if kubeReserved, ok := kubeletConfigs[common.KubeReservedKubeletConfig]; ok {
-		for _, krPair := range strings.Split(kubeReserved, ",") {
-			krKV := strings.SplitN(krPair, "=", 2)
-			if len(krKV) != 2 {
-				continue
-			}
-			cfg.KubeReserved[krKV[0]] = krKV[1]
-		}
-	}
-
-	if systemReserved, ok := kubeletConfigs[common.SystemReservedKubeletConfig]; ok {
-		for _, srPair := range strings.Split(systemReserved, ",") {
-			srKV := strings.SplitN(srPair, "=", 2)
-			if len(srKV) != 2 {
-				continue
-			}
-			cfg.SystemReserved[srKV[0]] = srKV[1]
-		}
-	}
-
-	if evictionHard, ok := kubeletConfigs[common.EvictionHardKubeletConfig]; ok {
-		for _, ehPair := range strings.Split(evictionHard, ",") {
-			ehKV := strings.SplitN(ehPair, "<", 2)
-			if len(ehKV) != 2 {
-				continue
-			}
-			cfg.EvictionHard[ehKV[0]] = ehKV[1]
-		}
+	reservations, err := parseResourceReservations(kubeletConfigs)
+	if err != nil {
+		return "", err
 	}
+	cfg.KubeReserved = reservations.KubeReserved
+	cfg.SystemReserved = reservations.SystemReserved
+	cfg.EvictionHard = reservations.EvictionHard
 
 	if containerLogMaxSize, ok := kubeletConfigs[common.ContainerLogMaxSizeKubeletConfig]; ok {
 		cfg.ContainerLogMaxSize = containerLogMaxSize
@@ -259,6 +275,18 @@ func kubeletConfiguration(clusterDomain string, clusterDNS []net.IP, featureGate
 		}
 	}
 
+	if err := setNodeAllocatableEnforcement(&cfg, kubeletConfigs); err != nil {
+		return "", err
+	}
+
+	// The distribution default of the kubelet is kept if no resolv.conf is configured
+	if err := validateResolvConf(kubeletConfigs); err != nil {
+		return "", err
+	}
+	if resolvConf := kubeletResolvConf(kubeletConfigs); resolvConf != "" {
+		cfg.ResolverConfig = pointer.String(resolvConf)
+	}
+
 	if enabled, ok := featureGates["SeccompDefault"]; ok && enabled {
 		cfg.SeccompDefault = pointer.Bool(true)
 	}
@@ -267,6 +295,250 @@ func kubeletConfiguration(clusterDomain string, clusterDNS []net.IP, featureGate
 	return string(buf), err
 }
 
+// resourceReservations are the resources reserved by the kubelet, they use the field names of the kubelet
+// configuration.
+type resourceReservations struct {
+	SystemReserved map[string]string `json:"systemReserved,omitempty"`
+	KubeReserved   map[string]string `json:"kubeReserved,omitempty"`
+	EvictionHard   map[string]string `json:"evictionHard,omitempty"`
+}
+
+// ValidateKubeletConfigs validates the resource reservations, the node allocatable enforcement and the resolv.conf
+// path of the kubelet configs, e.g. SystemReserved=cpu=500m,memory=1Gi or EvictionHard=memory.available<200Mi.
+// Malformed configs would otherwise only surface when the userdata of the machine gets rendered.
+func ValidateKubeletConfigs(kubeletConfigs map[string]string) error {
+	if _, err := parseResourceReservations(kubeletConfigs); err != nil {
+		return err
+	}
+	if err := setNodeAllocatableEnforcement(&kubeletv1b1.KubeletConfiguration{}, kubeletConfigs); err != nil {
+		return err
+	}
+	return validateResolvConf(kubeletConfigs)
+}
+
+// KubeletResourceReservations returns the systemReserved, kubeReserved and evictionHard blocks of the kubelet
+// configuration file. The reservations of the kubelet configs are validated and merged into the defaults.
+func KubeletResourceReservations(kubeletConfigs map[string]string) (string, error) {
+	reservations, err := parseResourceReservations(kubeletConfigs)
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := kyaml.Marshal(reservations)
+	return string(buf), err
+}
+
+// parseResourceReservations returns the systemReserved, kubeReserved and evictionHard settings of the kubelet
+// configuration. The reservations of the kubelet configs are merged into the defaults.
+func parseResourceReservations(kubeletConfigs map[string]string) (resourceReservations, error) {
+	reservations := resourceReservations{
+		SystemReserved: map[string]string{"cpu": "200m", "memory": "200Mi", "ephemeral-storage": "1Gi"},
+		KubeReserved:   map[string]string{"cpu": "200m", "memory": "200Mi", "ephemeral-storage": "1Gi"},
+		EvictionHard:   map[string]string{"memory.available": "100Mi", "nodefs.available": "10%", "nodefs.inodesFree": "5%", "imagefs.available": "15%"},
+	}
+
+	if err := parseReservations(reservations.SystemReserved, kubeletConfigs[common.SystemReservedKubeletConfig], "=", validateQuantity); err != nil {
+		return resourceReservations{}, fmt.Errorf("invalid %s: %w", common.SystemReservedKubeletConfig, err)
+	}
+	if err := parseReservations(reservations.KubeReserved, kubeletConfigs[common.KubeReservedKubeletConfig], "=", validateQuantity); err != nil {
+		return resourceReservations{}, fmt.Errorf("invalid %s: %w", common.KubeReservedKubeletConfig, err)
+	}
+	if err := parseReservations(reservations.EvictionHard, kubeletConfigs[common.EvictionHardKubeletConfig], "<", validateEvictionThreshold); err != nil {
+		return resourceReservations{}, fmt.Errorf("invalid %s: %w", common.EvictionHardKubeletConfig, err)
+	}
+
+	return reservations, nil
+}
+
+// parseReservations parses the comma separated list of reservations, whose names and values are separated by
+// separator, into reservations.
+func parseReservations(reservations map[string]string, list, separator string, validate func(string) error) error {
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, separator, 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return fmt.Errorf("reservation %q must have the format <name>%s<value>", pair, separator)
+		}
+
+		name, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if err := validate(value); err != nil {
+			return fmt.Errorf("invalid value of %s: %w", name, err)
+		}
+		reservations[name] = value
+	}
+
+	return nil
+}
+
+func validateQuantity(value string) error {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a quantity: %w", value, err)
+	}
+	if quantity.Sign() < 0 {
+		return fmt.Errorf("%q must not be negative", value)
+	}
+	return nil
+}
+
+// validateEvictionThreshold accepts a quantity or a percentage, e.g. 100Mi or 10%.
+func validateEvictionThreshold(value string) error {
+	if strings.HasSuffix(value, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || p < 0 || p > 100 {
+			return fmt.Errorf("%q is not a percentage between 0%% and 100%%", value)
+		}
+		return nil
+	}
+	return validateQuantity(value)
+}
+
+// setNodeAllocatableEnforcement configures which reservations are enforced by the kubelet. Enforcing
+// system-reserved or kube-reserved requires the respective cgroup to be configured.
+func setNodeAllocatableEnforcement(cfg *kubeletv1b1.KubeletConfiguration, kubeletConfigs map[string]string) error {
+	enforceNodeAllocatable, ok := kubeletConfigs[common.EnforceNodeAllocatableKubeletConfig]
+	if !ok {
+		return nil
+	}
+
+	cgroups := map[string]string{
+		systemReservedEnforcementKey: kubeletConfigs[common.SystemReservedCgroupKubeletConfig],
+		kubeReservedEnforcementKey:   kubeletConfigs[common.KubeReservedCgroupKubeletConfig],
+	}
+
+	for _, key := range strings.Split(enforceNodeAllocatable, ",") {
+		key = strings.TrimSpace(key)
+		switch key {
+		case nodeAllocatableEnforcementKey, nodeAllocatableNoneKey:
+		case systemReservedEnforcementKey, kubeReservedEnforcementKey:
+			if _, err := reservedCgroupSlice(cgroups[key]); err != nil {
+				return fmt.Errorf("invalid cgroup for enforcing %s: %w", key, err)
+			}
+		default:
+			return fmt.Errorf("unknown node allocatable enforcement %q", key)
+		}
+		cfg.EnforceNodeAllocatable = append(cfg.EnforceNodeAllocatable, key)
+	}
+
+	cfg.SystemReservedCgroup = cgroups[systemReservedEnforcementKey]
+	cfg.KubeReservedCgroup = cgroups[kubeReservedEnforcementKey]
+
+	return nil
+}
+
+// reservedCgroupSlice returns the systemd slice for the given cgroup. The reserved cgroups are created as systemd
+// slices, so they have to be top level slices, e.g. /kube-reserved.slice. Top level slices have the same cgroup path
+// with the systemd and the cgroupfs driver, so they can be used with both.
+func reservedCgroupSlice(cgroup string) (string, error) {
+	if cgroup == "" {
+		return "", errors.New("cgroup must be set")
+	}
+
+	slice := strings.TrimPrefix(cgroup, "/")
+	if !strings.HasPrefix(cgroup, "/") || strings.Contains(slice, "/") || !strings.HasSuffix(slice, ".slice") {
+		return "", fmt.Errorf("cgroup %q must be a top level systemd slice, e.g. /kube-reserved.slice", cgroup)
+	}
+
+	return slice, nil
+}
+
+// enforcedReservedCgroupSlices returns the systemd slices of the enforced system-reserved and kube-reserved cgroups.
+// The slice is empty if the reservation isn't enforced.
+func enforcedReservedCgroupSlices(kubeletConfigs map[string]string) (systemReserved, kubeReserved string) {
+	enforceNodeAllocatable, ok := kubeletConfigs[common.EnforceNodeAllocatableKubeletConfig]
+	if !ok {
+		return "", ""
+	}
+
+	for _, key := range strings.Split(enforceNodeAllocatable, ",") {
+		switch strings.TrimSpace(key) {
+		case systemReservedEnforcementKey:
+			systemReserved, _ = reservedCgroupSlice(kubeletConfigs[common.SystemReservedCgroupKubeletConfig])
+		case kubeReservedEnforcementKey:
+			kubeReserved, _ = reservedCgroupSlice(kubeletConfigs[common.KubeReservedCgroupKubeletConfig])
+		}
+	}
+
+	return systemReserved, kubeReserved
+}
+
+// reservedCgroupsFiles returns the systemd units of the enforced reservations by their path. The slices are created,
+// unless they always exist like system.slice, and the kubelet and the container runtime are started in the
+// kube-reserved slice, so that the kube-reserved limits apply to them.
+func reservedCgroupsFiles(kubeletConfigs map[string]string, containerRuntime string) map[string]string {
+	systemReserved, kubeReserved := enforcedReservedCgroupSlices(kubeletConfigs)
+
+	files := map[string]string{}
+	var slices []string
+	for _, slice := range []string{systemReserved, kubeReserved} {
+		if slice == "" || builtinSystemdSlices.Has(slice) {
+			continue
+		}
+		slices = append(slices, slice)
+		files[path.Join("/etc/systemd/system", slice)] = "[Unit]\nDescription=Slice for resources reserved by the kubelet\nBefore=slices.target\n"
+	}
+
+	if len(slices) > 0 || kubeReserved != "" {
+		files["/etc/systemd/system/kubelet.service.d/reserved-cgroups.conf"] = reservedCgroupsDropIn(slices, kubeReserved)
+	}
+	if kubeReserved != "" {
+		var kubeReservedSlices []string
+		if !builtinSystemdSlices.Has(kubeReserved) {
+			kubeReservedSlices = []string{kubeReserved}
+		}
+		files[path.Join("/etc/systemd/system", containerRuntime+".service.d", "reserved-cgroups.conf")] = reservedCgroupsDropIn(kubeReservedSlices, kubeReserved)
+	}
+
+	return files
+}
+
+// reservedCgroupsDropIn returns a drop-in which orders the service after the given slices and starts it in slice,
+// if it's set.
+func reservedCgroupsDropIn(slices []string, slice string) string {
+	var dropIn strings.Builder
+	if len(slices) > 0 {
+		fmt.Fprintf(&dropIn, "[Unit]\nWants=%s\nAfter=%s\n", strings.Join(slices, " "), strings.Join(slices, " "))
+	}
+	if slice != "" {
+		if dropIn.Len() > 0 {
+			dropIn.WriteString("\n")
+		}
+		fmt.Fprintf(&dropIn, "[Service]\nSlice=%s\n", slice)
+	}
+	return dropIn.String()
+}
+
+// ReservedCgroupsWriteFiles returns the cloud-init write_files entries of the systemd units for the enforced
+// reservations.
+func ReservedCgroupsWriteFiles(kubeletConfigs map[string]string, containerRuntime string) string {
+	files := reservedCgroupsFiles(kubeletConfigs, containerRuntime)
+
+	paths := make([]string, 0, len(files))
+	for filePath := range files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	entries := make([]string, 0, len(paths))
+	for _, filePath := range paths {
+		var entry strings.Builder
+		fmt.Fprintf(&entry, "- path: %s\n  permissions: \"0644\"\n  content: |", quoteYAML(filePath))
+		for _, line := range strings.Split(strings.TrimSuffix(files[filePath], "\n"), "\n") {
+			entry.WriteString("\n")
+			if line != "" {
+				entry.WriteString("    " + line)
+			}
+		}
+		entries = append(entries, entry.String())
+	}
+
+	return strings.Join(entries, "\n\n")
+}
+
 // KubeletFlags returns the kubelet flags
 func KubeletFlags(version, cloudProvider, hostname string, dnsIPs []net.IP, external bool, pauseImage string, initialTaints []corev1.Taint, extraKubeletFlags []string) (string, error) {
 	tmpl, err := template.New("kubelet-flags").Funcs(TxtFuncMap()).Parse(kubeletFlagsTpl)
@@ -339,18 +611,90 @@ func KubeletFlags(version, cloudProvider, hostname string, dnsIPs []net.IP, exte
 	return buf.String(), nil
 }
 
-// KubeletHealthCheckSystemdUnit kubelet health checking systemd unit
-func KubeletHealthCheckSystemdUnit() string {
-	return `[Unit]
-Requires=kubelet.service
-After=kubelet.service
+// kubeletResolvConf returns the resolv.conf path configured for the kubelet, if any.
+func kubeletResolvConf(kubeletConfigs map[string]string) string {
+	return kubeletConfigs[common.ResolvConfKubeletConfig]
+}
 
-[Service]
-ExecStart=/opt/bin/health-monitor.sh kubelet
+// validateResolvConf checks that the resolv.conf path of the kubelet configs is absolute, if it's set.
+func validateResolvConf(kubeletConfigs map[string]string) error {
+	if resolvConf := kubeletResolvConf(kubeletConfigs); resolvConf != "" && !path.IsAbs(resolvConf) {
+		return fmt.Errorf("resolvConf path %q must be absolute", resolvConf)
+	}
+	return nil
+}
 
-[Install]
-WantedBy=multi-user.target
-`
+// KubeletHealthCheckSystemdUnit kubelet health checking systemd unit. The settings of the health check are passed
+// to the health monitor script as environment variables, the defaults of the script apply if check is nil.
+func KubeletHealthCheckSystemdUnit(check *providerconfigtypes.KubeletHealthCheck) (string, error) {
+	tmpl, err := template.New("kubelet-healthcheck-systemd-unit").Funcs(TxtFuncMap()).Parse(kubeletHealthCheckSystemdUnitTpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse kubelet-healthcheck-systemd-unit template: %w", err)
+	}
+
+	environment, err := kubeletHealthCheckEnvironment(check)
+	if err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, struct{ Environment []string }{Environment: environment}); err != nil {
+		return "", fmt.Errorf("failed to execute kubelet-healthcheck-systemd-unit template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// kubeletHealthCheckEnvironment returns the environment variables of the health monitor script for the health
+// check, unset fields of the check are defaulted.
+func kubeletHealthCheckEnvironment(check *providerconfigtypes.KubeletHealthCheck) ([]string, error) {
+	if check == nil {
+		return nil, nil
+	}
+
+	host := check.Host
+	if host == "" {
+		host = defaultKubeletHealthzHost
+	}
+	if net.ParseIP(host) == nil && len(validation.IsDNS1123Subdomain(host)) > 0 {
+		return nil, fmt.Errorf("invalid kubelet health check host %q", host)
+	}
+
+	port := check.Port
+	if port == 0 {
+		port = defaultKubeletHealthzPort
+	}
+	if port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid kubelet health check port %d", port)
+	}
+
+	settings := []struct {
+		name         string
+		value        int32
+		defaultValue int32
+	}{
+		{name: "timeoutSeconds", value: check.TimeoutSeconds, defaultValue: defaultKubeletHealthCheckTimeoutSeconds},
+		{name: "periodSeconds", value: check.PeriodSeconds, defaultValue: defaultKubeletHealthCheckPeriodSeconds},
+		{name: "failureThreshold", value: check.FailureThreshold, defaultValue: defaultKubeletHealthCheckFailureThreshold},
+	}
+	values := make([]int32, len(settings))
+	for i, setting := range settings {
+		switch {
+		case setting.value < 0:
+			return nil, fmt.Errorf("kubelet health check %s must not be negative, got %d", setting.name, setting.value)
+		case setting.value == 0:
+			values[i] = setting.defaultValue
+		default:
+			values[i] = setting.value
+		}
+	}
+
+	return []string{
+		fmt.Sprintf("KUBELET_HEALTHZ_URL=http://%s/healthz", net.JoinHostPort(host, strconv.Itoa(int(port)))),
+		fmt.Sprintf("KUBELET_HEALTHCHECK_TIMEOUT_SECONDS=%d", values[0]),
+		fmt.Sprintf("KUBELET_HEALTHCHECK_PERIOD_SECONDS=%d", values[1]),
+		fmt.Sprintf("KUBELET_HEALTHCHECK_FAILURE_THRESHOLD=%d", values[2]),
+	}, nil
 }
 
 // ContainerRuntimeHealthCheckSystemdUnit container-runtime health checking systemd unit
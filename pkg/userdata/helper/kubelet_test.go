@@ -19,13 +19,19 @@ package helper
 import (
 	"fmt"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/Masterminds/semver/v3"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
 	testhelper "github.com/kubermatic/machine-controller/pkg/test"
 
 	corev1 "k8s.io/api/core/v1"
+	kubeletv1b1 "k8s.io/kubelet/config/v1beta1"
+	kyaml "sigs.k8s.io/yaml"
 )
 
 type kubeletFlagTestCase struct {
@@ -138,3 +144,392 @@ func defaultTo(in string, defaultValue string) string {
 
 	return in
 }
+
+func TestKubeletConfigurationResolvConf(t *testing.T) {
+	tests := []struct {
+		name           string
+		kubeletConfigs map[string]string
+		expected       string
+		expectError    bool
+	}{
+		{
+			name: "default",
+		},
+		{
+			name:           "custom path",
+			kubeletConfigs: map[string]string{common.ResolvConfKubeletConfig: "/etc/kubernetes/resolv.conf"},
+			expected:       "resolvConf: /etc/kubernetes/resolv.conf\n",
+		},
+		{
+			name:           "relative path",
+			kubeletConfigs: map[string]string{common.ResolvConfKubeletConfig: "resolv.conf"},
+			expectError:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := kubeletConfiguration("cluster.local", []net.IP{net.ParseIP("10.10.10.10")}, nil, test.kubeletConfigs, "containerd", CgroupDriverSystemd)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error %t, got %v", test.expectError, err)
+			}
+			if test.expectError {
+				return
+			}
+
+			if test.expected == "" {
+				if strings.Contains(out, "resolvConf") {
+					t.Errorf("expected no resolvConf, got:\n%s", out)
+				}
+				return
+			}
+			if !strings.Contains(out, test.expected) {
+				t.Errorf("expected %q, got:\n%s", test.expected, out)
+			}
+		})
+	}
+}
+
+func TestKubeletHealthCheckSystemdUnit(t *testing.T) {
+	tests := []struct {
+		name        string
+		check       *providerconfigtypes.KubeletHealthCheck
+		expected    []string
+		unexpected  []string
+		expectError bool
+	}{
+		{
+			name:       "defaults",
+			unexpected: []string{"Environment="},
+		},
+		{
+			name:  "custom port",
+			check: &providerconfigtypes.KubeletHealthCheck{Port: 10249},
+			expected: []string{
+				`Environment="KUBELET_HEALTHZ_URL=http://127.0.0.1:10249/healthz"`,
+				`Environment="KUBELET_HEALTHCHECK_TIMEOUT_SECONDS=10"`,
+				`Environment="KUBELET_HEALTHCHECK_PERIOD_SECONDS=10"`,
+				`Environment="KUBELET_HEALTHCHECK_FAILURE_THRESHOLD=1"`,
+			},
+		},
+		{
+			name:  "custom host and thresholds",
+			check: &providerconfigtypes.KubeletHealthCheck{Host: "::1", TimeoutSeconds: 5, PeriodSeconds: 30, FailureThreshold: 3},
+			expected: []string{
+				`Environment="KUBELET_HEALTHZ_URL=http://[::1]:10248/healthz"`,
+				`Environment="KUBELET_HEALTHCHECK_TIMEOUT_SECONDS=5"`,
+				`Environment="KUBELET_HEALTHCHECK_PERIOD_SECONDS=30"`,
+				`Environment="KUBELET_HEALTHCHECK_FAILURE_THRESHOLD=3"`,
+			},
+		},
+		{
+			name:        "invalid port",
+			check:       &providerconfigtypes.KubeletHealthCheck{Port: 70000},
+			expectError: true,
+		},
+		{
+			name:        "invalid host",
+			check:       &providerconfigtypes.KubeletHealthCheck{Host: "http://localhost"},
+			expectError: true,
+		},
+		{
+			name:        "negative threshold",
+			check:       &providerconfigtypes.KubeletHealthCheck{FailureThreshold: -1},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unit, err := KubeletHealthCheckSystemdUnit(test.check)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if test.expectError {
+				return
+			}
+
+			if !strings.Contains(unit, "ExecStart=/opt/bin/health-monitor.sh kubelet\n") {
+				t.Errorf("expected the health monitor to be started, got:\n%s", unit)
+			}
+			for _, line := range test.expected {
+				if !strings.Contains(unit, line+"\n") {
+					t.Errorf("expected %s, got:\n%s", line, unit)
+				}
+			}
+			for _, line := range test.unexpected {
+				if strings.Contains(unit, line) {
+					t.Errorf("expected no %s, got:\n%s", line, unit)
+				}
+			}
+		})
+	}
+}
+
+func TestKubeletConfigurationNodeAllocatableEnforcement(t *testing.T) {
+	tests := []struct {
+		name           string
+		kubeletConfigs map[string]string
+		expectError    bool
+	}{
+		{
+			name: "enforced reservations",
+			kubeletConfigs: map[string]string{
+				common.EnforceNodeAllocatableKubeletConfig: "pods,system-reserved,kube-reserved",
+				common.SystemReservedCgroupKubeletConfig:   "/system.slice",
+				common.KubeReservedCgroupKubeletConfig:     "/kube-reserved.slice",
+			},
+		},
+		{
+			name: "missing cgroup",
+			kubeletConfigs: map[string]string{
+				common.EnforceNodeAllocatableKubeletConfig: "pods,kube-reserved",
+			},
+			expectError: true,
+		},
+		{
+			name: "nested cgroup",
+			kubeletConfigs: map[string]string{
+				common.EnforceNodeAllocatableKubeletConfig: "kube-reserved",
+				common.KubeReservedCgroupKubeletConfig:     "/kube.slice/kube-reserved.slice",
+			},
+			expectError: true,
+		},
+		{
+			name: "unknown enforcement",
+			kubeletConfigs: map[string]string{
+				common.EnforceNodeAllocatableKubeletConfig: "everything",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := kubeletConfiguration("cluster.local", []net.IP{net.ParseIP("10.10.10.10")}, nil, test.kubeletConfigs, "containerd", CgroupDriverSystemd)
+			if (err != nil) != test.expectError {
+				t.Errorf("expected error: %t, got: %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestReservedCgroupsFiles(t *testing.T) {
+	tests := []struct {
+		name           string
+		kubeletConfigs map[string]string
+		expected       map[string]string
+	}{
+		{
+			name:     "no enforced reservations",
+			expected: map[string]string{},
+		},
+		{
+			name: "system-reserved in system.slice",
+			kubeletConfigs: map[string]string{
+				common.EnforceNodeAllocatableKubeletConfig: "pods,system-reserved",
+				common.SystemReservedCgroupKubeletConfig:   "/system.slice",
+			},
+			expected: map[string]string{},
+		},
+		{
+			name: "kube-reserved",
+			kubeletConfigs: map[string]string{
+				common.EnforceNodeAllocatableKubeletConfig: "pods,system-reserved,kube-reserved",
+				common.SystemReservedCgroupKubeletConfig:   "/system.slice",
+				common.KubeReservedCgroupKubeletConfig:     "/kube-reserved.slice",
+			},
+			expected: map[string]string{
+				"/etc/systemd/system/kube-reserved.slice":                        "[Unit]\nDescription=Slice for resources reserved by the kubelet\nBefore=slices.target\n",
+				"/etc/systemd/system/kubelet.service.d/reserved-cgroups.conf":    "[Unit]\nWants=kube-reserved.slice\nAfter=kube-reserved.slice\n\n[Service]\nSlice=kube-reserved.slice\n",
+				"/etc/systemd/system/containerd.service.d/reserved-cgroups.conf": "[Unit]\nWants=kube-reserved.slice\nAfter=kube-reserved.slice\n\n[Service]\nSlice=kube-reserved.slice\n",
+			},
+		},
+		{
+			name: "kube-reserved in system.slice",
+			kubeletConfigs: map[string]string{
+				common.EnforceNodeAllocatableKubeletConfig: "kube-reserved",
+				common.KubeReservedCgroupKubeletConfig:     "/system.slice",
+			},
+			expected: map[string]string{
+				"/etc/systemd/system/kubelet.service.d/reserved-cgroups.conf":    "[Service]\nSlice=system.slice\n",
+				"/etc/systemd/system/containerd.service.d/reserved-cgroups.conf": "[Service]\nSlice=system.slice\n",
+			},
+		},
+		{
+			name: "system-reserved only",
+			kubeletConfigs: map[string]string{
+				common.EnforceNodeAllocatableKubeletConfig: "system-reserved",
+				common.SystemReservedCgroupKubeletConfig:   "/system-reserved.slice",
+				common.KubeReservedCgroupKubeletConfig:     "/kube-reserved.slice",
+			},
+			expected: map[string]string{
+				"/etc/systemd/system/system-reserved.slice":                   "[Unit]\nDescription=Slice for resources reserved by the kubelet\nBefore=slices.target\n",
+				"/etc/systemd/system/kubelet.service.d/reserved-cgroups.conf": "[Unit]\nWants=system-reserved.slice\nAfter=system-reserved.slice\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := reservedCgroupsFiles(test.kubeletConfigs, "containerd")
+			if !reflect.DeepEqual(files, test.expected) {
+				t.Errorf("expected files %v, got %v", test.expected, files)
+			}
+		})
+	}
+}
+
+func TestKubeletResourceReservations(t *testing.T) {
+	tests := []struct {
+		name           string
+		kubeletConfigs map[string]string
+		expected       resourceReservations
+		expectError    bool
+	}{
+		{
+			name: "defaults",
+			expected: resourceReservations{
+				SystemReserved: map[string]string{"cpu": "200m", "memory": "200Mi", "ephemeral-storage": "1Gi"},
+				KubeReserved:   map[string]string{"cpu": "200m", "memory": "200Mi", "ephemeral-storage": "1Gi"},
+				EvictionHard:   map[string]string{"memory.available": "100Mi", "nodefs.available": "10%", "nodefs.inodesFree": "5%", "imagefs.available": "15%"},
+			},
+		},
+		{
+			name: "reservations are merged into the defaults",
+			kubeletConfigs: map[string]string{
+				common.SystemReservedKubeletConfig: "cpu=500m, memory=1Gi",
+				common.KubeReservedKubeletConfig:   "pid=1000",
+				common.EvictionHardKubeletConfig:   "memory.available<200Mi,nodefs.available<5.5%",
+			},
+			expected: resourceReservations{
+				SystemReserved: map[string]string{"cpu": "500m", "memory": "1Gi", "ephemeral-storage": "1Gi"},
+				KubeReserved:   map[string]string{"cpu": "200m", "memory": "200Mi", "ephemeral-storage": "1Gi", "pid": "1000"},
+				EvictionHard:   map[string]string{"memory.available": "200Mi", "nodefs.available": "5.5%", "nodefs.inodesFree": "5%", "imagefs.available": "15%"},
+			},
+		},
+		{
+			name:           "malformed quantity",
+			kubeletConfigs: map[string]string{common.SystemReservedKubeletConfig: "memory=1GB"},
+			expectError:    true,
+		},
+		{
+			name:           "negative quantity",
+			kubeletConfigs: map[string]string{common.KubeReservedKubeletConfig: "cpu=-100m"},
+			expectError:    true,
+		},
+		{
+			name:           "missing value",
+			kubeletConfigs: map[string]string{common.KubeReservedKubeletConfig: "cpu"},
+			expectError:    true,
+		},
+		{
+			name:           "eviction threshold with wrong operator",
+			kubeletConfigs: map[string]string{common.EvictionHardKubeletConfig: "memory.available=100Mi"},
+			expectError:    true,
+		},
+		{
+			name:           "eviction threshold above 100%",
+			kubeletConfigs: map[string]string{common.EvictionHardKubeletConfig: "nodefs.available<110%"},
+			expectError:    true,
+		},
+		{
+			name:           "malformed eviction threshold",
+			kubeletConfigs: map[string]string{common.EvictionHardKubeletConfig: "nodefs.available<ten%"},
+			expectError:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reservations, err := parseResourceReservations(test.kubeletConfigs)
+			if (err != nil) != test.expectError {
+				t.Fatalf("expected error: %t, got: %v", test.expectError, err)
+			}
+			if _, err := KubeletResourceReservations(test.kubeletConfigs); (err != nil) != test.expectError {
+				t.Errorf("expected rendering error: %t, got: %v", test.expectError, err)
+			}
+			if err := ValidateKubeletConfigs(test.kubeletConfigs); (err != nil) != test.expectError {
+				t.Errorf("expected validation error: %t, got: %v", test.expectError, err)
+			}
+			if test.expectError {
+				if _, err := kubeletConfiguration("cluster.local", nil, nil, test.kubeletConfigs, "containerd", CgroupDriverSystemd); err == nil {
+					t.Error("expected the kubelet configuration to be rejected as well")
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(reservations, test.expected) {
+				t.Errorf("expected reservations %v, got %v", test.expected, reservations)
+			}
+
+			blocks, err := KubeletResourceReservations(test.kubeletConfigs)
+			if err != nil {
+				t.Fatalf("failed to render reservations: %v", err)
+			}
+			rendered := kubeletv1b1.KubeletConfiguration{}
+			if err := kyaml.UnmarshalStrict([]byte(blocks), &rendered); err != nil {
+				t.Fatalf("failed to unmarshal reservations: %v", err)
+			}
+			if !reflect.DeepEqual(rendered.SystemReserved, test.expected.SystemReserved) || !reflect.DeepEqual(rendered.KubeReserved, test.expected.KubeReserved) || !reflect.DeepEqual(rendered.EvictionHard, test.expected.EvictionHard) {
+				t.Errorf("expected the rendered blocks to contain the reservations %v, got %v", test.expected, blocks)
+			}
+
+			config, err := kubeletConfiguration("cluster.local", nil, nil, test.kubeletConfigs, "containerd", CgroupDriverSystemd)
+			if err != nil {
+				t.Fatalf("failed to render kubelet configuration: %v", err)
+			}
+			cfg := kubeletv1b1.KubeletConfiguration{}
+			if err := kyaml.UnmarshalStrict([]byte(config), &cfg); err != nil {
+				t.Fatalf("failed to unmarshal kubelet configuration: %v", err)
+			}
+			if !reflect.DeepEqual(cfg.SystemReserved, test.expected.SystemReserved) || !reflect.DeepEqual(cfg.KubeReserved, test.expected.KubeReserved) || !reflect.DeepEqual(cfg.EvictionHard, test.expected.EvictionHard) {
+				t.Errorf("expected the kubelet configuration to contain the reservations %v, got %v", test.expected, config)
+			}
+		})
+	}
+}
+
+func TestKubeletConfigurationCgroupDriver(t *testing.T) {
+	for _, driver := range []string{CgroupDriverSystemd, CgroupDriverCgroupfs} {
+		out, err := kubeletConfiguration("cluster.local", nil, nil, nil, "docker", driver)
+		if err != nil {
+			t.Fatalf("failed to render kubelet configuration: %v", err)
+		}
+
+		cfg := kubeletv1b1.KubeletConfiguration{}
+		if err := kyaml.UnmarshalStrict([]byte(out), &cfg); err != nil {
+			t.Fatalf("failed to unmarshal kubelet configuration: %v", err)
+		}
+		if cfg.CgroupDriver != driver {
+			t.Errorf("expected cgroup driver %q, got %q", driver, cfg.CgroupDriver)
+		}
+	}
+}
+
+func TestKubeletConfigurationCertificateRotation(t *testing.T) {
+	out, err := kubeletConfiguration("cluster.local", []net.IP{net.ParseIP("10.10.10.10")}, nil, nil, "containerd", CgroupDriverSystemd)
+	if err != nil {
+		t.Fatalf("failed to render kubelet configuration: %v", err)
+	}
+
+	cfg := kubeletv1b1.KubeletConfiguration{}
+	if err := kyaml.UnmarshalStrict([]byte(out), &cfg); err != nil {
+		t.Fatalf("failed to unmarshal kubelet configuration: %v", err)
+	}
+
+	// The client certificate gets renewed and the serving certificate is requested via a CSR, which has to be
+	// approved by the NodeCSRApprover controller
+	if !cfg.RotateCertificates {
+		t.Error("expected the client certificate to be rotated")
+	}
+	if !cfg.ServerTLSBootstrap {
+		t.Error("expected the serving certificate to be requested via a CSR")
+	}
+	if cfg.TLSCertFile != "" || cfg.TLSPrivateKeyFile != "" {
+		t.Errorf("expected no static serving certificate, got %q and %q", cfg.TLSCertFile, cfg.TLSPrivateKeyFile)
+	}
+	if cfg.Authentication.X509.ClientCAFile != "/etc/kubernetes/pki/ca.crt" {
+		t.Errorf("expected the cluster CA to authenticate clients, got %q", cfg.Authentication.X509.ClientCAFile)
+	}
+}
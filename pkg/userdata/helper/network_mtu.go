@@ -0,0 +1,49 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+
+	azuretypes "github.com/kubermatic/machine-controller/pkg/cloudprovider/provider/azure/types"
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+)
+
+// NetworkMTU returns the MTU configured for the network interfaces of the node, 0 means that the default MTU is kept.
+// It's only supported on Azure so far.
+func NetworkMTU(cloudProviderName string, pconfig *providerconfigtypes.Config) (int, error) {
+	if cloudProviderName != string(providerconfigtypes.CloudProviderAzure) || len(pconfig.CloudProviderSpec.Raw) == 0 {
+		return 0, nil
+	}
+
+	rawConfig, err := azuretypes.GetConfig(*pconfig)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse azure config: %v", err)
+	}
+
+	if rawConfig.NetworkMTU == nil {
+		return 0, nil
+	}
+
+	return *rawConfig.NetworkMTU, nil
+}
+
+// NetworkMTUUdevRule returns a udev rule which sets the MTU of the Azure synthetic network interfaces. The virtual
+// functions of accelerated networking are bonded to them and use the same MTU.
+func NetworkMTUUdevRule(mtu int) string {
+	return fmt.Sprintf(`SUBSYSTEM=="net", DRIVERS=="hv_netvsc", ATTR{mtu}="%d"`, mtu)
+}
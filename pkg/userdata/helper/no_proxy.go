@@ -0,0 +1,41 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
+)
+
+// cloudMetadataHosts are the metadata endpoints of the clouds in addition to 169.254.169.254. Requests to them
+// must not go through the proxy, as the endpoints only answer requests from the instance itself.
+var cloudMetadataHosts = map[providerconfigtypes.CloudProvider][]string{
+	// IPv6 endpoint of the instance metadata service
+	providerconfigtypes.CloudProviderAWS: {"fd00:ec2::254"},
+	// Wire server, which is used by the guest agent for the provisioning and the extensions of the VM
+	providerconfigtypes.CloudProviderAzure:        {"168.63.129.16"},
+	providerconfigtypes.CloudProviderGoogle:       {"metadata.google.internal"},
+	providerconfigtypes.CloudProviderAlibaba:      {"100.100.100.200"},
+	providerconfigtypes.CloudProviderScaleway:     {"169.254.42.42"},
+	providerconfigtypes.CloudProviderEquinixMetal: {"metadata.platformequinix.com"},
+	providerconfigtypes.CloudProviderPacket:       {"metadata.platformequinix.com"},
+}
+
+// metadataNoProxy returns the metadata endpoints of the cloud which are always excluded from the proxy.
+func metadataNoProxy(cloudProviderName string) []string {
+	hosts := []string{"169.254.169.254"}
+	return append(hosts, cloudMetadataHosts[providerconfigtypes.CloudProvider(cloudProviderName)]...)
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultPauseImage is used for the sandbox of pods when only a registry for the pause image is configured.
+const DefaultPauseImage = "registry.k8s.io/pause:3.9"
+
+// PauseImage returns the pause image that has to be configured for both the kubelet's --pod-infra-container-image
+// flag and containerd's sandbox_image, so the image the kubelet pins matches the one containerd pulls. If registry
+// is set, the registry of the image is replaced with it, e.g. to pull the image from an internal mirror in
+// air-gapped setups. An empty result means that the defaults of the kubelet and the container runtime are kept.
+func PauseImage(image, registry string) (string, error) {
+	image = strings.TrimSpace(image)
+	registry = strings.TrimSuffix(strings.TrimSpace(registry), "/")
+
+	if registry == "" {
+		return image, nil
+	}
+	if strings.Contains(registry, "://") {
+		return "", fmt.Errorf("pause image registry %q must not contain a scheme", registry)
+	}
+
+	if image == "" {
+		image = DefaultPauseImage
+	}
+
+	return registry + "/" + imageRepository(image), nil
+}
+
+// imageRepository returns the image reference without its registry. Like docker, the first component is only
+// treated as registry if it contains a dot or a port or is localhost.
+func imageRepository(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[1]
+	}
+
+	return image
+}
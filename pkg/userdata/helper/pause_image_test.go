@@ -0,0 +1,74 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"testing"
+)
+
+func TestPauseImage(t *testing.T) {
+	tests := []struct {
+		name          string
+		image         string
+		registry      string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name: "defaults are kept",
+		},
+		{
+			name:     "custom image",
+			image:    "192.168.1.1:5000/kubernetes/pause:3.1",
+			expected: "192.168.1.1:5000/kubernetes/pause:3.1",
+		},
+		{
+			name:     "default image from registry",
+			registry: "registry.internal",
+			expected: "registry.internal/pause:3.9",
+		},
+		{
+			name:     "registry of custom image is replaced",
+			image:    "k8s.gcr.io/pause:3.1",
+			registry: "registry.internal:5000/mirror/",
+			expected: "registry.internal:5000/mirror/pause:3.1",
+		},
+		{
+			name:     "image without registry",
+			image:    "kubernetes/pause:3.1",
+			registry: "localhost:5000",
+			expected: "localhost:5000/kubernetes/pause:3.1",
+		},
+		{
+			name:          "registry with scheme",
+			registry:      "https://registry.internal",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image, err := PauseImage(test.image, test.registry)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("expected error: %t, got: %v", test.expectedError, err)
+			}
+			if image != test.expected {
+				t.Errorf("expected pause image %q, got %q", test.expected, image)
+			}
+		})
+	}
+}
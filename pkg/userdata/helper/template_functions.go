@@ -21,6 +21,8 @@ import (
 	"text/template"
 
 	"github.com/Masterminds/sprig/v3"
+
+	"github.com/kubermatic/machine-controller/pkg/userdata/scripts"
 )
 
 // TxtFuncMap returns an aggregated template function map. Currently (custom functions + sprig)
@@ -31,16 +33,28 @@ func TxtFuncMap() template.FuncMap {
 	funcMap["safeDownloadBinariesScript"] = SafeDownloadBinariesScript
 	funcMap["kubeletSystemdUnit"] = KubeletSystemdUnit
 	funcMap["kubeletConfiguration"] = kubeletConfiguration
+	funcMap["kubeletResourceReservations"] = KubeletResourceReservations
+	funcMap["reservedCgroupsFiles"] = reservedCgroupsFiles
+	funcMap["reservedCgroupsWriteFiles"] = ReservedCgroupsWriteFiles
 	funcMap["kubeletFlags"] = KubeletFlags
+	funcMap["kubeletResolvConf"] = kubeletResolvConf
 	funcMap["cloudProviderFlags"] = CloudProviderFlags
 	funcMap["kernelModulesScript"] = LoadKernelModulesScript
 	funcMap["kernelSettings"] = KernelSettings
 	funcMap["journalDConfig"] = JournalDConfig
 	funcMap["kubeletHealthCheckSystemdUnit"] = KubeletHealthCheckSystemdUnit
 	funcMap["containerRuntimeHealthCheckSystemdUnit"] = ContainerRuntimeHealthCheckSystemdUnit
+	funcMap["healthMonitorScript"] = func() string { return scripts.HealthMonitor }
 	funcMap["dockerConfig"] = DockerConfig
 	funcMap["proxyEnvironment"] = ProxyEnvironment
 	funcMap["sshConfigAddendum"] = SSHConfigAddendum
+	funcMap["extraWriteFiles"] = ExtraWriteFiles
+	funcMap["caCertificatesWriteFiles"] = CACertificatesWriteFiles
+	funcMap["updateCATrustCommand"] = UpdateCATrustCommand
+	funcMap["networkMTU"] = NetworkMTU
+	funcMap["networkMTUUdevRule"] = NetworkMTUUdevRule
+	funcMap["cgroupDriverScript"] = CgroupDriverScript
+	funcMap["applyCgroupDriverScript"] = ApplyCgroupDriverScript
 
 	return funcMap
 }
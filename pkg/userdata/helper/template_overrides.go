@@ -0,0 +1,81 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// emptyTemplateOverride replaces overrides which only contain white space. text/template does not replace a
+// template with an empty one, so removing a snippet requires a template with an empty output.
+const emptyTemplateOverride = `{{- "" -}}`
+
+// ParseTemplate parses the template of an operating system and replaces its named snippets, which the template
+// defines with block actions, by the given overrides. Overrides for unknown snippets or which do not parse are
+// rejected, so a broken override fails before a node gets provisioned with it.
+func ParseTemplate(name, text string, overrides map[string]string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(TxtFuncMap()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s template: %w", name, err)
+	}
+
+	snippets := make([]string, 0, len(overrides))
+	for snippet := range overrides {
+		snippets = append(snippets, snippet)
+	}
+	sort.Strings(snippets)
+
+	for _, snippet := range snippets {
+		if snippet == name || tmpl.Lookup(snippet) == nil {
+			return nil, fmt.Errorf("unknown %s template snippet %q, supported snippets are: %s", name, snippet, strings.Join(templateSnippets(tmpl), ", "))
+		}
+
+		override := overrides[snippet]
+		if strings.TrimSpace(override) == "" {
+			override = emptyTemplateOverride
+		}
+
+		parsed, err := template.New(snippet).Funcs(TxtFuncMap()).Parse(override)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse override of %s template snippet %q: %w", name, snippet, err)
+		}
+		if len(parsed.Templates()) > 1 {
+			return nil, fmt.Errorf("override of %s template snippet %q must not define further templates", name, snippet)
+		}
+
+		if _, err := tmpl.AddParseTree(snippet, parsed.Tree); err != nil {
+			return nil, fmt.Errorf("failed to override %s template snippet %q: %w", name, snippet, err)
+		}
+	}
+
+	return tmpl, nil
+}
+
+// templateSnippets returns the sorted names of the snippets which can be overridden.
+func templateSnippets(tmpl *template.Template) []string {
+	var snippets []string
+	for _, t := range tmpl.Templates() {
+		if t.Name() != tmpl.Name() {
+			snippets = append(snippets, t.Name())
+		}
+	}
+	sort.Strings(snippets)
+	return snippets
+}
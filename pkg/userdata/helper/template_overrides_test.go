@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	const base = `packages:
+{{ block "packages" . }}- curl{{ end }}
+runcmd:
+{{ block "runcmd" . }}- {{ .Command }}{{ end }}
+`
+
+	tests := []struct {
+		name                  string
+		overrides             map[string]string
+		expected              string
+		expectedParseError    bool
+		expectedExecuteError  string
+		expectedErrorContains string
+	}{
+		{
+			name:     "no overrides",
+			expected: "packages:\n- curl\nruncmd:\n- setup\n",
+		},
+		{
+			name: "override snippet",
+			overrides: map[string]string{
+				"packages": "- curl\n- {{ .Command | upper }}",
+			},
+			expected: "packages:\n- curl\n- SETUP\nruncmd:\n- setup\n",
+		},
+		{
+			name: "remove snippet",
+			overrides: map[string]string{
+				"packages": " ",
+			},
+			expected: "packages:\n\nruncmd:\n- setup\n",
+		},
+		{
+			name: "unknown snippet",
+			overrides: map[string]string{
+				"bootcmd": "- modprobe ip_tables",
+			},
+			expectedParseError:    true,
+			expectedErrorContains: "supported snippets are: packages, runcmd",
+		},
+		{
+			name: "root template",
+			overrides: map[string]string{
+				"user-data": "#cloud-config",
+			},
+			expectedParseError: true,
+		},
+		{
+			name: "invalid override",
+			overrides: map[string]string{
+				"runcmd": "- {{ .Command ",
+			},
+			expectedParseError:    true,
+			expectedErrorContains: `snippet "runcmd"`,
+		},
+		{
+			name: "override defines templates",
+			overrides: map[string]string{
+				"runcmd": `{{ define "user-data" }}#cloud-config{{ end }}- {{ .Command }}`,
+			},
+			expectedParseError: true,
+		},
+		{
+			name: "override fails to execute",
+			overrides: map[string]string{
+				"runcmd": "- {{ .Missing }}",
+			},
+			expectedExecuteError: `executing "runcmd"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := ParseTemplate("user-data", base, test.overrides)
+			if (err != nil) != test.expectedParseError {
+				t.Fatalf("expected parse error: %t, got: %v", test.expectedParseError, err)
+			}
+			if err != nil {
+				if !strings.Contains(err.Error(), test.expectedErrorContains) {
+					t.Errorf("expected error to contain %q, got: %v", test.expectedErrorContains, err)
+				}
+				return
+			}
+
+			var buf strings.Builder
+			err = tmpl.Execute(&buf, struct{ Command string }{Command: "setup"})
+			if test.expectedExecuteError != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedExecuteError) {
+					t.Fatalf("expected execute error containing %q, got: %v", test.expectedExecuteError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to execute template: %v", err)
+			}
+			if buf.String() != test.expected {
+				t.Errorf("expected:\n%q\ngot:\n%q", test.expected, buf.String())
+			}
+		})
+	}
+}
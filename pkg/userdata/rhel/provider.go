@@ -24,7 +24,6 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"text/template"
 
 	"github.com/Masterminds/semver/v3"
 
@@ -38,11 +37,6 @@ type Provider struct{}
 
 // UserData renders user-data template to string.
 func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
-	tmpl, err := template.New("user-data").Funcs(userdatahelper.TxtFuncMap()).Parse(userDataTemplate)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse user-data template: %w", err)
-	}
-
 	kubeletVersion, err := semver.NewVersion(req.MachineSpec.Versions.Kubelet)
 	if err != nil {
 		return "", fmt.Errorf("invalid kubelet version: %w", err)
@@ -53,6 +47,16 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		return "", fmt.Errorf("failed to get provider config: %w", err)
 	}
 
+	req.DNSIPs, err = userdatahelper.NormalizeDNSIPs(req.DNSIPs)
+	if err != nil {
+		return "", fmt.Errorf("invalid cluster DNS IPs: %w", err)
+	}
+
+	tmpl, err := userdatahelper.ParseTemplate("user-data", userDataTemplate, pconfig.TemplateOverrides)
+	if err != nil {
+		return "", err
+	}
+
 	if pconfig.OverwriteCloudConfig != nil {
 		req.CloudConfig = *pconfig.OverwriteCloudConfig
 	}
@@ -66,6 +70,10 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		return "", fmt.Errorf("failed to parse OperatingSystemSpec: %w", err)
 	}
 
+	if err := rhelConfig.Validate(); err != nil {
+		return "", fmt.Errorf("invalid OperatingSystemSpec: %w", err)
+	}
+
 	serverAddr, err := userdatahelper.GetServerAddressFromKubeconfig(req.Kubeconfig)
 	if err != nil {
 		return "", fmt.Errorf("error extracting server address from kubeconfig: %w", err)
@@ -92,6 +100,11 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		return "", fmt.Errorf("failed to generate container runtime config: %w", err)
 	}
 
+	cgroupDriver := userdatahelper.CgroupDriver(providerconfigtypes.OperatingSystemRHEL, crEngine.String(), kubeletVersion.String())
+	if req.ContainerRuntime.DockerCgroupDriver != "" {
+		cgroupDriver = crEngine.CgroupDriver()
+	}
+
 	data := struct {
 		plugin.UserDataRequest
 		ProviderSpec                   *providerconfigtypes.Config
@@ -106,6 +119,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		ContainerRuntimeConfigFileName string
 		ContainerRuntimeConfig         string
 		ContainerRuntimeName           string
+		CgroupDriver                   string
 	}{
 		UserDataRequest:                req,
 		ProviderSpec:                   pconfig,
@@ -120,6 +134,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		ContainerRuntimeConfigFileName: crEngine.ConfigFileName(),
 		ContainerRuntimeConfig:         crConfig,
 		ContainerRuntimeName:           crEngine.String(),
+		CgroupDriver:                   cgroupDriver,
 	}
 
 	var buf strings.Builder
@@ -133,7 +148,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 // UserData template.
 const userDataTemplate = `#cloud-config
 bootcmd:
-- modprobe ip_tables
+{{ block "bootcmd" . }}- modprobe ip_tables{{ end }}
 {{ if ne .CloudProviderName "aws" }}
 hostname: {{ .MachineSpec.Name }}
 fqdn: {{ .MachineSpec.Name }}
@@ -158,7 +173,7 @@ write_files:
 {{- if .HTTPProxy }}
 - path: "/etc/environment"
   content: |
-{{ proxyEnvironment .HTTPProxy .NoProxy | indent 4 }}
+{{ proxyEnvironment .HTTPProxy .NoProxy .CloudProviderName .ClusterCIDRs .ServerAddr | indent 4 }}
 {{- end }}
 
 - path: "/etc/systemd/journald.conf.d/max_disk_use.conf"
@@ -170,6 +185,19 @@ write_files:
   content: |
 {{ kernelModulesScript | indent 4 }}
 
+{{- if .OSConfig.NTPServers }}
+
+- path: "/etc/chrony.conf"
+  content: |
+    {{- range .OSConfig.NTPServers }}
+    server {{ . }} iburst
+    {{- end }}
+    driftfile /var/lib/chrony/drift
+    makestep 1.0 3
+    rtcsync
+    logdir /var/log/chrony
+{{- end }}
+
 - path: "/etc/sysctl.d/k8s.conf"
   content: |
 {{ kernelSettings | indent 4 }}
@@ -195,10 +223,16 @@ write_files:
     set -xeuo pipefail
 
     setenforce 0 || true
+{{- if .ProviderSpec.CACertificates }}
+    {{ updateCATrustCommand "rhel" }}
+{{- end }}
 
 {{- /* As we added some modules and don't want to reboot, restart the service */}}
     systemctl restart systemd-modules-load.service
     sysctl --system
+{{- if networkMTU .CloudProviderName .ProviderSpec }}
+    udevadm trigger --subsystem-match=net --action=change
+{{- end }}
     {{ if ne .CloudProviderName "aws" }}
 {{- /*  The normal way of setting it via cloud-init is broken, see */}}
 {{- /*  https://bugs.launchpad.net/cloud-init/+bug/1662542 */}}
@@ -207,10 +241,17 @@ write_files:
     {{ if eq .CloudProviderName "azure" }}
     yum update -y --disablerepo='*' --enablerepo='*microsoft*'
     {{ end }}
+    source /etc/os-release
+    EBTABLES_PACKAGE=ebtables
+    if [ "${VERSION_ID%%.*}" -ge 9 ]; then
+{{- /* RHEL 9 dropped the legacy ebtables package, the nftables based ebtables is part of iptables-nft */}}
+      EBTABLES_PACKAGE=iptables-nft
+    fi
+
     yum install -y \
       device-mapper-persistent-data \
       lvm2 \
-      ebtables \
+      ${EBTABLES_PACKAGE} \
       ethtool \
       nfs-utils \
       bash-completion \
@@ -218,6 +259,12 @@ write_files:
       socat \
       wget \
       curl \
+      {{- if .OSConfig.NTPServers }}
+      chrony \
+      {{- end }}
+      {{- range .OSConfig.ExtraPackages }}
+      {{ . }} \
+      {{- end }}
       {{- if eq .CloudProviderName "vsphere" }}
       open-vm-tools \
       {{- end }}
@@ -226,10 +273,16 @@ write_files:
       {{- end }}
       ipvsadm
 
+    {{- if .OSConfig.NTPServers }}
+    systemctl enable chronyd
+    systemctl restart chronyd
+    {{- end }}
+
     {{- /* iscsid service is required on Nutanix machines for CSI driver to attach volumes. */}}
     {{- if eq .CloudProviderName "nutanix" }}
     systemctl enable --now iscsid
     {{ end }}
+{{ applyCgroupDriverScript .ContainerRuntimeConfigFileName | indent 4 }}
 {{ .ContainerRuntimeScript | indent 4 }}
 {{ safeDownloadBinariesScript .KubeletVersion | indent 4 }}
     # set kubelet nodeip environment variable
@@ -247,6 +300,11 @@ write_files:
     systemctl enable --now --no-block restart-kubelet.service
     {{ end }}
 
+- path: "/opt/bin/detect-cgroup-driver"
+  permissions: "0755"
+  content: |
+{{ cgroupDriverScript .CgroupDriver | indent 4 }}
+
 - path: "/opt/bin/supervise.sh"
   permissions: "0755"
   content: |
@@ -273,6 +331,11 @@ write_files:
   content: |
 {{ .CloudConfig | indent 4 }}
 
+- path: "/opt/bin/health-monitor.sh"
+  permissions: "0755"
+  content: |
+{{ healthMonitorScript | indent 4 }}
+
 - path: "/opt/bin/setup_net_env.sh"
   permissions: "0755"
   content: |
@@ -285,7 +348,11 @@ write_files:
 
 - path: "/etc/kubernetes/kubelet.conf"
   content: |
-{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName | indent 4 }}
+{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName .CgroupDriver | indent 4 }}
+{{- with reservedCgroupsWriteFiles .KubeletConfigs .ContainerRuntimeName }}
+
+{{ . }}
+{{- end }}
 
 - path: "/etc/kubernetes/pki/ca.crt"
   content: |
@@ -320,7 +387,7 @@ write_files:
 - path: /etc/systemd/system/kubelet-healthcheck.service
   permissions: "0644"
   content: |
-{{ kubeletHealthCheckSystemdUnit | indent 4 }}
+{{ kubeletHealthCheckSystemdUnit .ProviderSpec.KubeletHealthCheck | indent 4 }}
 
 {{- with .ProviderSpec.CAPublicKey }}
 
@@ -397,8 +464,22 @@ write_files:
     [Install]
     WantedBy=multi-user.target
 {{- end }}
+{{- with networkMTU .CloudProviderName .ProviderSpec }}
+
+- path: "/etc/udev/rules.d/99-network-mtu.rules"
+  content: |
+    {{ networkMTUUdevRule . }}
+{{- end }}
+{{- with .ProviderSpec.CACertificates }}
 
-rh_subscription:
+{{ caCertificatesWriteFiles . "rhel" }}
+{{- end }}
+{{- with .ProviderSpec.ExtraFiles }}
+
+{{ extraWriteFiles . }}
+{{- end }}
+
+{{ block "rh_subscription" . }}rh_subscription:
 {{- if .OSConfig.RHELUseSatelliteServer }}
     org: "{{.OSConfig.RHELOrganizationName}}"
     activation-key: "{{.OSConfig.RHELActivationKey}}"
@@ -408,9 +489,9 @@ rh_subscription:
     username: "{{.OSConfig.RHELSubscriptionManagerUser}}"
     password: "{{.OSConfig.RHELSubscriptionManagerPassword}}"
     auto-attach: {{.OSConfig.AttachSubscription}}
-{{- end }}
+{{- end }}{{ end }}
 
 runcmd:
-- systemctl start setup.service
-- systemctl start disable-nm-cloud-setup.service
+{{ block "runcmd" . }}- systemctl start setup.service
+- systemctl start disable-nm-cloud-setup.service{{ end }}
 `
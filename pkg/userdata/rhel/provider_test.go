@@ -21,6 +21,7 @@ limitations under the License.
 package rhel
 
 import (
+	"encoding/json"
 	"flag"
 	"net"
 	"testing"
@@ -28,6 +29,7 @@ import (
 	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
 	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
 	"github.com/kubermatic/machine-controller/pkg/containerruntime"
+	providerconfigtypes "github.com/kubermatic/machine-controller/pkg/providerconfig/types"
 	testhelper "github.com/kubermatic/machine-controller/pkg/test"
 	"github.com/kubermatic/machine-controller/pkg/userdata/convert"
 
@@ -91,6 +93,9 @@ type userDataTestCase struct {
 	registryMirrors       string
 	pauseImage            string
 	containerruntime      string
+	osConfig              *Config
+	templateOverrides     map[string]string
+	caCertificates        []string
 }
 
 // TestUserDataGeneration runs the data generation for different
@@ -213,6 +218,53 @@ func TestUserDataGeneration(t *testing.T) {
 			},
 			cloudProviderName: stringPtr("azure"),
 		},
+		{
+			name: "kubelet-v1.24-aws-extra-packages",
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: "1.24.0",
+				},
+			},
+			osConfig: &Config{
+				ExtraPackages: []string{"jq", "chrony"},
+			},
+		},
+		{
+			name: "kubelet-v1.24-aws-ntp-servers",
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: "1.24.0",
+				},
+			},
+			osConfig: &Config{
+				NTPServers: []string{"ntp.example.com", "192.168.0.1"},
+			},
+		},
+		{
+			name: "kubelet-v1.24-aws-ca-certificates",
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: "1.24.0",
+				},
+			},
+			caCertificates: []string{pemCertificate},
+		},
+		{
+			name: "kubelet-v1.24-aws-template-overrides",
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: "1.24.0",
+				},
+			},
+			templateOverrides: map[string]string{
+				"bootcmd":         "- modprobe ip_tables\n- echo {{ .MachineSpec.Name }} > /etc/node-name",
+				"rh_subscription": "",
+			},
+		},
 	}
 
 	defaultCloudProvider := &fakeCloudConfigProvider{
@@ -245,6 +297,26 @@ func TestUserDataGeneration(t *testing.T) {
 				Value: &runtime.RawExtension{},
 			}
 			test.spec.ProviderSpec = emtpyProviderSpec
+			if test.osConfig != nil || test.templateOverrides != nil || test.caCertificates != nil {
+				pconfig := providerconfigtypes.Config{
+					TemplateOverrides: test.templateOverrides,
+					CACertificates:    test.caCertificates,
+				}
+				if test.osConfig != nil {
+					osConfig, err := json.Marshal(test.osConfig)
+					if err != nil {
+						t.Fatalf("failed to marshal OS config: %v", err)
+					}
+					pconfig.OperatingSystemSpec = runtime.RawExtension{Raw: osConfig}
+				}
+				providerSpec, err := json.Marshal(pconfig)
+				if err != nil {
+					t.Fatalf("failed to marshal provider spec: %v", err)
+				}
+				test.spec.ProviderSpec = clusterv1alpha1.ProviderSpec{
+					Value: &runtime.RawExtension{Raw: providerSpec},
+				}
+			}
 			var cloudProvider *fakeCloudConfigProvider
 			if test.cloudProviderName != nil {
 				cloudProvider = &fakeCloudConfigProvider{
@@ -299,6 +371,54 @@ func TestUserDataGeneration(t *testing.T) {
 	}
 }
 
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		extraPackages []string
+		ntpServers    []string
+		expectedError string
+	}{
+		{
+			name:          "valid packages",
+			extraPackages: []string{"jq", "chrony", "python3-pip", "jq-1.6.x86_64", "libstdc++"},
+		},
+		{
+			name:          "shell injection",
+			extraPackages: []string{"jq; rm -rf /"},
+			expectedError: `invalid extra package name "jq; rm -rf /"`,
+		},
+		{
+			name:          "option",
+			extraPackages: []string{"--nogpgcheck"},
+			expectedError: `invalid extra package name "--nogpgcheck"`,
+		},
+		{
+			name:       "valid NTP servers",
+			ntpServers: []string{"ntp.example.com", "192.168.0.1", "fd00::1"},
+		},
+		{
+			name:          "invalid NTP server",
+			ntpServers:    []string{"ntp.example.com\nserver evil.example.com"},
+			expectedError: `invalid NTP server "ntp.example.com\nserver evil.example.com", must be a hostname or an IP address`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := (&Config{ExtraPackages: test.extraPackages, NTPServers: test.ntpServers}).Validate()
+			if test.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Fatalf("expected error %q, got %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
 // stringPtr returns pointer to given string.
 func stringPtr(a string) *string {
 	return &a
@@ -18,8 +18,12 @@ package rhel
 
 import (
 	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 // Config contains specific configuration for RHEL.
@@ -33,6 +37,33 @@ type Config struct {
 	RHELSatelliteServer             string `json:"rhelSatelliteServer"`
 	RHELOrganizationName            string `json:"rhelOrganizationName"`
 	RHELActivationKey               string `json:"rhelActivationKey"`
+
+	// ExtraPackages are installed with yum in addition to the packages required by the node.
+	ExtraPackages []string `json:"extraPackages,omitempty"`
+
+	// NTPServers are the hostnames or IP addresses of the NTP servers chronyd synchronizes the clock with, instead
+	// of the servers configured in the image.
+	NTPServers []string `json:"ntpServers,omitempty"`
+}
+
+// packageNameRegexp matches RPM package names, optionally with version and architecture, e.g. jq-1.6.x86_64.
+var packageNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._+-]*$`)
+
+// Validate returns an error if the configuration can't be rendered into a valid setup script.
+func (cfg *Config) Validate() error {
+	for _, pkg := range cfg.ExtraPackages {
+		if !packageNameRegexp.MatchString(pkg) {
+			return fmt.Errorf("invalid extra package name %q", pkg)
+		}
+	}
+
+	for _, server := range cfg.NTPServers {
+		if net.ParseIP(server) == nil && len(validation.IsDNS1123Subdomain(server)) > 0 {
+			return fmt.Errorf("invalid NTP server %q, must be a hostname or an IP address", server)
+		}
+	}
+
+	return nil
 }
 
 func DefaultConfig(operatingSystemSpec runtime.RawExtension) runtime.RawExtension {
@@ -24,6 +24,8 @@
 # Adjustments are:
 # * Kubelet health port is 10248 not 10255
 # * Removal of all all references to the KUBE_ENV file
+# * The kubelet health check can be configured with the KUBELET_HEALTHZ_URL, KUBELET_HEALTHCHECK_TIMEOUT_SECONDS,
+#   KUBELET_HEALTHCHECK_PERIOD_SECONDS and KUBELET_HEALTHCHECK_FAILURE_THRESHOLD environment variables
 
 set -o nounset
 set -o pipefail
@@ -74,27 +76,37 @@ function kubelet_monitoring() {
   echo "Wait for 2 minutes for kubelet to be functional"
   # TODO(andyzheng0831): replace it with a more reliable method if possible.
   sleep 120
-  local -r max_seconds=10
+  local -r max_seconds="${KUBELET_HEALTHCHECK_TIMEOUT_SECONDS:-10}"
+  local -r healthz_url="${KUBELET_HEALTHZ_URL:-http://127.0.0.1:10248/healthz}"
+  local -r period_seconds="${KUBELET_HEALTHCHECK_PERIOD_SECONDS:-${SLEEP_SECONDS}}"
+  local -r failure_threshold="${KUBELET_HEALTHCHECK_FAILURE_THRESHOLD:-1}"
   local output=""
+  local failures=0
   while true; do
     local failed=false
 
     if journalctl -u kubelet -n 1 | grep -q "use of closed network connection"; then
       failed=true
+      failures="${failure_threshold}"
       echo "Kubelet stopped posting node status. Restarting"
-    elif ! output=$(curl -m "${max_seconds}" -f -s -S http://127.0.0.1:10248/healthz 2>&1); then
+    elif ! output=$(curl -m "${max_seconds}" -f -s -S "${healthz_url}" 2>&1); then
       failed=true
+      failures=$((failures + 1))
       # Print the response and/or errors.
       echo "$output"
     fi
 
-    if [[ "$failed" == "true" ]]; then
+    if [[ "$failed" == "true" ]] && ((failures >= failure_threshold)); then
       echo "Kubelet is unhealthy!"
       systemctl kill kubelet
+      failures=0
       # Wait for a while, as we don't want to kill it again before it is really up.
       sleep 60
     else
-      sleep "${SLEEP_SECONDS}"
+      if [[ "$failed" != "true" ]]; then
+        failures=0
+      fi
+      sleep "${period_seconds}"
     fi
   done
 }
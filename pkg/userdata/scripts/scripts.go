@@ -0,0 +1,27 @@
+/*
+Copyright 2022 The Machine Controller Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scripts contains the scripts which are written to the nodes.
+package scripts
+
+import (
+	_ "embed"
+)
+
+// HealthMonitor is the script which restarts the kubelet or the container runtime once their health checks fail.
+//
+//go:embed health-monitor.sh
+var HealthMonitor string
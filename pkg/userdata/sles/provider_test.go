@@ -29,6 +29,7 @@ import (
 
 	"github.com/Masterminds/semver/v3"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
 	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
 	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
 	"github.com/kubermatic/machine-controller/pkg/containerruntime"
@@ -122,6 +123,7 @@ type userDataTestCase struct {
 	registryMirrors       string
 	pauseImage            string
 	containerruntime      string
+	kubeletConfigs        map[string]string
 }
 
 func simpleVersionTests() []userDataTestCase {
@@ -408,6 +410,32 @@ func TestUserDataGeneration(t *testing.T) {
 				DistUpgradeOnBoot: false,
 			},
 		},
+		{
+			name: "resolv-conf",
+			providerSpec: &providerconfigtypes.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+			},
+			kubeletConfigs: map[string]string{common.ResolvConfKubeletConfig: "/etc/kubernetes/resolv.conf"},
+		},
 	}...)
 
 	for _, test := range tests {
@@ -460,6 +488,7 @@ func TestUserDataGeneration(t *testing.T) {
 				PauseImage:               test.pauseImage,
 				KubeletFeatureGates:      kubeletFeatureGates,
 				ContainerRuntime:         containerRuntimeConfig,
+				KubeletConfigs:           test.kubeletConfigs,
 			}
 			s, err := provider.UserData(req)
 			if err != nil {
@@ -53,6 +53,11 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		return "", fmt.Errorf("failed to get providerSpec: %v", err)
 	}
 
+	req.DNSIPs, err = userdatahelper.NormalizeDNSIPs(req.DNSIPs)
+	if err != nil {
+		return "", fmt.Errorf("invalid cluster DNS IPs: %w", err)
+	}
+
 	if pconfig.OverwriteCloudConfig != nil {
 		req.CloudConfig = *pconfig.OverwriteCloudConfig
 	}
@@ -91,6 +96,11 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to generate container runtime config: %w", err)
 	}
+	cgroupDriver := userdatahelper.CgroupDriver(providerconfigtypes.OperatingSystemUbuntu, crEngine.String(), kubeletVersion.String())
+	if req.ContainerRuntime.DockerCgroupDriver != "" {
+		cgroupDriver = crEngine.CgroupDriver()
+	}
+
 	data := struct {
 		plugin.UserDataRequest
 		ProviderSpec                   *providerconfigtypes.Config
@@ -105,6 +115,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		ContainerRuntimeConfigFileName string
 		ContainerRuntimeConfig         string
 		ContainerRuntimeName           string
+		CgroupDriver                   string
 	}{
 		UserDataRequest:                req,
 		ProviderSpec:                   pconfig,
@@ -119,6 +130,7 @@ func (p Provider) UserData(req plugin.UserDataRequest) (string, error) {
 		ContainerRuntimeConfigFileName: crEngine.ConfigFileName(),
 		ContainerRuntimeConfig:         crConfig,
 		ContainerRuntimeName:           crEngine.String(),
+		CgroupDriver:                   cgroupDriver,
 	}
 
 	var buf strings.Builder
@@ -155,7 +167,7 @@ write_files:
 - path: "/etc/environment"
   content: |
     PATH="/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/usr/games:/usr/local/games"
-{{ proxyEnvironment .HTTPProxy .NoProxy | indent 4 }}
+{{ proxyEnvironment .HTTPProxy .NoProxy .CloudProviderName .ClusterCIDRs .ServerAddr | indent 4 }}
 {{- end }}
 
 - path: "/etc/systemd/journald.conf.d/max_disk_use.conf"
@@ -188,6 +200,9 @@ write_files:
 {{- /* As we added some modules and don't want to reboot, restart the service */}}
     systemctl restart systemd-modules-load.service
     sysctl --system
+{{- if networkMTU .CloudProviderName .ProviderSpec }}
+    udevadm trigger --subsystem-match=net --action=change
+{{- end }}
     apt-get update
 
     DEBIAN_FRONTEND=noninteractive apt-get -o Dpkg::Options::="--force-confdef" -o Dpkg::Options::="--force-confold" install -y \
@@ -230,6 +245,7 @@ write_files:
       touch /var/run/reboot-required
     fi
     {{ end }}
+{{ applyCgroupDriverScript .ContainerRuntimeConfigFileName | indent 4 }}
 {{ .ContainerRuntimeScript | indent 4 }}
 
 {{ safeDownloadBinariesScript .KubeletVersion | indent 4 }}
@@ -242,6 +258,11 @@ write_files:
     systemctl enable --now --no-block restart-kubelet.service
     {{ end }}
 
+- path: "/opt/bin/detect-cgroup-driver"
+  permissions: "0755"
+  content: |
+{{ cgroupDriverScript .CgroupDriver | indent 4 }}
+
 - path: "/opt/bin/supervise.sh"
   permissions: "0755"
   content: |
@@ -271,6 +292,11 @@ write_files:
   content: |
 {{ .CloudConfig | indent 4 }}
 
+- path: "/opt/bin/health-monitor.sh"
+  permissions: "0755"
+  content: |
+{{ healthMonitorScript | indent 4 }}
+
 - path: "/opt/bin/setup_net_env.sh"
   permissions: "0755"
   content: |
@@ -313,12 +339,16 @@ write_files:
 
 - path: "/etc/kubernetes/kubelet.conf"
   content: |
-{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName | indent 4 }}
+{{ kubeletConfiguration "cluster.local" .DNSIPs .KubeletFeatureGates .KubeletConfigs .ContainerRuntimeName .CgroupDriver | indent 4 }}
+{{- with reservedCgroupsWriteFiles .KubeletConfigs .ContainerRuntimeName }}
+
+{{ . }}
+{{- end }}
 
 - path: /etc/systemd/system/kubelet-healthcheck.service
   permissions: "0644"
   content: |
-{{ kubeletHealthCheckSystemdUnit | indent 4 }}
+{{ kubeletHealthCheckSystemdUnit .ProviderSpec.KubeletHealthCheck | indent 4 }}
 
 {{- with .ProviderSpec.CAPublicKey }}
 
@@ -367,6 +397,16 @@ write_files:
     [Install]
     WantedBy=multi-user.target
 {{- end }}
+{{- with networkMTU .CloudProviderName .ProviderSpec }}
+
+- path: "/etc/udev/rules.d/99-network-mtu.rules"
+  content: |
+    {{ networkMTUUdevRule . }}
+{{- end }}
+{{- with .ProviderSpec.ExtraFiles }}
+
+{{ extraWriteFiles . }}
+{{- end }}
 
 runcmd:
 - systemctl start setup.service
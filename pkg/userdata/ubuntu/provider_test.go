@@ -29,6 +29,7 @@ import (
 
 	"github.com/Masterminds/semver/v3"
 
+	"github.com/kubermatic/machine-controller/pkg/apis/cluster/common"
 	clusterv1alpha1 "github.com/kubermatic/machine-controller/pkg/apis/cluster/v1alpha1"
 	"github.com/kubermatic/machine-controller/pkg/apis/plugin"
 	"github.com/kubermatic/machine-controller/pkg/containerruntime"
@@ -123,6 +124,8 @@ type userDataTestCase struct {
 	registryCredentials       map[string]containerruntime.AuthConfig
 	pauseImage                string
 	containerruntime          string
+	dockerCgroupDriver        string
+	kubeletConfigs            map[string]string
 }
 
 func simpleVersionTests() []userDataTestCase {
@@ -409,6 +412,33 @@ func TestUserDataGeneration(t *testing.T) {
 			registryMirrors: "https://registry.docker-cn.com",
 			pauseImage:      "192.168.100.100:5000/kubernetes/pause:v3.1",
 		},
+		{
+			name:               "docker-cgroupfs",
+			containerruntime:   "docker",
+			dockerCgroupDriver: "cgroupfs",
+			providerSpec: &providerconfigtypes.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+			},
+		},
 		{
 			name:             "containerd",
 			containerruntime: "containerd",
@@ -445,6 +475,132 @@ func TestUserDataGeneration(t *testing.T) {
 				DistUpgradeOnBoot: true,
 			},
 		},
+		{
+			name: "enforced-reservations",
+			providerSpec: &providerconfigtypes.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+			},
+			kubeletConfigs: map[string]string{
+				common.EnforceNodeAllocatableKubeletConfig: "pods,system-reserved,kube-reserved",
+				common.SystemReservedCgroupKubeletConfig:   "/system.slice",
+				common.KubeReservedCgroupKubeletConfig:     "/kube-reserved.slice",
+			},
+		},
+		{
+			name: "kubelet-health-check",
+			providerSpec: &providerconfigtypes.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+				KubeletHealthCheck: &providerconfigtypes.KubeletHealthCheck{
+					Port:             10250,
+					TimeoutSeconds:   5,
+					PeriodSeconds:    30,
+					FailureThreshold: 3,
+				},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+			},
+		},
+		{
+			name: "azure-network-mtu",
+			providerSpec: &providerconfigtypes.Config{
+				CloudProvider: "azure",
+				CloudProviderSpec: runtime.RawExtension{
+					Raw: []byte(`{"networkMTU":9000}`),
+				},
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "azure",
+				config: "{azure-config:true}",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+			},
+		},
+		{
+			name: "extra-files",
+			providerSpec: &providerconfigtypes.Config{
+				CloudProvider: "",
+				SSHPublicKeys: []string{"ssh-rsa AAABBB"},
+				ExtraFiles: []providerconfigtypes.ExtraFile{
+					{
+						Path:        "/etc/example/config.conf",
+						Permissions: "0600",
+						Content:     "key: value\n",
+					},
+					{
+						Path:     "/usr/local/share/ca-certificates/ca.crt",
+						Content:  "Q0FDZXJ0Cg==",
+						Encoding: "base64",
+					},
+				},
+			},
+			spec: clusterv1alpha1.MachineSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "node1",
+				},
+				Versions: clusterv1alpha1.MachineVersionInfo{
+					Kubelet: defaultVersion,
+				},
+			},
+			ccProvider: &fakeCloudConfigProvider{
+				name:   "",
+				config: "",
+				err:    nil,
+			},
+			DNSIPs:           []net.IP{net.ParseIP("10.10.10.10")},
+			kubernetesCACert: "CACert",
+			osConfig: &Config{
+				DistUpgradeOnBoot: false,
+			},
+		},
 		{
 			name: "nutanix",
 			providerSpec: &providerconfigtypes.Config{
@@ -505,6 +661,7 @@ func TestUserDataGeneration(t *testing.T) {
 				InsecureRegistries:        test.insecureRegistries,
 				RegistryMirrors:           test.registryMirrors,
 				ContainerdRegistryMirrors: test.containerdRegistryMirrors,
+				DockerCgroupDriver:        test.dockerCgroupDriver,
 			}
 			containerRuntimeConfig, err := containerruntime.BuildConfig(containerRuntimeOpts)
 			if err != nil {
@@ -524,6 +681,7 @@ func TestUserDataGeneration(t *testing.T) {
 				NoProxy:                  test.noProxy,
 				PauseImage:               test.pauseImage,
 				KubeletFeatureGates:      kubeletFeatureGates,
+				KubeletConfigs:           test.kubeletConfigs,
 				ContainerRuntime:         containerRuntimeConfig,
 			}
 			s, err := provider.UserData(req)